@@ -149,6 +149,7 @@ func main() {
 
 	pa, err := policy.New(c.PA.Challenges)
 	cmd.FailOnError(err, "Couldn't create PA")
+	pa.SetRejectPrivateSuffixes(c.PA.RejectPrivateSuffixes)
 
 	if c.CA.HostnamePolicyFile == "" {
 		cmd.FailOnError(fmt.Errorf("HostnamePolicyFile was empty."), "")
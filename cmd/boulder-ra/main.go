@@ -39,6 +39,10 @@ type config struct {
 
 		MaxContactsPerRegistration int
 
+		// AllowedContactSchemes lists the URI schemes registration contacts
+		// may use. Defaults to only "mailto".
+		AllowedContactSchemes []string
+
 		// UseIsSafeDomain determines whether to call VA.IsSafeDomain
 		UseIsSafeDomain bool // TODO: remove after va IsSafeDomain deploy
 
@@ -73,6 +77,15 @@ type config struct {
 		// you need to request a new challenge.
 		PendingAuthorizationLifetimeDays int
 
+		// PreAuthorizedNames lists, by registration ID, names that those
+		// registrations may be issued certificates for without completing
+		// challenges. Only for trusted integrations.
+		PreAuthorizedNames map[int64][]string
+
+		// AuditRetention tags revocation audit log entries, by registration
+		// ID, with a retention class for log pipelines.
+		AuditRetention core.AuditRetentionClasses
+
 		Features map[string]bool
 	}
 
@@ -114,6 +127,8 @@ func main() {
 
 	pa, err := policy.New(c.PA.Challenges)
 	cmd.FailOnError(err, "Couldn't create PA")
+	pa.SetChallengesByIdentifier(c.PA.ChallengesByIdentifier)
+	pa.SetRejectPrivateSuffixes(c.PA.RejectPrivateSuffixes)
 
 	if c.RA.HostnamePolicyFile == "" {
 		cmd.FailOnError(fmt.Errorf("HostnamePolicyFile must be provided."), "")
@@ -187,6 +202,9 @@ func main() {
 	policyErr := rai.SetRateLimitPoliciesFile(c.RA.RateLimitPoliciesFilename)
 	cmd.FailOnError(policyErr, "Couldn't load rate limit policies file")
 	rai.PA = pa
+	rai.PreAuthorizedNames = c.RA.PreAuthorizedNames
+	rai.AuditRetention = c.RA.AuditRetention
+	rai.AllowedContactSchemes = c.RA.AllowedContactSchemes
 
 	raDNSTimeout, err := time.ParseDuration(c.Common.DNSTimeout)
 	cmd.FailOnError(err, "Couldn't parse RA DNS timeout")
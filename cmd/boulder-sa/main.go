@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"net"
 	"os"
 
@@ -26,6 +27,12 @@ type config struct {
 
 		MaxConcurrentRPCServerRequests int64
 
+		// IPv4RateLimitPrefix and IPv6RateLimitPrefix are the prefix lengths
+		// within which IP-based rate limits count addresses together. They
+		// default to 32 (a single IPv4 address) and 48.
+		IPv4RateLimitPrefix int
+		IPv6RateLimitPrefix int
+
 		Features map[string]bool
 	}
 
@@ -66,6 +73,18 @@ func main() {
 
 	sai, err := sa.NewSQLStorageAuthority(dbMap, clock.Default(), logger)
 	cmd.FailOnError(err, "Failed to create SA impl")
+	if c.SA.IPv4RateLimitPrefix != 0 {
+		if c.SA.IPv4RateLimitPrefix < 1 || c.SA.IPv4RateLimitPrefix > 32 {
+			cmd.FailOnError(fmt.Errorf("must be between 1 and 32, was %d", c.SA.IPv4RateLimitPrefix), "Invalid IPv4RateLimitPrefix")
+		}
+		sai.IPv4RateLimitPrefix = c.SA.IPv4RateLimitPrefix
+	}
+	if c.SA.IPv6RateLimitPrefix != 0 {
+		if c.SA.IPv6RateLimitPrefix < 1 || c.SA.IPv6RateLimitPrefix > 128 {
+			cmd.FailOnError(fmt.Errorf("must be between 1 and 128, was %d", c.SA.IPv6RateLimitPrefix), "Invalid IPv6RateLimitPrefix")
+		}
+		sai.IPv6RateLimitPrefix = c.SA.IPv6RateLimitPrefix
+	}
 
 	var grpcSrv *grpc.Server
 	if c.SA.GRPC != nil {
@@ -3,8 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log/syslog"
 	"net/http"
 	"os"
+	"regexp"
+	"time"
 
 	"github.com/facebookgo/httpdown"
 	"github.com/jmhodges/clock"
@@ -31,20 +35,264 @@ type config struct {
 		ListenAddress string
 
 		AllowOrigins []string
+		// AllowOriginPatterns are regular expressions matched against the
+		// Origin of CORS requests that are not listed in AllowOrigins.
+		AllowOriginPatterns []string
 
 		CertCacheDuration           cmd.ConfigDuration
 		CertNoCacheExpirationWindow cmd.ConfigDuration
 		IndexCacheDuration          cmd.ConfigDuration
 		IssuerCacheDuration         cmd.ConfigDuration
 
+		// NonceBufferSize is the number of anti-replay nonces to generate
+		// ahead of time. Zero generates each nonce on demand.
+		NonceBufferSize int
+
 		ShutdownStopTimeout cmd.ConfigDuration
 		ShutdownKillTimeout cmd.ConfigDuration
 
 		SubscriberAgreementURL string
+		// LocalizedSubscriberAgreementURLs maps language tags to translated
+		// subscriber agreements served from /terms by Accept-Language.
+		LocalizedSubscriberAgreementURLs map[string]string
+		// LocalizedProblemDetails maps problem types (in their
+		// urn:ietf:params:acme:error form) and then language tags to
+		// translated problem details, chosen by Accept-Language.
+		LocalizedProblemDetails map[string]map[string]string
+		// IETFErrorNamespace sends problem types in the
+		// urn:ietf:params:acme:error namespace rather than the legacy
+		// urn:acme:error one.
+		IETFErrorNamespace bool
 
 		AcceptRevocationReason bool
 		AllowAuthzDeactivation bool
 
+		// GloballyDisabledMethods overrides the default list of HTTP methods
+		// refused on every path (TRACE).
+		GloballyDisabledMethods []string
+
+		// DeprecationWarnings names the deprecated request shapes (e.g.
+		// "agreement") that get a Warning header on successful responses.
+		DeprecationWarnings []string
+
+		// CertSerialEncoding is the encoding of serials in certificate URLs,
+		// "hex" (the default) or "base64url".
+		CertSerialEncoding string
+
+		// SlowBackendThreshold is how long a single SA or RA call may take
+		// before a warning is logged.
+		SlowBackendThreshold cmd.ConfigDuration
+
+		// RequirePostAsGet refuses unauthenticated GETs of authorizations,
+		// challenges and certificates.
+		RequirePostAsGet bool
+
+		// RequireAgreementAtRegistration refuses new registrations that
+		// don't agree to the subscriber agreement.
+		RequireAgreementAtRegistration bool
+
+		// StrictJSONFieldCasing refuses payloads that spell "resource" or
+		// "status" with the wrong case.
+		StrictJSONFieldCasing bool
+
+		// AllowedJWSAlgorithms, if set, restricts requests to being signed
+		// with the listed JWS algorithms, out of RS256, ES256, ES384 and
+		// ES512.
+		AllowedJWSAlgorithms []string
+
+		// IssuanceWebhookURL, if set, is sent a POST with a JSON event for
+		// each certificate issued. Up to IssuanceWebhookQueueSize events
+		// (default 1000) wait to be sent, and any more are dropped. Each
+		// is retried up to IssuanceWebhookRetries times if the URL doesn't
+		// accept it within IssuanceWebhookTimeout (default 10s). On
+		// shutdown, queued events are sent for up to one more
+		// IssuanceWebhookTimeout, and those left after that are dropped.
+		IssuanceWebhookURL       string
+		IssuanceWebhookQueueSize int
+		IssuanceWebhookRetries   int
+		IssuanceWebhookTimeout   cmd.ConfigDuration
+
+		// CertificateLinkRelations lists the Link relations sent with
+		// certificates, out of "up", "index", "author" and "alternate".
+		// Defaults to only "up".
+		CertificateLinkRelations []string
+
+		// StrictContentType refuses POSTs that aren't labeled
+		// application/jose+json.
+		StrictContentType bool
+
+		// StrictAccept answers requests for certificates that don't accept
+		// any format they're served in with a 406, rather than the default
+		// format.
+		StrictAccept bool
+
+		// CertificateRequestContentTypes lists the content types, besides
+		// application/jose+json, that new-cert accepts. Only
+		// "application/pkcs10", a JWS with a DER CSR payload, is supported.
+		CertificateRequestContentTypes []string
+
+		// MaxContactsTotalBytes limits the combined length of a
+		// registration's contacts. Zero means no limit.
+		MaxContactsTotalBytes int
+
+		// EmitTermsLinkEverywhere adds the terms-of-service Link to
+		// new-authz, new-cert and challenge responses.
+		EmitTermsLinkEverywhere bool
+
+		// CertificateAttachment serves all certificates with a
+		// Content-Disposition filename, not just when ?download is given.
+		CertificateAttachment bool
+
+		// RejectSubjectAttributes refuses CSRs with subject attributes other
+		// than the common name.
+		RejectSubjectAttributes bool
+
+		// RejectDisallowedEKU refuses CSRs requesting extended key usages
+		// other than serverAuth and clientAuth.
+		RejectDisallowedEKU bool
+
+		// MinRSAKeyBits is the smallest RSA modulus accepted in a CSR, and
+		// AllowedECCurves the ECDSA curves accepted, e.g. "P-256". Unset,
+		// only the key policy applies.
+		MinRSAKeyBits   int
+		AllowedECCurves []string
+
+		// AllowDuplicateCSRNames accepts CSRs that repeat DNS names,
+		// compared lowercased and without trailing dots, instead of
+		// refusing them.
+		AllowDuplicateCSRNames bool
+
+		// MaxNames is the most DNS names a CSR may list, checked before
+		// the request reaches the RA.
+		MaxNames int
+
+		// InvalidAccountStatusCode is the HTTP status sent for requests from
+		// deactivated or revoked registrations, e.g. 410. Defaults to 403.
+		InvalidAccountStatusCode int
+
+		// LogPreAuthorizedIssuance audit logs certificate requests the RA
+		// issues without challenges because the registration is
+		// pre-authorized for the names.
+		LogPreAuthorizedIssuance bool
+
+		// DebugRateLimitHeaders names the rate limit bucket of rate limited
+		// requests in a response header. Do not enable in production.
+		DebugRateLimitHeaders bool
+
+		// CSRSyslogTag, if set, sends the audit logs of certificate requests,
+		// which carry whole CSRs, to syslog under this tag rather than the
+		// WFE's own, so they can be routed to a separate sink.
+		CSRSyslogTag string
+
+		// AuditRetention tags certificate request audit log entries, by
+		// registration ID, with a retention class for log pipelines.
+		AuditRetention core.AuditRetentionClasses
+
+		// DedupeLinkHeaders drops repeated identical Link headers from
+		// responses.
+		DedupeLinkHeaders bool
+
+		// AccountThumbprintHeader adds the account key's JWK thumbprint to
+		// registration responses.
+		AccountThumbprintHeader bool
+
+		// MaxLinkHeaders caps the number of optional Link headers in a
+		// response: "up", "next" and "terms-of-service" links are always
+		// sent. Zero means no cap.
+		MaxLinkHeaders int
+
+		// MaxHeaderBytes caps the total size of a response's headers by
+		// dropping optional ones. Required headers and links are sent even
+		// if they don't fit. Zero means no cap.
+		MaxHeaderBytes int
+
+		// LogSampleRate, if between 0 and 1, is the fraction of requests
+		// logged in full. The rest only log their ID, endpoint, method
+		// and requester.
+		LogSampleRate float64
+
+		// ServerTimingHeader adds a Server-Timing header breaking down the
+		// time spent on JWS verification and SA and RA calls.
+		ServerTimingHeader bool
+
+		// CertificateProfiles lists the certificate profiles new-cert
+		// requests may select by name.
+		CertificateProfiles []string
+
+		// ExpectedHosts, if set, lists the Host headers the WFE will serve
+		// requests for. Requests for any other host get a 400.
+		ExpectedHosts []string
+
+		// EmitServerTime adds a Boulder-Server-Time header with the CA's
+		// current time to every response.
+		EmitServerTime bool
+
+		// ChallengeIdempotencyWindow is how long a challenge response is
+		// replayed for retries carrying the same Idempotency-Key header.
+		ChallengeIdempotencyWindow cmd.ConfigDuration
+
+		// RejectChallengesOnValidAuthz refuses challenge responses for
+		// authorizations that are already valid.
+		RejectChallengesOnValidAuthz bool
+
+		// DirectoryKeyTypes lists the allowed account and certificate key
+		// types in the directory's meta object.
+		DirectoryKeyTypes bool
+
+		// DirectoryRandomKey adds a randomly named entry to every
+		// directory, making each response unique.
+		DirectoryRandomKey bool
+
+		// DirectoryWebsite and DirectoryCAAIdentities are listed in the
+		// directory's meta object as "website" and "caaIdentities".
+		DirectoryWebsite       string
+		DirectoryCAAIdentities []string
+
+		// RateLimitRetryAfter maps rate limit names to the Retry-After
+		// sent to clients that hit them.
+		RateLimitRetryAfter map[string]cmd.ConfigDuration
+
+		// NonceInstance, if set, prefixes this WFE's nonces so that a
+		// rejected nonce can be traced to the instance that minted it.
+		// Only nonces with the prefix are accepted, unless
+		// NonceSecretFile is set, in which case nonces from every
+		// instance are.
+		NonceInstance string
+
+		// NonceSecretFile, if set, names a file holding a secret shared
+		// by all WFEs. Their nonces are then HMACs under it, valid at
+		// any of them for NonceTTL (default ten minutes) plus
+		// NonceExpiryGrace. NonceBufferSize doesn't apply to these
+		// nonces. Each WFE only remembers the nonces used at it, so
+		// within that time a nonce can be used once at every WFE sharing
+		// the secret: keep NonceTTL short. It remembers at most 65536 of
+		// them, and refuses further nonces until some expire.
+		NonceSecretFile string
+		NonceTTL        cmd.ConfigDuration
+
+		// NonceMaxAge, if set, is how long after they were handed out
+		// nonces are accepted. It can't be combined with NonceBufferSize.
+		NonceMaxAge cmd.ConfigDuration
+
+		// NonceExpiryGrace is how long nonces are still accepted after
+		// they expire, to absorb clock jitter.
+		NonceExpiryGrace cmd.ConfigDuration
+
+		// SkipNonceOnTerminalErrors leaves the Replay-Nonce header off 4xx
+		// responses other than bad nonce and rate limit errors.
+		SkipNonceOnTerminalErrors bool
+
+		// MaxConcurrentJWSVerifications limits the number of JWS
+		// signatures verified at once. Zero, the default, means no
+		// limit. Requests that find the limit reached wait up to
+		// JWSVerificationWait, then get a 503.
+		MaxConcurrentJWSVerifications int
+		JWSVerificationWait           cmd.ConfigDuration
+
+		// EnabledEndpoints turns endpoints off by name, e.g.
+		// {"new-cert": false}. Unlisted endpoints stay enabled.
+		EnabledEndpoints map[string]bool
+
 		RAService *cmd.GRPCClientConfig
 		SAService *cmd.GRPCClientConfig
 
@@ -90,6 +338,20 @@ func setupWFE(c config, logger blog.Logger, stats metrics.Scope) (core.Registrat
 	return rac, sac
 }
 
+// csrLogger returns a Logger writing to syslog under tag, at the levels of
+// logConf.
+func csrLogger(tag string, logConf cmd.SyslogConfig) blog.Logger {
+	syslogger, err := syslog.Dial("", "", syslog.LOG_INFO, tag)
+	cmd.FailOnError(err, "Could not connect to Syslog for CSR logs")
+	syslogLevel := int(syslog.LOG_INFO)
+	if logConf.SyslogLevel != 0 {
+		syslogLevel = logConf.SyslogLevel
+	}
+	logger, err := blog.New(syslogger, logConf.StdoutLevel, syslogLevel)
+	cmd.FailOnError(err, "Could not create CSR logger")
+	return logger
+}
+
 func main() {
 	configFile := flag.String("config", "", "File path to the configuration file for this service")
 	flag.Parse()
@@ -110,7 +372,46 @@ func main() {
 	defer logger.AuditPanic()
 	logger.Info(cmd.VersionString(clientName))
 
-	wfe, err := wfe.NewWebFrontEndImpl(scope, clock.Default(), goodkey.NewKeyPolicy(), logger)
+	serialEncoding := wfe.HexSerialEncoding
+	if c.WFE.CertSerialEncoding != "" {
+		var ok bool
+		serialEncoding, ok = wfe.SerialEncodings[c.WFE.CertSerialEncoding]
+		if !ok {
+			cmd.FailOnError(fmt.Errorf("unknown encoding %q", c.WFE.CertSerialEncoding), "Invalid certSerialEncoding")
+		}
+	}
+
+	for _, contentType := range c.WFE.CertificateRequestContentTypes {
+		supported := false
+		for _, t := range wfe.SupportedCertificateRequestContentTypes {
+			supported = supported || t == contentType
+		}
+		if !supported {
+			cmd.FailOnError(fmt.Errorf("unsupported content type %q", contentType), "Invalid certificateRequestContentTypes")
+		}
+	}
+
+	if code := c.WFE.InvalidAccountStatusCode; code != 0 && (code < 400 || code > 499) {
+		cmd.FailOnError(fmt.Errorf("%d is not a 4xx status", code), "Invalid invalidAccountStatusCode")
+	}
+
+	for _, alg := range c.WFE.AllowedJWSAlgorithms {
+		supported := false
+		for _, s := range wfe.SupportedJWSAlgorithms {
+			supported = supported || alg == s
+		}
+		if !supported {
+			cmd.FailOnError(fmt.Errorf("%q is not one of %v", alg, wfe.SupportedJWSAlgorithms), "Invalid allowedJWSAlgorithms")
+		}
+	}
+
+	var nonceSecret []byte
+	if c.WFE.NonceSecretFile != "" {
+		nonceSecret, err = ioutil.ReadFile(c.WFE.NonceSecretFile)
+		cmd.FailOnError(err, "Unable to read nonce secret")
+	}
+
+	wfe, err := wfe.NewWebFrontEndImpl(scope, clock.Default(), goodkey.NewKeyPolicy(), c.WFE.NonceBufferSize, nonceSecret, logger)
 	cmd.FailOnError(err, "Unable to create WFE")
 	rac, sac := setupWFE(c, logger, scope)
 	wfe.RA = rac
@@ -122,10 +423,93 @@ func main() {
 	} else {
 		wfe.SubscriberAgreementURL = c.SubscriberAgreementURL
 	}
+	wfe.LocalizedSubscriberAgreementURLs = c.WFE.LocalizedSubscriberAgreementURLs
+	wfe.LocalizedProblemDetails = c.WFE.LocalizedProblemDetails
+	wfe.IETFErrorNamespace = c.WFE.IETFErrorNamespace
 
 	wfe.AllowOrigins = c.WFE.AllowOrigins
+	for _, pattern := range c.WFE.AllowOriginPatterns {
+		re, err := regexp.Compile(pattern)
+		cmd.FailOnError(err, fmt.Sprintf("Invalid CORS origin pattern [%s]", pattern))
+		wfe.AllowOriginRegexps = append(wfe.AllowOriginRegexps, re)
+	}
 	wfe.AcceptRevocationReason = c.WFE.AcceptRevocationReason
 	wfe.AllowAuthzDeactivation = c.WFE.AllowAuthzDeactivation
+	if c.WFE.GloballyDisabledMethods != nil {
+		wfe.GloballyDisabledMethods = c.WFE.GloballyDisabledMethods
+	}
+	wfe.DeprecationWarnings = c.WFE.DeprecationWarnings
+	wfe.CertSerialEncoding = serialEncoding
+	wfe.SlowBackendThreshold = c.WFE.SlowBackendThreshold.Duration
+	wfe.RequirePostAsGet = c.WFE.RequirePostAsGet
+	wfe.RequireAgreementAtRegistration = c.WFE.RequireAgreementAtRegistration
+	wfe.StrictJSONFieldCasing = c.WFE.StrictJSONFieldCasing
+	wfe.StrictContentType = c.WFE.StrictContentType
+	wfe.StrictAccept = c.WFE.StrictAccept
+	wfe.CertificateRequestContentTypes = c.WFE.CertificateRequestContentTypes
+	wfe.AllowedJWSAlgorithms = c.WFE.AllowedJWSAlgorithms
+	if c.WFE.IssuanceWebhookURL != "" {
+		queueSize := c.WFE.IssuanceWebhookQueueSize
+		if queueSize <= 0 {
+			queueSize = 1000
+		}
+		timeout := c.WFE.IssuanceWebhookTimeout.Duration
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		wfe.SetIssuanceWebhook(c.WFE.IssuanceWebhookURL, queueSize, c.WFE.IssuanceWebhookRetries, timeout)
+	}
+	if len(c.WFE.CertificateLinkRelations) > 0 {
+		cmd.FailOnError(wfe.SetCertificateLinkRelations(c.WFE.CertificateLinkRelations), "Invalid certificateLinkRelations")
+	}
+	wfe.MaxContactsTotalBytes = c.WFE.MaxContactsTotalBytes
+	wfe.EmitTermsLinkEverywhere = c.WFE.EmitTermsLinkEverywhere
+	wfe.CertificateAttachment = c.WFE.CertificateAttachment
+	wfe.RejectSubjectAttributes = c.WFE.RejectSubjectAttributes
+	wfe.RejectDisallowedEKU = c.WFE.RejectDisallowedEKU
+	wfe.MinRSAKeyBits = c.WFE.MinRSAKeyBits
+	wfe.AllowedECCurves = c.WFE.AllowedECCurves
+	wfe.MaxNames = c.WFE.MaxNames
+	wfe.AllowDuplicateCSRNames = c.WFE.AllowDuplicateCSRNames
+	wfe.InvalidAccountStatusCode = c.WFE.InvalidAccountStatusCode
+	wfe.LogPreAuthorizedIssuance = c.WFE.LogPreAuthorizedIssuance
+	wfe.DebugRateLimitHeaders = c.WFE.DebugRateLimitHeaders
+	wfe.DedupeLinkHeaders = c.WFE.DedupeLinkHeaders
+	wfe.AccountThumbprintHeader = c.WFE.AccountThumbprintHeader
+	wfe.MaxLinkHeaders = c.WFE.MaxLinkHeaders
+	wfe.MaxHeaderBytes = c.WFE.MaxHeaderBytes
+	wfe.ServerTimingHeader = c.WFE.ServerTimingHeader
+	wfe.LogSampleRate = c.WFE.LogSampleRate
+	wfe.CertificateProfiles = c.WFE.CertificateProfiles
+	wfe.ExpectedHosts = c.WFE.ExpectedHosts
+	wfe.EmitServerTime = c.WFE.EmitServerTime
+	wfe.ChallengeIdempotencyWindow = c.WFE.ChallengeIdempotencyWindow.Duration
+	wfe.RejectChallengesOnValidAuthz = c.WFE.RejectChallengesOnValidAuthz
+	wfe.DirectoryKeyTypes = c.WFE.DirectoryKeyTypes
+	wfe.DirectoryRandomKey = c.WFE.DirectoryRandomKey
+	wfe.DirectoryWebsite = c.WFE.DirectoryWebsite
+	wfe.DirectoryCAAIdentities = c.WFE.DirectoryCAAIdentities
+	wfe.EnabledEndpoints = c.WFE.EnabledEndpoints
+	if c.WFE.NonceInstance != "" {
+		cmd.FailOnError(wfe.SetNonceInstance(c.WFE.NonceInstance), "Invalid nonce instance")
+	}
+	wfe.NonceMaxAge = c.WFE.NonceMaxAge.Duration
+	wfe.SetNonceExpiryGrace(c.WFE.NonceExpiryGrace.Duration)
+	wfe.SkipNonceOnTerminalErrors = c.WFE.SkipNonceOnTerminalErrors
+	if c.WFE.NonceTTL.Duration > 0 {
+		wfe.SetNonceTTL(c.WFE.NonceTTL.Duration)
+	}
+	wfe.SetMaxConcurrentJWSVerifications(c.WFE.MaxConcurrentJWSVerifications, c.WFE.JWSVerificationWait.Duration)
+	if len(c.WFE.RateLimitRetryAfter) > 0 {
+		wfe.RateLimitRetryAfter = make(map[string]time.Duration, len(c.WFE.RateLimitRetryAfter))
+		for name, d := range c.WFE.RateLimitRetryAfter {
+			wfe.RateLimitRetryAfter[name] = d.Duration
+		}
+	}
+	if c.WFE.CSRSyslogTag != "" {
+		wfe.CSRLog = csrLogger(c.WFE.CSRSyslogTag, c.Syslog)
+	}
+	wfe.AuditRetention = c.WFE.AuditRetention
 
 	wfe.CertCacheDuration = c.WFE.CertCacheDuration.Duration
 	wfe.CertNoCacheExpirationWindow = c.WFE.CertNoCacheExpirationWindow.Duration
@@ -139,6 +523,7 @@ func main() {
 
 	// Set up paths
 	wfe.BaseURL = c.Common.BaseURL
+	cmd.FailOnError(wfe.CheckConfig(), "Invalid WFE configuration")
 	h := wfe.Handler()
 
 	httpMonitor := metrics.NewHTTPMonitor(scope, h)
@@ -160,7 +545,10 @@ func main() {
 	hdSrv, err := hd.ListenAndServe(srv)
 	cmd.FailOnError(err, "Error starting HTTP server")
 
-	go cmd.CatchSignals(logger, func() { _ = hdSrv.Stop() })
+	go cmd.CatchSignals(logger, func() {
+		_ = hdSrv.Stop()
+		wfe.Close()
+	})
 
 	forever := make(chan struct{}, 1)
 	<-forever
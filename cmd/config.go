@@ -158,6 +158,13 @@ type PAConfig struct {
 	DBConfig
 	EnforcePolicyWhitelist bool
 	Challenges             map[string]bool
+	// ChallengesByIdentifier restricts the challenges offered for a domain
+	// and its subdomains to the listed types, e.g. {"example.com": ["dns-01"]}.
+	ChallengesByIdentifier map[string][]string
+	// RejectPrivateSuffixes forbids issuance for names that are exactly a
+	// private public suffix, like "github.io". ICANN suffixes are always
+	// forbidden.
+	RejectPrivateSuffixes bool
 }
 
 // HostnamePolicyConfig specifies a file from which to load a policy regarding
@@ -177,6 +184,13 @@ func (pc PAConfig) CheckChallenges() error {
 			return fmt.Errorf("Invalid challenge in PA config: %s", name)
 		}
 	}
+	for domain, names := range pc.ChallengesByIdentifier {
+		for _, name := range names {
+			if !core.ValidChallenge(name) {
+				return fmt.Errorf("Invalid challenge for %s in PA config: %s", domain, name)
+			}
+		}
+	}
 	return nil
 }
 
@@ -80,6 +80,15 @@ func TestCertificateRequest(t *testing.T) {
 	if err != nil {
 		t.Errorf("Marshalled certificate request failed to unmarshal: %v", err)
 	}
+
+	// The profile survives a round trip
+	goodCR.Profile = "short-lived"
+	jsonCR, err = json.Marshal(goodCR)
+	test.AssertNotError(t, err, "Failed to marshal certificate request with profile")
+	var profileCR CertificateRequest
+	err = json.Unmarshal(jsonCR, &profileCR)
+	test.AssertNotError(t, err, "Failed to unmarshal certificate request with profile")
+	test.AssertEquals(t, profileCR.Profile, "short-lived")
 }
 
 // util.go
@@ -75,6 +75,9 @@ type RegistrationAuthority interface {
 	// [WebFrontEnd]
 	DeactivateAuthorization(ctx context.Context, auth Authorization) error
 
+	// [WebFrontEnd]
+	CheckPreAuthorization(ctx context.Context, regID int64, names []string) (bool, error)
+
 	// [AdminRevoker]
 	AdministrativelyRevokeCertificate(ctx context.Context, cert x509.Certificate, code revocation.Reason, adminName string) error
 }
@@ -90,6 +93,7 @@ type CertificateAuthority interface {
 type PolicyAuthority interface {
 	WillingToIssue(domain AcmeIdentifier) error
 	ChallengesFor(domain AcmeIdentifier) (challenges []Challenge, validCombinations [][]int)
+	ChallengeTypeAllowed(t string, domain AcmeIdentifier) bool
 }
 
 // StorageGetter are the Boulder SA's read-only methods
@@ -97,6 +101,7 @@ type StorageGetter interface {
 	GetRegistration(ctx context.Context, regID int64) (Registration, error)
 	GetRegistrationByKey(ctx context.Context, jwk *jose.JsonWebKey) (Registration, error)
 	GetAuthorization(ctx context.Context, authzID string) (Authorization, error)
+	GetChallenge(ctx context.Context, challengeID int64) (Challenge, string, error)
 	GetValidAuthorizations(ctx context.Context, regID int64, domains []string, now time.Time) (map[string]*Authorization, error)
 	GetCertificate(ctx context.Context, serial string) (Certificate, error)
 	GetCertificateStatus(ctx context.Context, serial string) (CertificateStatus, error)
@@ -110,10 +110,14 @@ type AcmeIdentifier struct {
 type CertificateRequest struct {
 	CSR   *x509.CertificateRequest // The CSR
 	Bytes []byte                   // The original bytes of the CSR, for logging.
+	// The name of the certificate profile to issue under, or empty for the
+	// default.
+	Profile string
 }
 
 type RawCertificateRequest struct {
-	CSR JSONBuffer `json:"csr"` // The encoded CSR
+	CSR     JSONBuffer `json:"csr"`               // The encoded CSR
+	Profile string     `json:"profile,omitempty"` // The certificate profile
 }
 
 // UnmarshalJSON provides an implementation for decoding CertificateRequest objects.
@@ -130,13 +134,15 @@ func (cr *CertificateRequest) UnmarshalJSON(data []byte) error {
 
 	cr.CSR = csr
 	cr.Bytes = raw.CSR
+	cr.Profile = raw.Profile
 	return nil
 }
 
 // MarshalJSON provides an implementation for encoding CertificateRequest objects.
 func (cr CertificateRequest) MarshalJSON() ([]byte, error) {
 	return json.Marshal(RawCertificateRequest{
-		CSR: cr.CSR.Raw,
+		CSR:     cr.CSR.Raw,
+		Profile: cr.Profile,
 	})
 }
 
@@ -161,7 +167,7 @@ type Registration struct {
 	// CreatedAt is the time the registration was created.
 	CreatedAt time.Time `json:"createdAt"`
 
-	Status AcmeStatus
+	Status AcmeStatus `json:"status"`
 }
 
 // ValidationRecord represents a validation attempt against a specific URL/hostname
@@ -228,6 +234,9 @@ type Challenge struct {
 	// Contains information about URLs used or redirected to and IPs resolved and
 	// used
 	ValidationRecord []ValidationRecord `json:"validationRecord,omitempty"`
+
+	// The time at which the challenge was successfully validated
+	Validated *time.Time `json:"validated,omitempty"`
 }
 
 // ExpectedKeyAuthorization computes the expected KeyAuthorization value for
@@ -362,6 +371,10 @@ type Authorization struct {
 	// The server may suggest combinations of challenges if it
 	// requires more than one challenge to be completed.
 	Combinations [][]int `json:"combinations,omitempty" db:"combinations"`
+
+	// Wildcard is set, for display only, on authorizations for a wildcard
+	// name. Their identifier is then the base domain the wildcard covers.
+	Wildcard bool `json:"wildcard,omitempty" db:"-"`
 }
 
 // FindChallenge will look for the given challenge inside this authorization. If
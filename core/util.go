@@ -78,8 +78,15 @@ type SignatureValidationError string
 // NoSuchRegistrationError indicates that a registration could not be found.
 type NoSuchRegistrationError string
 
-// RateLimitedError indicates the user has hit a rate limit
-type RateLimitedError string
+// RateLimitedError indicates the user has hit a rate limit. Limit names the
+// limit as the rate limit policy does, e.g. "certificatesPerName", and Bucket
+// is the key the request was counted against under that limit, e.g. an IP or
+// a registration ID, if they are known.
+type RateLimitedError struct {
+	Detail string
+	Limit  string
+	Bucket string
+}
 
 // TooManyRPCRequestsError indicates an RPC server has hit it's concurrent request
 // limit
@@ -88,6 +95,10 @@ type TooManyRPCRequestsError string
 // BadNonceError indicates an empty of invalid nonce was provided
 type BadNonceError string
 
+// MultipleMatchingCertificatesError indicates that a certificate lookup
+// matched more than one certificate, as a short serial can.
+type MultipleMatchingCertificatesError string
+
 func (e InternalServerError) Error() string      { return string(e) }
 func (e NotSupportedError) Error() string        { return string(e) }
 func (e MalformedRequestError) Error() string    { return string(e) }
@@ -96,9 +107,12 @@ func (e NotFoundError) Error() string            { return string(e) }
 func (e LengthRequiredError) Error() string      { return string(e) }
 func (e SignatureValidationError) Error() string { return string(e) }
 func (e NoSuchRegistrationError) Error() string  { return string(e) }
-func (e RateLimitedError) Error() string         { return string(e) }
+func (e RateLimitedError) Error() string         { return e.Detail }
 func (e TooManyRPCRequestsError) Error() string  { return string(e) }
 func (e BadNonceError) Error() string            { return string(e) }
+func (e MultipleMatchingCertificatesError) Error() string {
+	return string(e)
+}
 
 // statusTooManyRequests is the HTTP status code meant for rate limiting
 // errors. It's not currently in the net/http library so we add it here.
@@ -134,6 +148,8 @@ func ProblemDetailsForError(err error, msg string) *probs.ProblemDetails {
 		return probs.RateLimited(fmt.Sprintf("%s :: %s", msg, err))
 	case BadNonceError:
 		return probs.BadNonce(fmt.Sprintf("%s :: %s", msg, err))
+	case MultipleMatchingCertificatesError:
+		return probs.Conflict(fmt.Sprintf("%s :: %s", msg, err))
 	default:
 		// Internal server error messages may include sensitive data, so we do
 		// not include it.
@@ -306,6 +322,13 @@ func UniqueLowerNames(names []string) (unique []string) {
 	return
 }
 
+// NormalizeDNSName returns the form of a DNS name used when comparing names
+// from certificates and authorizations: lowercased, without a trailing dot.
+// "Example.com." and "example.com" normalize to the same name.
+func NormalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
 // LoadCertBundle loads a PEM bundle of certificates from disk
 func LoadCertBundle(filename string) ([]*x509.Certificate, error) {
 	bundleBytes, err := ioutil.ReadFile(filename)
@@ -386,3 +409,22 @@ func IsASCII(str string) bool {
 	}
 	return true
 }
+
+// AuditRetentionClasses tags audit log entries about registrations with a
+// retention class, so that log pipelines can keep them for longer, or
+// shorter.
+type AuditRetentionClasses struct {
+	// Registrations maps the IDs of registrations to their entries' class.
+	Registrations map[int64]string
+	// Default is the class of other registrations' entries, if set.
+	Default string
+}
+
+// Class returns the retention class to tag audit log entries about regID
+// with, or "" for none.
+func (c AuditRetentionClasses) Class(regID int64) string {
+	if class, ok := c.Registrations[regID]; ok {
+		return class
+	}
+	return c.Default
+}
@@ -111,6 +111,13 @@ func TestUniqueLowerNames(t *testing.T) {
 	test.AssertDeepEquals(t, []string{"a.com", "bar.com", "baz.com", "foobar.com"}, u)
 }
 
+func TestNormalizeDNSName(t *testing.T) {
+	test.AssertEquals(t, NormalizeDNSName("example.com"), "example.com")
+	test.AssertEquals(t, NormalizeDNSName("example.com."), "example.com")
+	test.AssertEquals(t, NormalizeDNSName("Example.COM."), "example.com")
+	test.AssertEquals(t, NormalizeDNSName("WWW.example.com"), "www.example.com")
+}
+
 func TestProblemDetailsFromError(t *testing.T) {
 	testCases := []struct {
 		err        error
@@ -123,9 +130,10 @@ func TestProblemDetailsFromError(t *testing.T) {
 		{UnauthorizedError("foo"), 403, probs.UnauthorizedProblem},
 		{NotFoundError("foo"), 404, probs.MalformedProblem},
 		{SignatureValidationError("foo"), 400, probs.MalformedProblem},
-		{RateLimitedError("foo"), 429, probs.RateLimitedProblem},
+		{RateLimitedError{Detail: "foo"}, 429, probs.RateLimitedProblem},
 		{LengthRequiredError("foo"), 411, probs.MalformedProblem},
 		{BadNonceError("foo"), 400, probs.BadNonceProblem},
+		{MultipleMatchingCertificatesError("foo"), 409, probs.MalformedProblem},
 	}
 	for _, c := range testCases {
 		p := ProblemDetailsForError(c.err, "k")
@@ -145,3 +153,16 @@ func TestProblemDetailsFromError(t *testing.T) {
 	p := ProblemDetailsForError(expected, "k")
 	test.AssertDeepEquals(t, expected, p)
 }
+
+func TestAuditRetentionClasses(t *testing.T) {
+	var classes AuditRetentionClasses
+	test.AssertEquals(t, classes.Class(1), "")
+
+	classes.Registrations = map[int64]string{1: "extended"}
+	test.AssertEquals(t, classes.Class(1), "extended")
+	test.AssertEquals(t, classes.Class(2), "")
+
+	classes.Default = "standard"
+	test.AssertEquals(t, classes.Class(1), "extended")
+	test.AssertEquals(t, classes.Class(2), "standard")
+}
@@ -27,6 +27,10 @@ func (pa *mockPA) ChallengesFor(identifier core.AcmeIdentifier) (challenges []co
 	return
 }
 
+func (pa *mockPA) ChallengeTypeAllowed(t string, id core.AcmeIdentifier) bool {
+	return true
+}
+
 func (pa *mockPA) WillingToIssue(id core.AcmeIdentifier) error {
 	if id.Value == "bad-name.com" || id.Value == "other-bad-name.com" {
 		return errors.New("")
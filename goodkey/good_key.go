@@ -43,6 +43,36 @@ type KeyPolicy struct {
 	AllowECDSANISTP384 bool // Whether ECDSA NISTP384 keys should be allowed.
 }
 
+// Baseline Requirements Appendix A bounds on RSA modulus length, in bits.
+const (
+	minRSAKeySize = 2048
+	maxRSAKeySize = 4096
+)
+
+// KeyType describes one kind of key a KeyPolicy allows, for advertising to
+// clients.
+type KeyType struct {
+	Type    string `json:"type"`
+	MinSize int    `json:"minSize,omitempty"`
+	MaxSize int    `json:"maxSize,omitempty"`
+	Curve   string `json:"curve,omitempty"`
+}
+
+// KeyTypes lists the kinds of key the policy allows.
+func (policy *KeyPolicy) KeyTypes() []KeyType {
+	var types []KeyType
+	if policy.AllowRSA {
+		types = append(types, KeyType{Type: "RSA", MinSize: minRSAKeySize, MaxSize: maxRSAKeySize})
+	}
+	if policy.AllowECDSANISTP256 {
+		types = append(types, KeyType{Type: "EC", Curve: "P-256"})
+	}
+	if policy.AllowECDSANISTP384 {
+		types = append(types, KeyType{Type: "EC", Curve: "P-384"})
+	}
+	return types
+}
+
 // NewKeyPolicy returns a KeyPolicy that allows RSA, ECDSA256 and ECDSA384.
 func NewKeyPolicy() KeyPolicy {
 	return KeyPolicy{
@@ -192,12 +222,11 @@ func (policy *KeyPolicy) goodKeyRSA(key rsa.PublicKey) (err error) {
 	// Modulus must be >= 2048 bits and <= 4096 bits
 	modulus := key.N
 	modulusBitLen := modulus.BitLen()
-	const maxKeySize = 4096
-	if modulusBitLen < 2048 {
+	if modulusBitLen < minRSAKeySize {
 		return core.MalformedRequestError(fmt.Sprintf("Key too small: %d", modulusBitLen))
 	}
-	if modulusBitLen > maxKeySize {
-		return core.MalformedRequestError(fmt.Sprintf("Key too large: %d > %d", modulusBitLen, maxKeySize))
+	if modulusBitLen > maxRSAKeySize {
+		return core.MalformedRequestError(fmt.Sprintf("Key too large: %d > %d", modulusBitLen, maxRSAKeySize))
 	}
 	// Bit lengths that are not a multiple of 8 may cause problems on some
 	// client implementations.
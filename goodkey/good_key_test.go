@@ -198,3 +198,14 @@ func TestECDSAIdentity(t *testing.T) {
 		test.AssertError(t, testingPolicy.GoodKey(public), "Should not have accepted key with point at infinity.")
 	}
 }
+
+func TestKeyTypes(t *testing.T) {
+	test.AssertDeepEquals(t, testingPolicy.KeyTypes(), []KeyType{
+		{Type: "RSA", MinSize: 2048, MaxSize: 4096},
+		{Type: "EC", Curve: "P-256"},
+		{Type: "EC", Curve: "P-384"},
+	})
+
+	ecOnly := &KeyPolicy{AllowECDSANISTP384: true}
+	test.AssertDeepEquals(t, ecOnly.KeyTypes(), []KeyType{{Type: "EC", Curve: "P-384"}})
+}
@@ -1,6 +1,7 @@
 package grpc
 
 import (
+	"encoding/json"
 	"errors"
 
 	"google.golang.org/grpc"
@@ -23,6 +24,7 @@ const (
 	BadNonceError
 	NoSuchRegistrationError
 	InternalServerError
+	MultipleMatchingCertificatesError
 )
 
 var (
@@ -52,15 +54,43 @@ func errorToCode(err error) codes.Code {
 		return NoSuchRegistrationError
 	case core.InternalServerError:
 		return InternalServerError
+	case core.MultipleMatchingCertificatesError:
+		return MultipleMatchingCertificatesError
 	default:
 		return codes.Unknown
 	}
 }
 
+// rateLimitedErrorBody is the gRPC error description of a
+// core.RateLimitedError, which carries the name and bucket of the limit as
+// well as its message.
+type rateLimitedErrorBody struct {
+	Detail string `json:"detail"`
+	Limit  string `json:"limit,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+}
+
 func wrapError(err error) error {
+	if rlErr, ok := err.(core.RateLimitedError); ok {
+		body, jsonErr := json.Marshal(rateLimitedErrorBody{rlErr.Detail, rlErr.Limit, rlErr.Bucket})
+		if jsonErr == nil {
+			return grpc.Errorf(RateLimitedError, "%s", body)
+		}
+	}
 	return grpc.Errorf(errorToCode(err), err.Error())
 }
 
+// unwrapRateLimitedError decodes the description of a rate limited gRPC
+// error. A description that isn't a rateLimitedErrorBody is the message of an
+// error from a server that doesn't send the limit's name.
+func unwrapRateLimitedError(errBody string) error {
+	var body rateLimitedErrorBody
+	if err := json.Unmarshal([]byte(errBody), &body); err != nil {
+		return core.RateLimitedError{Detail: errBody}
+	}
+	return core.RateLimitedError{Detail: body.Detail, Limit: body.Limit, Bucket: body.Bucket}
+}
+
 func unwrapError(err error) error {
 	code := grpc.Code(err)
 	errBody := grpc.ErrorDesc(err)
@@ -80,11 +110,13 @@ func unwrapError(err error) error {
 	case NoSuchRegistrationError:
 		return core.NoSuchRegistrationError(errBody)
 	case RateLimitedError:
-		return core.RateLimitedError(errBody)
+		return unwrapRateLimitedError(errBody)
 	case LengthRequiredError:
 		return core.LengthRequiredError(errBody)
 	case BadNonceError:
 		return core.BadNonceError(errBody)
+	case MultipleMatchingCertificatesError:
+		return core.MultipleMatchingCertificatesError(errBody)
 	default:
 		return err
 	}
@@ -21,10 +21,12 @@ func TestErrors(t *testing.T) {
 		{core.NotFoundError("test 4"), NotFoundError},
 		{core.LengthRequiredError("test 5"), LengthRequiredError},
 		{core.SignatureValidationError("test 6"), SignatureValidationError},
-		{core.RateLimitedError("test 7"), RateLimitedError},
+		{core.RateLimitedError{Detail: "test 7"}, RateLimitedError},
+		{core.RateLimitedError{Detail: "test 7", Limit: "certificatesPerName", Bucket: "example.com"}, RateLimitedError},
 		{core.BadNonceError("test 8"), BadNonceError},
 		{core.NoSuchRegistrationError("test 9"), NoSuchRegistrationError},
 		{core.InternalServerError("test 10"), InternalServerError},
+		{core.MultipleMatchingCertificatesError("test 11"), MultipleMatchingCertificatesError},
 	}
 
 	for _, tc := range testcases {
@@ -32,4 +34,7 @@ func TestErrors(t *testing.T) {
 		test.AssertEquals(t, grpc.Code(wrappedErr), tc.expectedCode)
 		test.AssertEquals(t, tc.err, unwrapError(wrappedErr))
 	}
+
+	// Rate limit errors from servers that don't send the limit's name
+	test.AssertEquals(t, unwrapError(grpc.Errorf(RateLimitedError, "test 12")), error(core.RateLimitedError{Detail: "test 12"}))
 }
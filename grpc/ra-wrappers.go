@@ -64,7 +64,11 @@ func (rac RegistrationAuthorityClientWrapper) NewAuthorization(ctx context.Conte
 }
 
 func (rac RegistrationAuthorityClientWrapper) NewCertificate(ctx context.Context, csr core.CertificateRequest, regID int64) (core.Certificate, error) {
-	response, err := rac.inner.NewCertificate(ctx, &rapb.NewCertificateRequest{Csr: csr.Bytes, RegID: &regID})
+	req := &rapb.NewCertificateRequest{Csr: csr.Bytes, RegID: &regID}
+	if csr.Profile != "" {
+		req.Profile = &csr.Profile
+	}
+	response, err := rac.inner.NewCertificate(ctx, req)
 	if err != nil {
 		return core.Certificate{}, unwrapError(err)
 	}
@@ -168,6 +172,22 @@ func (rac RegistrationAuthorityClientWrapper) DeactivateAuthorization(ctx contex
 	return nil
 }
 
+func (rac RegistrationAuthorityClientWrapper) CheckPreAuthorization(ctx context.Context, regID int64, names []string) (bool, error) {
+	response, err := rac.inner.CheckPreAuthorization(ctx, &rapb.CheckPreAuthorizationRequest{
+		RegID: &regID,
+		Names: names,
+	})
+	if err != nil {
+		return false, unwrapError(err)
+	}
+
+	if response == nil || response.PreAuthorized == nil {
+		return false, errIncompleteResponse
+	}
+
+	return *response.PreAuthorized, nil
+}
+
 func (rac RegistrationAuthorityClientWrapper) AdministrativelyRevokeCertificate(ctx context.Context, cert x509.Certificate, code revocation.Reason, adminName string) error {
 	reason := int64(code)
 	_, err := rac.inner.AdministrativelyRevokeCertificate(ctx, &rapb.AdministrativelyRevokeCertificateRequest{
@@ -229,7 +249,7 @@ func (ras *RegistrationAuthorityServerWrapper) NewCertificate(ctx context.Contex
 	if err != nil {
 		return nil, err
 	}
-	cert, err := ras.inner.NewCertificate(ctx, core.CertificateRequest{CSR: csr, Bytes: request.Csr}, *request.RegID)
+	cert, err := ras.inner.NewCertificate(ctx, core.CertificateRequest{CSR: csr, Bytes: request.Csr, Profile: request.GetProfile()}, *request.RegID)
 	if err != nil {
 		return nil, wrapError(err)
 	}
@@ -319,6 +339,17 @@ func (ras *RegistrationAuthorityServerWrapper) DeactivateAuthorization(ctx conte
 	return &corepb.Empty{}, nil
 }
 
+func (ras *RegistrationAuthorityServerWrapper) CheckPreAuthorization(ctx context.Context, request *rapb.CheckPreAuthorizationRequest) (*rapb.CheckPreAuthorizationResponse, error) {
+	if request == nil || request.RegID == nil {
+		return nil, errIncompleteRequest
+	}
+	preAuthorized, err := ras.inner.CheckPreAuthorization(ctx, *request.RegID, request.Names)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &rapb.CheckPreAuthorizationResponse{PreAuthorized: &preAuthorized}, nil
+}
+
 func (ras *RegistrationAuthorityServerWrapper) AdministrativelyRevokeCertificate(ctx context.Context, request *rapb.AdministrativelyRevokeCertificateRequest) (*corepb.Empty, error) {
 	if request == nil || request.Cert == nil || request.Code == nil || request.AdminName == nil {
 		return nil, errIncompleteRequest
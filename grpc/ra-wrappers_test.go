@@ -0,0 +1,110 @@
+package grpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/letsencrypt/boulder/core"
+	rapb "github.com/letsencrypt/boulder/ra/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// recordingRA is a core.RegistrationAuthority that records the certificate
+// requests and pre-authorization checks it's sent. Its other methods aren't
+// implemented.
+type recordingRA struct {
+	core.RegistrationAuthority
+	lastRequest core.CertificateRequest
+	lastNames   []string
+}
+
+func (ra *recordingRA) NewCertificate(_ context.Context, req core.CertificateRequest, regID int64) (core.Certificate, error) {
+	ra.lastRequest = req
+	return core.Certificate{
+		RegistrationID: regID,
+		Serial:         "00",
+		Digest:         "digest",
+		DER:            []byte{1},
+		Issued:         time.Unix(0, 0),
+		Expires:        time.Unix(0, 0),
+	}, nil
+}
+
+// CheckPreAuthorization reports registration 1 as pre-authorized, and fails
+// for registrations that don't exist.
+func (ra *recordingRA) CheckPreAuthorization(_ context.Context, regID int64, names []string) (bool, error) {
+	ra.lastNames = names
+	if regID > 2 {
+		return false, core.NotFoundError("no such registration")
+	}
+	return regID == 1, nil
+}
+
+// setupRAWrappers serves inner over gRPC on a local port, and returns a
+// client wrapper connected to it along with a function to shut both down.
+func setupRAWrappers(t *testing.T, inner core.RegistrationAuthority) (*RegistrationAuthorityClientWrapper, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	test.AssertNotError(t, err, "Failed to listen")
+	srv := grpc.NewServer()
+	rapb.RegisterRegistrationAuthorityServer(srv, NewRegistrationAuthorityServer(inner))
+	go srv.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	test.AssertNotError(t, err, "Failed to dial")
+	return NewRegistrationAuthorityClient(rapb.NewRegistrationAuthorityClient(conn)), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestNewCertificateProfile(t *testing.T) {
+	inner := &recordingRA{}
+	rac, stop := setupRAWrappers(t, inner)
+	defer stop()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com"},
+	}, key)
+	test.AssertNotError(t, err, "Failed to create CSR")
+
+	_, err = rac.NewCertificate(context.Background(), core.CertificateRequest{Bytes: csrDER}, 1)
+	test.AssertNotError(t, err, "NewCertificate failed")
+	test.AssertEquals(t, inner.lastRequest.Profile, "")
+
+	_, err = rac.NewCertificate(context.Background(), core.CertificateRequest{Bytes: csrDER, Profile: "short-lived"}, 1)
+	test.AssertNotError(t, err, "NewCertificate with a profile failed")
+	test.AssertEquals(t, inner.lastRequest.Profile, "short-lived")
+	test.AssertDeepEquals(t, inner.lastRequest.Bytes, csrDER)
+}
+
+func TestCheckPreAuthorization(t *testing.T) {
+	inner := &recordingRA{}
+	rac, stop := setupRAWrappers(t, inner)
+	defer stop()
+
+	names := []string{"example.com", "www.example.com"}
+	preAuthorized, err := rac.CheckPreAuthorization(context.Background(), 1, names)
+	test.AssertNotError(t, err, "CheckPreAuthorization failed")
+	test.Assert(t, preAuthorized, "Registration 1 wasn't pre-authorized")
+	test.AssertDeepEquals(t, inner.lastNames, names)
+
+	preAuthorized, err = rac.CheckPreAuthorization(context.Background(), 2, names)
+	test.AssertNotError(t, err, "CheckPreAuthorization failed")
+	test.Assert(t, !preAuthorized, "Registration 2 was pre-authorized")
+
+	_, err = rac.CheckPreAuthorization(context.Background(), 3, names)
+	test.AssertError(t, err, "CheckPreAuthorization succeeded for a missing registration")
+	_, ok := err.(core.NotFoundError)
+	test.Assert(t, ok, "Missing registration didn't return a NotFoundError")
+}
@@ -72,6 +72,23 @@ func (sac StorageAuthorityClientWrapper) GetAuthorization(ctx context.Context, a
 	return pbToAuthz(response)
 }
 
+func (sac StorageAuthorityClientWrapper) GetChallenge(ctx context.Context, id int64) (core.Challenge, string, error) {
+	response, err := sac.inner.GetChallenge(ctx, &sapb.ChallengeID{Id: &id})
+	if err != nil {
+		return core.Challenge{}, "", unwrapError(err)
+	}
+
+	if response == nil || response.AuthorizationID == nil {
+		return core.Challenge{}, "", errIncompleteResponse
+	}
+
+	chall, err := pbToChallenge(response.Challenge)
+	if err != nil {
+		return core.Challenge{}, "", err
+	}
+	return chall, *response.AuthorizationID, nil
+}
+
 func (sac StorageAuthorityClientWrapper) GetValidAuthorizations(ctx context.Context, regID int64, domains []string, now time.Time) (map[string]*core.Authorization, error) {
 	nowUnix := now.UnixNano()
 
@@ -469,6 +486,23 @@ func (sas StorageAuthorityServerWrapper) GetAuthorization(ctx context.Context, r
 	return authzToPB(authz)
 }
 
+func (sas StorageAuthorityServerWrapper) GetChallenge(ctx context.Context, request *sapb.ChallengeID) (*sapb.GetChallengeResponse, error) {
+	if request == nil || request.Id == nil {
+		return nil, errIncompleteRequest
+	}
+
+	chall, authzID, err := sas.inner.GetChallenge(ctx, *request.Id)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	pb, err := challengeToPB(chall)
+	if err != nil {
+		return nil, err
+	}
+	return &sapb.GetChallengeResponse{Challenge: pb, AuthorizationID: &authzID}, nil
+}
+
 func (sas StorageAuthorityServerWrapper) GetValidAuthorizations(ctx context.Context, request *sapb.GetValidAuthorizationsRequest) (*sapb.ValidAuthorizations, error) {
 	if request == nil || request.RegistrationID == nil || request.Domains == nil || request.Now == nil {
 		return nil, errIncompleteRequest
@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/mocks"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// setupSAWrappers serves inner over gRPC on a local port, and returns a
+// client wrapper connected to it along with a function to shut both down.
+func setupSAWrappers(t *testing.T, inner core.StorageAuthority) (*StorageAuthorityClientWrapper, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	test.AssertNotError(t, err, "Failed to listen")
+	srv := grpc.NewServer()
+	sapb.RegisterStorageAuthorityServer(srv, NewStorageAuthorityServer(inner))
+	go srv.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	test.AssertNotError(t, err, "Failed to dial")
+	return NewStorageAuthorityClient(sapb.NewStorageAuthorityClient(conn)), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestGetChallenge(t *testing.T) {
+	sa := mocks.NewStorageAuthority(clock.NewFake())
+	sac, stop := setupSAWrappers(t, sa)
+	defer stop()
+
+	expected, expectedAuthzID, err := sa.GetChallenge(context.Background(), 23)
+	test.AssertNotError(t, err, "Mock GetChallenge failed")
+
+	chall, authzID, err := sac.GetChallenge(context.Background(), 23)
+	test.AssertNotError(t, err, "GetChallenge failed")
+	test.AssertEquals(t, authzID, expectedAuthzID)
+	test.AssertEquals(t, chall.ID, expected.ID)
+	test.AssertEquals(t, chall.Type, expected.Type)
+	test.AssertEquals(t, chall.Status, expected.Status)
+	test.AssertEquals(t, chall.Token, expected.Token)
+
+	_, _, err = sac.GetChallenge(context.Background(), 99)
+	test.AssertError(t, err, "GetChallenge found a missing challenge")
+	_, ok := err.(core.NotFoundError)
+	test.Assert(t, ok, "Missing challenge didn't return a NotFoundError")
+}
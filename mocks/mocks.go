@@ -149,8 +149,13 @@ func (sa *StorageAuthority) GetRegistrationByKey(_ context.Context, jwk *jose.Js
 	}
 
 	if core.KeyDigestEquals(jwk, test4KeyPublic) {
-		// No key found
-		return core.Registration{ID: 5}, core.NoSuchRegistrationError("reg not found")
+		// Holds authorizations for bad.example.com
+		return core.Registration{
+			ID:        5,
+			Key:       jwk,
+			Agreement: agreementURL,
+			Status:    core.StatusValid,
+		}, nil
 	}
 
 	if core.KeyDigestEquals(jwk, testE1KeyPublic) {
@@ -206,6 +211,25 @@ func (sa *StorageAuthority) GetAuthorization(_ context.Context, id string) (core
 	return core.Authorization{}, fmt.Errorf("authz not found")
 }
 
+// GetChallenge is a mock. Challenge 23 belongs to the "valid" authorization
+// and challenge 32 to the "expired" one, where it appears as challenge 23.
+func (sa *StorageAuthority) GetChallenge(ctx context.Context, id int64) (core.Challenge, string, error) {
+	var authzID string
+	switch id {
+	case 23:
+		authzID = "valid"
+	case 32:
+		authzID = "expired"
+	default:
+		return core.Challenge{}, "", core.NotFoundError("challenge not found")
+	}
+	authz, err := sa.GetAuthorization(ctx, authzID)
+	if err != nil {
+		return core.Challenge{}, "", err
+	}
+	return authz.Challenges[0], authzID, nil
+}
+
 // RevokeAuthorizationsByDomain is a mock
 func (sa *StorageAuthority) RevokeAuthorizationsByDomain(_ context.Context, ident core.AcmeIdentifier) (int64, int64, error) {
 	return 0, 0, nil
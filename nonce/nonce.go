@@ -1,24 +1,80 @@
 package nonce
 
 import (
+	"container/heap"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
-	"math/big"
+	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/jmhodges/clock"
+
 	"github.com/letsencrypt/boulder/metrics"
 )
 
 // MaxUsed defines the maximum number of Nonces we're willing to hold in
 // memory.
 const MaxUsed = 65536
-const nonceLen = 32
 
-var errInvalidNonceLength = errors.New("invalid nonce length")
+// currentNonceVersion is the first byte of the nonces we generate. Version 3
+// nonces have a fully random 12 byte GCM nonce, encrypt the counter and the
+// mint time, and authenticate the version as additional data. Earlier nonces,
+// versioned or not, are no longer understood: the key is generated per
+// process, so none of them could still be valid anyway.
+const currentNonceVersion = 3
+
+// hmacNonceVersion is the first byte of nonces from an HMAC nonce service.
+// They are followed by the counter and mint time, as big-endian 8 byte
+// integers, and an HMAC-SHA256 over everything before it.
+const hmacNonceVersion = 2
+
+// olderHMACNonceVersions lists earlier versions of HMAC nonces that are still
+// valid. Services sharing a secret run different releases during a rolling
+// upgrade, so when hmacNonceVersion changes, the version it replaces stays
+// listed until no service can still be minting it. The MAC covers the version
+// byte, so an older version listed here must share the current layout; if the
+// layout changes, verify has to learn the old one too.
+var olderHMACNonceVersions = []byte{}
+
+const (
+	nonceLen     = 45
+	hmacNonceLen = 1 + 8 + 8 + sha256.Size
+)
+
+// minHMACSecretLen is the shortest secret NewHMACNonceService accepts.
+const minHMACSecretLen = 32
+
+// Failures to fill the nonce buffer are retried after a backoff that starts
+// at minBufferBackoff and doubles up to maxBufferBackoff.
+const (
+	minBufferBackoff = 10 * time.Millisecond
+	maxBufferBackoff = time.Second
+)
+
+// DefaultHMACNonceTTL is how long nonces from an HMAC nonce service are valid
+// unless TTL is set.
+const DefaultHMACNonceTTL = 10 * time.Minute
+
+// instanceSeparator ends the instance prefix of a nonce. It can't appear in
+// the base64url encoded remainder.
+const instanceSeparator = "."
+
+var validInstance = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var (
+	errInvalidNonceLength  = errors.New("invalid nonce length")
+	errUnknownNonceVersion = errors.New("unknown nonce version")
+	errBadNonceMAC         = errors.New("invalid nonce MAC")
+)
 
 // NonceService generates, cancels, and tracks Nonces.
 type NonceService struct {
@@ -29,10 +85,88 @@ type NonceService struct {
 	gcm      cipher.AEAD
 	maxUsed  int
 	stats    metrics.Scope
+	// buffer, if non-nil, holds nonces generated ahead of time by a
+	// background goroutine, which stops when stop is closed.
+	buffer   chan string
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// instance, if set, prefixes every nonce. Unless the service uses an
+	// HMAC key, only nonces with that prefix are valid.
+	instance string
+
+	// ExpiryGrace is how long nonces are still accepted after they expire:
+	// after they are forgotten because too many later nonces were used, or,
+	// at an HMAC nonce service, after their TTL. This spares clients a
+	// BadNonce for a nonce that expired moments before it arrived.
+	ExpiryGrace time.Duration
+	// advances records the values earliest moved on from within the last
+	// ExpiryGrace, oldest first, and when it did. Unused nonces above the
+	// oldest of them are accepted, once, though they're no longer above
+	// earliest. graceUsed holds the nonces no longer above earliest that have
+	// been used; it was last swept of those below the grace at graceSwept.
+	advances   []earliestAdvance
+	graceUsed  map[int64]bool
+	graceSwept time.Time
+
+	// hmacKey, if set, makes nonces an HMAC over a counter and mint time
+	// rather than an encrypted counter, so that any service sharing the key
+	// can check them without shared state. See NewHMACNonceService.
+	hmacKey []byte
+	// TTL is how long nonces from an HMAC nonce service are valid after they
+	// were minted.
+	TTL time.Duration
+	// hmacUsed holds the MACs of HMAC nonces used here, and hmacExpiries the
+	// same MACs ordered by when the nonces expire, so that they can be
+	// forgotten as they do. Once maxUsed unexpired nonces have been used,
+	// further ones are refused until some expire.
+	hmacUsed     map[string]bool
+	hmacExpiries usedMACs
+	clk          clock.Clock
+}
+
+// usedMAC is the MAC of a used HMAC nonce and when the nonce expires.
+type usedMAC struct {
+	mac     string
+	expires time.Time
+}
+
+// usedMACs is a heap of used MACs, soonest to expire first.
+type usedMACs []usedMAC
+
+func (u usedMACs) Len() int            { return len(u) }
+func (u usedMACs) Less(i, j int) bool  { return u[i].expires.Before(u[j].expires) }
+func (u usedMACs) Swap(i, j int)       { u[i], u[j] = u[j], u[i] }
+func (u *usedMACs) Push(x interface{}) { *u = append(*u, x.(usedMAC)) }
+func (u *usedMACs) Pop() interface{} {
+	old := *u
+	last := old[len(old)-1]
+	*u = old[:len(old)-1]
+	return last
+}
+
+// earliestAdvance records that a NonceService's earliest counter moved on from
+// prev at a time.
+type earliestAdvance struct {
+	prev int64
+	at   time.Time
 }
 
 // NewNonceService constructs a NonceService with defaults
 func NewNonceService(scope metrics.Scope) (*NonceService, error) {
+	return NewBufferedNonceService(scope, clock.Default(), 0)
+}
+
+// NewBufferedNonceService constructs a NonceService that keeps up to
+// bufferSize nonces generated ahead of time, so that under load Nonce() is a
+// channel receive rather than a trip through the service lock and the cipher.
+// A bufferSize of zero disables buffering. Nonces record their mint time by
+// clk; buffered nonces are minted when they enter the buffer, not when they
+// are handed out.
+func NewBufferedNonceService(scope metrics.Scope, clk clock.Clock, bufferSize int) (*NonceService, error) {
+	if bufferSize < 0 {
+		return nil, errors.New("nonce buffer size must not be negative")
+	}
 	scope = scope.NewScope("NonceService")
 	key := make([]byte, 16)
 	if _, err := rand.Read(key); err != nil {
@@ -48,94 +182,413 @@ func NewNonceService(scope metrics.Scope) (*NonceService, error) {
 		panic("Failure in NewGCM: " + err.Error())
 	}
 
+	ns := &NonceService{
+		earliest:  0,
+		latest:    0,
+		used:      make(map[int64]bool, MaxUsed),
+		graceUsed: make(map[int64]bool),
+		gcm:       gcm,
+		maxUsed:   MaxUsed,
+		stats:     scope,
+		clk:       clk,
+	}
+	if bufferSize > 0 {
+		ns.buffer = make(chan string, bufferSize)
+		ns.stop = make(chan struct{})
+		go ns.fillBuffer()
+	}
+	return ns, nil
+}
+
+// NewHMACNonceService constructs a NonceService whose nonces carry a counter
+// and their mint time, authenticated with an HMAC keyed by secret. Any
+// service constructed with the same secret accepts them, so several WFEs
+// behind a load balancer can share clients. Nonces older than TTL are
+// rejected. A nonce used here won't be accepted here again, but may be used
+// once more at each other service sharing the secret until it expires. At
+// most MaxUsed nonces are accepted here per TTL. Mint times and the TTL are
+// measured by clk.
+func NewHMACNonceService(scope metrics.Scope, clk clock.Clock, secret []byte) (*NonceService, error) {
+	if len(secret) < minHMACSecretLen {
+		return nil, fmt.Errorf("nonce HMAC secret must be at least %d bytes", minHMACSecretLen)
+	}
+	key := make([]byte, len(secret))
+	copy(key, secret)
 	return &NonceService{
-		earliest: 0,
-		latest:   0,
-		used:     make(map[int64]bool, MaxUsed),
-		gcm:      gcm,
 		maxUsed:  MaxUsed,
-		stats:    scope,
+		stats:    scope.NewScope("NonceService"),
+		hmacKey:  key,
+		TTL:      DefaultHMACNonceTTL,
+		hmacUsed: make(map[string]bool),
+		clk:      clk,
 	}, nil
 }
 
-func (ns *NonceService) encrypt(counter int64) (string, error) {
-	// Generate a nonce with upper 4 bytes zero
-	nonce := make([]byte, 12)
-	for i := 0; i < 4; i++ {
-		nonce[i] = 0
+// fillBuffer generates nonces into ns.buffer until ns.stop is closed,
+// blocking whenever the buffer is full and backing off when generating fails.
+func (ns *NonceService) fillBuffer() {
+	var backoff time.Duration
+	for {
+		nonce, err := ns.generate()
+		if err != nil {
+			ns.stats.Inc("Buffer.GenerateFailed", 1)
+			if backoff == 0 {
+				backoff = minBufferBackoff
+			} else if backoff *= 2; backoff > maxBufferBackoff {
+				backoff = maxBufferBackoff
+			}
+			select {
+			case <-ns.clk.After(backoff):
+			case <-ns.stop:
+				return
+			}
+			continue
+		}
+		backoff = 0
+		select {
+		case ns.buffer <- nonce:
+		case <-ns.stop:
+			return
+		}
 	}
-	if _, err := rand.Read(nonce[4:]); err != nil {
+}
+
+// Close stops generating nonces ahead of time. Nonce still works afterwards,
+// but generates each nonce when it's asked for.
+func (ns *NonceService) Close() {
+	if ns.stop == nil {
+		return
+	}
+	ns.stopOnce.Do(func() { close(ns.stop) })
+}
+
+func (ns *NonceService) encrypt(counter int64, minted time.Time) (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
 		return "", err
 	}
 
-	// Encode counter to plaintext
-	pt := make([]byte, 8)
-	ctr := big.NewInt(counter)
-	pad := 8 - len(ctr.Bytes())
-	copy(pt[pad:], ctr.Bytes())
+	// Encode counter and mint time to plaintext
+	pt := make([]byte, 16)
+	binary.BigEndian.PutUint64(pt[:8], uint64(counter))
+	binary.BigEndian.PutUint64(pt[8:], uint64(minted.UnixNano()))
 
 	// Encrypt
-	ret := make([]byte, nonceLen)
-	ct := ns.gcm.Seal(nil, nonce, pt, nil)
-	copy(ret, nonce[4:])
-	copy(ret[8:], ct)
+	version := []byte{currentNonceVersion}
+	ret := make([]byte, 0, nonceLen)
+	ret = append(ret, version...)
+	ret = append(ret, nonce...)
+	ret = ns.gcm.Seal(ret, nonce, pt, version)
 	return base64.RawURLEncoding.EncodeToString(ret), nil
 }
 
-func (ns *NonceService) decrypt(nonce string) (int64, error) {
+// decrypt returns the counter in nonce and the time it was minted.
+func (ns *NonceService) decrypt(nonce string) (int64, time.Time, error) {
 	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
 	if err != nil {
-		return 0, err
+		return 0, time.Time{}, err
+	}
+	if len(decoded) == 0 {
+		return 0, time.Time{}, errInvalidNonceLength
+	}
+	if decoded[0] != currentNonceVersion {
+		return 0, time.Time{}, errUnknownNonceVersion
 	}
 	if len(decoded) != nonceLen {
-		return 0, errInvalidNonceLength
+		return 0, time.Time{}, errInvalidNonceLength
 	}
 
-	n := make([]byte, 12)
-	for i := 0; i < 4; i++ {
-		n[i] = 0
+	pt, err := ns.gcm.Open(nil, decoded[1:13], decoded[13:], decoded[:1])
+	if err != nil {
+		return 0, time.Time{}, err
 	}
-	copy(n[4:], decoded[:8])
 
-	pt, err := ns.gcm.Open(nil, n, decoded[8:], nil)
-	if err != nil {
-		return 0, err
+	counter := int64(binary.BigEndian.Uint64(pt[:8]))
+	minted := time.Unix(0, int64(binary.BigEndian.Uint64(pt[8:])))
+	return counter, minted, nil
+}
+
+// SetInstance makes the service prefix its nonces with instance, so that when
+// several instances share a client it's clear which one minted a nonce that
+// was later rejected. Nonces without the prefix are no longer valid, except at
+// an HMAC nonce service, whose nonces are meant to be valid at every service
+// sharing its key: there the prefix only labels them. It must be called
+// before any nonces are handed out.
+func (ns *NonceService) SetInstance(instance string) error {
+	if !validInstance.MatchString(instance) {
+		return errors.New("nonce instance must be non-empty and only contain letters, digits, '-' and '_'")
 	}
+	ns.instance = instance
+	return nil
+}
+
+// Buffered returns true if the service generates nonces ahead of time, in
+// which case their mint times are earlier than when they were handed out.
+func (ns *NonceService) Buffered() bool {
+	return ns.buffer != nil
+}
 
-	ctr := big.NewInt(0)
-	ctr.SetBytes(pt)
-	return ctr.Int64(), nil
+// Instance returns the instance prefix of nonce, or "" if it has none.
+func Instance(nonce string) string {
+	if i := strings.Index(nonce, instanceSeparator); i >= 0 {
+		return nonce[:i]
+	}
+	return ""
 }
 
 // Nonce provides a new Nonce.
 func (ns *NonceService) Nonce() (string, error) {
+	defer ns.stats.Inc("Generated", 1)
+	var nonce string
+	if ns.buffer != nil {
+		select {
+		case nonce = <-ns.buffer:
+		case <-ns.stop:
+		}
+	}
+	if nonce == "" {
+		var err error
+		if nonce, err = ns.generate(); err != nil {
+			return "", err
+		}
+	}
+	if ns.instance != "" {
+		nonce = ns.instance + instanceSeparator + nonce
+	}
+	return nonce, nil
+}
+
+// generate allocates the next counter value and encrypts it into a nonce.
+func (ns *NonceService) generate() (string, error) {
 	ns.mu.Lock()
 	ns.latest++
 	latest := ns.latest
 	ns.mu.Unlock()
-	defer ns.stats.Inc("Generated", 1)
-	return ns.encrypt(latest)
+	if ns.hmacKey != nil {
+		return ns.sign(latest, ns.clk.Now()), nil
+	}
+	return ns.encrypt(latest, ns.clk.Now())
+}
+
+func (ns *NonceService) mac(msg []byte) []byte {
+	h := hmac.New(sha256.New, ns.hmacKey)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// sign makes an HMAC nonce from counter and its mint time.
+func (ns *NonceService) sign(counter int64, minted time.Time) string {
+	ret := make([]byte, hmacNonceLen-sha256.Size, hmacNonceLen)
+	ret[0] = hmacNonceVersion
+	binary.BigEndian.PutUint64(ret[1:9], uint64(counter))
+	binary.BigEndian.PutUint64(ret[9:17], uint64(minted.UnixNano()))
+	ret = append(ret, ns.mac(ret)...)
+	return base64.RawURLEncoding.EncodeToString(ret)
+}
+
+// verify checks the MAC of an HMAC nonce, returning the MAC and the time the
+// nonce was minted.
+func (ns *NonceService) verify(nonce string) (string, time.Time, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if len(decoded) == 0 {
+		return "", time.Time{}, errInvalidNonceLength
+	}
+	if !acceptedHMACNonceVersion(decoded[0]) {
+		return "", time.Time{}, errUnknownNonceVersion
+	}
+	if len(decoded) != hmacNonceLen {
+		return "", time.Time{}, errInvalidNonceLength
+	}
+	mac := decoded[hmacNonceLen-sha256.Size:]
+	if !hmac.Equal(mac, ns.mac(decoded[:hmacNonceLen-sha256.Size])) {
+		return "", time.Time{}, errBadNonceMAC
+	}
+	minted := time.Unix(0, int64(binary.BigEndian.Uint64(decoded[9:17])))
+	return string(mac), minted, nil
+}
+
+// acceptedHMACNonceVersion returns true if HMAC nonces of version are valid.
+func acceptedHMACNonceVersion(version byte) bool {
+	if version == hmacNonceVersion {
+		return true
+	}
+	for _, v := range olderHMACNonceVersions {
+		if version == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validHMAC is Valid for an HMAC nonce service.
+func (ns *NonceService) validHMAC(nonce string) bool {
+	mac, minted, err := ns.verify(nonce)
+	if err == errUnknownNonceVersion {
+		ns.stats.Inc("Invalid.Version", 1)
+		return false
+	} else if err != nil {
+		ns.stats.Inc("Invalid.Decrypt", 1)
+		return false
+	}
+
+	now := ns.clk.Now()
+	expires := minted.Add(ns.TTL)
+	if ns.ExpiryGrace > 0 {
+		expires = expires.Add(ns.ExpiryGrace)
+	}
+	if !now.Before(expires) {
+		ns.stats.Inc("Invalid.Expired", 1)
+		return false
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	// Expired nonces are rejected above, so there's no need to remember
+	// them.
+	for len(ns.hmacExpiries) > 0 && !now.Before(ns.hmacExpiries[0].expires) {
+		delete(ns.hmacUsed, heap.Pop(&ns.hmacExpiries).(usedMAC).mac)
+	}
+	if ns.hmacUsed[mac] {
+		ns.stats.Inc("Invalid.AlreadyUsed", 1)
+		return false
+	}
+	if len(ns.hmacUsed) >= ns.maxUsed {
+		ns.stats.Inc("Invalid.UsedFull", 1)
+		return false
+	}
+	ns.hmacUsed[mac] = true
+	heap.Push(&ns.hmacExpiries, usedMAC{mac: mac, expires: expires})
+
+	ns.stats.Inc("Valid", 1)
+	return true
 }
 
 // minUsed returns the lowest key in the used map. Requires that a lock be held
 // by caller.
 func (ns *NonceService) minUsed() int64 {
-	s := time.Now()
+	s := ns.clk.Now()
 	min := ns.latest
 	for t := range ns.used {
 		if t < min {
 			min = t
 		}
 	}
-	ns.stats.TimingDuration("LinearScan.Latency", time.Since(s))
+	ns.stats.TimingDuration("LinearScan.Latency", ns.clk.Since(s))
 	return min
 }
 
+// advanceEarliest forgets the lowest used nonce, moving earliest up to it,
+// and records the move for ExpiryGrace. Requires that a lock be held by
+// caller.
+func (ns *NonceService) advanceEarliest() {
+	prev := ns.earliest
+	ns.earliest = ns.minUsed()
+	delete(ns.used, ns.earliest)
+	ns.pruneGrace()
+	if ns.ExpiryGrace > 0 {
+		ns.advances = append(ns.advances, earliestAdvance{prev: prev, at: ns.clk.Now()})
+		// earliest was used, so it mustn't be accepted within the grace.
+		ns.graceUsed[ns.earliest] = true
+	}
+}
+
+// pruneGrace forgets the advances of earliest, and the nonces used below it,
+// that are beyond ExpiryGrace. Requires that a lock be held by caller.
+func (ns *NonceService) pruneGrace() {
+	now := ns.clk.Now()
+	expired := 0
+	for expired < len(ns.advances) && now.Sub(ns.advances[expired].at) >= ns.ExpiryGrace {
+		expired++
+	}
+	ns.advances = ns.advances[expired:]
+	// Used nonces at or below the oldest advance are rejected regardless, so
+	// they're only swept out once per grace period.
+	if now.Sub(ns.graceSwept) < ns.ExpiryGrace {
+		return
+	}
+	ns.graceSwept = now
+	floor := ns.earliest
+	if len(ns.advances) > 0 {
+		floor = ns.advances[0].prev
+	}
+	for u := range ns.graceUsed {
+		if u <= floor {
+			delete(ns.graceUsed, u)
+		}
+	}
+}
+
+// withinExpiryGrace returns true if c, which is no later than earliest, was
+// forgotten less than ExpiryGrace ago and hasn't been used. Requires that a
+// lock be held by caller.
+func (ns *NonceService) withinExpiryGrace(c int64) bool {
+	ns.pruneGrace()
+	if len(ns.advances) == 0 {
+		return false
+	}
+	return c > ns.advances[0].prev && !ns.graceUsed[c]
+}
+
+// stripInstance removes the instance prefix from nonce, returning false if
+// the nonce isn't valid here because of it. An HMAC nonce service accepts any
+// prefix, and none.
+func (ns *NonceService) stripInstance(nonce string) (string, bool) {
+	if ns.hmacKey != nil {
+		if i := strings.Index(nonce, instanceSeparator); i >= 0 {
+			return nonce[i+len(instanceSeparator):], true
+		}
+		return nonce, true
+	}
+	if ns.instance != "" {
+		if Instance(nonce) != ns.instance {
+			return "", false
+		}
+		return nonce[len(ns.instance)+len(instanceSeparator):], true
+	}
+	return nonce, true
+}
+
+// Minted returns the time nonce was minted. The nonce must come from this
+// service or, for HMAC nonces, one sharing its secret. It returns false if
+// the nonce is not authentic. It neither uses the nonce up nor checks that
+// it's unused, so it belongs alongside a call to Valid.
+func (ns *NonceService) Minted(nonce string) (time.Time, bool) {
+	nonce, ok := ns.stripInstance(nonce)
+	if !ok {
+		return time.Time{}, false
+	}
+	var minted time.Time
+	var err error
+	if ns.hmacKey != nil {
+		_, minted, err = ns.verify(nonce)
+	} else {
+		_, minted, err = ns.decrypt(nonce)
+	}
+	if err != nil {
+		return time.Time{}, false
+	}
+	return minted, true
+}
+
 // Valid determines whether the provided Nonce string is valid, returning
 // true if so.
 func (ns *NonceService) Valid(nonce string) bool {
-	c, err := ns.decrypt(nonce)
-	if err != nil {
+	nonce, ok := ns.stripInstance(nonce)
+	if !ok {
+		ns.stats.Inc("Invalid.Instance", 1)
+		return false
+	}
+	if ns.hmacKey != nil {
+		return ns.validHMAC(nonce)
+	}
+	c, _, err := ns.decrypt(nonce)
+	if err == errUnknownNonceVersion {
+		ns.stats.Inc("Invalid.Version", 1)
+		return false
+	} else if err != nil {
 		ns.stats.Inc("Invalid.Decrypt", 1)
 		return false
 	}
@@ -148,6 +601,11 @@ func (ns *NonceService) Valid(nonce string) bool {
 	}
 
 	if c <= ns.earliest {
+		if ns.withinExpiryGrace(c) {
+			ns.graceUsed[c] = true
+			ns.stats.Inc("Valid.ExpiryGrace", 1)
+			return true
+		}
 		ns.stats.Inc("Invalid.TooLow", 1)
 		return false
 	}
@@ -160,8 +618,7 @@ func (ns *NonceService) Valid(nonce string) bool {
 	ns.used[c] = true
 	if len(ns.used) > ns.maxUsed {
 		ns.stats.Inc("LinearScan.Full", 1)
-		ns.earliest = ns.minUsed()
-		delete(ns.used, ns.earliest)
+		ns.advanceEarliest()
 	}
 
 	ns.stats.Inc("Valid", 1)
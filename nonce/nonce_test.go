@@ -1,8 +1,13 @@
 package nonce
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
 
 	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/test"
@@ -50,6 +55,56 @@ func TestRejectUnknown(t *testing.T) {
 	test.Assert(t, !ns2.Valid(n), "Accepted a foreign nonce")
 }
 
+func TestNonceVersions(t *testing.T) {
+	ns, err := NewNonceService(metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Could not create nonce service")
+
+	// Current version
+	n, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	decoded, err := base64.RawURLEncoding.DecodeString(n)
+	test.AssertNotError(t, err, "Could not decode nonce")
+	test.AssertEquals(t, decoded[0], byte(currentNonceVersion))
+	test.Assert(t, ns.Valid(n), "Rejected a current version nonce")
+
+	// Unknown versions are never valid, even if otherwise well formed
+	n, err = ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	decoded, err = base64.RawURLEncoding.DecodeString(n)
+	test.AssertNotError(t, err, "Could not decode nonce")
+	decoded[0] = 7
+	test.Assert(t, !ns.Valid(base64.RawURLEncoding.EncodeToString(decoded)), "Accepted a nonce of unknown version")
+	test.Assert(t, ns.Valid(n), "Rejected a current version nonce")
+}
+
+func TestNonceInstance(t *testing.T) {
+	ns, err := NewNonceService(metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Could not create nonce service")
+	test.AssertError(t, ns.SetInstance(""), "Accepted an empty instance")
+	test.AssertError(t, ns.SetInstance("wfe.a"), "Accepted an instance containing the separator")
+
+	// Unprefixed nonces have no instance
+	n, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.AssertEquals(t, Instance(n), "")
+
+	test.AssertNotError(t, ns.SetInstance("wfe-a"), "Rejected a valid instance")
+	test.Assert(t, !ns.Valid(n), "Accepted a nonce without the instance prefix")
+	n, err = ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.AssertEquals(t, Instance(n), "wfe-a")
+	test.Assert(t, ns.Valid(n), "Rejected a nonce with the instance prefix")
+
+	// Another instance's nonces are rejected
+	other, err := NewNonceService(metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Could not create nonce service")
+	test.AssertNotError(t, other.SetInstance("wfe-b"), "Rejected a valid instance")
+	n, err = other.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.AssertEquals(t, Instance(n), "wfe-b")
+	test.Assert(t, !ns.Valid(n), "Accepted another instance's nonce")
+}
+
 func TestRejectTooLate(t *testing.T) {
 	ns, err := NewNonceService(metrics.NewNoopScope())
 	test.AssertNotError(t, err, "Could not create nonce service")
@@ -80,3 +135,299 @@ func TestRejectTooEarly(t *testing.T) {
 	test.Assert(t, ns.Valid(n1), "Rejected a valid nonce")
 	test.Assert(t, !ns.Valid(n0), "Accepted a nonce that we should have forgotten")
 }
+
+func TestExpiryGrace(t *testing.T) {
+	fc := clock.NewFake()
+	ns, err := NewBufferedNonceService(metrics.NewNoopScope(), fc, 0)
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.maxUsed = 2
+	ns.ExpiryGrace = time.Minute
+
+	nonces := make([]string, 7)
+	for i := range nonces {
+		nonces[i], err = ns.Nonce()
+		test.AssertNotError(t, err, "Could not create nonce")
+	}
+
+	// Using the fourth, fifth and sixth nonce forgets the fourth, which has
+	// been used, and the first three, which haven't.
+	test.Assert(t, ns.Valid(nonces[3]), "Rejected a valid nonce")
+	test.Assert(t, ns.Valid(nonces[4]), "Rejected a valid nonce")
+	test.Assert(t, ns.Valid(nonces[5]), "Rejected a valid nonce")
+
+	// Within the grace, the expired nonces are accepted, but only once.
+	test.Assert(t, ns.Valid(nonces[2]), "Rejected a nonce within the expiry grace")
+	test.Assert(t, !ns.Valid(nonces[2]), "Accepted a nonce twice within the expiry grace")
+	// The nonce that was forgotten because it had been used is not.
+	test.Assert(t, !ns.Valid(nonces[3]), "Accepted a used nonce within the expiry grace")
+
+	// Forgetting more nonces doesn't cut the grace of those forgotten
+	// earlier short.
+	fc.Add(30 * time.Second)
+	test.Assert(t, ns.Valid(nonces[6]), "Rejected a valid nonce")
+	test.Assert(t, ns.Valid(nonces[1]), "Rejected a nonce within the expiry grace")
+	test.Assert(t, !ns.Valid(nonces[4]), "Accepted a used nonce within the expiry grace")
+
+	// Beyond the grace, they're rejected.
+	fc.Add(45 * time.Second)
+	test.Assert(t, !ns.Valid(nonces[0]), "Accepted a nonce beyond the expiry grace")
+	// and eventually forgotten entirely.
+	fc.Add(time.Minute)
+	test.Assert(t, !ns.Valid(nonces[1]), "Accepted a nonce beyond the expiry grace")
+	test.AssertEquals(t, len(ns.advances), 0)
+	test.AssertEquals(t, len(ns.graceUsed), 0)
+
+	// As they are without any grace.
+	ns, err = NewBufferedNonceService(metrics.NewNoopScope(), fc, 0)
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.maxUsed = 1
+	n0, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	n1, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	n2, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.Assert(t, ns.Valid(n1), "Rejected a valid nonce")
+	test.Assert(t, ns.Valid(n2), "Rejected a valid nonce")
+	test.Assert(t, !ns.Valid(n0), "Accepted an expired nonce without an expiry grace")
+}
+
+func TestHMACExpiryGrace(t *testing.T) {
+	fc := clock.NewFake()
+	ns, err := NewHMACNonceService(metrics.NewNoopScope(), fc, []byte("0123456789abcdef0123456789abcdef"))
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.TTL = time.Minute
+	ns.ExpiryGrace = 30 * time.Second
+
+	n1, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	n2, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+
+	// Within the grace after their TTL, nonces are accepted, once
+	fc.Add(80 * time.Second)
+	test.Assert(t, ns.Valid(n1), "Rejected a nonce within the expiry grace")
+	test.Assert(t, !ns.Valid(n1), "Accepted a nonce twice within the expiry grace")
+
+	// but not beyond it
+	fc.Add(10 * time.Second)
+	test.Assert(t, !ns.Valid(n2), "Accepted a nonce beyond the expiry grace")
+}
+
+func TestBufferedNoncesUnique(t *testing.T) {
+	ns, err := NewBufferedNonceService(metrics.NewNoopScope(), clock.Default(), 16)
+	test.AssertNotError(t, err, "Could not create nonce service")
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		n, err := ns.Nonce()
+		test.AssertNotError(t, err, "Could not create nonce")
+		test.Assert(t, !seen[n], fmt.Sprintf("Buffered nonce %s handed out twice", n))
+		seen[n] = true
+	}
+	for n := range seen {
+		test.Assert(t, ns.Valid(n), fmt.Sprintf("Did not recognize buffered nonce %s", n))
+	}
+}
+
+func TestBufferedNonceClose(t *testing.T) {
+	ns, err := NewBufferedNonceService(metrics.NewNoopScope(), clock.Default(), 4)
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.Close()
+	ns.Close()
+
+	// Nonces are still handed out, and valid, once the buffer has stopped
+	for i := 0; i < 10; i++ {
+		n, err := ns.Nonce()
+		test.AssertNotError(t, err, "Could not create nonce")
+		test.Assert(t, ns.Valid(n), "Rejected a nonce from a closed service")
+	}
+
+	// Closing an unbuffered service does nothing
+	ns, err = NewNonceService(metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.Close()
+	_, err = ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+}
+
+func TestBufferedNonceSizeNegative(t *testing.T) {
+	_, err := NewBufferedNonceService(metrics.NewNoopScope(), clock.Default(), -1)
+	test.AssertError(t, err, "Created nonce service with negative buffer size")
+}
+
+func TestMinted(t *testing.T) {
+	fc := clock.NewFake()
+	ns, err := NewBufferedNonceService(metrics.NewNoopScope(), fc, 0)
+	test.AssertNotError(t, err, "Could not create nonce service")
+	hmacNS, err := NewHMACNonceService(metrics.NewNoopScope(), fc, []byte("0123456789abcdef0123456789abcdef"))
+	test.AssertNotError(t, err, "Could not create nonce service")
+
+	for _, ns := range []*NonceService{ns, hmacNS} {
+		minted := fc.Now()
+		n, err := ns.Nonce()
+		test.AssertNotError(t, err, "Could not create nonce")
+		fc.Add(time.Minute)
+		m, ok := ns.Minted(n)
+		test.Assert(t, ok, "No mint time for a fresh nonce")
+		test.Assert(t, m.Equal(minted), fmt.Sprintf("Nonce minted at %s, not %s", m, minted))
+		_, ok = ns.Minted("asdf" + n)
+		test.Assert(t, !ok, "Mint time for a malformed nonce")
+	}
+}
+
+func TestHMACNonce(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ns1, err := NewHMACNonceService(metrics.NewNoopScope(), clock.Default(), secret)
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns2, err := NewHMACNonceService(metrics.NewNoopScope(), clock.Default(), secret)
+	test.AssertNotError(t, err, "Could not create nonce service")
+	other, err := NewHMACNonceService(metrics.NewNoopScope(), clock.Default(), []byte("fedcba9876543210fedcba9876543210"))
+	test.AssertNotError(t, err, "Could not create nonce service")
+
+	n, err := ns1.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.Assert(t, ns2.Valid(n), "Rejected a nonce from a service with the same secret")
+	test.Assert(t, !ns2.Valid(n), "Recognized the same nonce twice")
+	test.Assert(t, !other.Valid(n), "Accepted a nonce from a service with a different secret")
+
+	encrypted, err := NewNonceService(metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Could not create nonce service")
+	n, err = encrypted.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.Assert(t, !ns1.Valid(n), "Accepted an encrypted nonce")
+	n, err = ns1.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.Assert(t, !encrypted.Valid(n), "Accepted an HMAC nonce")
+
+	// Tampering with the counter or mint time invalidates the MAC.
+	decoded, err := base64.RawURLEncoding.DecodeString(n)
+	test.AssertNotError(t, err, "Could not decode nonce")
+	decoded[16]++
+	test.Assert(t, !ns1.Valid(base64.RawURLEncoding.EncodeToString(decoded)), "Accepted a tampered nonce")
+	test.Assert(t, ns1.Valid(n), "Rejected a valid nonce")
+
+	// Instance prefixes label HMAC nonces, but don't stop them being valid
+	// at other services sharing the secret.
+	test.AssertNotError(t, ns1.SetInstance("wfe-a"), "Rejected a valid instance")
+	test.AssertNotError(t, ns2.SetInstance("wfe-b"), "Rejected a valid instance")
+	n, err = ns1.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.AssertEquals(t, Instance(n), "wfe-a")
+	_, ok := ns2.Minted(n)
+	test.Assert(t, ok, "No mint time for another instance's nonce")
+	test.Assert(t, ns2.Valid(n), "Rejected another instance's nonce")
+}
+
+func TestHMACNonceVersions(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ns, err := NewHMACNonceService(metrics.NewNoopScope(), clock.Default(), secret)
+	test.AssertNotError(t, err, "Could not create nonce service")
+
+	// oldVersion re-signs a current nonce as version 1, as a service from
+	// before the format changed would have minted it.
+	oldVersion := func() string {
+		n, err := ns.Nonce()
+		test.AssertNotError(t, err, "Could not create nonce")
+		decoded, err := base64.RawURLEncoding.DecodeString(n)
+		test.AssertNotError(t, err, "Could not decode nonce")
+		test.AssertEquals(t, decoded[0], byte(hmacNonceVersion))
+		decoded[0] = 1
+		signed := decoded[:hmacNonceLen-sha256.Size]
+		return base64.RawURLEncoding.EncodeToString(append(signed, ns.mac(signed)...))
+	}
+
+	// Older versions are valid during the transition, while they're listed
+	defer func(older []byte) { olderHMACNonceVersions = older }(olderHMACNonceVersions)
+	olderHMACNonceVersions = []byte{1}
+	n := oldVersion()
+	test.Assert(t, ns.Valid(n), "Rejected an older version nonce during the transition")
+	test.Assert(t, !ns.Valid(n), "Recognized the same older version nonce twice")
+
+	// and unknown once it's over
+	olderHMACNonceVersions = nil
+	test.Assert(t, !ns.Valid(oldVersion()), "Accepted an older version nonce after the transition")
+
+	n, err = ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.Assert(t, ns.Valid(n), "Rejected a current version nonce")
+}
+
+func TestHMACNonceTTL(t *testing.T) {
+	fc := clock.NewFake()
+	ns, err := NewHMACNonceService(metrics.NewNoopScope(), fc, []byte("0123456789abcdef0123456789abcdef"))
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.TTL = time.Minute
+
+	fresh, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	stale, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	fc.Add(59 * time.Second)
+	test.Assert(t, ns.Valid(fresh), "Rejected a nonce within its TTL")
+	fc.Add(time.Second)
+	test.Assert(t, !ns.Valid(stale), "Accepted a nonce past its TTL")
+}
+
+func TestHMACNonceUsedBound(t *testing.T) {
+	fc := clock.NewFake()
+	ns, err := NewHMACNonceService(metrics.NewNoopScope(), fc, []byte("0123456789abcdef0123456789abcdef"))
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.TTL = time.Minute
+	ns.maxUsed = 2
+
+	n1, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	fc.Add(10 * time.Second)
+	n2, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	n3, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+
+	// Used nonces are remembered, up to maxUsed of them
+	test.Assert(t, ns.Valid(n2), "Rejected a fresh nonce")
+	test.Assert(t, ns.Valid(n1), "Rejected a fresh nonce")
+	test.Assert(t, !ns.Valid(n3), "Accepted a nonce with too many used nonces remembered")
+	test.AssertEquals(t, len(ns.hmacUsed), 2)
+
+	// and forgotten as they expire, soonest first
+	fc.Add(50 * time.Second)
+	test.Assert(t, ns.Valid(n3), "Rejected a nonce once a used one expired")
+	test.AssertEquals(t, len(ns.hmacUsed), 2)
+	test.AssertEquals(t, len(ns.hmacExpiries), 2)
+	test.Assert(t, !ns.Valid(n2), "Accepted a used nonce")
+	fc.Add(10 * time.Second)
+	n4, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.Assert(t, ns.Valid(n4), "Rejected a fresh nonce")
+	test.AssertEquals(t, len(ns.hmacUsed), 1)
+	test.AssertEquals(t, len(ns.hmacExpiries), 1)
+}
+
+func TestHMACNonceSecretTooShort(t *testing.T) {
+	_, err := NewHMACNonceService(metrics.NewNoopScope(), clock.Default(), []byte("short"))
+	test.AssertError(t, err, "Created nonce service with a short secret")
+}
+
+func benchmarkNonce(b *testing.B, bufferSize int) {
+	ns, err := NewBufferedNonceService(metrics.NewNoopScope(), clock.Default(), bufferSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := ns.Nonce(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkNonce(b *testing.B) {
+	benchmarkNonce(b, 0)
+}
+
+func BenchmarkBufferedNonce(b *testing.B) {
+	benchmarkNonce(b, 1024)
+}
@@ -31,6 +31,14 @@ type AuthorityImpl struct {
 
 	enabledChallenges map[string]bool
 	pseudoRNG         *rand.Rand
+
+	// challengesByIdentifier restricts the challenge types offered for names
+	// under each of its domains. See SetChallengesByIdentifier.
+	challengesByIdentifier map[string]map[string]bool
+
+	// rejectPrivateSuffixes forbids names that are exactly a suffix in the
+	// private section of the Public Suffix List. See SetRejectPrivateSuffixes.
+	rejectPrivateSuffixes bool
 }
 
 // New constructs a Policy Authority.
@@ -48,6 +56,59 @@ func New(challengeTypes map[string]bool) (*AuthorityImpl, error) {
 	return &pa, nil
 }
 
+// SetRejectPrivateSuffixes makes WillingToIssue forbid names that are exactly
+// a suffix in the private section of the Public Suffix List, like
+// "github.io". Names that are exactly an ICANN suffix are always forbidden.
+func (pa *AuthorityImpl) SetRejectPrivateSuffixes(reject bool) {
+	pa.rejectPrivateSuffixes = reject
+}
+
+// SetChallengesByIdentifier restricts the challenges offered for identifiers
+// to the types listed for the most specific domain in challenges that the
+// identifier is, or is a subdomain of. Challenge types must also be enabled
+// to be offered. Identifiers under none of the domains are offered every
+// enabled challenge.
+func (pa *AuthorityImpl) SetChallengesByIdentifier(challenges map[string][]string) {
+	pa.challengesByIdentifier = make(map[string]map[string]bool, len(challenges))
+	for domain, types := range challenges {
+		allowed := make(map[string]bool, len(types))
+		for _, t := range types {
+			allowed[t] = true
+		}
+		pa.challengesByIdentifier[strings.ToLower(domain)] = allowed
+	}
+}
+
+// challengeTypesFor returns the challenge types identifier is restricted to
+// by challengesByIdentifier, or nil if it isn't restricted.
+func (pa *AuthorityImpl) challengeTypesFor(identifier core.AcmeIdentifier) map[string]bool {
+	name := strings.ToLower(identifier.Value)
+	for {
+		if allowed, ok := pa.challengesByIdentifier[name]; ok {
+			return allowed
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return nil
+		}
+		name = name[i+1:]
+	}
+}
+
+// ChallengeTypeAllowed returns whether SetChallengesByIdentifier allows
+// challenges of type t for identifier. It doesn't consider whether t is
+// enabled.
+func (pa *AuthorityImpl) ChallengeTypeAllowed(t string, identifier core.AcmeIdentifier) bool {
+	allowed := pa.challengeTypesFor(identifier)
+	return allowed == nil || allowed[t]
+}
+
+// challengeTypeOffered returns whether challenges of type t are both enabled
+// and allowed for identifier.
+func (pa *AuthorityImpl) challengeTypeOffered(t string, identifier core.AcmeIdentifier) bool {
+	return pa.enabledChallenges[t] && pa.ChallengeTypeAllowed(t, identifier)
+}
+
 type blacklistJSON struct {
 	Blacklist      []string
 	ExactBlacklist []string
@@ -130,6 +191,7 @@ var (
 	errInvalidIdentifier   = probs.Malformed("Invalid identifier type")
 	errNonPublic           = probs.Malformed("Name does not end in a public suffix")
 	errICANNTLD            = probs.Malformed("Name is an ICANN TLD")
+	errPrivateSuffix       = probs.RejectedIdentifier("Policy forbids issuing for a public suffix")
 	errBlacklisted         = probs.RejectedIdentifier("Policy forbids issuing for name")
 	errNotWhitelisted      = probs.Malformed("Name is not whitelisted")
 	errInvalidDNSCharacter = probs.Malformed("Invalid character in DNS name")
@@ -161,6 +223,8 @@ var (
 //  * MUST NOT match the syntax of an IP address
 //  * MUST end in a public suffix
 //  * MUST have at least one label in addition to the public suffix
+//  * MUST NOT be a suffix in the private section of the Public Suffix List,
+//    if SetRejectPrivateSuffixes is in effect
 //  * MUST NOT be a label-wise suffix match for a name on the black list,
 //    where comparison is case-independent (normalized to lower case)
 //
@@ -240,6 +304,9 @@ func (pa *AuthorityImpl) WillingToIssue(id core.AcmeIdentifier) error {
 	if icannTLD == domain {
 		return errICANNTLD
 	}
+	if pa.rejectPrivateSuffixes && isPublicSuffix(domain) {
+		return errPrivateSuffix
+	}
 
 	// Require no match against blacklist
 	if err := pa.checkHostLists(domain); err != nil {
@@ -273,20 +340,20 @@ func (pa *AuthorityImpl) checkHostLists(domain string) error {
 
 // ChallengesFor makes a decision of what challenges, and combinations, are
 // acceptable for the given identifier.
-//
-// Note: Current implementation is static, but future versions may not be.
+// The challenges are those enabled, less any not allowed for the identifier
+// by SetChallengesByIdentifier.
 func (pa *AuthorityImpl) ChallengesFor(identifier core.AcmeIdentifier) ([]core.Challenge, [][]int) {
 	challenges := []core.Challenge{}
 
-	if pa.enabledChallenges[core.ChallengeTypeHTTP01] {
+	if pa.challengeTypeOffered(core.ChallengeTypeHTTP01, identifier) {
 		challenges = append(challenges, core.HTTPChallenge01())
 	}
 
-	if pa.enabledChallenges[core.ChallengeTypeTLSSNI01] {
+	if pa.challengeTypeOffered(core.ChallengeTypeTLSSNI01, identifier) {
 		challenges = append(challenges, core.TLSSNIChallenge01())
 	}
 
-	if pa.enabledChallenges[core.ChallengeTypeDNS01] {
+	if pa.challengeTypeOffered(core.ChallengeTypeDNS01, identifier) {
 		challenges = append(challenges, core.DNSChallenge01())
 	}
 
@@ -308,6 +375,18 @@ func (pa *AuthorityImpl) ChallengesFor(identifier core.AcmeIdentifier) ([]core.C
 	return shuffled, shuffledCombos
 }
 
+// isPublicSuffix returns true if name is exactly a suffix in either section of
+// the Public Suffix List.
+func isPublicSuffix(name string) bool {
+	rule := publicsuffix.DefaultList.Find(name, &publicsuffix.FindOptions{IgnorePrivate: false, DefaultRule: nil})
+	if rule == nil {
+		return false
+	}
+	// As in extractDomainIANASuffix, decompose gives empty strings when name
+	// is itself a suffix.
+	return rule.Decompose(name)[1] == ""
+}
+
 // ExtractDomainIANASuffix returns the public suffix of the domain using only the "ICANN"
 // section of the Public Suffix List database.
 // If the domain does not end in a suffix that belongs to an IANA-assigned
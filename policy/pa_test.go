@@ -2,6 +2,7 @@ package policy
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -200,6 +201,43 @@ func TestWillingToIssue(t *testing.T) {
 	}
 }
 
+func TestWillingToIssuePublicSuffixes(t *testing.T) {
+	pa := paImpl(t)
+	f, _ := ioutil.TempFile("", "test-blacklist.txt")
+	defer os.Remove(f.Name())
+	err := ioutil.WriteFile(f.Name(), []byte(`{"blacklist":["example.net"]}`), 0640)
+	test.AssertNotError(t, err, "Couldn't write blacklist")
+	err = pa.SetHostnamePolicyFile(f.Name())
+	test.AssertNotError(t, err, "Couldn't load rules")
+
+	testCases := []struct {
+		name          string
+		rejectPrivate bool
+		expected      error
+	}{
+		// ICANN suffixes and single labels are always forbidden
+		{"com", false, errTooFewLabels},
+		{"co.uk", false, errICANNTLD},
+		{"co.uk", true, errICANNTLD},
+		{"example", true, errTooFewLabels},
+		// Private suffixes are only forbidden on request
+		{"github.io", false, nil},
+		{"github.io", true, errPrivateSuffix},
+		{"blogspot.co.uk", true, errPrivateSuffix},
+		// Names under any suffix are fine
+		{"example.co.uk", true, nil},
+		{"example.github.io", true, nil},
+		{"www.example.com", true, nil},
+	}
+	for _, tc := range testCases {
+		pa.SetRejectPrivateSuffixes(tc.rejectPrivate)
+		err := pa.WillingToIssue(core.AcmeIdentifier{Type: core.IdentifierDNS, Value: tc.name})
+		if err != tc.expected {
+			t.Errorf("WillingToIssue(%q) with rejectPrivateSuffixes %t: got %v, expected %v", tc.name, tc.rejectPrivate, err, tc.expected)
+		}
+	}
+}
+
 var accountKeyJSON = `{
   "kty":"RSA",
   "n":"yNWVhtYEKJR21y9xsHV-PD_bYwbXSeNuFal46xYxVfRL5mqha7vttvjB_vc7Xg2RvgCxHPCqoxgMPTzHrZT75LjCwIW2K_klBYN8oYvTwwmeSkAz6ut7ZxPv-nZaT5TJhGk0NT2kh_zSpdriEJ_3vW-mqxYbbBmpvHqsa1_zx9fSuHYctAZJWzxzUZXykbWMWQZpEiE0J4ajj51fInEzVn7VxV-mzfMyboQjujPh7aNJxAWSq4oQEJJDgWwSh9leyoJoPpONHxh5nEE5AjE01FkGICSxjpZsF-w8hOTI3XXohUdu29Se26k2B0PolDSuj0GIQU6-W9TdLXSjBb2SpQ",
@@ -227,6 +265,42 @@ func TestChallengesFor(t *testing.T) {
 	test.AssertDeepEquals(t, expectedCombos, combinations)
 }
 
+func TestChallengesForIdentifier(t *testing.T) {
+	pa := paImpl(t)
+	pa.SetChallengesByIdentifier(map[string][]string{
+		"example.com":     {core.ChallengeTypeDNS01},
+		"www.example.com": {core.ChallengeTypeHTTP01, core.ChallengeTypeDNS01},
+	})
+
+	testCases := []struct {
+		name     string
+		expected []string
+	}{
+		{"example.com", []string{core.ChallengeTypeDNS01}},
+		{"mail.EXAMPLE.com", []string{core.ChallengeTypeDNS01}},
+		{"www.example.com", []string{core.ChallengeTypeHTTP01, core.ChallengeTypeDNS01}},
+		{"example.net", []string{core.ChallengeTypeHTTP01, core.ChallengeTypeTLSSNI01, core.ChallengeTypeDNS01}},
+		{"notexample.com", []string{core.ChallengeTypeHTTP01, core.ChallengeTypeTLSSNI01, core.ChallengeTypeDNS01}},
+	}
+	for _, tc := range testCases {
+		identifier := core.AcmeIdentifier{Type: core.IdentifierDNS, Value: tc.name}
+		challenges, combinations := pa.ChallengesFor(identifier)
+		test.AssertEquals(t, len(challenges), len(tc.expected))
+		test.AssertEquals(t, len(combinations), len(tc.expected))
+		offered := make(map[string]bool)
+		for _, challenge := range challenges {
+			offered[challenge.Type] = true
+		}
+		for _, expected := range tc.expected {
+			test.Assert(t, offered[expected], fmt.Sprintf("%s not offered for %s", expected, tc.name))
+			test.Assert(t, pa.ChallengeTypeAllowed(expected, identifier), fmt.Sprintf("%s not allowed for %s", expected, tc.name))
+		}
+	}
+
+	test.Assert(t, !pa.ChallengeTypeAllowed(core.ChallengeTypeHTTP01, core.AcmeIdentifier{Type: core.IdentifierDNS, Value: "example.com"}),
+		"http-01 allowed for DNS-only identifier")
+}
+
 func TestExtractDomainIANASuffix_Valid(t *testing.T) {
 	testCases := []struct {
 		domain, want string
@@ -3,6 +3,8 @@ package probs
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // Error types that can be used in ACME payloads
@@ -18,11 +20,28 @@ const (
 	InvalidEmailProblem          = ProblemType("urn:acme:error:invalidEmail")
 	RejectedIdentifierProblem    = ProblemType("urn:acme:error:rejectedIdentifier")
 	UnsupportedIdentifierProblem = ProblemType("urn:acme:error:unsupportedIdentifier")
+	AccountDoesNotExistProblem   = ProblemType("urn:acme:error:accountDoesNotExist")
+)
+
+// legacyErrorNamespace is the prefix of the error types above, from early
+// ACME drafts. errorNamespace is where the IETF registered them.
+const (
+	legacyErrorNamespace = "urn:acme:error:"
+	errorNamespace       = "urn:ietf:params:acme:error:"
 )
 
 // ProblemType defines the error types in the ACME protocol
 type ProblemType string
 
+// FullType returns pt in the urn:ietf:params:acme:error namespace if it is
+// one of the ACME error types, and pt unchanged otherwise.
+func FullType(pt ProblemType) ProblemType {
+	if strings.HasPrefix(string(pt), legacyErrorNamespace) {
+		return ProblemType(errorNamespace + strings.TrimPrefix(string(pt), legacyErrorNamespace))
+	}
+	return pt
+}
+
 // ProblemDetails objects represent problem documents
 // https://tools.ietf.org/html/draft-ietf-appsawg-http-problem-00
 type ProblemDetails struct {
@@ -31,6 +50,29 @@ type ProblemDetails struct {
 	// HTTPStatus is the HTTP status code the ProblemDetails should probably be sent
 	// as.
 	HTTPStatus int `json:"status,omitempty"`
+	// RateLimit names the rate limit that was exceeded, for problems of type
+	// RateLimitedProblem.
+	RateLimit string `json:"rateLimit,omitempty"`
+	// RetryAfter, if non-zero, is how long the client should wait before
+	// retrying. It is sent as a Retry-After header, and only for 429 and 503
+	// responses.
+	RetryAfter time.Duration `json:"-"`
+	// Identifier is the identifier a subproblem is about.
+	Identifier *ProblemIdentifier `json:"identifier,omitempty"`
+	// Instance identifies the occurrence of the problem: the ID of the
+	// request that ran into it, under which the request is logged.
+	Instance string `json:"instance,omitempty"`
+	// Subproblems break a problem affecting several identifiers down into one
+	// problem per identifier. The top-level fields still describe the problem
+	// as a whole, for clients that don't look at subproblems.
+	Subproblems []ProblemDetails `json:"subproblems,omitempty"`
+}
+
+// ProblemIdentifier names the identifier, e.g. a DNS name, that a subproblem
+// concerns.
+type ProblemIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
 }
 
 func (pd *ProblemDetails) Error() string {
@@ -57,6 +99,8 @@ func ProblemDetailsToStatusCode(prob *ProblemDetails) int {
 		return http.StatusForbidden
 	case RateLimitedProblem:
 		return statusTooManyRequests
+	case AccountDoesNotExistProblem:
+		return http.StatusNotFound
 	default:
 		return http.StatusInternalServerError
 	}
@@ -92,6 +136,16 @@ func UnsupportedIdentifier(detail string) *ProblemDetails {
 	}
 }
 
+// AccountDoesNotExist returns a ProblemDetails with an
+// AccountDoesNotExistProblem and a 404 Not Found status code.
+func AccountDoesNotExist(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       AccountDoesNotExistProblem,
+		Detail:     detail,
+		HTTPStatus: http.StatusNotFound,
+	}
+}
+
 // Conflict returns a ProblemDetails with a MalformedProblem and a 409 Conflict
 // status code.
 func Conflict(detail string) *ProblemDetails {
@@ -155,6 +209,17 @@ func MethodNotAllowed() *ProblemDetails {
 	}
 }
 
+// NotAcceptable returns a ProblemDetails with a MalformedProblem and a 406 Not
+// Acceptable status code, for requests whose Accept header excludes every
+// representation of the resource.
+func NotAcceptable(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       MalformedProblem,
+		Detail:     detail,
+		HTTPStatus: http.StatusNotAcceptable,
+	}
+}
+
 // ContentLengthRequired returns a ProblemDetails representing a missing
 // Content-Length header error
 func ContentLengthRequired() *ProblemDetails {
@@ -17,6 +17,12 @@ func TestProblemDetails(t *testing.T) {
 	test.AssertEquals(t, pd.Error(), "urn:acme:error:malformed :: Wat? o.O")
 }
 
+func TestFullType(t *testing.T) {
+	test.AssertEquals(t, FullType(MalformedProblem), ProblemType("urn:ietf:params:acme:error:malformed"))
+	test.AssertEquals(t, FullType("urn:ietf:params:acme:error:badNonce"), ProblemType("urn:ietf:params:acme:error:badNonce"))
+	test.AssertEquals(t, FullType("about:blank"), ProblemType("about:blank"))
+}
+
 func TestProblemDetailsToStatusCode(t *testing.T) {
 	testCases := []struct {
 		pb         *ProblemDetails
@@ -31,6 +37,7 @@ func TestProblemDetailsToStatusCode(t *testing.T) {
 		{&ProblemDetails{Type: RateLimitedProblem}, statusTooManyRequests},
 		{&ProblemDetails{Type: BadNonceProblem}, http.StatusBadRequest},
 		{&ProblemDetails{Type: InvalidEmailProblem}, http.StatusBadRequest},
+		{&ProblemDetails{Type: AccountDoesNotExistProblem}, http.StatusNotFound},
 		{&ProblemDetails{Type: "foo"}, http.StatusInternalServerError},
 		{&ProblemDetails{Type: "foo", HTTPStatus: 200}, 200},
 		{&ProblemDetails{Type: ConnectionProblem, HTTPStatus: 200}, 200},
@@ -62,6 +69,8 @@ func TestProblemDetailsConvenience(t *testing.T) {
 		{TLSError("TLS error detail"), TLSProblem, http.StatusBadRequest, "TLS error detail"},
 		{RejectedIdentifier("rejected identifier detail"), RejectedIdentifierProblem, http.StatusBadRequest, "rejected identifier detail"},
 		{UnsupportedIdentifier("unsupported identifier detail"), UnsupportedIdentifierProblem, http.StatusBadRequest, "unsupported identifier detail"},
+		{NotAcceptable("not acceptable detail"), MalformedProblem, http.StatusNotAcceptable, "not acceptable detail"},
+		{AccountDoesNotExist("account does not exist detail"), AccountDoesNotExistProblem, http.StatusNotFound, "account does not exist detail"},
 	}
 
 	for _, c := range testCases {
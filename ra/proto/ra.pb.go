@@ -15,6 +15,8 @@ It has these top-level messages:
 	UpdateAuthorizationRequest
 	RevokeCertificateWithRegRequest
 	AdministrativelyRevokeCertificateRequest
+	CheckPreAuthorizationRequest
+	CheckPreAuthorizationResponse
 */
 package proto
 
@@ -65,9 +67,10 @@ func (m *NewAuthorizationRequest) GetRegID() int64 {
 }
 
 type NewCertificateRequest struct {
-	Csr              []byte `protobuf:"bytes,1,opt,name=csr" json:"csr,omitempty"`
-	RegID            *int64 `protobuf:"varint,2,opt,name=regID" json:"regID,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	Csr              []byte  `protobuf:"bytes,1,opt,name=csr" json:"csr,omitempty"`
+	RegID            *int64  `protobuf:"varint,2,opt,name=regID" json:"regID,omitempty"`
+	Profile          *string `protobuf:"bytes,3,opt,name=profile" json:"profile,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *NewCertificateRequest) Reset()                    { *m = NewCertificateRequest{} }
@@ -89,6 +92,13 @@ func (m *NewCertificateRequest) GetRegID() int64 {
 	return 0
 }
 
+func (m *NewCertificateRequest) GetProfile() string {
+	if m != nil && m.Profile != nil {
+		return *m.Profile
+	}
+	return ""
+}
+
 type UpdateRegistrationRequest struct {
 	Base             *core.Registration `protobuf:"bytes,1,opt,name=base" json:"base,omitempty"`
 	Update           *core.Registration `protobuf:"bytes,2,opt,name=update" json:"update,omitempty"`
@@ -217,6 +227,48 @@ func (m *AdministrativelyRevokeCertificateRequest) GetAdminName() string {
 	return ""
 }
 
+type CheckPreAuthorizationRequest struct {
+	RegID            *int64   `protobuf:"varint,1,opt,name=regID" json:"regID,omitempty"`
+	Names            []string `protobuf:"bytes,2,rep,name=names" json:"names,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *CheckPreAuthorizationRequest) Reset()                    { *m = CheckPreAuthorizationRequest{} }
+func (m *CheckPreAuthorizationRequest) String() string            { return proto1.CompactTextString(m) }
+func (*CheckPreAuthorizationRequest) ProtoMessage()               {}
+func (*CheckPreAuthorizationRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *CheckPreAuthorizationRequest) GetRegID() int64 {
+	if m != nil && m.RegID != nil {
+		return *m.RegID
+	}
+	return 0
+}
+
+func (m *CheckPreAuthorizationRequest) GetNames() []string {
+	if m != nil {
+		return m.Names
+	}
+	return nil
+}
+
+type CheckPreAuthorizationResponse struct {
+	PreAuthorized    *bool  `protobuf:"varint,1,opt,name=preAuthorized" json:"preAuthorized,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *CheckPreAuthorizationResponse) Reset()                    { *m = CheckPreAuthorizationResponse{} }
+func (m *CheckPreAuthorizationResponse) String() string            { return proto1.CompactTextString(m) }
+func (*CheckPreAuthorizationResponse) ProtoMessage()               {}
+func (*CheckPreAuthorizationResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *CheckPreAuthorizationResponse) GetPreAuthorized() bool {
+	if m != nil && m.PreAuthorized != nil {
+		return *m.PreAuthorized
+	}
+	return false
+}
+
 func init() {
 	proto1.RegisterType((*NewAuthorizationRequest)(nil), "ra.NewAuthorizationRequest")
 	proto1.RegisterType((*NewCertificateRequest)(nil), "ra.NewCertificateRequest")
@@ -224,6 +276,8 @@ func init() {
 	proto1.RegisterType((*UpdateAuthorizationRequest)(nil), "ra.UpdateAuthorizationRequest")
 	proto1.RegisterType((*RevokeCertificateWithRegRequest)(nil), "ra.RevokeCertificateWithRegRequest")
 	proto1.RegisterType((*AdministrativelyRevokeCertificateRequest)(nil), "ra.AdministrativelyRevokeCertificateRequest")
+	proto1.RegisterType((*CheckPreAuthorizationRequest)(nil), "ra.CheckPreAuthorizationRequest")
+	proto1.RegisterType((*CheckPreAuthorizationResponse)(nil), "ra.CheckPreAuthorizationResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -246,6 +300,7 @@ type RegistrationAuthorityClient interface {
 	DeactivateRegistration(ctx context.Context, in *core.Registration, opts ...grpc.CallOption) (*core.Empty, error)
 	DeactivateAuthorization(ctx context.Context, in *core.Authorization, opts ...grpc.CallOption) (*core.Empty, error)
 	AdministrativelyRevokeCertificate(ctx context.Context, in *AdministrativelyRevokeCertificateRequest, opts ...grpc.CallOption) (*core.Empty, error)
+	CheckPreAuthorization(ctx context.Context, in *CheckPreAuthorizationRequest, opts ...grpc.CallOption) (*CheckPreAuthorizationResponse, error)
 }
 
 type registrationAuthorityClient struct {
@@ -337,6 +392,15 @@ func (c *registrationAuthorityClient) AdministrativelyRevokeCertificate(ctx cont
 	return out, nil
 }
 
+func (c *registrationAuthorityClient) CheckPreAuthorization(ctx context.Context, in *CheckPreAuthorizationRequest, opts ...grpc.CallOption) (*CheckPreAuthorizationResponse, error) {
+	out := new(CheckPreAuthorizationResponse)
+	err := grpc.Invoke(ctx, "/ra.RegistrationAuthority/CheckPreAuthorization", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for RegistrationAuthority service
 
 type RegistrationAuthorityServer interface {
@@ -349,6 +413,7 @@ type RegistrationAuthorityServer interface {
 	DeactivateRegistration(context.Context, *core.Registration) (*core.Empty, error)
 	DeactivateAuthorization(context.Context, *core.Authorization) (*core.Empty, error)
 	AdministrativelyRevokeCertificate(context.Context, *AdministrativelyRevokeCertificateRequest) (*core.Empty, error)
+	CheckPreAuthorization(context.Context, *CheckPreAuthorizationRequest) (*CheckPreAuthorizationResponse, error)
 }
 
 func RegisterRegistrationAuthorityServer(s *grpc.Server, srv RegistrationAuthorityServer) {
@@ -517,6 +582,24 @@ func _RegistrationAuthority_AdministrativelyRevokeCertificate_Handler(srv interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RegistrationAuthority_CheckPreAuthorization_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckPreAuthorizationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistrationAuthorityServer).CheckPreAuthorization(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ra.RegistrationAuthority/CheckPreAuthorization",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistrationAuthorityServer).CheckPreAuthorization(ctx, req.(*CheckPreAuthorizationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _RegistrationAuthority_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "ra.RegistrationAuthority",
 	HandlerType: (*RegistrationAuthorityServer)(nil),
@@ -557,6 +640,10 @@ var _RegistrationAuthority_serviceDesc = grpc.ServiceDesc{
 			MethodName: "AdministrativelyRevokeCertificate",
 			Handler:    _RegistrationAuthority_AdministrativelyRevokeCertificate_Handler,
 		},
+		{
+			MethodName: "CheckPreAuthorization",
+			Handler:    _RegistrationAuthority_CheckPreAuthorization_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: fileDescriptor0,
@@ -565,35 +652,39 @@ var _RegistrationAuthority_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("ra/proto/ra.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 465 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x9c, 0x54, 0xc1, 0x6e, 0xd3, 0x40,
-	0x14, 0x4c, 0x9a, 0x06, 0xe8, 0x4b, 0x69, 0xc9, 0xab, 0xd2, 0xa6, 0x46, 0x40, 0xba, 0x5c, 0x72,
-	0x40, 0xa9, 0x54, 0x8e, 0x15, 0x12, 0xa5, 0x05, 0x29, 0x52, 0xe4, 0x43, 0x24, 0x84, 0xe0, 0xc4,
-	0xe2, 0x3c, 0xe2, 0x15, 0x89, 0xd7, 0xac, 0xd7, 0x09, 0x29, 0xdf, 0xc3, 0x7f, 0x22, 0xaf, 0x37,
-	0x24, 0x76, 0x6c, 0x35, 0xf4, 0xb6, 0xd6, 0xbe, 0x99, 0x9d, 0x99, 0x37, 0x32, 0x34, 0x15, 0x3f,
-	0x0f, 0x95, 0xd4, 0xf2, 0x5c, 0xf1, 0x9e, 0x39, 0xe0, 0x8e, 0xe2, 0x4e, 0xcb, 0x93, 0x8a, 0xec,
-	0x45, 0x72, 0x4c, 0xaf, 0xd8, 0x00, 0x4e, 0x5c, 0x9a, 0x5f, 0xc5, 0xda, 0x97, 0x4a, 0xdc, 0x72,
-	0x2d, 0x64, 0x30, 0xa4, 0x9f, 0x31, 0x45, 0x1a, 0x19, 0xd4, 0x79, 0xac, 0xfd, 0xdb, 0x76, 0xb5,
-	0x53, 0xed, 0x36, 0x2e, 0x8e, 0x7a, 0x06, 0x96, 0x19, 0xc5, 0xc7, 0x50, 0x57, 0x34, 0xee, 0xdf,
-	0xb4, 0x77, 0x3a, 0xd5, 0x6e, 0x8d, 0xbd, 0x86, 0x96, 0x4b, 0xf3, 0x6b, 0x52, 0x5a, 0x7c, 0x17,
-	0x1e, 0xd7, 0xb4, 0xe4, 0x6a, 0x40, 0xcd, 0x8b, 0x94, 0x61, 0xda, 0xcf, 0x83, 0x38, 0x9c, 0x7e,
-	0x0c, 0x47, 0x66, 0x78, 0x2c, 0x22, 0xad, 0x32, 0x22, 0x3a, 0xb0, 0xfb, 0x8d, 0x47, 0x64, 0x35,
-	0x60, 0xaa, 0x61, 0x7d, 0x10, 0x19, 0x3c, 0x88, 0x0d, 0xdc, 0xd0, 0x15, 0xce, 0xb0, 0xdf, 0xe0,
-	0xa4, 0x4f, 0xdc, 0xdb, 0xe8, 0x31, 0x1c, 0x78, 0x3e, 0x9f, 0x4c, 0x28, 0x18, 0x53, 0x3f, 0x18,
-	0xd1, 0xaf, 0x54, 0x3c, 0x9e, 0xc1, 0x23, 0x45, 0x51, 0x28, 0x83, 0x88, 0xda, 0x35, 0x03, 0x3f,
-	0x4c, 0xe1, 0xd7, 0xcb, 0x69, 0x36, 0x80, 0x17, 0x43, 0x9a, 0xc9, 0x1f, 0xb4, 0x96, 0xcb, 0x27,
-	0xa1, 0xfd, 0x21, 0x8d, 0x97, 0x0a, 0xf6, 0x61, 0xd7, 0x23, 0xa5, 0x6d, 0x3e, 0xc9, 0x97, 0x1c,
-	0x91, 0x7d, 0xe1, 0x5f, 0x5a, 0x35, 0x93, 0xd6, 0x67, 0xe8, 0x5e, 0x8d, 0xa6, 0x22, 0xb0, 0xe6,
-	0x66, 0x34, 0x59, 0x6c, 0xb0, 0x6f, 0x43, 0xdb, 0x84, 0x3d, 0x9e, 0xf0, 0xb8, 0x7c, 0x9a, 0x2a,
-	0xdf, 0xbb, 0xf8, 0x53, 0x87, 0xd6, 0x7a, 0x6c, 0x36, 0x01, 0xbd, 0xc0, 0x4b, 0x38, 0x74, 0x69,
-	0x9e, 0x89, 0xbd, 0x20, 0x66, 0xa7, 0x28, 0xfa, 0x0a, 0x7e, 0x80, 0x27, 0xf9, 0x8a, 0xe1, 0xd3,
-	0x9e, 0xe2, 0xbd, 0x92, 0xe2, 0x39, 0x45, 0x0b, 0x60, 0x15, 0x7c, 0x0b, 0x07, 0xd9, 0x72, 0xe1,
-	0xa9, 0x65, 0xd9, 0xb4, 0xee, 0x34, 0xed, 0x16, 0x56, 0x37, 0xac, 0x82, 0x7d, 0xc0, 0xcd, 0xa6,
-	0xe1, 0xb3, 0x84, 0xa5, 0xb4, 0x81, 0x25, 0xa6, 0x06, 0x70, 0x54, 0xd0, 0x28, 0x7c, 0xbe, 0xe2,
-	0xfa, 0x1f, 0x6b, 0x2e, 0xb4, 0xcb, 0x2a, 0x82, 0x2f, 0x13, 0xca, 0x3b, 0x0a, 0xe4, 0x34, 0x52,
-	0xde, 0xf7, 0xd3, 0x50, 0x2f, 0x58, 0x05, 0x2f, 0xe1, 0xf8, 0x86, 0xb8, 0xa7, 0xc5, 0x2c, 0x6f,
-	0xb6, 0x68, 0x6d, 0x39, 0xf0, 0x1b, 0x38, 0x59, 0x81, 0xb3, 0xf6, 0x8a, 0xe4, 0xe7, 0xe1, 0x5f,
-	0xe1, 0xec, 0xce, 0x82, 0xe2, 0xab, 0xc4, 0xd4, 0xb6, 0x3d, 0xce, 0xbd, 0xf0, 0xee, 0xe1, 0x97,
-	0xba, 0xf9, 0x79, 0xfd, 0x0d, 0x00, 0x00, 0xff, 0xff, 0xeb, 0x14, 0xc6, 0x23, 0xeb, 0x04, 0x00,
-	0x00,
+	// 530 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x9d, 0x54, 0x4d, 0x4f, 0xdb, 0x40,
+	0x10, 0x25, 0x98, 0xf0, 0x31, 0x01, 0x42, 0x16, 0x19, 0x82, 0x5b, 0xda, 0xc4, 0xbd, 0x70, 0x40,
+	0x41, 0xca, 0xa1, 0x17, 0xa8, 0x54, 0x9a, 0xb4, 0x52, 0x24, 0x14, 0x55, 0x48, 0x55, 0xd5, 0x1e,
+	0xaa, 0x6e, 0xed, 0x21, 0xb1, 0x48, 0x6c, 0x77, 0xbd, 0xa1, 0x0d, 0x9c, 0xfb, 0xbf, 0xbb, 0x5e,
+	0xaf, 0x49, 0xec, 0xac, 0x81, 0xf6, 0xb6, 0xde, 0x99, 0xf7, 0xf6, 0xcd, 0xcc, 0x1b, 0x43, 0x8d,
+	0xd1, 0x93, 0x90, 0x05, 0x3c, 0x38, 0x61, 0xb4, 0x25, 0x0f, 0x64, 0x99, 0x51, 0xcb, 0x74, 0x02,
+	0x86, 0x2a, 0x10, 0x1f, 0x93, 0x90, 0x7d, 0x01, 0xfb, 0x7d, 0xfc, 0x75, 0x3e, 0xe1, 0xc3, 0x80,
+	0x79, 0xb7, 0x94, 0x7b, 0x81, 0x7f, 0x89, 0x3f, 0x27, 0x18, 0x71, 0x62, 0x43, 0x99, 0x8a, 0xfb,
+	0xdb, 0x7a, 0xa9, 0x51, 0x3a, 0xaa, 0xb4, 0x77, 0x5b, 0x12, 0x96, 0x49, 0x25, 0x5b, 0x50, 0x66,
+	0x38, 0xe8, 0x75, 0xeb, 0xcb, 0x22, 0xc7, 0xb0, 0xbb, 0x60, 0x0a, 0xb6, 0x0e, 0x32, 0xee, 0x5d,
+	0x79, 0x0e, 0xe5, 0x98, 0x72, 0x55, 0xc0, 0x70, 0x22, 0x26, 0x99, 0x36, 0x73, 0x20, 0x52, 0x85,
+	0x35, 0xa1, 0xe5, 0xca, 0x1b, 0x61, 0xdd, 0x10, 0x17, 0x1b, 0x36, 0x85, 0x83, 0x4f, 0xa1, 0x2b,
+	0xd1, 0x03, 0x2f, 0xe2, 0x2c, 0xa3, 0xaa, 0x01, 0x2b, 0x3f, 0x68, 0x84, 0x4a, 0x14, 0x49, 0x44,
+	0xcd, 0x27, 0x0a, 0xdd, 0xab, 0x13, 0x09, 0x97, 0xfc, 0xda, 0x1c, 0xfb, 0x0e, 0xac, 0xe4, 0x89,
+	0xff, 0xae, 0x7c, 0x0f, 0xb6, 0x9d, 0x21, 0x1d, 0x8d, 0xd0, 0x1f, 0x60, 0xcf, 0x77, 0xf1, 0xb7,
+	0xaa, 0xa6, 0x09, 0xeb, 0x0c, 0xa3, 0x30, 0xf0, 0xa3, 0xa4, 0x9c, 0x4a, 0xbb, 0x9a, 0xc0, 0x3b,
+	0x69, 0xb6, 0xe8, 0xf9, 0xcb, 0x4b, 0xbc, 0x09, 0xae, 0x71, 0xae, 0x51, 0x9f, 0x3d, 0x3e, 0x14,
+	0x0a, 0x53, 0x05, 0x9b, 0xb0, 0xe2, 0x88, 0xa0, 0x6a, 0x58, 0xfc, 0x15, 0xb8, 0xa8, 0x5e, 0xb8,
+	0x6f, 0x9f, 0x21, 0x7b, 0xfe, 0x05, 0x8e, 0xce, 0xdd, 0xb1, 0xe7, 0xab, 0xe2, 0x6e, 0x70, 0x34,
+	0x5d, 0x60, 0x7f, 0x0a, 0x6d, 0x0d, 0x36, 0x68, 0xcc, 0xd3, 0xa7, 0xe3, 0x74, 0x10, 0x67, 0xf0,
+	0xbc, 0x33, 0x44, 0xe7, 0xfa, 0x23, 0xd3, 0xf7, 0xe9, 0x5e, 0x49, 0x29, 0x15, 0xe6, 0x0b, 0x70,
+	0x24, 0x08, 0x0d, 0x81, 0x7e, 0x0d, 0x87, 0x05, 0xe8, 0xa4, 0x3d, 0xc4, 0x84, 0xad, 0x70, 0x16,
+	0x43, 0x57, 0xd2, 0xac, 0xb7, 0xff, 0xac, 0x82, 0x39, 0x3f, 0x2c, 0x95, 0xc0, 0xa7, 0xe4, 0x14,
+	0xaa, 0xc2, 0x5e, 0x99, 0x61, 0x6b, 0x86, 0x6b, 0xe9, 0x06, 0xbe, 0x44, 0x3e, 0xc0, 0x4e, 0xde,
+	0xe9, 0xe4, 0x59, 0x4b, 0xec, 0x48, 0x81, 0xff, 0x2d, 0xdd, 0xd8, 0x05, 0xcf, 0x5b, 0xd8, 0xce,
+	0x7a, 0x9c, 0x1c, 0x28, 0x96, 0xc5, 0x86, 0x5b, 0x35, 0x35, 0xfb, 0x59, 0x44, 0x30, 0xf4, 0x80,
+	0x2c, 0xfa, 0x9b, 0x1c, 0xc6, 0x2c, 0x85, 0xbe, 0x2f, 0x28, 0xea, 0x02, 0x76, 0x35, 0x3e, 0x26,
+	0x2f, 0x66, 0x5c, 0xff, 0x52, 0x5a, 0x1f, 0xea, 0x45, 0xc6, 0x24, 0xaf, 0x62, 0xca, 0x47, 0x6c,
+	0x6b, 0x55, 0x12, 0xde, 0xf7, 0xe3, 0x90, 0x4f, 0x05, 0xdf, 0x29, 0xec, 0x75, 0x91, 0x3a, 0xc2,
+	0x94, 0xf9, 0x62, 0x75, 0x63, 0xcb, 0x81, 0xdf, 0xc0, 0xfe, 0x0c, 0x9c, 0x2d, 0x4f, 0x27, 0x3f,
+	0x0f, 0xff, 0x0e, 0xcd, 0x47, 0xd7, 0x82, 0x1c, 0xc7, 0x45, 0x3d, 0x75, 0x7b, 0xf2, 0x2f, 0x7c,
+	0x03, 0x53, 0xeb, 0x6f, 0xd2, 0x88, 0x59, 0x1f, 0x5a, 0x1c, 0xab, 0xf9, 0x40, 0x46, 0xb2, 0x1c,
+	0xf6, 0xd2, 0xbb, 0xb5, 0xaf, 0x65, 0xf9, 0x8f, 0xfe, 0x0b, 0x97, 0x4e, 0x7c, 0x2c, 0xd2, 0x05,
+	0x00, 0x00,
 }
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +50,20 @@ type RegistrationAuthorityImpl struct {
 	PA        core.PolicyAuthority
 	publisher core.Publisher
 
+	// PreAuthorizedNames lists, by registration ID, the names that trusted
+	// registrations may be issued certificates for without holding
+	// authorizations for them.
+	PreAuthorizedNames map[int64][]string
+
+	// AuditRetention tags revocation audit log entries with a retention
+	// class.
+	AuditRetention core.AuditRetentionClasses
+
+	// AllowedContactSchemes lists the URI schemes registrations may use for
+	// their contacts. If it's empty only "mailto" is allowed. Only mailto
+	// contacts are checked for a well-formed, resolvable address.
+	AllowedContactSchemes []string
+
 	stats       metrics.Scope
 	DNSResolver bdns.DNSResolver
 	clk         clock.Clock
@@ -238,6 +253,8 @@ type certificateRequestEvent struct {
 	RequestTime         time.Time `json:",omitempty"`
 	ResponseTime        time.Time `json:",omitempty"`
 	Error               string    `json:",omitempty"`
+	PreAuthorized       bool      `json:",omitempty"`
+	Profile             string    `json:",omitempty"`
 }
 
 // noRegistrationID is used for the regID parameter to GetThreshold when no
@@ -256,7 +273,11 @@ func (ra *RegistrationAuthorityImpl) checkRegistrationLimit(ctx context.Context,
 		if count >= limit.GetThreshold(ip.String(), noRegistrationID) {
 			ra.regByIPStats.Inc("Exceeded", 1)
 			ra.log.Info(fmt.Sprintf("Rate limit exceeded, RegistrationsByIP, IP: %s", ip))
-			return core.RateLimitedError("Too many registrations from this IP")
+			return core.RateLimitedError{
+				Detail: "Too many registrations from this IP",
+				Limit:  "registrationsPerIP",
+				Bucket: ip.String(),
+			}
 		}
 		ra.regByIPStats.Inc("Pass", 1)
 	}
@@ -316,13 +337,20 @@ func (ra *RegistrationAuthorityImpl) validateContacts(ctx context.Context, conta
 		if err != nil {
 			return core.MalformedRequestError("Invalid contact")
 		}
-		if parsed.Scheme != "mailto" {
+		if !ra.contactSchemeAllowed(parsed.Scheme) {
 			return core.MalformedRequestError(fmt.Sprintf("Contact method %s is not supported", parsed.Scheme))
 		}
+		if parsed.Scheme != "mailto" {
+			continue
+		}
 		if !core.IsASCII(contact) {
 			return core.MalformedRequestError(
 				fmt.Sprintf("Contact email [%s] contains non-ASCII characters", contact))
 		}
+		if parsed.RawQuery != "" || parsed.Fragment != "" {
+			return core.MalformedRequestError(
+				fmt.Sprintf("Contact email [%s] contains hfields", contact))
+		}
 
 		start := ra.clk.Now()
 		ra.stats.Inc("ValidateEmail.Calls", 1)
@@ -338,6 +366,20 @@ func (ra *RegistrationAuthorityImpl) validateContacts(ctx context.Context, conta
 	return nil
 }
 
+// contactSchemeAllowed returns true if registrations may use contacts with
+// the given URI scheme.
+func (ra *RegistrationAuthorityImpl) contactSchemeAllowed(scheme string) bool {
+	if len(ra.AllowedContactSchemes) == 0 {
+		return scheme == "mailto"
+	}
+	for _, allowed := range ra.AllowedContactSchemes {
+		if scheme == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 func (ra *RegistrationAuthorityImpl) checkPendingAuthorizationLimit(ctx context.Context, regID int64) error {
 	limit := ra.rlPolicies.PendingAuthorizationsPerAccount()
 	if limit.Enabled() {
@@ -351,7 +393,11 @@ func (ra *RegistrationAuthorityImpl) checkPendingAuthorizationLimit(ctx context.
 		if count >= limit.GetThreshold(noKey, regID) {
 			ra.pendAuthByRegIDStats.Inc("Exceeded", 1)
 			ra.log.Info(fmt.Sprintf("Rate limit exceeded, PendingAuthorizationsByRegID, regID: %d", regID))
-			return core.RateLimitedError("Too many currently pending authorizations.")
+			return core.RateLimitedError{
+				Detail: "Too many currently pending authorizations.",
+				Limit:  "pendingAuthorizationsPerAccount",
+				Bucket: strconv.FormatInt(regID, 10),
+			}
 		}
 		ra.pendAuthByRegIDStats.Inc("Pass", 1)
 	}
@@ -562,6 +608,26 @@ func (ra *RegistrationAuthorityImpl) checkAuthorizations(ctx context.Context, na
 	return nil
 }
 
+// CheckPreAuthorization reports whether regID is listed in PreAuthorizedNames
+// for every one of names, so that certificates for them can be issued without
+// authorizations.
+func (ra *RegistrationAuthorityImpl) CheckPreAuthorization(ctx context.Context, regID int64, names []string) (bool, error) {
+	preAuthorized, ok := ra.PreAuthorizedNames[regID]
+	if !ok || len(names) == 0 {
+		return false, nil
+	}
+	allowed := make(map[string]bool, len(preAuthorized))
+	for _, name := range preAuthorized {
+		allowed[core.NormalizeDNSName(name)] = true
+	}
+	for _, name := range names {
+		if !allowed[core.NormalizeDNSName(name)] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // NewCertificate requests the issuance of a certificate.
 func (ra *RegistrationAuthorityImpl) NewCertificate(ctx context.Context, req core.CertificateRequest, regID int64) (cert core.Certificate, err error) {
 	emptyCert := core.Certificate{}
@@ -576,6 +642,7 @@ func (ra *RegistrationAuthorityImpl) NewCertificate(ctx context.Context, req cor
 		Requester:     regID,
 		RequestMethod: "online",
 		RequestTime:   ra.clk.Now(),
+		Profile:       req.Profile,
 	}
 
 	// No matter what, log the request
@@ -628,11 +695,21 @@ func (ra *RegistrationAuthorityImpl) NewCertificate(ctx context.Context, req cor
 		return emptyCert, err
 	}
 
-	err = ra.checkAuthorizations(ctx, names, &registration)
+	preAuthorized, err := ra.CheckPreAuthorization(ctx, registration.ID, names)
 	if err != nil {
 		logEvent.Error = err.Error()
 		return emptyCert, err
 	}
+	if preAuthorized {
+		// Audited by way of logEvent, along with the rest of the request.
+		logEvent.PreAuthorized = true
+	} else {
+		err = ra.checkAuthorizations(ctx, names, &registration)
+		if err != nil {
+			logEvent.Error = err.Error()
+			return emptyCert, err
+		}
+	}
 
 	// Mark that we verified the CN and SANs
 	logEvent.VerifiedFields = []string{"subject.commonName", "subjectAltName"}
@@ -741,8 +818,11 @@ func (ra *RegistrationAuthorityImpl) checkCertificatesPerNameLimit(ctx context.C
 		domains := strings.Join(badNames, ", ")
 		ra.certsForDomainStats.Inc("Exceeded", 1)
 		ra.log.Info(fmt.Sprintf("Rate limit exceeded, CertificatesForDomain, regID: %d, domains: %s", regID, domains))
-		return core.RateLimitedError(fmt.Sprintf(
-			"Too many certificates already issued for: %s", domains))
+		return core.RateLimitedError{
+			Detail: fmt.Sprintf("Too many certificates already issued for: %s", domains),
+			Limit:  "certificatesPerName",
+			Bucket: domains,
+		}
 
 	}
 	ra.certsForDomainStats.Inc("Pass", 1)
@@ -757,10 +837,12 @@ func (ra *RegistrationAuthorityImpl) checkCertificatesPerFQDNSetLimit(ctx contex
 	}
 	names = core.UniqueLowerNames(names)
 	if int(count) > limit.GetThreshold(strings.Join(names, ","), regID) {
-		return core.RateLimitedError(fmt.Sprintf(
-			"Too many certificates already issued for exact set of domains: %s",
-			strings.Join(names, ","),
-		))
+		fqdnSet := strings.Join(names, ",")
+		return core.RateLimitedError{
+			Detail: fmt.Sprintf("Too many certificates already issued for exact set of domains: %s", fqdnSet),
+			Limit:  "certificatesPerFQDNSet",
+			Bucket: fqdnSet,
+		}
 	}
 	return nil
 }
@@ -778,7 +860,11 @@ func (ra *RegistrationAuthorityImpl) checkTotalCertificatesLimit() error {
 	if ra.totalIssuedCount >= totalCertLimits.Threshold {
 		ra.totalCertsStats.Inc("Exceeded", 1)
 		ra.log.Info(fmt.Sprintf("Rate limit exceeded, TotalCertificates, totalIssued: %d, lastUpdated %s", ra.totalIssuedCount, ra.totalIssuedLastUpdate))
-		return core.RateLimitedError("Global certificate issuance limit reached. Try again in an hour.")
+		return core.RateLimitedError{
+			Detail: "Global certificate issuance limit reached. Try again in an hour.",
+			Limit:  "totalCertificates",
+			Bucket: "global",
+		}
 	}
 	ra.totalCertsStats.Inc("Pass", 1)
 	return nil
@@ -916,6 +1002,13 @@ func (ra *RegistrationAuthorityImpl) UpdateAuthorization(ctx context.Context, ba
 
 	ch := &authz.Challenges[challengeIndex]
 
+	// The identifier's allowed challenge types may have changed since the
+	// authorization was created.
+	if !ra.PA.ChallengeTypeAllowed(ch.Type, authz.Identifier) {
+		err = core.MalformedRequestError(fmt.Sprintf("Challenge type %s is not allowed for %s", ch.Type, authz.Identifier.Value))
+		return
+	}
+
 	if response.Type != "" && ch.Type != response.Type {
 		// TODO(riking): Check the rate on this, uncomment error return if negligible
 		ra.stats.Inc("StartChallengeWrongType", 1)
@@ -996,6 +1089,8 @@ func (ra *RegistrationAuthorityImpl) UpdateAuthorization(ctx context.Context, ba
 			challenge.Error = prob
 		} else {
 			challenge.Status = core.StatusValid
+			validated := ra.clk.Now()
+			challenge.Validated = &validated
 		}
 		authz.Challenges[challengeIndex] = *challenge
 
@@ -1033,11 +1128,15 @@ func (ra *RegistrationAuthorityImpl) RevokeCertificateWithReg(ctx context.Contex
 		//   Revocation reason
 		//   Registration ID of requester
 		//   Error (if there was one)
-		ra.log.AuditInfo(fmt.Sprintf(
+		msg := fmt.Sprintf(
 			"%s, Request by registration ID: %d",
 			revokeEvent(state, serialString, cert.Subject.CommonName, cert.DNSNames, revocationCode),
 			regID,
-		))
+		)
+		if class := ra.AuditRetention.Class(regID); class != "" {
+			msg = fmt.Sprintf("%s, Retention class: %s", msg, class)
+		}
+		ra.log.AuditInfo(msg)
 	}()
 
 	if err != nil {
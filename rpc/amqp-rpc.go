@@ -168,6 +168,10 @@ type rpcError struct {
 	Value      string `json:"value"`
 	Type       string `json:"type,omitempty"`
 	HTTPStatus int    `json:"status,omitempty"`
+	// RateLimit and RateLimitBucket are the Limit and Bucket of a
+	// core.RateLimitedError.
+	RateLimit       string `json:"rateLimit,omitempty"`
+	RateLimitBucket string `json:"rateLimitBucket,omitempty"`
 }
 
 // Wraps an error in a rpcError so it can be marshalled to
@@ -196,6 +200,10 @@ func wrapError(err error) *rpcError {
 			wrapped.Type = "TooManyRPCRequestsError"
 		case core.RateLimitedError:
 			wrapped.Type = "RateLimitedError"
+			wrapped.RateLimit = terr.Limit
+			wrapped.RateLimitBucket = terr.Bucket
+		case core.MultipleMatchingCertificatesError:
+			wrapped.Type = "MultipleMatchingCertificatesError"
 		case *probs.ProblemDetails:
 			wrapped.Type = string(terr.Type)
 			wrapped.Value = terr.Detail
@@ -227,7 +235,13 @@ func unwrapError(rpcError *rpcError) error {
 		case "TooManyRPCRequestsError":
 			return core.TooManyRPCRequestsError(rpcError.Value)
 		case "RateLimitedError":
-			return core.RateLimitedError(rpcError.Value)
+			return core.RateLimitedError{
+				Detail: rpcError.Value,
+				Limit:  rpcError.RateLimit,
+				Bucket: rpcError.RateLimitBucket,
+			}
+		case "MultipleMatchingCertificatesError":
+			return core.MultipleMatchingCertificatesError(rpcError.Value)
 		default:
 			if strings.HasPrefix(rpcError.Type, "urn:") {
 				return &probs.ProblemDetails{
@@ -19,8 +19,9 @@ func TestWrapError(t *testing.T) {
 		core.NotFoundError("foo"),
 		core.SignatureValidationError("foo"),
 		core.NoSuchRegistrationError("foo"),
-		core.RateLimitedError("foo"),
+		core.RateLimitedError{Detail: "foo"},
 		core.TooManyRPCRequestsError("foo"),
+		core.MultipleMatchingCertificatesError("foo"),
 		errors.New("foo"),
 	}
 	for _, c := range testCases {
@@ -56,6 +57,10 @@ func TestWrapError(t *testing.T) {
 			errors.New(""),
 			errors.New(""),
 		},
+		{
+			core.RateLimitedError{Detail: "slow down", Limit: "certificatesPerName", Bucket: "example.com"},
+			core.RateLimitedError{Detail: "slow down", Limit: "certificatesPerName", Bucket: "example.com"},
+		},
 	}
 	for i, tc := range complicated {
 		actual := unwrapError(wrapError(tc.given))
@@ -51,6 +51,7 @@ const (
 	MethodGetRegistration                   = "GetRegistration"                   // SA
 	MethodGetRegistrationByKey              = "GetRegistrationByKey"              // RA, SA
 	MethodGetAuthorization                  = "GetAuthorization"                  // SA
+	MethodGetChallenge                      = "GetChallenge"                      // SA
 	MethodGetValidAuthorizations            = "GetValidAuthorizations"            // SA
 	MethodGetCertificate                    = "GetCertificate"                    // SA
 	MethodGetCertificateStatus              = "GetCertificateStatus"              // SA
@@ -73,6 +74,7 @@ const (
 	MethodDeactivateAuthorization           = "DeactivateAuthorization"           // RA
 	MethodDeactivateRegistrationSA          = "DeactivateRegistrationSA"          // SA
 	MethodDeactivateRegistration            = "DeactivateRegistration"            // RA
+	MethodCheckPreAuthorization             = "CheckPreAuthorization"             // RA
 )
 
 // Request structs
@@ -194,6 +196,11 @@ type fqdnSetExistsRequest struct {
 	Names []string
 }
 
+type checkPreAuthorizationRequest struct {
+	RegID int64
+	Names []string
+}
+
 // Response structs
 type caaResponse struct {
 	Present bool
@@ -210,10 +217,19 @@ type countFQDNSetsResponse struct {
 	Count int64
 }
 
+type getChallengeResponse struct {
+	Challenge       core.Challenge
+	AuthorizationID string
+}
+
 type fqdnSetExistsResponse struct {
 	Exists bool
 }
 
+type checkPreAuthorizationResponse struct {
+	PreAuthorized bool
+}
+
 func improperMessage(method string, err error, obj interface{}) {
 	log := blog.Get()
 	log.AuditErr(fmt.Sprintf("Improper message. method: %s err: %s data: %+v", method, err, obj))
@@ -387,6 +403,25 @@ func NewRegistrationAuthorityServer(rpc Server, impl core.RegistrationAuthority,
 		return
 	})
 
+	rpc.Handle(MethodCheckPreAuthorization, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var r checkPreAuthorizationRequest
+		err = json.Unmarshal(req, &r)
+		if err != nil {
+			errorCondition(MethodCheckPreAuthorization, err, req)
+			return
+		}
+		preAuthorized, err := impl.CheckPreAuthorization(ctx, r.RegID, r.Names)
+		if err != nil {
+			return
+		}
+		response, err = json.Marshal(checkPreAuthorizationResponse{preAuthorized})
+		if err != nil {
+			errorCondition(MethodCheckPreAuthorization, err, req)
+			return
+		}
+		return
+	})
+
 	return nil
 }
 
@@ -548,6 +583,22 @@ func (rac RegistrationAuthorityClient) DeactivateRegistration(ctx context.Contex
 	return err
 }
 
+// CheckPreAuthorization asks whether regID may be issued certificates for
+// names without holding authorizations
+func (rac RegistrationAuthorityClient) CheckPreAuthorization(ctx context.Context, regID int64, names []string) (bool, error) {
+	data, err := json.Marshal(checkPreAuthorizationRequest{regID, names})
+	if err != nil {
+		return false, err
+	}
+	response, err := rac.rpc.DispatchSync(MethodCheckPreAuthorization, data)
+	if err != nil {
+		return false, err
+	}
+	var r checkPreAuthorizationResponse
+	err = json.Unmarshal(response, &r)
+	return r.PreAuthorized, err
+}
+
 // NewValidationAuthorityServer constructs an RPC server
 //
 // ValidationAuthorityClient / Server
@@ -847,6 +898,25 @@ func NewStorageAuthorityServer(rpc Server, impl core.StorageAuthority) error {
 		return
 	})
 
+	rpc.Handle(MethodGetChallenge, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var id int64
+		if err = json.Unmarshal(req, &id); err != nil {
+			improperMessage(MethodGetChallenge, err, req)
+			return
+		}
+		chall, authzID, err := impl.GetChallenge(ctx, id)
+		if err != nil {
+			return
+		}
+
+		response, err = json.Marshal(getChallengeResponse{chall, authzID})
+		if err != nil {
+			errorCondition(MethodGetChallenge, err, req)
+			return
+		}
+		return
+	})
+
 	rpc.Handle(MethodGetValidAuthorizations, func(ctx context.Context, req []byte) (response []byte, err error) {
 		var mreq getValidAuthorizationsRequest
 		if err = json.Unmarshal(req, &mreq); err != nil {
@@ -1207,6 +1277,24 @@ func (cac StorageAuthorityClient) GetAuthorization(ctx context.Context, id strin
 	return
 }
 
+// GetChallenge sends a request to get a Challenge, and the ID of its
+// authorization, by the challenge's ID
+func (cac StorageAuthorityClient) GetChallenge(ctx context.Context, id int64) (core.Challenge, string, error) {
+	data, err := json.Marshal(id)
+	if err != nil {
+		return core.Challenge{}, "", err
+	}
+	jsonResp, err := cac.rpc.DispatchSync(MethodGetChallenge, data)
+	if err != nil {
+		return core.Challenge{}, "", err
+	}
+	var resp getChallengeResponse
+	if err = json.Unmarshal(jsonResp, &resp); err != nil {
+		return core.Challenge{}, "", err
+	}
+	return resp.Challenge, resp.AuthorizationID, nil
+}
+
 // GetValidAuthorizations sends a request to get a batch of Authorizations by
 // RegID and dnsName. The current time is also included in the request to
 // assist filtering.
@@ -35,20 +35,45 @@ func TestIncrementIP(t *testing.T) {
 func TestIPRange(t *testing.T) {
 	testCases := []struct {
 		ip            string
+		ipv4Prefix    int
+		ipv6Prefix    int
 		expectedBegin string
 		expectedEnd   string
 	}{
-		{"28.45.45.28", "28.45.45.28", "28.45.45.29"},
-		{"2002:1001:4008::", "2002:1001:4008::", "2002:1001:4009::"},
+		{"28.45.45.28", 32, 48, "28.45.45.28", "28.45.45.29"},
+		{"2002:1001:4008::", 32, 48, "2002:1001:4008::", "2002:1001:4009::"},
+		{"28.45.45.28", 24, 48, "28.45.45.0", "28.45.46.0"},
+		{"2002:1001:4008:1:2:3:4:5", 32, 64, "2002:1001:4008:1::", "2002:1001:4008:2::"},
+		{"2002:1001:4008:1:2:3:4:5", 32, 128, "2002:1001:4008:1:2:3:4:5", "2002:1001:4008:1:2:3:4:6"},
 	}
 	for _, tc := range testCases {
 		ip := net.ParseIP(tc.ip)
 		expectedBegin := net.ParseIP(tc.expectedBegin)
 		expectedEnd := net.ParseIP(tc.expectedEnd)
-		actualBegin, actualEnd := ipRange(ip)
+		actualBegin, actualEnd := ipRange(ip, tc.ipv4Prefix, tc.ipv6Prefix)
 		if !expectedBegin.Equal(actualBegin) || !expectedEnd.Equal(actualEnd) {
-			t.Errorf("Expected ipRange(%s) to be (%s, %s), got (%s, %s)",
-				tc.ip, tc.expectedBegin, tc.expectedEnd, actualBegin, actualEnd)
+			t.Errorf("Expected ipRange(%s, %d, %d) to be (%s, %s), got (%s, %s)",
+				tc.ip, tc.ipv4Prefix, tc.ipv6Prefix, tc.expectedBegin, tc.expectedEnd, actualBegin, actualEnd)
 		}
 	}
 }
+
+func TestIPRangeSharedIPv6Prefix(t *testing.T) {
+	// Two addresses in the same /64 share a bucket with a /64 prefix, but not
+	// with a /128 one.
+	a := net.ParseIP("2001:db8:1:2:aaaa::1")
+	b := net.ParseIP("2001:db8:1:2:bbbb::2")
+
+	aBegin, aEnd := ipRange(a, DefaultIPv4RateLimitPrefix, 64)
+	bBegin, bEnd := ipRange(b, DefaultIPv4RateLimitPrefix, 64)
+	if !aBegin.Equal(bBegin) || !aEnd.Equal(bEnd) {
+		t.Errorf("Expected %s and %s to share a /64 range, got (%s, %s) and (%s, %s)",
+			a, b, aBegin, aEnd, bBegin, bEnd)
+	}
+
+	aBegin, _ = ipRange(a, DefaultIPv4RateLimitPrefix, 128)
+	bBegin, _ = ipRange(b, DefaultIPv4RateLimitPrefix, 128)
+	if aBegin.Equal(bBegin) {
+		t.Errorf("Expected %s and %s to have separate /128 ranges", a, b)
+	}
+}
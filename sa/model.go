@@ -228,8 +228,7 @@ type challModel struct {
 	Type   string          `db:"type"`
 	Status core.AcmeStatus `db:"status"`
 	Error  []byte          `db:"error"`
-	// This field is unused, but is kept temporarily to avoid a database migration.
-	// TODO(#1818): remove
+	// The time at which the challenge was successfully validated, if it was
 	Validated        *time.Time `db:"validated"`
 	Token            string     `db:"token"`
 	KeyAuthorization string     `db:"keyAuthorization"`
@@ -245,6 +244,13 @@ const getChallengesQuery = `
 		keyAuthorization, validationRecord
 	FROM challenges WHERE authorizationID = :authID ORDER BY id ASC`
 
+// getChallengeQuery fetches exactly the fields in challModel for a single
+// challenge.
+const getChallengeQuery = `
+	SELECT id, authorizationID, type, status, error, validated, token,
+		keyAuthorization, validationRecord
+	FROM challenges WHERE id = :id`
+
 // newReg creates a reg model object from a core.Registration
 func registrationToModel(r *core.Registration) (interface{}, error) {
 	key, err := json.Marshal(r.Key)
@@ -326,6 +332,7 @@ func challengeToModel(c *core.Challenge, authID string) (*challModel, error) {
 		Status:           c.Status,
 		Token:            c.Token,
 		KeyAuthorization: c.ProvidedKeyAuthorization,
+		Validated:        c.Validated,
 	}
 	if c.Error != nil {
 		errJSON, err := json.Marshal(c.Error)
@@ -357,6 +364,7 @@ func modelToChallenge(cm *challModel) (core.Challenge, error) {
 		Status: cm.Status,
 		Token:  cm.Token,
 		ProvidedKeyAuthorization: cm.KeyAuthorization,
+		Validated:                cm.Validated,
 	}
 	if len(cm.Error) > 0 {
 		var problem probs.ProblemDetails
@@ -2,7 +2,9 @@ package sa
 
 import (
 	"testing"
+	"time"
 
+	"github.com/letsencrypt/boulder/core"
 	"github.com/letsencrypt/boulder/features"
 )
 
@@ -43,3 +45,22 @@ func TestModelToRegistrationNonNilContact(t *testing.T) {
 		t.Errorf("Expected empty Contact field, got %#v", reg.Contact)
 	}
 }
+
+func TestChallengeModelValidated(t *testing.T) {
+	validated := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+	cm, err := challengeToModel(&core.Challenge{
+		Type:      core.ChallengeTypeHTTP01,
+		Status:    core.StatusValid,
+		Validated: &validated,
+	}, "authz")
+	if err != nil {
+		t.Fatalf("Got error from challengeToModel: %s", err)
+	}
+	chall, err := modelToChallenge(cm)
+	if err != nil {
+		t.Fatalf("Got error from modelToChallenge: %s", err)
+	}
+	if chall.Validated == nil || !chall.Validated.Equal(validated) {
+		t.Errorf("Expected Validated to be %s, got %v", validated, chall.Validated)
+	}
+}
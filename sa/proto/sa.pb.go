@@ -31,6 +31,8 @@ It has these top-level messages:
 	SignedCertificateTimestamp
 	RevokeAuthorizationsByDomainRequest
 	RevokeAuthorizationsByDomainResponse
+	ChallengeID
+	GetChallengeResponse
 */
 package proto
 
@@ -685,6 +687,48 @@ func (m *RevokeAuthorizationsByDomainResponse) GetPending() int64 {
 	return 0
 }
 
+type ChallengeID struct {
+	Id               *int64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *ChallengeID) Reset()                    { *m = ChallengeID{} }
+func (m *ChallengeID) String() string            { return proto1.CompactTextString(m) }
+func (*ChallengeID) ProtoMessage()               {}
+func (*ChallengeID) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+func (m *ChallengeID) GetId() int64 {
+	if m != nil && m.Id != nil {
+		return *m.Id
+	}
+	return 0
+}
+
+type GetChallengeResponse struct {
+	Challenge        *core.Challenge `protobuf:"bytes,1,opt,name=challenge" json:"challenge,omitempty"`
+	AuthorizationID  *string         `protobuf:"bytes,2,opt,name=authorizationID" json:"authorizationID,omitempty"`
+	XXX_unrecognized []byte          `json:"-"`
+}
+
+func (m *GetChallengeResponse) Reset()                    { *m = GetChallengeResponse{} }
+func (m *GetChallengeResponse) String() string            { return proto1.CompactTextString(m) }
+func (*GetChallengeResponse) ProtoMessage()               {}
+func (*GetChallengeResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+
+func (m *GetChallengeResponse) GetChallenge() *core.Challenge {
+	if m != nil {
+		return m.Challenge
+	}
+	return nil
+}
+
+func (m *GetChallengeResponse) GetAuthorizationID() string {
+	if m != nil && m.AuthorizationID != nil {
+		return *m.AuthorizationID
+	}
+	return ""
+}
+
 func init() {
 	proto1.RegisterType((*RegistrationID)(nil), "sa.RegistrationID")
 	proto1.RegisterType((*JsonWebKey)(nil), "sa.JsonWebKey")
@@ -710,6 +754,8 @@ func init() {
 	proto1.RegisterType((*SignedCertificateTimestamp)(nil), "sa.SignedCertificateTimestamp")
 	proto1.RegisterType((*RevokeAuthorizationsByDomainRequest)(nil), "sa.RevokeAuthorizationsByDomainRequest")
 	proto1.RegisterType((*RevokeAuthorizationsByDomainResponse)(nil), "sa.RevokeAuthorizationsByDomainResponse")
+	proto1.RegisterType((*ChallengeID)(nil), "sa.ChallengeID")
+	proto1.RegisterType((*GetChallengeResponse)(nil), "sa.GetChallengeResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -727,6 +773,7 @@ type StorageAuthorityClient interface {
 	GetRegistration(ctx context.Context, in *RegistrationID, opts ...grpc.CallOption) (*core.Registration, error)
 	GetRegistrationByKey(ctx context.Context, in *JsonWebKey, opts ...grpc.CallOption) (*core.Registration, error)
 	GetAuthorization(ctx context.Context, in *AuthorizationID, opts ...grpc.CallOption) (*core.Authorization, error)
+	GetChallenge(ctx context.Context, in *ChallengeID, opts ...grpc.CallOption) (*GetChallengeResponse, error)
 	GetValidAuthorizations(ctx context.Context, in *GetValidAuthorizationsRequest, opts ...grpc.CallOption) (*ValidAuthorizations, error)
 	GetCertificate(ctx context.Context, in *Serial, opts ...grpc.CallOption) (*core.Certificate, error)
 	GetCertificateStatus(ctx context.Context, in *Serial, opts ...grpc.CallOption) (*CertificateStatus, error)
@@ -786,6 +833,15 @@ func (c *storageAuthorityClient) GetAuthorization(ctx context.Context, in *Autho
 	return out, nil
 }
 
+func (c *storageAuthorityClient) GetChallenge(ctx context.Context, in *ChallengeID, opts ...grpc.CallOption) (*GetChallengeResponse, error) {
+	out := new(GetChallengeResponse)
+	err := grpc.Invoke(ctx, "/sa.StorageAuthority/GetChallenge", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *storageAuthorityClient) GetValidAuthorizations(ctx context.Context, in *GetValidAuthorizationsRequest, opts ...grpc.CallOption) (*ValidAuthorizations, error) {
 	out := new(ValidAuthorizations)
 	err := grpc.Invoke(ctx, "/sa.StorageAuthority/GetValidAuthorizations", in, out, c.cc, opts...)
@@ -982,6 +1038,7 @@ type StorageAuthorityServer interface {
 	GetRegistration(context.Context, *RegistrationID) (*core.Registration, error)
 	GetRegistrationByKey(context.Context, *JsonWebKey) (*core.Registration, error)
 	GetAuthorization(context.Context, *AuthorizationID) (*core.Authorization, error)
+	GetChallenge(context.Context, *ChallengeID) (*GetChallengeResponse, error)
 	GetValidAuthorizations(context.Context, *GetValidAuthorizationsRequest) (*ValidAuthorizations, error)
 	GetCertificate(context.Context, *Serial) (*core.Certificate, error)
 	GetCertificateStatus(context.Context, *Serial) (*CertificateStatus, error)
@@ -1064,6 +1121,24 @@ func _StorageAuthority_GetAuthorization_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StorageAuthority_GetChallenge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChallengeID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageAuthorityServer).GetChallenge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sa.StorageAuthority/GetChallenge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageAuthorityServer).GetChallenge(ctx, req.(*ChallengeID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _StorageAuthority_GetValidAuthorizations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetValidAuthorizationsRequest)
 	if err := dec(in); err != nil {
@@ -1458,6 +1533,10 @@ var _StorageAuthority_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetAuthorization",
 			Handler:    _StorageAuthority_GetAuthorization_Handler,
 		},
+		{
+			MethodName: "GetChallenge",
+			Handler:    _StorageAuthority_GetChallenge_Handler,
+		},
 		{
 			MethodName: "GetValidAuthorizations",
 			Handler:    _StorageAuthority_GetValidAuthorizations_Handler,
@@ -1550,80 +1629,82 @@ var _StorageAuthority_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("sa/proto/sa.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 1188 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x94, 0x57, 0xdd, 0x52, 0x1b, 0x47,
-	0x13, 0xd5, 0x8f, 0x05, 0xa8, 0xf5, 0x03, 0x1a, 0x90, 0x58, 0xef, 0x67, 0xbe, 0x90, 0x71, 0xaa,
-	0x2c, 0xdf, 0xe0, 0x98, 0x94, 0xe3, 0x0b, 0x42, 0xca, 0x80, 0x64, 0x02, 0x8e, 0x29, 0x47, 0xb2,
-	0x49, 0x55, 0xee, 0x06, 0x6d, 0x23, 0x4f, 0x90, 0x76, 0x37, 0x3b, 0x23, 0x84, 0x78, 0x84, 0x3c,
-	0x45, 0xde, 0x2e, 0x77, 0x79, 0x86, 0xd4, 0xcc, 0xac, 0xd0, 0xee, 0x6a, 0x05, 0xce, 0xdd, 0x6a,
-	0xa6, 0x4f, 0x77, 0x4f, 0xf7, 0xe9, 0xd3, 0x00, 0x35, 0xc1, 0x5e, 0xf8, 0x81, 0x27, 0xbd, 0x17,
-	0x82, 0xed, 0xe8, 0x0f, 0x92, 0x13, 0xcc, 0xae, 0xf7, 0xbc, 0x00, 0xc3, 0x0b, 0xf5, 0x69, 0xae,
-	0xe8, 0x13, 0xa8, 0x76, 0xb0, 0xcf, 0x85, 0x0c, 0x98, 0xe4, 0x9e, 0x7b, 0xd2, 0x22, 0x00, 0x39,
-	0xee, 0x58, 0xd9, 0xed, 0x6c, 0x33, 0x4f, 0x1f, 0x03, 0x9c, 0x0a, 0xcf, 0xfd, 0x15, 0x2f, 0xde,
-	0xe1, 0x84, 0x94, 0x20, 0xff, 0xfb, 0xf8, 0x4a, 0x5f, 0x95, 0xe9, 0x16, 0xac, 0x1e, 0x8c, 0xe4,
-	0x67, 0x2f, 0xe0, 0xb7, 0xf3, 0xc8, 0x22, 0xfd, 0x04, 0x5b, 0xc7, 0x28, 0xcf, 0xd9, 0x80, 0x3b,
-	0x31, 0x33, 0xd1, 0xc1, 0x3f, 0x46, 0x28, 0x24, 0x69, 0x40, 0x35, 0x88, 0x05, 0x36, 0x21, 0xc9,
-	0x2a, 0x2c, 0x3b, 0xde, 0x90, 0x71, 0x57, 0x58, 0xb9, 0xed, 0x7c, 0xb3, 0xa8, 0xa2, 0xba, 0xde,
-	0xd8, 0xca, 0xeb, 0x84, 0xfe, 0xcc, 0xc2, 0x7a, 0x8a, 0x53, 0xf2, 0x12, 0x0a, 0xd7, 0xea, 0xd8,
-	0xca, 0x6e, 0xe7, 0x9b, 0xa5, 0x5d, 0xba, 0x23, 0xd8, 0x4e, 0x8a, 0xdd, 0xce, 0x7b, 0xe6, 0xb7,
-	0x07, 0x38, 0x44, 0x57, 0xda, 0x6f, 0x00, 0x66, 0xbf, 0x48, 0x15, 0x96, 0x4c, 0x58, 0x93, 0x3f,
-	0xa1, 0x50, 0x60, 0x23, 0xf9, 0xf9, 0xd6, 0xca, 0x6d, 0x67, 0x9b, 0xa5, 0xdd, 0xf5, 0x1d, 0x5d,
-	0xb3, 0x98, 0x37, 0xfa, 0x4f, 0x16, 0x6a, 0x47, 0x18, 0x48, 0x7e, 0xc9, 0x7b, 0x4c, 0x62, 0x57,
-	0x32, 0x39, 0x12, 0xca, 0x93, 0xc0, 0x80, 0xb3, 0x41, 0xe8, 0xc9, 0x06, 0x22, 0x46, 0x17, 0xa2,
-	0x17, 0xf0, 0x0b, 0x0c, 0x0e, 0x7c, 0x3f, 0xf0, 0xae, 0xd1, 0xd1, 0x6e, 0x57, 0xb4, 0xad, 0x46,
-	0xe9, 0xe7, 0x15, 0xc9, 0x26, 0xac, 0x7a, 0x3d, 0xe1, 0xff, 0xcc, 0x84, 0xfc, 0xe4, 0x3b, 0x4c,
-	0xa2, 0x63, 0x3d, 0xd2, 0x55, 0x59, 0x87, 0x52, 0x80, 0xd7, 0xde, 0x15, 0x3a, 0x2d, 0x26, 0xd1,
-	0x2a, 0xe8, 0xc3, 0x3a, 0x54, 0xc2, 0xc3, 0x0e, 0x32, 0xe1, 0xb9, 0xd6, 0x92, 0x3e, 0xde, 0x82,
-	0xfa, 0x80, 0x09, 0xd9, 0xbe, 0xf1, 0xb9, 0xa9, 0xed, 0x19, 0xeb, 0x77, 0xd1, 0x95, 0xd6, 0xb2,
-	0xbe, 0xde, 0x80, 0xb2, 0x8a, 0xd1, 0x41, 0xe1, 0x7b, 0xae, 0x40, 0x6b, 0x45, 0xb5, 0x93, 0xac,
-	0xc1, 0x8a, 0xeb, 0xc9, 0x83, 0x4b, 0x89, 0x81, 0x55, 0xd4, 0x76, 0x35, 0x28, 0x72, 0xa1, 0x9d,
-	0xa0, 0x63, 0x81, 0x4a, 0x97, 0x5a, 0xb0, 0xd4, 0xd5, 0x4f, 0x4b, 0x3e, 0x92, 0x3e, 0x87, 0x42,
-	0x87, 0xb9, 0x7d, 0x54, 0x7e, 0x90, 0x05, 0x03, 0x8e, 0x42, 0x86, 0x0d, 0xad, 0xc2, 0xd2, 0x80,
-	0x49, 0xf5, 0x3b, 0xa7, 0x5b, 0xd8, 0x80, 0xc2, 0x91, 0x37, 0x72, 0x25, 0xa9, 0x40, 0xa1, 0xa7,
-	0x3e, 0x42, 0xae, 0x9d, 0xc2, 0x57, 0xfa, 0x3c, 0x52, 0x51, 0x71, 0x38, 0x39, 0x63, 0x43, 0xbc,
-	0xe3, 0x8c, 0x05, 0x85, 0x40, 0x45, 0xd1, 0x88, 0xd2, 0x6e, 0x51, 0x75, 0xd9, 0x84, 0xad, 0x40,
-	0xc1, 0x55, 0x96, 0x86, 0x33, 0x74, 0x00, 0x65, 0xed, 0x2b, 0xc4, 0x93, 0x97, 0x50, 0xee, 0x45,
-	0x7e, 0x87, 0x2c, 0xf9, 0x9f, 0xc2, 0x47, 0xed, 0xa2, 0xf4, 0x78, 0x1e, 0xa3, 0x47, 0x19, 0x1e,
-	0x29, 0xff, 0x61, 0x4b, 0xef, 0x32, 0x37, 0x2f, 0x6a, 0xc3, 0x96, 0xf6, 0x12, 0x1d, 0x24, 0x71,
-	0x38, 0x39, 0xf9, 0x30, 0xcd, 0x5b, 0x0d, 0x86, 0x6f, 0xe6, 0x66, 0xf6, 0x86, 0x5c, 0xe2, 0x0d,
-	0xf4, 0x15, 0x6c, 0x1c, 0xa3, 0xec, 0x1e, 0x7d, 0xec, 0x60, 0x0f, 0xb9, 0x2f, 0xa7, 0xe8, 0x24,
-	0xa1, 0x2a, 0x50, 0x18, 0x78, 0xfd, 0x93, 0x96, 0xf6, 0x50, 0xa4, 0xaf, 0x61, 0x43, 0x47, 0x7f,
-	0xfb, 0x4b, 0xeb, 0xac, 0x8b, 0x52, 0x44, 0x60, 0x63, 0xee, 0x3a, 0xde, 0x78, 0xc1, 0x60, 0xd1,
-	0x67, 0xb0, 0x11, 0x62, 0xda, 0x37, 0x5c, 0xcc, 0x80, 0x11, 0xc3, 0xac, 0x36, 0xb4, 0x60, 0xc9,
-	0x58, 0x28, 0x9f, 0xa8, 0xbf, 0xb4, 0xcf, 0x15, 0xba, 0x0f, 0x5b, 0xef, 0x59, 0x70, 0x15, 0x69,
-	0x59, 0x67, 0x4a, 0xc8, 0xf4, 0xdc, 0xcb, 0xf0, 0xa8, 0xe7, 0x39, 0x18, 0x16, 0xee, 0x3b, 0xa8,
-	0x1f, 0x38, 0x4e, 0x0c, 0x6d, 0x60, 0x25, 0xc8, 0x3b, 0x18, 0x84, 0x15, 0xab, 0x40, 0x21, 0xc0,
-	0xe9, 0x7b, 0xf3, 0xb4, 0x09, 0x8d, 0x24, 0xc8, 0x30, 0x59, 0xcf, 0x30, 0xef, 0x4f, 0x99, 0x57,
-	0xa4, 0x7f, 0x65, 0xc1, 0xee, 0xf2, 0xbe, 0x8b, 0x51, 0xeb, 0x8f, 0x7c, 0x88, 0x42, 0xb2, 0xa1,
-	0x1f, 0x15, 0x3a, 0x42, 0x00, 0x44, 0x4f, 0x9e, 0x63, 0x20, 0xb8, 0xe7, 0x9a, 0x40, 0xb3, 0x3a,
-	0x9b, 0xd9, 0xac, 0x41, 0x51, 0x4e, 0xb1, 0xe1, 0x54, 0x12, 0x00, 0xbc, 0x91, 0xe8, 0x2a, 0x90,
-	0xd0, 0x43, 0x59, 0x56, 0x66, 0x82, 0xf7, 0x5d, 0x26, 0x47, 0x01, 0xea, 0x81, 0x2c, 0x93, 0xc7,
-	0x50, 0xeb, 0x45, 0x64, 0xc2, 0xd4, 0x63, 0x59, 0xa7, 0xf8, 0x0a, 0x9e, 0x9a, 0x8a, 0xc5, 0x75,
-	0xea, 0x70, 0xd2, 0xd2, 0x1d, 0x88, 0x94, 0x31, 0xaa, 0x4e, 0xf4, 0x14, 0xbe, 0xb9, 0x1f, 0x16,
-	0x56, 0xa4, 0x06, 0xc5, 0x4b, 0xee, 0xb2, 0x01, 0xbf, 0x45, 0x67, 0x46, 0x03, 0x1f, 0x5d, 0x87,
-	0xbb, 0x7d, 0xf3, 0xcc, 0xdd, 0xbf, 0xcb, 0xb0, 0xd6, 0x95, 0x5e, 0xc0, 0xfa, 0x53, 0x6f, 0x72,
-	0x42, 0xf6, 0x60, 0xf5, 0x18, 0x63, 0x84, 0x26, 0x44, 0x33, 0x35, 0x26, 0xd9, 0x36, 0x31, 0xb2,
-	0x18, 0x3d, 0xa5, 0x19, 0xf2, 0x83, 0x26, 0x72, 0xf4, 0xf0, 0x70, 0xa2, 0xf6, 0x47, 0x55, 0x79,
-	0x98, 0xed, 0x93, 0x05, 0xe8, 0x1f, 0x61, 0xed, 0x18, 0x65, 0xec, 0x61, 0x64, 0x5d, 0x21, 0x13,
-	0xeb, 0xc6, 0x4e, 0xd5, 0xe4, 0x0c, 0x39, 0x87, 0x46, 0xfa, 0xe6, 0x21, 0x5f, 0x2b, 0x2f, 0xf7,
-	0x6e, 0x25, 0x7b, 0x73, 0xc1, 0xe2, 0xa0, 0x19, 0xf2, 0x12, 0xaa, 0xc7, 0x18, 0x55, 0x27, 0x02,
-	0xca, 0xd8, 0xb4, 0xd3, 0xae, 0x99, 0x64, 0x22, 0xd7, 0x34, 0x43, 0xf6, 0x74, 0x21, 0xe6, 0x57,
-	0x44, 0x14, 0x58, 0xd7, 0x22, 0x94, 0x34, 0xa1, 0x19, 0xf2, 0x2d, 0x34, 0xe6, 0xf4, 0xd0, 0x88,
-	0xdd, 0x4c, 0x33, 0xec, 0xe2, 0x9d, 0x84, 0xd1, 0x0c, 0xe9, 0x82, 0xb5, 0x48, 0x41, 0xc9, 0xd3,
-	0x3b, 0xc3, 0xc5, 0xfa, 0x6a, 0xaf, 0x25, 0x05, 0x91, 0x66, 0xc8, 0x4f, 0x61, 0x1a, 0x73, 0xe2,
-	0x66, 0xca, 0x79, 0xaf, 0xf0, 0xc5, 0xd3, 0xdb, 0x07, 0x5b, 0x7f, 0x7e, 0x30, 0xf4, 0x4b, 0x34,
-	0x27, 0x8d, 0x5e, 0x31, 0xf8, 0x3b, 0xa8, 0xc4, 0xe4, 0x91, 0x58, 0x61, 0x3b, 0xe7, 0x14, 0xd3,
-	0xfe, 0xbf, 0xae, 0xef, 0xc2, 0xc9, 0xa7, 0x19, 0xf2, 0x3d, 0x54, 0x62, 0xa2, 0x69, 0x9c, 0xa5,
-	0xe9, 0x68, 0x3c, 0x89, 0xd7, 0x50, 0x89, 0x69, 0xa6, 0xc1, 0xa5, 0xc9, 0xa8, 0xad, 0x9b, 0x6c,
-	0x8e, 0x34, 0x15, 0x56, 0xcf, 0x70, 0x9c, 0x18, 0xa8, 0x39, 0xfa, 0x2f, 0x18, 0x89, 0xd7, 0x40,
-	0xcc, 0x9f, 0x03, 0x0f, 0xe2, 0x4b, 0xe6, 0xac, 0x3d, 0xf4, 0xe5, 0x84, 0x66, 0x48, 0x1b, 0x36,
-	0xcf, 0x70, 0x9c, 0x56, 0x70, 0x92, 0x36, 0x3d, 0x8b, 0x46, 0xea, 0x0d, 0xd8, 0x26, 0xfe, 0x97,
-	0x7b, 0x4a, 0x24, 0xb2, 0x07, 0xf5, 0xb7, 0xa1, 0x10, 0xfd, 0x77, 0xf0, 0x29, 0x34, 0xd2, 0xb7,
-	0x8c, 0xa1, 0xe0, 0xbd, 0x1b, 0x28, 0xe9, 0xeb, 0x04, 0xaa, 0xf1, 0xed, 0x41, 0x1e, 0x6b, 0x6d,
-	0x49, 0x5b, 0x43, 0xb6, 0x9d, 0x76, 0x65, 0xa4, 0x55, 0x0b, 0x55, 0xe5, 0xc0, 0x71, 0x22, 0x84,
-	0x7c, 0x80, 0x76, 0xc9, 0x54, 0x04, 0x3c, 0xb9, 0x4f, 0xc4, 0xc9, 0x33, 0x33, 0x11, 0x0f, 0x6e,
-	0x07, 0xbb, 0xf9, 0xb0, 0xe1, 0x5d, 0xd2, 0x7b, 0xd0, 0x68, 0x21, 0xeb, 0x49, 0x7e, 0x3d, 0x4f,
-	0xa7, 0xf9, 0x01, 0x4c, 0x64, 0xbc, 0x0f, 0x9b, 0x33, 0xf0, 0x17, 0x28, 0x74, 0x1c, 0x7e, 0xb8,
-	0xfc, 0x5b, 0x41, 0xff, 0xd3, 0xf1, 0x6f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xc9, 0x9c, 0x67, 0x8d,
-	0xa3, 0x0c, 0x00, 0x00,
+	// 1226 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x95, 0x57, 0xdd, 0x56, 0xdb, 0x46,
+	0x10, 0x8e, 0xed, 0x18, 0xf0, 0xf8, 0x0f, 0x2f, 0xd8, 0x08, 0x35, 0x34, 0xa9, 0xd2, 0x73, 0x42,
+	0x6e, 0x48, 0x43, 0x4f, 0xca, 0x05, 0x25, 0x27, 0xfc, 0x38, 0x2d, 0xa4, 0xe1, 0xa4, 0x76, 0x42,
+	0xcf, 0xe9, 0xdd, 0x22, 0x2d, 0x8e, 0x8a, 0x2d, 0xa9, 0xda, 0x35, 0x60, 0x1e, 0xa1, 0x4f, 0xd1,
+	0x8b, 0x3e, 0x5f, 0x9f, 0xa1, 0xb3, 0xbb, 0xb2, 0x2d, 0xc9, 0x32, 0xa4, 0x77, 0xf2, 0xee, 0x7c,
+	0x33, 0xb3, 0x33, 0xf3, 0x7d, 0x03, 0xd0, 0xe0, 0xf4, 0x45, 0x10, 0xfa, 0xc2, 0x7f, 0xc1, 0xe9,
+	0x96, 0xfa, 0x20, 0x79, 0x4e, 0xcd, 0xa6, 0xed, 0x87, 0x2c, 0xba, 0x90, 0x9f, 0xfa, 0xca, 0x7a,
+	0x04, 0xb5, 0x0e, 0xeb, 0xb9, 0x5c, 0x84, 0x54, 0xb8, 0xbe, 0x77, 0x7c, 0x44, 0x00, 0xf2, 0xae,
+	0x63, 0xe4, 0x9e, 0xe4, 0x36, 0x0b, 0xd6, 0x3a, 0xc0, 0x09, 0xf7, 0xbd, 0xdf, 0xd8, 0xf9, 0x3b,
+	0x36, 0x22, 0x65, 0x28, 0xfc, 0x71, 0x7d, 0xa9, 0xae, 0x2a, 0xd6, 0x06, 0xd4, 0xf7, 0x87, 0xe2,
+	0xb3, 0x1f, 0xba, 0xb7, 0xb3, 0xc8, 0x92, 0xf5, 0x09, 0x36, 0x7e, 0x62, 0xe2, 0x8c, 0xf6, 0x5d,
+	0x27, 0x61, 0xc6, 0x3b, 0xec, 0xcf, 0x21, 0xe3, 0x82, 0xb4, 0xa0, 0x16, 0x26, 0x02, 0xeb, 0x90,
+	0xa4, 0x0e, 0x8b, 0x8e, 0x3f, 0xa0, 0xae, 0xc7, 0x8d, 0xfc, 0x93, 0xc2, 0x66, 0x49, 0x46, 0xf5,
+	0xfc, 0x6b, 0xa3, 0xa0, 0x12, 0xfa, 0x2b, 0x07, 0x2b, 0x19, 0x4e, 0xc9, 0x4b, 0x28, 0x5e, 0xc9,
+	0x63, 0x74, 0x52, 0xd8, 0x2c, 0x6f, 0x5b, 0x5b, 0xf8, 0xf6, 0x0c, 0xbb, 0xad, 0xf7, 0x34, 0x68,
+	0xf7, 0xd9, 0x80, 0x79, 0xc2, 0x7c, 0x03, 0x30, 0xfd, 0x45, 0x6a, 0xb0, 0xa0, 0xc3, 0xea, 0xfc,
+	0x89, 0x05, 0x45, 0x8a, 0xd0, 0x5b, 0x4c, 0x22, 0x87, 0x0e, 0x57, 0xb6, 0x54, 0xcd, 0x12, 0xde,
+	0xac, 0x7f, 0x73, 0xd0, 0x38, 0x64, 0xa1, 0x70, 0x2f, 0x5c, 0x9b, 0x0a, 0xd6, 0x15, 0x54, 0x0c,
+	0xb9, 0xf4, 0xc4, 0x59, 0xe8, 0xd2, 0x7e, 0xe4, 0xc9, 0x04, 0xc2, 0x87, 0xe7, 0xdc, 0x0e, 0xdd,
+	0x73, 0x16, 0xee, 0x07, 0x58, 0xf6, 0x2b, 0xe6, 0x28, 0xb7, 0x4b, 0xca, 0x56, 0xa1, 0xd4, 0xf3,
+	0x4a, 0x64, 0x0d, 0xea, 0xbe, 0xcd, 0x83, 0x5f, 0x28, 0x17, 0x9f, 0x02, 0x07, 0x7d, 0x3a, 0xc6,
+	0x43, 0x55, 0x95, 0x15, 0x28, 0x87, 0xec, 0xca, 0xbf, 0x64, 0xce, 0x11, 0x9e, 0x1a, 0x45, 0x75,
+	0xd8, 0x84, 0x6a, 0x74, 0xd8, 0x61, 0x14, 0xdb, 0x64, 0x2c, 0xa8, 0xe3, 0x0d, 0x68, 0xf6, 0xd1,
+	0x41, 0xfb, 0x26, 0x70, 0x75, 0x6d, 0x4f, 0x69, 0xaf, 0x8b, 0x6f, 0x34, 0x16, 0xd5, 0xf5, 0x2a,
+	0x54, 0x64, 0x8c, 0x0e, 0xe3, 0x01, 0x56, 0x84, 0x19, 0x4b, 0xb2, 0x9d, 0x64, 0x19, 0x96, 0x3c,
+	0x5f, 0xec, 0x5f, 0x08, 0x16, 0x1a, 0x25, 0x65, 0xd7, 0x80, 0x92, 0xcb, 0x95, 0x13, 0xcc, 0x02,
+	0x64, 0xba, 0x96, 0x01, 0x0b, 0x5d, 0xf5, 0xb4, 0xf4, 0x23, 0xad, 0xe7, 0x50, 0xec, 0x50, 0xaf,
+	0xc7, 0xa4, 0x1f, 0x46, 0xc3, 0xbe, 0x8b, 0x2d, 0x8e, 0x1a, 0x8a, 0xa6, 0x7d, 0xcc, 0x19, 0x7f,
+	0xe7, 0x55, 0x0b, 0x5b, 0x50, 0x3c, 0xf4, 0x87, 0x58, 0xf2, 0x2a, 0x14, 0x6d, 0xf9, 0x11, 0xcd,
+	0xda, 0x09, 0x3c, 0x56, 0xe7, 0xb1, 0x8a, 0xf2, 0x83, 0xd1, 0x29, 0x1d, 0xb0, 0xc9, 0xcc, 0x18,
+	0x50, 0x0c, 0x65, 0x14, 0x85, 0x28, 0x6f, 0x97, 0x64, 0x97, 0x75, 0x58, 0xf4, 0xe5, 0x49, 0x4b,
+	0x3d, 0x33, 0x56, 0x1f, 0x2a, 0xca, 0x57, 0x84, 0xc7, 0xf1, 0xa8, 0xd8, 0xb1, 0xdf, 0xd1, 0x94,
+	0x7c, 0x25, 0xf1, 0x71, 0xbb, 0xf8, 0x78, 0x3c, 0x4f, 0x8c, 0x47, 0x05, 0x1e, 0x4a, 0xff, 0x51,
+	0x4b, 0x27, 0x99, 0xeb, 0x17, 0xb5, 0x61, 0x43, 0x79, 0x89, 0x13, 0x09, 0x53, 0x3f, 0xfe, 0x30,
+	0xce, 0x5b, 0x12, 0x23, 0xd0, 0xbc, 0x99, 0xbe, 0x21, 0x9f, 0x7a, 0x83, 0xf5, 0x0a, 0x56, 0x91,
+	0x32, 0xdd, 0xc3, 0x8f, 0x1d, 0x66, 0x33, 0x37, 0x10, 0x63, 0x74, 0x7a, 0xa0, 0x30, 0x7a, 0xdf,
+	0xef, 0x21, 0x61, 0xf2, 0xaa, 0xf4, 0x3b, 0xb0, 0xaa, 0xa2, 0xbf, 0xfd, 0xf5, 0xe8, 0xb4, 0xcb,
+	0x04, 0x8f, 0xc1, 0xae, 0x5d, 0xcf, 0x41, 0xea, 0x64, 0x13, 0xcb, 0x7a, 0x06, 0xab, 0x11, 0xa6,
+	0x7d, 0x83, 0x79, 0x4f, 0x80, 0x31, 0xc3, 0x9c, 0x32, 0xc4, 0xb6, 0x6b, 0x0b, 0xe9, 0x93, 0xa9,
+	0x2f, 0xe5, 0x73, 0xc9, 0xda, 0x83, 0x8d, 0xf7, 0x34, 0xbc, 0x8c, 0xb5, 0xac, 0x33, 0x1e, 0xc8,
+	0xec, 0xdc, 0xb1, 0x8e, 0xb6, 0xef, 0xb0, 0xa8, 0x70, 0xdf, 0x43, 0x73, 0xdf, 0x71, 0x12, 0x68,
+	0x0d, 0x43, 0xce, 0x3b, 0x38, 0x88, 0xba, 0x62, 0xf8, 0x5e, 0x54, 0x8a, 0xe8, 0xbd, 0x05, 0x6b,
+	0x13, 0x5a, 0x69, 0x90, 0x9e, 0x64, 0xc5, 0x61, 0xb7, 0x37, 0x9e, 0xbc, 0x92, 0xf5, 0x77, 0x0e,
+	0xcc, 0xae, 0xdb, 0xf3, 0x58, 0xdc, 0xfa, 0xa3, 0x8b, 0x8d, 0x16, 0x74, 0x10, 0xc4, 0x85, 0x8e,
+	0xe0, 0x0f, 0x6e, 0x8b, 0x33, 0x16, 0x72, 0xec, 0x9d, 0x0e, 0x34, 0xad, 0xb3, 0xe6, 0x26, 0xf2,
+	0x41, 0x8c, 0xb1, 0x11, 0x2b, 0x11, 0xc5, 0x6e, 0x04, 0xf3, 0x24, 0x88, 0x2b, 0x52, 0x56, 0xa4,
+	0x19, 0xc7, 0x98, 0x48, 0xea, 0x90, 0x29, 0x42, 0x56, 0xc8, 0x3a, 0x34, 0xec, 0x98, 0x4c, 0xe8,
+	0x7a, 0x2c, 0xaa, 0x14, 0x5f, 0xc1, 0x53, 0x5d, 0xb1, 0xa4, 0x4e, 0x1d, 0x8c, 0x8e, 0x54, 0x07,
+	0x62, 0x65, 0x8c, 0xab, 0x13, 0x72, 0xe5, 0xdb, 0xbb, 0x61, 0x51, 0x45, 0x30, 0x99, 0x0b, 0xd7,
+	0x43, 0x1d, 0xbc, 0x65, 0xce, 0x74, 0x0c, 0x02, 0xe6, 0x39, 0xae, 0xd7, 0x8b, 0xea, 0xb9, 0x0e,
+	0xe5, 0xc3, 0xcf, 0xb4, 0xdf, 0x67, 0x38, 0x83, 0x29, 0xf9, 0xef, 0xaa, 0x89, 0x9c, 0xdc, 0x4e,
+	0xdc, 0x5a, 0x50, 0xb2, 0xc7, 0x87, 0x11, 0x17, 0xeb, 0x5a, 0x20, 0x27, 0xb6, 0x52, 0xca, 0x68,
+	0x72, 0x3f, 0xe8, 0x79, 0xdd, 0xfe, 0xa7, 0x0a, 0xcb, 0x5d, 0xe1, 0x87, 0xb4, 0x37, 0xce, 0x5e,
+	0x8c, 0xc8, 0x2e, 0xd4, 0x31, 0x52, 0x9c, 0x40, 0x84, 0x28, 0x66, 0x24, 0x56, 0x84, 0x49, 0x74,
+	0x94, 0xf8, 0xa9, 0xf5, 0x80, 0xfc, 0xa8, 0xd2, 0x8c, 0x1f, 0x1e, 0x8c, 0xe4, 0xbe, 0xaa, 0x49,
+	0x0f, 0xd3, 0xfd, 0x35, 0x07, 0xfd, 0x1a, 0x96, 0x11, 0x9d, 0x28, 0x24, 0x59, 0x91, 0xc8, 0xd4,
+	0x7a, 0x33, 0x33, 0x77, 0xc0, 0x03, 0x4c, 0xbd, 0x12, 0x2f, 0x12, 0xa9, 0x2b, 0x55, 0x99, 0x56,
+	0xd4, 0x34, 0xe4, 0x41, 0x56, 0x1d, 0x11, 0x7c, 0x06, 0xad, 0xec, 0x35, 0x49, 0xbe, 0x89, 0x50,
+	0xf3, 0x57, 0xa8, 0xb9, 0x36, 0x67, 0xcb, 0xa1, 0xdf, 0x97, 0x50, 0x93, 0x11, 0xa7, 0x53, 0x47,
+	0x40, 0x1a, 0xeb, 0xd9, 0x33, 0x1b, 0x51, 0xb3, 0xa6, 0xd7, 0xea, 0x1d, 0xab, 0x49, 0x48, 0xb4,
+	0xcf, 0xe2, 0xc0, 0xa6, 0x7a, 0x5b, 0xda, 0x04, 0xc1, 0xdf, 0x41, 0x6b, 0x46, 0xbc, 0xb5, 0x32,
+	0x4f, 0x05, 0xce, 0x2c, 0x4d, 0xf4, 0x16, 0x11, 0x5d, 0x30, 0xe6, 0xc9, 0x3d, 0x79, 0x3a, 0x31,
+	0x9c, 0xbf, 0x0c, 0xcc, 0xe5, 0xb4, 0x7a, 0xa3, 0xd3, 0x9f, 0xa3, 0x34, 0x66, 0x94, 0x58, 0x97,
+	0xf3, 0x4e, 0x95, 0x4e, 0xa6, 0xb7, 0x07, 0xa6, 0xfa, 0xfc, 0xa0, 0xb9, 0x92, 0x6a, 0x4e, 0xd6,
+	0x6c, 0x26, 0xe0, 0xef, 0xa0, 0x9a, 0xd0, 0x72, 0x32, 0x1e, 0x82, 0x19, 0x79, 0x37, 0xbf, 0x56,
+	0xf5, 0x9d, 0x2b, 0x53, 0xe8, 0xec, 0x07, 0xa8, 0x26, 0x14, 0x5e, 0x3b, 0xcb, 0x12, 0xfd, 0x64,
+	0x12, 0x3b, 0x50, 0x4d, 0x08, 0xbc, 0xc6, 0x65, 0x69, 0xbe, 0xa9, 0x9a, 0xac, 0x8f, 0xd4, 0x28,
+	0xd4, 0x4f, 0xd9, 0x75, 0x8a, 0x8d, 0x33, 0xdc, 0x99, 0xc3, 0xa7, 0x1d, 0x20, 0xfa, 0x6f, 0x97,
+	0x7b, 0xf1, 0x65, 0x7d, 0xd6, 0x1e, 0x04, 0x62, 0x84, 0xc0, 0x36, 0xac, 0x61, 0xd4, 0xac, 0x82,
+	0x93, 0x2c, 0xea, 0xcd, 0xe3, 0xe3, 0x1b, 0x30, 0x75, 0xfc, 0x2f, 0xf7, 0x94, 0x4a, 0x64, 0x17,
+	0x9a, 0x6f, 0x23, 0xd5, 0xfc, 0xff, 0xe0, 0x13, 0x68, 0x65, 0xaf, 0x44, 0x3d, 0x82, 0x77, 0xae,
+	0xcb, 0xb4, 0xaf, 0x63, 0xa8, 0x25, 0x57, 0x1d, 0x59, 0x57, 0xc2, 0x94, 0xb5, 0x33, 0x4d, 0x33,
+	0xeb, 0x6a, 0x22, 0x34, 0xaf, 0xa1, 0x8a, 0x77, 0xb1, 0x81, 0xbc, 0x67, 0xec, 0xd2, 0xa9, 0x70,
+	0x78, 0x74, 0xd7, 0xc6, 0x21, 0xcf, 0x34, 0x23, 0xee, 0x5d, 0x65, 0xe6, 0xe6, 0xfd, 0x86, 0x93,
+	0xa4, 0x77, 0xa1, 0x75, 0xc4, 0xa8, 0x2d, 0xdc, 0xab, 0xd9, 0x71, 0x9a, 0x25, 0x60, 0x2a, 0xe3,
+	0x3d, 0x58, 0x9b, 0x82, 0xbf, 0x40, 0xde, 0x93, 0xf0, 0x83, 0xc5, 0xdf, 0x8b, 0xea, 0x3f, 0xa4,
+	0xff, 0x00, 0x20, 0x45, 0x38, 0x18, 0x50, 0x0d, 0x00, 0x00,
 }
@@ -28,8 +28,24 @@ type SQLStorageAuthority struct {
 	dbMap *gorp.DbMap
 	clk   clock.Clock
 	log   blog.Logger
+
+	// IPv4RateLimitPrefix and IPv6RateLimitPrefix are the lengths of the
+	// network prefixes within which IP-based rate limits count addresses
+	// together. One client commonly controls a whole IPv6 /64 or more, so
+	// counting IPv6 addresses individually would make those limits trivial
+	// to evade.
+	IPv4RateLimitPrefix int
+	IPv6RateLimitPrefix int
 }
 
+const (
+	// DefaultIPv4RateLimitPrefix counts each IPv4 address on its own.
+	DefaultIPv4RateLimitPrefix = 32
+	// DefaultIPv6RateLimitPrefix counts a whole IPv6 /48 as one client, since
+	// it's not uncommon for one person to have a /48 to themselves.
+	DefaultIPv6RateLimitPrefix = 48
+)
+
 func digest256(data []byte) []byte {
 	d := sha256.New()
 	_, _ = d.Write(data) // Never returns an error
@@ -53,9 +69,11 @@ func NewSQLStorageAuthority(dbMap *gorp.DbMap, clk clock.Clock, logger blog.Logg
 	SetSQLDebug(dbMap, logger)
 
 	ssa := &SQLStorageAuthority{
-		dbMap: dbMap,
-		clk:   clk,
-		log:   logger,
+		dbMap:               dbMap,
+		clk:                 clk,
+		log:                 logger,
+		IPv4RateLimitPrefix: DefaultIPv4RateLimitPrefix,
+		IPv6RateLimitPrefix: DefaultIPv6RateLimitPrefix,
 	}
 
 	return ssa, nil
@@ -213,6 +231,23 @@ func (ssa *SQLStorageAuthority) GetAuthorization(ctx context.Context, id string)
 	return
 }
 
+// GetChallenge obtains a Challenge by ID, along with the ID of the
+// authorization it belongs to.
+func (ssa *SQLStorageAuthority) GetChallenge(ctx context.Context, id int64) (core.Challenge, string, error) {
+	var cm challModel
+	err := ssa.dbMap.SelectOne(&cm, getChallengeQuery, map[string]interface{}{"id": id})
+	if err == sql.ErrNoRows {
+		return core.Challenge{}, "", core.NotFoundError(fmt.Sprintf("No challenge with ID %d", id))
+	} else if err != nil {
+		return core.Challenge{}, "", err
+	}
+	chall, err := modelToChallenge(&cm)
+	if err != nil {
+		return core.Challenge{}, "", err
+	}
+	return chall, cm.AuthorizationID, nil
+}
+
 // GetValidAuthorizations returns the latest authorization object for all
 // domain names from the parameters that the account has authorizations for.
 func (ssa *SQLStorageAuthority) GetValidAuthorizations(ctx context.Context, registrationID int64, names []string, now time.Time) (map[string]*core.Authorization, error) {
@@ -285,19 +320,16 @@ func incrementIP(ip net.IP, index int) net.IP {
 
 // ipRange returns a range of IP addresses suitable for querying MySQL for the
 // purpose of rate limiting using a range that is inclusive on the lower end and
-// exclusive at the higher end. If ip is an IPv4 address, it returns that address,
-// plus the one immediately higher than it. If ip is an IPv6 address, it applies
-// a /48 mask to it and returns the lowest IP in the resulting network, and the
-// first IP outside of the resulting network.
-func ipRange(ip net.IP) (net.IP, net.IP) {
+// exclusive at the higher end. It applies an ipv4Prefix or ipv6Prefix mask to
+// ip, depending on its family, and returns the lowest IP in the resulting
+// network, and the first IP outside of the resulting network. With a /32 IPv4
+// prefix, that's just ip and the one immediately higher than it.
+func ipRange(ip net.IP, ipv4Prefix, ipv6Prefix int) (net.IP, net.IP) {
 	ip = ip.To16()
-	// For IPv6, match on a certain subnet range, since one person can commonly
-	// have an entire /48 to themselves.
-	maskLength := 48
-	// For IPv4 addresses, do a match on exact address, so begin = ip and end =
-	// next higher IP.
+	maskLength := ipv6Prefix
+	// IPv4 addresses are held in the last 32 bits of their IPv6 form.
 	if ip.To4() != nil {
-		maskLength = 128
+		maskLength = 96 + ipv4Prefix
 	}
 
 	mask := net.CIDRMask(maskLength, 128)
@@ -308,12 +340,12 @@ func ipRange(ip net.IP) (net.IP, net.IP) {
 }
 
 // CountRegistrationsByIP returns the number of registrations created in the
-// time range in an IP range. For IPv4 addresses, that range is limited to the
-// single IP. For IPv6 addresses, that range is a /48, since it's not uncommon
-// for one person to have a /48 to themselves.
+// time range in an IP range: the network of IPv4RateLimitPrefix or
+// IPv6RateLimitPrefix bits containing ip. By default, that's the single IP for
+// IPv4 addresses, and a /48 for IPv6 addresses.
 func (ssa *SQLStorageAuthority) CountRegistrationsByIP(ctx context.Context, ip net.IP, earliest time.Time, latest time.Time) (int, error) {
 	var count int64
-	beginIP, endIP := ipRange(ip)
+	beginIP, endIP := ipRange(ip, ssa.IPv4RateLimitPrefix, ssa.IPv6RateLimitPrefix)
 	err := ssa.dbMap.SelectOne(
 		&count,
 		`SELECT COUNT(1) FROM registrations
@@ -418,14 +450,22 @@ func (ssa *SQLStorageAuthority) GetCertificate(ctx context.Context, serial strin
 		return core.Certificate{}, err
 	}
 
-	cert, err := SelectCertificate(ssa.dbMap, "WHERE serial = ?", serial)
-	if err == sql.ErrNoRows {
-		return core.Certificate{}, core.NotFoundError(fmt.Sprintf("No certificate found for %s", serial))
-	}
+	// Two rows are enough to tell that serial isn't unique, as a short serial
+	// may not be.
+	certs, err := SelectCertificates(ssa.dbMap, "WHERE serial = :serial LIMIT 2",
+		map[string]interface{}{"serial": serial})
 	if err != nil {
 		return core.Certificate{}, err
 	}
-	return cert, err
+	switch len(certs) {
+	case 0:
+		return core.Certificate{}, core.NotFoundError(fmt.Sprintf("No certificate found for %s", serial))
+	case 1:
+		return certs[0], nil
+	default:
+		return core.Certificate{}, core.MultipleMatchingCertificatesError(
+			fmt.Sprintf("Multiple certificates found for %s", serial))
+	}
 }
 
 // GetCertificateStatus takes a hexadecimal string representing the full 128-bit serial
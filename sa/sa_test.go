@@ -202,6 +202,30 @@ func TestAddAuthorization(t *testing.T) {
 	test.AssertNotError(t, err, "Couldn't get authorization with ID "+PA.ID)
 }
 
+func TestGetChallenge(t *testing.T) {
+	sa, _, cleanUp := initSA(t)
+	defer cleanUp()
+
+	reg := satest.CreateWorkingRegistration(t, sa)
+	authz, err := sa.NewPendingAuthorization(ctx, core.Authorization{
+		RegistrationID: reg.ID,
+		Challenges:     []core.Challenge{{Type: core.ChallengeTypeHTTP01, Token: "tok"}},
+	})
+	test.AssertNotError(t, err, "Couldn't create new pending authorization")
+
+	chall, authzID, err := sa.GetChallenge(ctx, authz.Challenges[0].ID)
+	test.AssertNotError(t, err, "Couldn't get challenge")
+	test.AssertEquals(t, authzID, authz.ID)
+	test.AssertEquals(t, chall.ID, authz.Challenges[0].ID)
+	test.AssertEquals(t, chall.Type, core.ChallengeTypeHTTP01)
+	test.AssertEquals(t, chall.Token, "tok")
+
+	_, _, err = sa.GetChallenge(ctx, authz.Challenges[0].ID+1000)
+	test.AssertError(t, err, "Got a challenge that doesn't exist")
+	_, ok := err.(core.NotFoundError)
+	test.Assert(t, ok, "Missing challenge wasn't a NotFoundError")
+}
+
 func CreateDomainAuth(t *testing.T, domainName string, sa *SQLStorageAuthority) (authz core.Authorization) {
 	return CreateDomainAuthWithRegID(t, domainName, sa, 42)
 }
@@ -248,6 +248,7 @@ function rotateAccountKey() {
     makeAccountKeyPair("new-account-key.pem", function() {
         var payload = JSON.stringify({
             newKey: state.acme.privateKey.publicKey,
+            oldKey: oldAcme.privateKey.publicKey,
             account: state.registrationURL,
         }, null, 2)
         var signed = cryptoUtil.generateSignature(state.acme.privateKey, new Buffer(payload), null);
@@ -3,7 +3,10 @@ package wfe
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -28,12 +31,56 @@ type requestEvent struct {
 	ResponseNonce string                 `json:",omitempty"`
 	UserAgent     string                 `json:",omitempty"`
 	Extra         map[string]interface{} `json:",omitempty"`
+
+	// timings accumulates the time spent in each segment of handling the
+	// request, e.g. "JWS" or "SA", for the Server-Timing header.
+	timings []timing
+
+	// acceptLanguage is the request's Accept-Language header, which selects
+	// the language of problem details.
+	acceptLanguage string
+}
+
+// minimalRequestEvent is the part of a requestEvent that is logged for
+// requests not sampled for full logging.
+type minimalRequestEvent struct {
+	ID        string `json:",omitempty"`
+	Endpoint  string `json:",omitempty"`
+	Method    string `json:",omitempty"`
+	Requester int64  `json:",omitempty"`
+}
+
+// timing is the time spent so far in one segment of handling a request.
+type timing struct {
+	name     string
+	duration time.Duration
 }
 
 func (e *requestEvent) AddError(msg string, args ...interface{}) {
 	e.Errors = append(e.Errors, fmt.Sprintf(msg, args...))
 }
 
+// addTiming adds d to the time spent in the segment name.
+func (e *requestEvent) addTiming(name string, d time.Duration) {
+	for i := range e.timings {
+		if e.timings[i].name == name {
+			e.timings[i].duration += d
+			return
+		}
+	}
+	e.timings = append(e.timings, timing{name, d})
+}
+
+// serverTiming formats the timings as the value of a Server-Timing header,
+// with durations in milliseconds.
+func (e *requestEvent) serverTiming() string {
+	metrics := make([]string, len(e.timings))
+	for i, t := range e.timings {
+		metrics[i] = fmt.Sprintf("%s;dur=%.3f", t.name, float64(t.duration)/float64(time.Millisecond))
+	}
+	return strings.Join(metrics, ", ")
+}
+
 type wfeHandlerFunc func(context.Context, *requestEvent, http.ResponseWriter, *http.Request)
 
 func (f wfeHandlerFunc) ServeHTTP(e *requestEvent, w http.ResponseWriter, r *http.Request) {
@@ -49,6 +96,23 @@ type topHandler struct {
 	wfe wfeHandler
 	log blog.Logger
 	clk clock.Clock
+
+	// dedupeLinks drops repeated values of the Link header from responses.
+	dedupeLinks bool
+	// maxLinks, if non-zero, is the most Link headers a response may have.
+	// Optional ones beyond it are dropped with a warning; essential ones are
+	// always sent.
+	maxLinks int
+	// timing adds a Server-Timing header from the request's timings.
+	timing bool
+	// maxHeaderBytes, if non-zero, caps the total size of a response's
+	// headers. Optional headers are dropped, with a warning, to fit. Headers
+	// that still don't fit are sent anyway.
+	maxHeaderBytes int
+	// logSampleRate, if between zero and one, is the fraction of requests
+	// whose whole requestEvent is logged. Only the minimalRequestEvent of the
+	// rest is. Otherwise every request is logged in full.
+	logSampleRate float64
 }
 
 func (th *topHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -60,13 +124,198 @@ func (th *topHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		RequestTime: time.Now(),
 		UserAgent:   r.Header.Get("User-Agent"),
 		Extra:       make(map[string]interface{}, 0),
+
+		acceptLanguage: r.Header.Get("Accept-Language"),
 	}
 	w.Header().Set("Boulder-Request-ID", logEvent.ID)
 	defer th.logEvent(logEvent)
 
+	if th.dedupeLinks || th.maxLinks > 0 || th.timing || th.maxHeaderBytes > 0 {
+		fw := &finalizingWriter{ResponseWriter: w, th: th, logEvent: logEvent}
+		if r.URL != nil {
+			fw.endpoint = r.URL.Path
+		}
+		w = fw
+	}
 	th.wfe.ServeHTTP(logEvent, w, r)
 }
 
+// finalizeHeaders makes the last changes to header, which is about to be
+// written in the response to the request logEvent describes, for endpoint:
+// the deduplication and cap on Link headers, the Server-Timing header, and
+// the cap on total header size.
+func (th *topHandler) finalizeHeaders(header http.Header, endpoint string, logEvent *requestEvent) {
+	if th.dedupeLinks {
+		dedupeHeader(header, "Link")
+	}
+	if links := len(header["Link"]); th.maxLinks > 0 && links > th.maxLinks {
+		dropped := dropOptionalLinks(header, func() bool { return len(header["Link"]) <= th.maxLinks })
+		if dropped > 0 {
+			th.log.Warning(fmt.Sprintf("Dropping %d of %d Link headers in response to %s",
+				dropped, links, endpoint))
+		}
+		if remaining := len(header["Link"]); remaining > th.maxLinks {
+			th.log.Warning(fmt.Sprintf("Sending %d essential Link headers, over the cap of %d, in response to %s",
+				remaining, th.maxLinks, endpoint))
+		}
+	}
+	if th.timing && len(logEvent.timings) > 0 {
+		header.Set("Server-Timing", logEvent.serverTiming())
+	}
+	if th.maxHeaderBytes > 0 {
+		if size := headerSize(header); size > th.maxHeaderBytes {
+			if dropped := trimHeader(header, th.maxHeaderBytes); len(dropped) > 0 {
+				th.log.Warning(fmt.Sprintf("Dropping %s from %d bytes of headers in response to %s",
+					strings.Join(dropped, ", "), size, endpoint))
+			}
+			if size := headerSize(header); size > th.maxHeaderBytes {
+				th.log.Warning(fmt.Sprintf("Sending %d bytes of headers, over the cap of %d, in response to %s",
+					size, th.maxHeaderBytes, endpoint))
+			}
+		}
+	}
+}
+
+// optionalHeaders are the response headers that can be dropped to keep the
+// headers under topHandler.maxHeaderBytes, least important first. Optional
+// Link headers are dropped one at a time, from the last, after all of these.
+var optionalHeaders = []string{
+	"Server-Timing",
+	"Boulder-Server-Time",
+	"Boulder-RateLimit-Bucket",
+	"Boulder-Account-Thumbprint",
+}
+
+// essentialLinkRelations are the Link relations clients need to follow the
+// protocol. Link headers with them are never dropped.
+var essentialLinkRelations = map[string]bool{
+	"up":               true,
+	"next":             true,
+	"terms-of-service": true,
+}
+
+// essentialLink returns true if the Link header value has an essential
+// relation.
+func essentialLink(value string) bool {
+	for _, param := range strings.Split(value, ";")[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(strings.ToLower(param), "rel=") {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.Trim(param[len("rel="):], `"`)) {
+			if essentialLinkRelations[strings.ToLower(rel)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropOptionalLinks drops Link headers without an essential relation, from
+// the last, until done returns true or there are none left, and returns how
+// many it dropped.
+func dropOptionalLinks(header http.Header, done func() bool) int {
+	dropped := 0
+	for i := len(header["Link"]) - 1; i >= 0 && !done(); i-- {
+		links := header["Link"]
+		if essentialLink(links[i]) {
+			continue
+		}
+		header["Link"] = append(links[:i:i], links[i+1:]...)
+		dropped++
+	}
+	if len(header["Link"]) == 0 {
+		header.Del("Link")
+	}
+	return dropped
+}
+
+// headerSize returns the size of header as written in an HTTP/1.1 response.
+func headerSize(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, v := range values {
+			size += len(name) + len(": ") + len(v) + len("\r\n")
+		}
+	}
+	return size
+}
+
+// trimHeader drops optional headers, including optional Link headers, until
+// header fits in maxBytes, or there are none left to drop, and returns what it
+// dropped.
+func trimHeader(header http.Header, maxBytes int) []string {
+	var dropped []string
+	for _, name := range optionalHeaders {
+		if headerSize(header) <= maxBytes {
+			return dropped
+		}
+		if _, present := header[http.CanonicalHeaderKey(name)]; present {
+			header.Del(name)
+			dropped = append(dropped, name)
+		}
+	}
+	links := dropOptionalLinks(header, func() bool { return headerSize(header) <= maxBytes })
+	if links > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d Link headers", links))
+	}
+	return dropped
+}
+
+// finalizingWriter is an http.ResponseWriter that has its topHandler
+// finalize the headers just before they are written.
+type finalizingWriter struct {
+	http.ResponseWriter
+	th          *topHandler
+	logEvent    *requestEvent
+	endpoint    string
+	wroteHeader bool
+}
+
+func (w *finalizingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.th.finalizeHeaders(w.Header(), w.endpoint, w.logEvent)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *finalizingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// dedupeHeader removes all but the first of each identical value of the
+// header name, keeping their order.
+func dedupeHeader(header http.Header, name string) {
+	values := header[http.CanonicalHeaderKey(name)]
+	if len(values) < 2 {
+		return
+	}
+	seen := make(map[string]bool, len(values))
+	var deduped []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			deduped = append(deduped, v)
+		}
+	}
+	header[http.CanonicalHeaderKey(name)] = deduped
+}
+
+// sampled returns true if the request with the given ID should be logged in
+// full. The choice depends only on the ID, so that it can be reproduced.
+func (th *topHandler) sampled(id string) bool {
+	if th.logSampleRate <= 0 || th.logSampleRate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return float64(h.Sum64())/math.MaxUint64 < th.logSampleRate
+}
+
 func (th *topHandler) logEvent(logEvent *requestEvent) {
 	logEvent.ResponseTime = th.clk.Now()
 	var msg string
@@ -75,7 +324,16 @@ func (th *topHandler) logEvent(logEvent *requestEvent) {
 	} else {
 		msg = "Successful request"
 	}
-	jsonEvent, err := json.Marshal(logEvent)
+	var event interface{} = logEvent
+	if !th.sampled(logEvent.ID) {
+		event = minimalRequestEvent{
+			ID:        logEvent.ID,
+			Endpoint:  logEvent.Endpoint,
+			Method:    logEvent.Method,
+			Requester: logEvent.Requester,
+		}
+	}
+	jsonEvent, err := json.Marshal(event)
 	if err != nil {
 		th.log.AuditErr(fmt.Sprintf("%s - failed to marshal logEvent - %s", msg, err))
 		return
@@ -0,0 +1,113 @@
+package wfe
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// idempotencyKeyHeader is the request header clients may set on challenge
+// POSTs so that a retry of the same request is answered from the first
+// response rather than sent on to the RA again.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeys caps the number of keys an idempotencyCache remembers.
+// Requests with new keys aren't deduplicated while it's full.
+const maxIdempotencyKeys = 10000
+
+// idempotencySweepInterval is how often an idempotencyCache drops its expired
+// responses, unless it fills up sooner.
+const idempotencySweepInterval = time.Minute
+
+type idempotentResponse struct {
+	challenge core.Challenge
+	expires   time.Time
+	// pending is true until the request that claimed the key finishes. ready
+	// is closed then, whether or not it succeeded.
+	pending bool
+	ready   chan struct{}
+}
+
+// idempotencyCache remembers the challenge returned for each idempotency key
+// until it expires. Requests with a key that's still being answered wait for
+// that answer instead of going to the RA themselves.
+type idempotencyCache struct {
+	sync.Mutex
+	clk        clock.Clock
+	responses  map[string]*idempotentResponse
+	maxEntries int
+	nextSweep  time.Time
+}
+
+func newIdempotencyCache(clk clock.Clock) *idempotencyCache {
+	return &idempotencyCache{
+		clk:        clk,
+		responses:  make(map[string]*idempotentResponse),
+		maxEntries: maxIdempotencyKeys,
+	}
+}
+
+// claim returns the challenge stored under key, if it has not expired. If
+// there is none, the caller must answer the request itself and pass the
+// challenge it answered with, or nil if it failed, to finish. Until then,
+// claims of the same key wait.
+func (c *idempotencyCache) claim(key string) (challenge core.Challenge, cached bool, finish func(*core.Challenge, time.Duration)) {
+	for {
+		c.Lock()
+		now := c.clk.Now()
+		resp, ok := c.responses[key]
+		if ok && resp.pending {
+			c.Unlock()
+			<-resp.ready
+			continue
+		}
+		if ok && now.Before(resp.expires) {
+			c.Unlock()
+			return resp.challenge, true, nil
+		}
+		if ok {
+			delete(c.responses, key)
+		}
+		if !now.Before(c.nextSweep) {
+			c.sweep(now)
+		}
+		if len(c.responses) >= c.maxEntries {
+			c.Unlock()
+			return core.Challenge{}, false, func(*core.Challenge, time.Duration) {}
+		}
+		resp = &idempotentResponse{pending: true, ready: make(chan struct{})}
+		c.responses[key] = resp
+		c.Unlock()
+		return core.Challenge{}, false, func(challenge *core.Challenge, ttl time.Duration) {
+			c.finish(key, resp, challenge, ttl)
+		}
+	}
+}
+
+// finish stores challenge in resp for ttl, or forgets key if challenge is
+// nil, and wakes the requests waiting on it.
+func (c *idempotencyCache) finish(key string, resp *idempotentResponse, challenge *core.Challenge, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	if challenge == nil {
+		delete(c.responses, key)
+	} else {
+		resp.challenge = *challenge
+		resp.expires = c.clk.Now().Add(ttl)
+	}
+	resp.pending = false
+	close(resp.ready)
+}
+
+// sweep drops expired responses. Only to be called while c's lock is held.
+func (c *idempotencyCache) sweep(now time.Time) {
+	for k, resp := range c.responses {
+		if !resp.pending && !now.Before(resp.expires) {
+			delete(c.responses, k)
+		}
+	}
+	c.nextSweep = now.Add(idempotencySweepInterval)
+}
@@ -4,11 +4,21 @@ import (
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"fmt"
+	"strings"
 
 	"github.com/letsencrypt/boulder/core"
 	"gopkg.in/square/go-jose.v1"
 )
 
+// SupportedJWSAlgorithms are the JWS algorithms requests may be signed with,
+// each for its own key type and curve.
+var SupportedJWSAlgorithms = []string{
+	string(jose.RS256),
+	string(jose.ES256),
+	string(jose.ES384),
+	string(jose.ES512),
+}
+
 func algorithmForKey(key *jose.JsonWebKey) (string, error) {
 	switch k := key.Key.(type) {
 	case *rsa.PublicKey:
@@ -30,20 +40,64 @@ const (
 	noAlgorithmForKey     = "WFE.Errors.NoAlgorithmForKey"
 	invalidJWSAlgorithm   = "WFE.Errors.InvalidJWSAlgorithm"
 	invalidAlgorithmOnKey = "WFE.Errors.InvalidAlgorithmOnKey"
+	ecAlgorithmForRSAKey  = "WFE.Errors.ECAlgorithmForRSAKey"
+	rsaAlgorithmForECKey  = "WFE.Errors.RSAAlgorithmForECKey"
+	ecAlgorithmWrongCurve = "WFE.Errors.ECAlgorithmWrongCurve"
+	forbiddenJWSAlgorithm = "WFE.Errors.ForbiddenJWSAlgorithm"
 )
 
+// forbiddenAlgorithm returns true for JWS algorithms that must never be
+// accepted: "none", which isn't signed at all, and the HMAC algorithms, whose
+// "key" would be the public JWK.
+func forbiddenAlgorithm(jwsAlgorithm string) bool {
+	return strings.EqualFold(jwsAlgorithm, "none") || strings.HasPrefix(strings.ToUpper(jwsAlgorithm), "HS")
+}
+
+// algorithmMismatch returns the stat name for a JWS signed with jwsAlgorithm,
+// a known algorithm for a different key type or curve than that of a key
+// whose algorithm is keyAlgorithm, or "" if jwsAlgorithm isn't one of those.
+func algorithmMismatch(keyAlgorithm, jwsAlgorithm string) string {
+	keyIsEC := strings.HasPrefix(keyAlgorithm, "ES")
+	switch jose.SignatureAlgorithm(jwsAlgorithm) {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		if keyIsEC {
+			return rsaAlgorithmForECKey
+		}
+	case jose.ES256, jose.ES384, jose.ES512:
+		if keyIsEC {
+			return ecAlgorithmWrongCurve
+		}
+		return ecAlgorithmForRSAKey
+	}
+	return ""
+}
+
 // Check that (1) there is a suitable algorithm for the provided key based on its
-// Golang type, (2) the Algorithm field on the JWK is either absent, or matches
-// that algorithm, and (3) the Algorithm field on the JWK is present and matches
-// that algorithm. Precondition: parsedJws must have exactly one signature on
-// it. Returns stat name to increment if err is non-nil.
+// Golang type, (2) the JWS algorithm isn't a forbidden one such as "none", (3)
+// the Algorithm field on the JWK is either absent, or matches that algorithm,
+// and (4) the Algorithm field on the JWS is present and matches that
+// algorithm. A JWS algorithm meant for another key type or curve than the
+// key's is reported with its own stat. Precondition: parsedJws must have
+// exactly one signature on it. Returns stat name to increment if err is
+// non-nil.
 func checkAlgorithm(key *jose.JsonWebKey, parsedJws *jose.JsonWebSignature) (string, error) {
 	algorithm, err := algorithmForKey(key)
 	if err != nil {
 		return noAlgorithmForKey, err
 	}
 	jwsAlgorithm := parsedJws.Signatures[0].Header.Algorithm
+	if forbiddenAlgorithm(jwsAlgorithm) {
+		return forbiddenJWSAlgorithm,
+			core.SignatureValidationError(fmt.Sprintf(
+				"signature type '%s' in JWS header is forbidden", jwsAlgorithm))
+	}
 	if jwsAlgorithm != algorithm {
+		if stat := algorithmMismatch(algorithm, jwsAlgorithm); stat != "" {
+			return stat,
+				core.SignatureValidationError(fmt.Sprintf(
+					"signature type '%s' in JWS header does not match the JWK, expected %s",
+					jwsAlgorithm, algorithm))
+		}
 		return invalidJWSAlgorithm,
 			core.SignatureValidationError(fmt.Sprintf(
 				"signature type '%s' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
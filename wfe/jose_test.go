@@ -28,7 +28,7 @@ func TestRejectsNone(t *testing.T) {
 	if prob == nil {
 		t.Fatalf("verifyPOST did not reject JWS with alg: 'none'")
 	}
-	if prob.Detail != "signature type 'none' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512" {
+	if prob.Detail != "signature type 'none' in JWS header is forbidden" {
 		t.Fatalf("verifyPOST rejected JWS with alg: 'none', but for wrong reason: %#v", prob)
 	}
 }
@@ -52,7 +52,7 @@ func TestRejectsHS256(t *testing.T) {
 	if prob == nil {
 		t.Fatalf("verifyPOST did not reject JWS with alg: 'HS256'")
 	}
-	expected := "signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512"
+	expected := "signature type 'HS256' in JWS header is forbidden"
 	if prob.Detail != expected {
 		t.Fatalf("verifyPOST rejected JWS with alg: 'none', but for wrong reason: got '%s', wanted %s", prob, expected)
 	}
@@ -86,8 +86,8 @@ func TestCheckAlgorithm(t *testing.T) {
 					},
 				},
 			},
-			"signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
-			"WFE.Errors.InvalidJWSAlgorithm",
+			"signature type 'HS256' in JWS header is forbidden",
+			"WFE.Errors.ForbiddenJWSAlgorithm",
 		},
 		{
 			jose.JsonWebKey{
@@ -103,8 +103,8 @@ func TestCheckAlgorithm(t *testing.T) {
 					},
 				},
 			},
-			"signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
-			"WFE.Errors.InvalidJWSAlgorithm",
+			"signature type 'HS256' in JWS header is forbidden",
+			"WFE.Errors.ForbiddenJWSAlgorithm",
 		},
 		{
 			jose.JsonWebKey{
@@ -123,6 +123,92 @@ func TestCheckAlgorithm(t *testing.T) {
 			"algorithm 'HS256' on JWK is unacceptable",
 			"WFE.Errors.InvalidAlgorithmOnKey",
 		},
+		{
+			jose.JsonWebKey{
+				Key: &rsa.PublicKey{},
+			},
+			jose.JsonWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Header: jose.JoseHeader{
+							Algorithm: "ES256",
+						},
+					},
+				},
+			},
+			"signature type 'ES256' in JWS header does not match the JWK, expected RS256",
+			"WFE.Errors.ECAlgorithmForRSAKey",
+		},
+		{
+			jose.JsonWebKey{
+				Key: &ecdsa.PublicKey{
+					Curve: elliptic.P256(),
+				},
+			},
+			jose.JsonWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Header: jose.JoseHeader{
+							Algorithm: "RS256",
+						},
+					},
+				},
+			},
+			"signature type 'RS256' in JWS header does not match the JWK, expected ES256",
+			"WFE.Errors.RSAAlgorithmForECKey",
+		},
+		{
+			jose.JsonWebKey{
+				Key: &ecdsa.PublicKey{
+					Curve: elliptic.P384(),
+				},
+			},
+			jose.JsonWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Header: jose.JoseHeader{
+							Algorithm: "PS256",
+						},
+					},
+				},
+			},
+			"signature type 'PS256' in JWS header does not match the JWK, expected ES384",
+			"WFE.Errors.RSAAlgorithmForECKey",
+		},
+		{
+			jose.JsonWebKey{
+				Key: &ecdsa.PublicKey{
+					Curve: elliptic.P256(),
+				},
+			},
+			jose.JsonWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Header: jose.JoseHeader{
+							Algorithm: "ES384",
+						},
+					},
+				},
+			},
+			"signature type 'ES384' in JWS header does not match the JWK, expected ES256",
+			"WFE.Errors.ECAlgorithmWrongCurve",
+		},
+		{
+			jose.JsonWebKey{
+				Key: &rsa.PublicKey{},
+			},
+			jose.JsonWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Header: jose.JoseHeader{
+							Algorithm: "PS256",
+						},
+					},
+				},
+			},
+			"signature type 'PS256' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
+			"WFE.Errors.InvalidJWSAlgorithm",
+		},
 	}
 	for i, tc := range testCases {
 		stat, err := checkAlgorithm(&tc.key, &tc.jws)
@@ -201,3 +287,53 @@ func TestCheckAlgorithmSuccess(t *testing.T) {
 		t.Errorf("ES256 key: Expected nil error, got '%s'", err)
 	}
 }
+
+func TestCheckAlgorithmForbidden(t *testing.T) {
+	keys := map[string]*jose.JsonWebKey{
+		"RSA":   {Key: &rsa.PublicKey{}},
+		"P-256": {Key: &ecdsa.PublicKey{Curve: elliptic.P256()}},
+		"P-384": {Key: &ecdsa.PublicKey{Curve: elliptic.P384()}},
+	}
+	testCases := []struct {
+		algorithm    string
+		keys         []string
+		expectedStat string
+	}{
+		// Algorithms that are never acceptable, whatever the key
+		{"none", []string{"RSA", "P-256", "P-384"}, forbiddenJWSAlgorithm},
+		{"NONE", []string{"RSA", "P-256", "P-384"}, forbiddenJWSAlgorithm},
+		{"HS256", []string{"RSA", "P-256", "P-384"}, forbiddenJWSAlgorithm},
+		{"HS384", []string{"RSA", "P-256", "P-384"}, forbiddenJWSAlgorithm},
+		{"HS512", []string{"RSA", "P-256", "P-384"}, forbiddenJWSAlgorithm},
+		// Algorithms for another key type
+		{"ES256", []string{"RSA"}, ecAlgorithmForRSAKey},
+		{"ES384", []string{"RSA"}, ecAlgorithmForRSAKey},
+		{"ES512", []string{"RSA"}, ecAlgorithmForRSAKey},
+		{"RS256", []string{"P-256", "P-384"}, rsaAlgorithmForECKey},
+		{"RS512", []string{"P-256", "P-384"}, rsaAlgorithmForECKey},
+		{"PS256", []string{"P-256", "P-384"}, rsaAlgorithmForECKey},
+		// Algorithms for another curve
+		{"ES384", []string{"P-256"}, ecAlgorithmWrongCurve},
+		{"ES256", []string{"P-384"}, ecAlgorithmWrongCurve},
+	}
+	for _, tc := range testCases {
+		for _, keyName := range tc.keys {
+			stat, err := checkAlgorithm(keys[keyName], &jose.JsonWebSignature{
+				Signatures: []jose.Signature{{Header: jose.JoseHeader{Algorithm: tc.algorithm}}},
+			})
+			if err == nil {
+				t.Errorf("%s with %s key: expected an error", tc.algorithm, keyName)
+				continue
+			}
+			if stat != tc.expectedStat {
+				t.Errorf("%s with %s key: expected stat %q, got %q", tc.algorithm, keyName, tc.expectedStat, stat)
+			}
+			if tc.expectedStat == forbiddenJWSAlgorithm {
+				expected := "signature type '" + tc.algorithm + "' in JWS header is forbidden"
+				if err.Error() != expected {
+					t.Errorf("%s with %s key: expected %q, got %q", tc.algorithm, keyName, expected, err)
+				}
+			}
+		}
+	}
+}
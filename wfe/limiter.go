@@ -0,0 +1,55 @@
+package wfe
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// verificationLimiter bounds the number of JWS signature verifications, which
+// are CPU bound, running at once. A nil *verificationLimiter places no bound.
+type verificationLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func newVerificationLimiter(max int, wait time.Duration) *verificationLimiter {
+	return &verificationLimiter{
+		slots: make(chan struct{}, max),
+		wait:  wait,
+	}
+}
+
+// acquire takes a slot, waiting up to the limiter's wait for one to be
+// released if they are all in use. It returns false if no slot could be had,
+// in which case release must not be called.
+func (l *verificationLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release gives back a slot taken by acquire.
+func (l *verificationLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,208 @@
+package wfe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+)
+
+// issuanceEvent is the body POSTed to the issuance webhook for each
+// certificate issued.
+type issuanceEvent struct {
+	Serial         string    `json:"serial"`
+	RegistrationID int64     `json:"registrationID"`
+	Names          []string  `json:"names"`
+	Issued         time.Time `json:"issued"`
+}
+
+// maxWebhookBackoff caps the wait between retries of a failed delivery.
+const maxWebhookBackoff = time.Minute
+
+// issuanceWebhook POSTs issuance events to a URL from a bounded queue, so that
+// a slow or failing receiver never holds up issuance. Events that arrive while
+// the queue is full, or after close, are dropped, as are those still queued
+// when close gives up on delivering them. A nil *issuanceWebhook drops every
+// event.
+type issuanceWebhook struct {
+	url     string
+	client  *http.Client
+	events  chan issuanceEvent
+	retries int
+	// backoff is the wait before the first retry of a failed delivery. It
+	// doubles for each retry after that, up to maxWebhookBackoff.
+	backoff time.Duration
+	clk     clock.Clock
+	stats   metrics.Scope
+	log     blog.Logger
+	// stop is closed by close to make run deliver what's queued and exit,
+	// closing done. If that takes longer than drainTimeout, abort is closed,
+	// cancelling the delivery in flight and dropping the rest.
+	stop         chan struct{}
+	abort        chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+	drainTimeout time.Duration
+}
+
+// newIssuanceWebhook starts delivering events to url, queueing up to
+// queueSize of them. Each delivery is tried up to retries more times if it
+// fails or takes longer than timeout. Closing the webhook waits up to timeout
+// for the queue to drain.
+func newIssuanceWebhook(url string, queueSize, retries int, timeout time.Duration, clk clock.Clock, stats metrics.Scope, log blog.Logger) *issuanceWebhook {
+	w := &issuanceWebhook{
+		url:     url,
+		client:  &http.Client{Timeout: timeout},
+		events:  make(chan issuanceEvent, queueSize),
+		retries: retries,
+		backoff: time.Second,
+		clk:     clk,
+		stats:   stats.NewScope("IssuanceWebhook"),
+		log:     log,
+		stop:    make(chan struct{}),
+		abort:   make(chan struct{}),
+		done:    make(chan struct{}),
+
+		drainTimeout: timeout,
+	}
+	go w.run()
+	return w
+}
+
+// notify queues event for delivery, without waiting.
+func (w *issuanceWebhook) notify(event issuanceEvent) {
+	if w == nil {
+		return
+	}
+	select {
+	case <-w.stop:
+		w.stats.Inc("Dropped", 1)
+		w.log.Warning(fmt.Sprintf("Issuance webhook closed, dropping event for serial %s", event.Serial))
+		return
+	default:
+	}
+	select {
+	case w.events <- event:
+	default:
+		w.stats.Inc("Dropped", 1)
+		w.log.Warning(fmt.Sprintf("Issuance webhook queue full, dropping event for serial %s", event.Serial))
+	}
+}
+
+// close stops the webhook, waiting until each event already queued has been
+// tried, or drainTimeout has passed. Once closing, failed deliveries aren't
+// retried.
+func (w *issuanceWebhook) close() {
+	if w == nil {
+		return
+	}
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		go func() {
+			select {
+			case <-w.clk.After(w.drainTimeout):
+				close(w.abort)
+			case <-w.done:
+			}
+		}()
+	})
+	<-w.done
+}
+
+func (w *issuanceWebhook) run() {
+	defer close(w.done)
+	for {
+		select {
+		case event := <-w.events:
+			w.tryDeliver(event)
+		case <-w.stop:
+			for {
+				select {
+				case event := <-w.events:
+					w.tryDeliver(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// tryDeliver delivers event, unless close has given up waiting for the queue
+// to drain.
+func (w *issuanceWebhook) tryDeliver(event issuanceEvent) {
+	select {
+	case <-w.abort:
+		w.stats.Inc("Dropped", 1)
+		w.log.Warning(fmt.Sprintf("Issuance webhook timed out closing, dropping event for serial %s", event.Serial))
+	default:
+		w.deliver(event)
+	}
+}
+
+// deliver POSTs event, retrying with exponential backoff until it succeeds,
+// the retries run out or the webhook is closed.
+func (w *issuanceWebhook) deliver(event issuanceEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.log.AuditErr(fmt.Sprintf("Unable to marshal issuance event for serial %s: %s", event.Serial, err))
+		return
+	}
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			w.stats.Inc("Delivered", 1)
+			return
+		}
+		if attempt >= w.retries {
+			w.stats.Inc("Failed", 1)
+			w.log.Warning(fmt.Sprintf("Unable to deliver issuance event for serial %s after %d attempts: %s",
+				event.Serial, attempt+1, err))
+			return
+		}
+		w.stats.Inc("Retries", 1)
+		select {
+		case <-w.clk.After(backoff):
+		case <-w.stop:
+			w.stats.Inc("Failed", 1)
+			w.log.Warning(fmt.Sprintf("Unable to deliver issuance event for serial %s before shutdown: %s",
+				event.Serial, err))
+			return
+		}
+		backoff *= 2
+		if backoff > maxWebhookBackoff {
+			backoff = maxWebhookBackoff
+		}
+	}
+}
+
+// post sends body to the webhook's URL, giving up if abort is closed.
+func (w *issuanceWebhook) post(body []byte) error {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Cancel = w.abort
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -2,20 +2,32 @@ package wfe
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"path"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jmhodges/clock"
 	"golang.org/x/net/context"
@@ -33,19 +45,20 @@ import (
 
 // Paths are the ACME-spec identified URL path-segments for various methods
 const (
-	directoryPath  = "/directory"
-	newRegPath     = "/acme/new-reg"
-	regPath        = "/acme/reg/"
-	newAuthzPath   = "/acme/new-authz"
-	authzPath      = "/acme/authz/"
-	challengePath  = "/acme/challenge/"
-	newCertPath    = "/acme/new-cert"
-	certPath       = "/acme/cert/"
-	revokeCertPath = "/acme/revoke-cert"
-	termsPath      = "/terms"
-	issuerPath     = "/acme/issuer-cert"
-	buildIDPath    = "/build"
-	rolloverPath   = "/acme/key-change"
+	directoryPath   = "/directory"
+	newRegPath      = "/acme/new-reg"
+	regPath         = "/acme/reg/"
+	newAuthzPath    = "/acme/new-authz"
+	authzPath       = "/acme/authz/"
+	challengePath   = "/acme/challenge/"
+	challengeV2Path = "/acme/chall-v2/"
+	newCertPath     = "/acme/new-cert"
+	certPath        = "/acme/cert/"
+	revokeCertPath  = "/acme/revoke-cert"
+	termsPath       = "/terms"
+	issuerPath      = "/acme/issuer-cert"
+	buildIDPath     = "/build"
+	rolloverPath    = "/acme/key-change"
 )
 
 // WebFrontEndImpl provides all the logic for Boulder's web-facing interface,
@@ -68,9 +81,37 @@ type WebFrontEndImpl struct {
 	// URL to the current subscriber agreement (should contain some version identifier)
 	SubscriberAgreementURL string
 
+	// LocalizedSubscriberAgreementURLs maps language tags (e.g. "fr" or
+	// "pt-br") to translations of the subscriber agreement. /terms picks one
+	// according to the request's Accept-Language.
+	LocalizedSubscriberAgreementURLs map[string]string
+
+	// LocalizedProblemDetails maps problem types, in their
+	// urn:ietf:params:acme:error form, to translations of a detail for that
+	// type of problem keyed by language tag. When the request's
+	// Accept-Language matches one, problem documents of that type carry the
+	// translated detail in place of the default English one.
+	LocalizedProblemDetails map[string]map[string]string
+
+	// IETFErrorNamespace sends problem types in the
+	// urn:ietf:params:acme:error namespace rather than the legacy
+	// urn:acme:error one that existing clients match on.
+	IETFErrorNamespace bool
+
 	// Register of anti-replay nonces
 	nonceService *nonce.NonceService
 
+	// NonceMaxAge, if non-zero, is how long after they were handed out
+	// anti-replay nonces are accepted, so that clients can't stockpile them.
+	// It can't be used with a nonce buffer.
+	NonceMaxAge time.Duration
+
+	// SkipNonceOnTerminalErrors leaves the Replay-Nonce header off 4xx
+	// responses that a client can't recover from by retrying, such as
+	// malformed or unauthorized requests, and doesn't generate a nonce for
+	// them. Bad nonce and rate limit errors still carry one.
+	SkipNonceOnTerminalErrors bool
+
 	// Key policy.
 	keyPolicy goodkey.KeyPolicy
 
@@ -82,47 +123,667 @@ type WebFrontEndImpl struct {
 
 	// CORS settings
 	AllowOrigins []string
+	// AllowOriginRegexps are consulted when no entry in AllowOrigins matches
+	// the request's Origin. Patterns should be anchored, since they are
+	// matched against any part of the origin.
+	AllowOriginRegexps []*regexp.Regexp
 
 	// Maximum duration of a request
 	RequestTimeout time.Duration
 
 	AcceptRevocationReason bool
 	AllowAuthzDeactivation bool
+
+	// GloballyDisabledMethods lists HTTP methods that are refused on every
+	// path, before any route-specific handling takes place.
+	GloballyDisabledMethods []string
+
+	// DeprecationWarnings lists the deprecated request shapes (see the
+	// deprecated* constants) that earn a Warning header on success.
+	DeprecationWarnings []string
+
+	// CertSerialEncoding determines how serial numbers appear in certificate
+	// URLs.
+	CertSerialEncoding SerialEncoding
+
+	// Individual SA and RA calls that take longer than SlowBackendThreshold
+	// are logged. Zero disables the warning.
+	SlowBackendThreshold time.Duration
+
+	// RequirePostAsGet refuses unauthenticated GETs of authorizations,
+	// challenges and certificates, which must then be fetched with
+	// POST-as-GET.
+	RequirePostAsGet bool
+
+	// RequireAgreementAtRegistration refuses new registrations that don't
+	// agree to the current subscriber agreement, rather than waiting for
+	// the first authorization request to insist on it.
+	RequireAgreementAtRegistration bool
+
+	// StrictJSONFieldCasing refuses request payloads that spell a field in
+	// canonicalJSONFields with the wrong case. Otherwise such payloads are
+	// accepted (Go matches field names case-insensitively) but logged.
+	StrictJSONFieldCasing bool
+
+	// AllowedJWSAlgorithms, if non-empty, restricts the JWS algorithms
+	// requests may be signed with to those listed. Algorithms the WFE
+	// doesn't otherwise support are never accepted.
+	AllowedJWSAlgorithms []string
+
+	// StrictContentType refuses POSTs whose Content-Type isn't
+	// application/jose+json. Otherwise they are accepted but logged.
+	StrictContentType bool
+
+	// StrictAccept answers requests for certificates or the build ID whose
+	// Accept header allows none of the formats they're served in with a 406.
+	// Otherwise they get the default format, as they always have.
+	StrictAccept bool
+
+	// CertificateRequestContentTypes lists the content types, besides
+	// application/jose+json, that new-cert accepts. The only one is
+	// application/pkcs10: a request with that Content-Type is still a JWS,
+	// but its payload is a DER CSR rather than an ACME certificate request.
+	CertificateRequestContentTypes []string
+
+	// EmitTermsLinkEverywhere adds the terms-of-service Link to new-authz,
+	// new-cert and challenge responses, not just registration responses.
+	EmitTermsLinkEverywhere bool
+
+	// MaxContactsTotalBytes limits the combined length of a registration's
+	// contact URIs. Zero means no limit.
+	MaxContactsTotalBytes int
+
+	// CertificateAttachment serves every certificate with a
+	// Content-Disposition naming a file to save it as. Without it, only
+	// requests with a "download" query parameter get one.
+	CertificateAttachment bool
+
+	// RejectSubjectAttributes refuses CSRs whose subject has any attribute
+	// besides the common name, rather than ignoring them.
+	RejectSubjectAttributes bool
+
+	// RejectDisallowedEKU refuses CSRs that request an extended key usage
+	// other than serverAuth and clientAuth, the only ones we issue.
+	RejectDisallowedEKU bool
+
+	// MinRSAKeyBits, if non-zero, is the smallest RSA modulus accepted in a
+	// CSR, and AllowedECCurves, if set, lists the curves (e.g. "P-256")
+	// accepted for ECDSA keys. Both are checked before the key policy, so
+	// out-of-policy keys are rejected cheaply.
+	MinRSAKeyBits   int
+	AllowedECCurves []string
+
+	// AllowDuplicateCSRNames accepts CSRs that repeat a DNS name, compared
+	// lowercased and without trailing dots, rather than refusing them. The
+	// CSR is passed on unchanged; the RA issues for each name once.
+	AllowDuplicateCSRNames bool
+
+	// MaxNames, if non-zero, is the most DNS names a CSR may list. The RA
+	// enforces its own limit; this one rejects oversized CSRs before any
+	// backend does work on them.
+	MaxNames int
+
+	// InvalidAccountStatusCode is the HTTP status of the unauthorized problem
+	// sent for requests from deactivated or revoked registrations, e.g. 410
+	// to mark them as permanently gone. Zero means 403.
+	InvalidAccountStatusCode int
+
+	// LogPreAuthorizedIssuance asks the RA whether each new-cert request is
+	// for names its registration is pre-authorized for, and audit logs the
+	// requests that are, since they are issued without challenges.
+	LogPreAuthorizedIssuance bool
+
+	// DebugRateLimitHeaders adds a Boulder-RateLimit-Bucket header naming the
+	// bucket a rate limited request was counted against, to help client
+	// developers see why they hit a limit. Not for production.
+	DebugRateLimitHeaders bool
+
+	// CSRLog, if set, receives the audit objects of certificate requests,
+	// with their full CSRs, in place of the main log.
+	CSRLog blog.Logger
+
+	// AuditRetention tags certificate request audit log entries with a
+	// retention class.
+	AuditRetention core.AuditRetentionClasses
+
+	// DedupeLinkHeaders drops repeated identical Link headers from responses.
+	DedupeLinkHeaders bool
+
+	// AccountThumbprintHeader adds a Boulder-Account-Thumbprint header with
+	// the RFC 7638 thumbprint of the account key to registration responses,
+	// so clients can check it against the one in their key authorizations.
+	AccountThumbprintHeader bool
+
+	// MaxLinkHeaders, if non-zero, caps the number of Link headers in a
+	// response. Any more are dropped, and a warning logged, except those with
+	// an essential relation ("up", "next" or "terms-of-service"), which are
+	// always sent.
+	MaxLinkHeaders int
+
+	// LogSampleRate, if between zero and one, is the fraction of requests
+	// logged in full, with their headers, timings and errors. Only the ID,
+	// endpoint, method and requester of the rest are logged. By default all
+	// requests are logged in full.
+	LogSampleRate float64
+
+	// ServerTimingHeader adds a Server-Timing header to responses, giving
+	// the time spent verifying the JWS and calling the SA and RA.
+	ServerTimingHeader bool
+
+	// MaxHeaderBytes, if non-zero, caps the total size of a response's
+	// headers. Optional headers, such as Server-Timing and then the last
+	// Link headers without an essential relation, are dropped to fit, and a
+	// warning logged. Headers that still don't fit are sent, with another
+	// warning.
+	MaxHeaderBytes int
+
+	// CertificateProfiles are the names of the certificate profiles that
+	// new-cert requests may select with their "profile" field. Requests
+	// without one are issued under the CA's default profile.
+	CertificateProfiles []string
+
+	// ExpectedHosts, if set, lists the Host headers requests may carry.
+	// Requests for any other host are rejected, since the URLs we generate
+	// from BaseURL would not match the host the client asked for.
+	ExpectedHosts []string
+
+	// EmitServerTime adds a Boulder-Server-Time header to every response, so
+	// clients can check their clock against ours.
+	EmitServerTime bool
+
+	// ChallengeIdempotencyWindow, if non-zero, is how long a challenge
+	// response is remembered under the Idempotency-Key header of the request
+	// that produced it. A retry with the same key within the window gets the
+	// same response without the RA being asked to update the challenge again.
+	ChallengeIdempotencyWindow time.Duration
+	challengeResponses         *idempotencyCache
+
+	// RejectChallengesOnValidAuthz refuses challenge responses for
+	// authorizations that are already valid, rather than asking the RA to
+	// validate a challenge that can't change anything.
+	RejectChallengesOnValidAuthz bool
+
+	// DirectoryKeyTypes adds the key types allowed by the key policy to the
+	// directory, under "meta", so clients can choose a key that will be
+	// accepted before signing anything with it.
+	DirectoryKeyTypes bool
+
+	// DirectoryRandomKey adds an entry with a random key to every directory,
+	// so that no two directory responses are the same.
+	DirectoryRandomKey bool
+
+	// DirectoryWebsite and DirectoryCAAIdentities are given in the
+	// directory's meta object, alongside the subscriber agreement URL, as
+	// "website" and "caaIdentities": the CA's home page, and the domain names
+	// that CAA records must list to let us issue.
+	DirectoryWebsite       string
+	DirectoryCAAIdentities []string
+
+	// RateLimitRetryAfter maps the names of rate limits, as used in the rate
+	// limit policy file, to how long clients that hit them are told to wait
+	// in a Retry-After header.
+	RateLimitRetryAfter map[string]time.Duration
+
+	// jwsVerifications, if set with SetMaxConcurrentJWSVerifications,
+	// limits how many JWS signatures are verified at once.
+	jwsVerifications *verificationLimiter
+
+	// issuanceWebhook, if set, is notified of each certificate issued.
+	issuanceWebhook *issuanceWebhook
+
+	// certLinkRelations, if set with SetCertificateLinkRelations, are the
+	// Link relations sent with certificates. Only "up" is sent otherwise.
+	certLinkRelations []string
+
+	// EnabledEndpoints turns individual endpoints, named as in endpointNames,
+	// on or off. Endpoints that aren't listed are enabled. Disabled endpoints
+	// answer 404 and are left out of the directory.
+	EnabledEndpoints map[string]bool
+}
+
+// endpointNames maps the paths handled by the WFE to the names used for them
+// in EnabledEndpoints. Those listed in the directory use their directory key.
+var endpointNames = map[string]string{
+	directoryPath:   "directory",
+	newRegPath:      "new-reg",
+	regPath:         "reg",
+	newAuthzPath:    "new-authz",
+	authzPath:       "authz",
+	challengePath:   "challenge",
+	challengeV2Path: "chall-v2",
+	newCertPath:     "new-cert",
+	certPath:        "cert",
+	revokeCertPath:  "revoke-cert",
+	termsPath:       "terms",
+	issuerPath:      "issuer-cert",
+	buildIDPath:     "build",
+	rolloverPath:    "key-change",
+}
+
+// endpointEnabled returns false if the endpoint at path has been turned off
+// in EnabledEndpoints.
+func (wfe *WebFrontEndImpl) endpointEnabled(path string) bool {
+	enabled, ok := wfe.EnabledEndpoints[endpointNames[path]]
+	return !ok || enabled
+}
+
+// subjectAttributeNames names the subject attributes commonly found in CSRs.
+var subjectAttributeNames = map[string]string{
+	"2.5.4.5":              "serialNumber",
+	"2.5.4.6":              "country",
+	"2.5.4.7":              "locality",
+	"2.5.4.8":              "province",
+	"2.5.4.9":              "streetAddress",
+	"2.5.4.10":             "organization",
+	"2.5.4.11":             "organizationalUnit",
+	"2.5.4.17":             "postalCode",
+	"1.2.840.113549.1.9.1": "emailAddress",
+}
+
+// checkSubjectAttributes returns a MalformedRequestError naming the first
+// attribute of subject that isn't the common name, if there is one.
+func checkSubjectAttributes(subject pkix.Name) error {
+	commonName := asn1.ObjectIdentifier{2, 5, 4, 3}
+	for _, attr := range subject.Names {
+		if attr.Type.Equal(commonName) {
+			continue
+		}
+		name, ok := subjectAttributeNames[attr.Type.String()]
+		if !ok {
+			name = attr.Type.String()
+		}
+		return core.MalformedRequestError(fmt.Sprintf("subject attribute %s is not allowed", name))
+	}
+	return nil
+}
+
+// extKeyUsageNames names the extended key usages, by OID, that CSRs might
+// request. Only those mapped to true are issued.
+var extKeyUsageNames = map[string]struct {
+	name    string
+	allowed bool
+}{
+	"2.5.29.37.0":       {"anyExtendedKeyUsage", false},
+	"1.3.6.1.5.5.7.3.1": {"serverAuth", true},
+	"1.3.6.1.5.5.7.3.2": {"clientAuth", true},
+	"1.3.6.1.5.5.7.3.3": {"codeSigning", false},
+	"1.3.6.1.5.5.7.3.4": {"emailProtection", false},
+	"1.3.6.1.5.5.7.3.8": {"timeStamping", false},
+	"1.3.6.1.5.5.7.3.9": {"OCSPSigning", false},
+}
+
+// checkCSRKey returns a MalformedRequestError if key is an RSA key smaller than
+// MinRSAKeyBits or an ECDSA key on a curve missing from AllowedECCurves.
+func (wfe *WebFrontEndImpl) checkCSRKey(key interface{}) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if bits := k.N.BitLen(); wfe.MinRSAKeyBits > 0 && bits < wfe.MinRSAKeyBits {
+			return core.MalformedRequestError(fmt.Sprintf("RSA key is %d bits, less than the minimum of %d", bits, wfe.MinRSAKeyBits))
+		}
+	case *ecdsa.PublicKey:
+		if len(wfe.AllowedECCurves) == 0 {
+			return nil
+		}
+		curve := k.Curve.Params().Name
+		for _, allowed := range wfe.AllowedECCurves {
+			if curve == allowed {
+				return nil
+			}
+		}
+		return core.MalformedRequestError(fmt.Sprintf("ECDSA curve %s is not allowed", curve))
+	}
+	return nil
+}
+
+// normalizeCSRNames returns the distinct names in names, lowercased and
+// without trailing dots, in the order they first appear. If a name is
+// repeated, it returns a MalformedRequestError unless allowDuplicates is set.
+// The CSR itself is left alone: the RA parses it again, and folds the case of
+// its names and drops repeats itself.
+func normalizeCSRNames(names []string, allowDuplicates bool) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	var normalized []string
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if seen[name] {
+			if !allowDuplicates {
+				return nil, core.MalformedRequestError(fmt.Sprintf("CSR contains duplicate name %s", name))
+			}
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	return normalized, nil
+}
+
+// checkRequestedEKUs returns a MalformedRequestError naming the first extended
+// key usage requested by csr that we don't issue, if there is one.
+func checkRequestedEKUs(csr *x509.CertificateRequest) error {
+	extKeyUsage := asn1.ObjectIdentifier{2, 5, 29, 37}
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(extKeyUsage) {
+			continue
+		}
+		var usages []asn1.ObjectIdentifier
+		if rest, err := asn1.Unmarshal(ext.Value, &usages); err != nil || len(rest) != 0 {
+			return core.MalformedRequestError("requested extended key usage extension is malformed")
+		}
+		for _, usage := range usages {
+			eku, ok := extKeyUsageNames[usage.String()]
+			if ok && eku.allowed {
+				continue
+			}
+			name := usage.String()
+			if ok {
+				name = eku.name
+			}
+			return core.MalformedRequestError(fmt.Sprintf("extended key usage %s is not allowed", name))
+		}
+	}
+	return nil
 }
 
-// NewWebFrontEndImpl constructs a web service for Boulder
+// certificateFileExtensions gives the file extension used for each format
+// certificates are served in.
+var certificateFileExtensions = map[string]string{
+	"application/pkix-cert":             "der",
+	"application/pem-certificate-chain": "pem",
+}
+
+// certificateContentTypes are the formats certificates are served in, in
+// order of preference. A DER certificate is the default.
+var certificateContentTypes = []string{"application/pkix-cert", "application/pem-certificate-chain"}
+
+// certificateBody returns the response body serving the DER certificate der
+// as contentType. A PEM chain is followed by the issuer certificate.
+func (wfe *WebFrontEndImpl) certificateBody(contentType string, der []byte) []byte {
+	if contentType != "application/pem-certificate-chain" {
+		return der
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: wfe.IssuerCert})...)
+}
+
+// addCertificateDisposition marks a certificate response as an attachment to
+// be saved under a name made from its serial and format.
+func addCertificateDisposition(response http.ResponseWriter, serial, contentType string) {
+	response.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s.%s\"", serial, certificateFileExtensions[contentType]))
+}
+
+// A SerialEncoding converts between certificate serial numbers and the form
+// they take in certificate URLs.
+type SerialEncoding struct {
+	// Encode returns the URL form of a serial number.
+	Encode func(*big.Int) string
+	// Decode validates the URL form of a serial number and returns it in the
+	// hex form used by the SA.
+	Decode func(string) (string, error)
+}
+
+// HexSerialEncoding is the default encoding, the same zero-padded hex string
+// that is used to store serials.
+var HexSerialEncoding = SerialEncoding{
+	Encode: core.SerialToString,
+	Decode: func(s string) (string, error) {
+		// The SA looks up serials by exact match, and older certificates have
+		// shorter serials, so valid serials are passed through untouched.
+		if !core.ValidSerial(s) {
+			return "", fmt.Errorf("invalid hex serial %q", s)
+		}
+		return s, nil
+	},
+}
+
+// Base64URLSerialEncoding encodes serials as the unpadded base64url form of
+// the bytes of their zero-padded hex string, giving shorter URLs. Like
+// HexSerialEncoding, it keeps the width of the serial: older certificates
+// are stored under 32 hex digit serials, which are 16 bytes rather than 18.
+var Base64URLSerialEncoding = SerialEncoding{
+	Encode: func(serial *big.Int) string {
+		b, _ := hex.DecodeString(core.SerialToString(serial))
+		return base64.RawURLEncoding.EncodeToString(b)
+	},
+	Decode: func(s string) (string, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) < 16 || len(b) > 18 {
+			return "", fmt.Errorf("invalid base64url serial %q", s)
+		}
+		return hex.EncodeToString(b), nil
+	},
+}
+
+// SerialEncodings maps configuration names to the available serial
+// encodings.
+var SerialEncodings = map[string]SerialEncoding{
+	"hex":       HexSerialEncoding,
+	"base64url": Base64URLSerialEncoding,
+}
+
+// NewWebFrontEndImpl constructs a web service for Boulder. A non-zero
+// nonceBufferSize enables generating that many nonces ahead of time. A
+// non-empty nonceSecret instead makes nonces stateless, so that they are
+// accepted by every WFE configured with the same secret.
 func NewWebFrontEndImpl(
 	stats metrics.Scope,
 	clk clock.Clock,
 	keyPolicy goodkey.KeyPolicy,
+	nonceBufferSize int,
+	nonceSecret []byte,
 	logger blog.Logger,
 ) (WebFrontEndImpl, error) {
-	nonceService, err := nonce.NewNonceService(stats)
+	var nonceService *nonce.NonceService
+	var err error
+	if len(nonceSecret) > 0 {
+		nonceService, err = nonce.NewHMACNonceService(stats, clk, nonceSecret)
+	} else {
+		nonceService, err = nonce.NewBufferedNonceService(stats, clk, nonceBufferSize)
+	}
 	if err != nil {
 		return WebFrontEndImpl{}, err
 	}
 
 	return WebFrontEndImpl{
-		log:          logger,
-		clk:          clk,
-		nonceService: nonceService,
-		stats:        stats,
-		keyPolicy:    keyPolicy,
+		log:                     logger,
+		clk:                     clk,
+		nonceService:            nonceService,
+		stats:                   stats,
+		keyPolicy:               keyPolicy,
+		GloballyDisabledMethods: []string{"TRACE"},
+		CertSerialEncoding:      HexSerialEncoding,
+		challengeResponses:      newIdempotencyCache(clk),
 	}, nil
 }
 
+// SetNonceInstance prefixes the nonces this WFE hands out with instance, so
+// that a nonce rejected by one instance can be traced to the one that minted
+// it.
+func (wfe *WebFrontEndImpl) SetNonceInstance(instance string) error {
+	return wfe.nonceService.SetInstance(instance)
+}
+
+// SetMaxConcurrentJWSVerifications limits the number of JWS signatures
+// verified at once to max, so that a flood of signed requests can't starve
+// everything else of CPU. Requests that find max verifications in progress
+// wait up to wait for one to finish, then get a 503. A max of zero or less
+// removes the limit. It must be called before the WFE serves requests.
+func (wfe *WebFrontEndImpl) SetMaxConcurrentJWSVerifications(max int, wait time.Duration) {
+	if max <= 0 {
+		wfe.jwsVerifications = nil
+		return
+	}
+	wfe.jwsVerifications = newVerificationLimiter(max, wait)
+}
+
+// SetIssuanceWebhook makes the WFE POST a JSON event to url for each
+// certificate it issues. Events wait, up to queueSize of them, to be sent in
+// the background, and each is retried up to retries times if url fails to
+// accept it within timeout. Events that don't fit in the queue are dropped.
+// A webhook set earlier is closed first.
+//
+// Closing a webhook waits up to timeout for its queue to drain, then drops
+// the events left.
+func (wfe *WebFrontEndImpl) SetIssuanceWebhook(url string, queueSize, retries int, timeout time.Duration) {
+	wfe.issuanceWebhook.close()
+	wfe.issuanceWebhook = newIssuanceWebhook(url, queueSize, retries, timeout, wfe.clk, wfe.stats, wfe.log)
+}
+
+// Close stops the WFE's background work, waiting a while for the issuance
+// events it has queued to be sent. It is meant to be called on shutdown,
+// once the WFE has stopped serving requests.
+func (wfe *WebFrontEndImpl) Close() {
+	wfe.nonceService.Close()
+	wfe.issuanceWebhook.close()
+}
+
+// certificateLinkRelations are the Link relations that can be sent with
+// certificates, in the order they are sent:
+//   - up: the issuer certificate
+//   - index: the directory
+//   - author: the registration that requested the certificate
+//   - alternate: the certificate's own URL
+var certificateLinkRelations = []string{"up", "index", "author", "alternate"}
+
+// SetCertificateLinkRelations sets the Link relations, out of
+// certificateLinkRelations, that are sent with certificates from new-cert and
+// the certificate endpoint. They are always sent in the same order,
+// whichever order they're given in.
+func (wfe *WebFrontEndImpl) SetCertificateLinkRelations(relations []string) error {
+	known := make(map[string]bool)
+	for _, relation := range certificateLinkRelations {
+		known[relation] = true
+	}
+	wanted := make(map[string]bool)
+	for _, relation := range relations {
+		if !known[relation] {
+			return fmt.Errorf("unknown certificate Link relation %q", relation)
+		}
+		wanted[relation] = true
+	}
+	var ordered []string
+	for _, relation := range certificateLinkRelations {
+		if wanted[relation] {
+			ordered = append(ordered, relation)
+		}
+	}
+	wfe.certLinkRelations = ordered
+	return nil
+}
+
+// addCertificateLinks adds the configured Link relations for the certificate
+// at certURL, requested by regID, to response.
+func (wfe *WebFrontEndImpl) addCertificateLinks(response http.ResponseWriter, request *http.Request, certURL string, regID int64) {
+	relations := wfe.certLinkRelations
+	if relations == nil {
+		relations = []string{"up"}
+	}
+	for _, relation := range relations {
+		var target string
+		switch relation {
+		case "up":
+			target = wfe.relativeEndpoint(request, issuerPath)
+		case "index":
+			target = wfe.relativeEndpoint(request, directoryPath)
+		case "author":
+			target = wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, regID))
+		case "alternate":
+			target = certURL
+		}
+		response.Header().Add("Link", link(target, relation))
+	}
+}
+
+// verifyJWSSignature verifies jws with key, subject to the limit on
+// concurrent verifications. If the limit is reached it returns a problem
+// rather than a verification error.
+func (wfe *WebFrontEndImpl) verifyJWSSignature(ctx context.Context, logEvent *requestEvent, jws *jose.JsonWebSignature, key *jose.JsonWebKey) ([]byte, *probs.ProblemDetails, error) {
+	if !wfe.jwsVerifications.acquire(ctx) {
+		wfe.stats.Inc("Errors.JWSVerificationsShed", 1)
+		logEvent.AddError("too many concurrent JWS verifications")
+		// Shedding load isn't an internal error, and mustn't be audit
+		// logged like one: any client could flood the audit log with them.
+		return nil, &probs.ProblemDetails{
+			Type:       probs.RateLimitedProblem,
+			Detail:     "Server is too busy to verify the request signature, try again later",
+			HTTPStatus: http.StatusServiceUnavailable,
+			RetryAfter: time.Second,
+		}, nil
+	}
+	defer wfe.jwsVerifications.release()
+	payload, err := jws.Verify(key)
+	return payload, nil, err
+}
+
+// SetNonceExpiryGrace makes this WFE accept nonces for grace after they
+// would otherwise have expired.
+func (wfe *WebFrontEndImpl) SetNonceExpiryGrace(grace time.Duration) {
+	wfe.nonceService.ExpiryGrace = grace
+}
+
+// SetNonceTTL sets how long stateless nonces, from a WFE constructed with a
+// nonce secret, are valid. It has no effect on other nonces.
+func (wfe *WebFrontEndImpl) SetNonceTTL(ttl time.Duration) {
+	wfe.nonceService.TTL = ttl
+}
+
+// postAsGetPaths are the routes whose resources can be read with
+// POST-as-GET, and whose GETs are refused if RequirePostAsGet is set.
+var postAsGetPaths = map[string]bool{
+	authzPath:       true,
+	challengePath:   true,
+	challengeV2Path: true,
+	certPath:        true,
+}
+
+// effectiveMethods filters the globally disabled methods out of the methods
+// of the route at pattern, returning the remainder as a string suitable for
+// an Allow header and as a set. If RequirePostAsGet is set, GET and HEAD are
+// left out of the string for routes that refuse them, but stay in the set so
+// that the handler can explain the refusal.
+func (wfe *WebFrontEndImpl) effectiveMethods(pattern string, methods []string) (string, map[string]bool) {
+	var enabled []string
+	methodsMap := make(map[string]bool)
+	for _, m := range methods {
+		if wfe.methodDisabled(m) {
+			continue
+		}
+		methodsMap[m] = true
+		if wfe.RequirePostAsGet && postAsGetPaths[pattern] && (m == "GET" || m == "HEAD") {
+			continue
+		}
+		enabled = append(enabled, m)
+	}
+	return strings.Join(enabled, ", "), methodsMap
+}
+
+// methodDisabled returns true if method is listed in
+// wfe.GloballyDisabledMethods.
+func (wfe *WebFrontEndImpl) methodDisabled(method string) bool {
+	for _, m := range wfe.GloballyDisabledMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
 // HandleFunc registers a handler at the given path. It's
 // http.HandleFunc(), but with a wrapper around the handler that
 // provides some generic per-request functionality:
 //
 // * Set a Replay-Nonce header.
 //
+// * Respond http.StatusMethodNotAllowed for HTTP methods listed in
+// GloballyDisabledMethods, whatever the route accepts.
+//
 // * Respond to OPTIONS requests, including CORS preflight requests.
 //
 // * Set a no cache header
 //
 // * Respond http.StatusMethodNotAllowed for HTTP methods other than
-// those listed.
+// those listed. The Allow header, here and for OPTIONS, leaves out any
+// listed methods that are globally disabled.
 //
 // * Set CORS headers when responding to CORS "actual" requests.
 //
@@ -130,35 +791,62 @@ func NewWebFrontEndImpl(
 // written by the handler will be discarded if the method is HEAD.
 // Also, all handlers that accept GET automatically accept HEAD.
 func (wfe *WebFrontEndImpl) HandleFunc(mux *http.ServeMux, pattern string, h wfeHandlerFunc, methods ...string) {
-	methodsMap := make(map[string]bool)
+	registered := make(map[string]bool)
 	for _, m := range methods {
-		methodsMap[m] = true
+		registered[m] = true
 	}
-	if methodsMap["GET"] && !methodsMap["HEAD"] {
+	if registered["GET"] && !registered["HEAD"] {
 		// Allow HEAD for any resource that allows GET
 		methods = append(methods, "HEAD")
-		methodsMap["HEAD"] = true
 	}
-	methodsStr := strings.Join(methods, ", ")
 	handler := http.StripPrefix(pattern, &topHandler{
-		log: wfe.log,
-		clk: clock.Default(),
+		log:            wfe.log,
+		clk:            clock.Default(),
+		dedupeLinks:    wfe.DedupeLinkHeaders,
+		maxLinks:       wfe.MaxLinkHeaders,
+		timing:         wfe.ServerTimingHeader,
+		maxHeaderBytes: wfe.MaxHeaderBytes,
+		logSampleRate:  wfe.LogSampleRate,
 		wfe: wfeHandlerFunc(func(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
-			// We do not propagate errors here, because (1) they should be
-			// transient, and (2) they fail closed.
-			nonce, err := wfe.nonceService.Nonce()
-			if err == nil {
-				response.Header().Set("Replay-Nonce", nonce)
-				logEvent.ResponseNonce = nonce
+			if wfe.SkipNonceOnTerminalErrors {
+				// Wait to see whether the response is a terminal error.
+				nw := &nonceWriter{ResponseWriter: response, wfe: wfe, logEvent: logEvent}
+				defer nw.finish()
+				response = nw
 			} else {
-				logEvent.AddError("unable to make nonce: %s", err)
+				wfe.setReplayNonce(response, logEvent)
 			}
 
+			wfe.addServerTimeHeader(response)
+
 			logEvent.Endpoint = pattern
 			if request.URL != nil {
 				logEvent.Endpoint = path.Join(logEvent.Endpoint, request.URL.Path)
 			}
 
+			if wfe.rejectUnexpectedHost(logEvent, response, request) {
+				return
+			}
+
+			if !wfe.endpointEnabled(pattern) {
+				logEvent.AddError("request to disabled endpoint %s", endpointNames[pattern])
+				wfe.stats.Inc("Errors.EndpointDisabled", 1)
+				addNoCacheHeader(response)
+				wfe.sendError(response, logEvent, probs.NotFound("Endpoint is disabled"), nil)
+				return
+			}
+
+			// The methods we advertise and accept are those registered for
+			// the route, less any that have since been disabled.
+			methodsStr, methodsMap := wfe.effectiveMethods(pattern, methods)
+
+			if wfe.methodDisabled(request.Method) {
+				addNoCacheHeader(response)
+				response.Header().Set("Allow", methodsStr)
+				wfe.sendError(response, logEvent, probs.MethodNotAllowed(), nil)
+				return
+			}
+
 			switch request.Method {
 			case "HEAD":
 				// Go's net/http (and httptest) servers will strip out the body
@@ -195,6 +883,65 @@ func (wfe *WebFrontEndImpl) HandleFunc(mux *http.ServeMux, pattern string, h wfe
 	mux.Handle(pattern, handler)
 }
 
+// setReplayNonce sets a fresh Replay-Nonce header on response.
+func (wfe *WebFrontEndImpl) setReplayNonce(response http.ResponseWriter, logEvent *requestEvent) {
+	// We do not propagate errors here, because (1) they should be
+	// transient, and (2) they fail closed.
+	nonce, err := wfe.nonceService.Nonce()
+	if err == nil {
+		response.Header().Set("Replay-Nonce", nonce)
+		logEvent.ResponseNonce = nonce
+	} else {
+		logEvent.AddError("unable to make nonce: %s", err)
+	}
+}
+
+// nonceWriter is an http.ResponseWriter that sets a Replay-Nonce header when
+// the response is written, unless sendError has marked it as a terminal
+// error.
+type nonceWriter struct {
+	http.ResponseWriter
+	wfe           *WebFrontEndImpl
+	logEvent      *requestEvent
+	terminalError bool
+	wroteHeader   bool
+}
+
+// finish sets the Replay-Nonce header of a response whose handler returned
+// without writing anything, which net/http then sends as an empty 200.
+func (w *nonceWriter) finish() {
+	if !w.wroteHeader && !w.terminalError {
+		w.wroteHeader = true
+		w.wfe.setReplayNonce(w.ResponseWriter, w.logEvent)
+	}
+}
+
+func (w *nonceWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if !w.terminalError {
+			w.wfe.setReplayNonce(w.ResponseWriter, w.logEvent)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *nonceWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// terminalClientError returns true if prob, sent with code, is a 4xx error
+// that retrying the request can't fix.
+func terminalClientError(code int, prob *probs.ProblemDetails) bool {
+	if code < 400 || code >= 500 || code == http.StatusTooManyRequests {
+		return false
+	}
+	return prob.Type != probs.BadNonceProblem
+}
+
 func marshalIndent(v interface{}) ([]byte, error) {
 	return json.MarshalIndent(v, "", "  ")
 }
@@ -249,10 +996,16 @@ func (wfe *WebFrontEndImpl) relativeEndpoint(request *http.Request, endpoint str
 	return result
 }
 
-func (wfe *WebFrontEndImpl) relativeDirectory(request *http.Request, directory map[string]string) ([]byte, error) {
+// randomDirectoryKeyExplanation is the value of the random directory entry
+// added by DirectoryRandomKey.
+const randomDirectoryKeyExplanation = "https://community.letsencrypt.org/t/adding-random-entries-to-the-directory/33417"
+
+// relativeDirectory marshals the directory, relative to the request, with the
+// entries of extra, e.g. "meta", added as they are.
+func (wfe *WebFrontEndImpl) relativeDirectory(request *http.Request, directory map[string]string, extra map[string]interface{}) ([]byte, error) {
 	// Create an empty map sized equal to the provided directory to store the
 	// relative-ized result
-	relativeDir := make(map[string]string, len(directory))
+	relativeDir := make(map[string]interface{}, len(directory)+len(extra))
 
 	// Copy each entry of the provided directory into the new relative map. If
 	// `wfe.BaseURL` != "", use the old behaviour and prefix each endpoint with
@@ -261,6 +1014,9 @@ func (wfe *WebFrontEndImpl) relativeDirectory(request *http.Request, directory m
 	for k, v := range directory {
 		relativeDir[k] = wfe.relativeEndpoint(request, v)
 	}
+	for k, v := range extra {
+		relativeDir[k] = v
+	}
 
 	directoryJSON, err := marshalIndent(relativeDir)
 	// This should never happen since we are just marshalling known strings
@@ -271,6 +1027,20 @@ func (wfe *WebFrontEndImpl) relativeDirectory(request *http.Request, directory m
 	return directoryJSON, nil
 }
 
+// CheckConfig returns an error if the WFE is missing configuration it cannot
+// serve without. It should pass before the result of Handler is served.
+func (wfe *WebFrontEndImpl) CheckConfig() error {
+	if len(wfe.IssuerCert) == 0 {
+		return errors.New("no issuer certificate configured")
+	}
+	if wfe.NonceMaxAge > 0 && wfe.nonceService.Buffered() {
+		// Buffered nonces may have sat in the buffer for longer than the
+		// max age before they were handed out.
+		return errors.New("nonce max age can't be used with a nonce buffer")
+	}
+	return nil
+}
+
 // Handler returns an http.Handler that uses various functions for
 // various ACME-specified paths.
 func (wfe *WebFrontEndImpl) Handler() http.Handler {
@@ -279,10 +1049,11 @@ func (wfe *WebFrontEndImpl) Handler() http.Handler {
 	wfe.HandleFunc(m, newRegPath, wfe.NewRegistration, "POST")
 	wfe.HandleFunc(m, newAuthzPath, wfe.NewAuthorization, "POST")
 	wfe.HandleFunc(m, newCertPath, wfe.NewCertificate, "POST")
-	wfe.HandleFunc(m, regPath, wfe.Registration, "POST")
+	wfe.HandleFunc(m, regPath, wfe.Registration, "POST", "HEAD")
 	wfe.HandleFunc(m, authzPath, wfe.Authorization, "GET", "POST")
 	wfe.HandleFunc(m, challengePath, wfe.Challenge, "GET", "POST")
-	wfe.HandleFunc(m, certPath, wfe.Certificate, "GET")
+	wfe.HandleFunc(m, challengeV2Path, wfe.ChallengeV2, "GET", "POST")
+	wfe.HandleFunc(m, certPath, wfe.Certificate, "GET", "POST")
 	wfe.HandleFunc(m, revokeCertPath, wfe.RevokeCertificate, "POST")
 	wfe.HandleFunc(m, termsPath, wfe.Terms, "GET")
 	wfe.HandleFunc(m, issuerPath, wfe.Issuer, "GET")
@@ -294,17 +1065,56 @@ func (wfe *WebFrontEndImpl) Handler() http.Handler {
 	// meaning we can wind up returning 405 when we mean to return 404. See
 	// https://github.com/letsencrypt/boulder/issues/717
 	m.Handle("/", &topHandler{
-		log: wfe.log,
-		clk: clock.Default(),
-		wfe: wfeHandlerFunc(wfe.Index),
+		log:            wfe.log,
+		clk:            clock.Default(),
+		wfe:            wfeHandlerFunc(wfe.Index),
+		dedupeLinks:    wfe.DedupeLinkHeaders,
+		maxLinks:       wfe.MaxLinkHeaders,
+		timing:         wfe.ServerTimingHeader,
+		maxHeaderBytes: wfe.MaxHeaderBytes,
+		logSampleRate:  wfe.LogSampleRate,
 	})
 	return m
 }
 
+// addServerTimeHeader sets the Boulder-Server-Time header to the current time
+// if EmitServerTime is enabled.
+func (wfe *WebFrontEndImpl) addServerTimeHeader(response http.ResponseWriter) {
+	if wfe.EmitServerTime {
+		response.Header().Set("Boulder-Server-Time", wfe.clk.Now().UTC().Format(http.TimeFormat))
+	}
+}
+
+// rejectUnexpectedHost sends an error and returns true if ExpectedHosts is
+// set and the request's Host header is not among them. Entries without a
+// port match the host on any port.
+func (wfe *WebFrontEndImpl) rejectUnexpectedHost(logEvent *requestEvent, response http.ResponseWriter, request *http.Request) bool {
+	if len(wfe.ExpectedHosts) == 0 {
+		return false
+	}
+	hostname := request.Host
+	if h, _, err := net.SplitHostPort(request.Host); err == nil {
+		hostname = h
+	}
+	for _, expected := range wfe.ExpectedHosts {
+		if strings.EqualFold(expected, request.Host) || strings.EqualFold(expected, hostname) {
+			return false
+		}
+	}
+	logEvent.AddError("unexpected Host header %q", request.Host)
+	wfe.stats.Inc("Errors.UnexpectedHost", 1)
+	wfe.sendError(response, logEvent, probs.Malformed(fmt.Sprintf("Unexpected Host header %q", request.Host)), nil)
+	return true
+}
+
 // Method implementations
 
 // Index serves a simple identification page. It is not part of the ACME spec.
 func (wfe *WebFrontEndImpl) Index(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	wfe.addServerTimeHeader(response)
+	if wfe.rejectUnexpectedHost(logEvent, response, request) {
+		return
+	}
 	// http://golang.org/pkg/net/http/#example_ServeMux_Handle
 	// The "/" pattern matches everything, so we need to check
 	// that we're at the root here.
@@ -315,6 +1125,15 @@ func (wfe *WebFrontEndImpl) Index(ctx context.Context, logEvent *requestEvent, r
 		return
 	}
 
+	// "/" isn't registered with HandleFunc, so it checks for globally
+	// disabled methods itself, once it's known not to be a 404.
+	if wfe.methodDisabled(request.Method) {
+		allow, _ := wfe.effectiveMethods("/", []string{"GET"})
+		response.Header().Set("Allow", allow)
+		wfe.sendError(response, logEvent, probs.MethodNotAllowed(), nil)
+		return
+	}
+
 	if request.Method != "GET" {
 		logEvent.AddError("Bad method")
 		response.Header().Set("Allow", "GET")
@@ -334,16 +1153,115 @@ func (wfe *WebFrontEndImpl) Index(ctx context.Context, logEvent *requestEvent, r
 	`, directoryPath, directoryPath)))
 }
 
+// isPostAsGet reports whether request is a POST whose JWS has an empty
+// payload. The JWS is only looked at, not verified, so that handlers which
+// also take other POSTs can tell the two apart before choosing how to verify
+// the request. The body is left in place for verification to read.
+func isPostAsGet(request *http.Request) bool {
+	if request.Method != "POST" || request.Body == nil {
+		return false
+	}
+	body, err := ioutil.ReadAll(request.Body)
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	trimmed := strings.TrimSpace(string(body))
+	if !strings.HasPrefix(trimmed, "{") {
+		parts := strings.Split(trimmed, ".")
+		return len(parts) == 3 && parts[1] == ""
+	}
+	var jws struct {
+		Payload *string `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &jws); err != nil || jws.Payload == nil {
+		return false
+	}
+	return *jws.Payload == ""
+}
+
+// authenticateRead authenticates a request to read an authorization,
+// challenge or certificate. A POST-as-GET is verified and reported along
+// with the registration that made it, which the caller must check owns the
+// resource. Other POSTs are left to the caller, and GETs are refused if
+// RequirePostAsGet is set. If ok is false an error has been sent.
+func (wfe *WebFrontEndImpl) authenticateRead(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) (postAsGet bool, reg *core.Registration, ok bool) {
+	if !isPostAsGet(request) {
+		return false, nil, !wfe.rejectUnauthenticatedGET(logEvent, response, request)
+	}
+	reg, prob := wfe.verifyPOSTAsGET(ctx, logEvent, request)
+	addRequesterHeader(response, logEvent.Requester)
+	if prob != nil {
+		wfe.sendError(response, logEvent, prob, nil)
+		return true, nil, false
+	}
+	return true, reg, true
+}
+
+// rejectForeignResource sends an error and returns true if reg, which made a
+// POST-as-GET request, is not owner, the registration owning the resource
+// requested.
+func (wfe *WebFrontEndImpl) rejectForeignResource(logEvent *requestEvent, response http.ResponseWriter, reg *core.Registration, owner int64, resource string) bool {
+	if reg.ID == owner {
+		return false
+	}
+	logEvent.AddError("registration %d requested %s belonging to registration %d", reg.ID, resource, owner)
+	wfe.sendError(response, logEvent, probs.Unauthorized(fmt.Sprintf("Registration ID doesn't match ID for %s", resource)), nil)
+	return true
+}
+
+// rejectUnauthenticatedGET sends a 405 for GET and HEAD requests when
+// RequirePostAsGet is set, and reports whether it did so.
+func (wfe *WebFrontEndImpl) rejectUnauthenticatedGET(logEvent *requestEvent, response http.ResponseWriter, request *http.Request) bool {
+	if !wfe.RequirePostAsGet || (request.Method != "GET" && request.Method != "HEAD") {
+		return false
+	}
+	logEvent.AddError("unauthenticated %s refused", request.Method)
+	response.Header().Set("Allow", "POST")
+	prob := probs.MethodNotAllowed()
+	prob.Detail = "Unauthenticated GET is not supported, use POST-as-GET"
+	wfe.sendError(response, logEvent, prob, nil)
+	return true
+}
+
 func addNoCacheHeader(w http.ResponseWriter) {
 	w.Header().Add("Cache-Control", "public, max-age=0, no-cache")
 }
 
+// addCacheHeader replaces the no-cache header every response starts out with
+// by one allowing caches to keep the response for age.
+func addCacheHeader(w http.ResponseWriter, age time.Duration) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%.f", age.Seconds()))
+}
+
+// addTermsOfServiceLink adds a Link to the current subscriber agreement, if
+// there is one.
+func (wfe *WebFrontEndImpl) addTermsOfServiceLink(response http.ResponseWriter) {
+	if len(wfe.SubscriberAgreementURL) > 0 {
+		response.Header().Add("Link", link(wfe.SubscriberAgreementURL, "terms-of-service"))
+	}
+}
+
 func addRequesterHeader(w http.ResponseWriter, requester int64) {
 	if requester > 0 {
 		w.Header().Set("Boulder-Requester", fmt.Sprintf("%d", requester))
 	}
 }
 
+// addThumbprintHeader sets the Boulder-Account-Thumbprint header to the
+// base64url SHA-256 JWK thumbprint of key, if AccountThumbprintHeader is set.
+func (wfe *WebFrontEndImpl) addThumbprintHeader(w http.ResponseWriter, key *jose.JsonWebKey) {
+	if !wfe.AccountThumbprintHeader || key == nil {
+		return
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		wfe.log.Warning(fmt.Sprintf("Could not compute account key thumbprint: %s", err))
+		return
+	}
+	w.Header().Set("Boulder-Account-Thumbprint", base64.RawURLEncoding.EncodeToString(thumbprint))
+}
+
 // Directory is an HTTP request handler that provides the directory
 // object stored in the WFE's DirectoryEndpoints member with paths prefixed
 // using the `request.Host` of the HTTP request.
@@ -354,16 +1272,47 @@ func (wfe *WebFrontEndImpl) Directory(ctx context.Context, logEvent *requestEven
 		"new-cert":    newCertPath,
 		"revoke-cert": revokeCertPath,
 	}
-	if features.Enabled(features.AllowKeyRollover) && !strings.HasPrefix(request.UserAgent(), "LetsEncryptPythonClient") {
-		// Versions of Certbot pre-0.6.0 (named LetsEncryptPythonClient at the time) break when they
-		// encounter a directory containing elements they don't expect so we gate adding the key-change
-		// field on a User-Agent header that doesn't start with 'LetsEncryptPythonClient'
+	// Versions of Certbot pre-0.6.0 (named LetsEncryptPythonClient at the time) break when they
+	// encounter a directory containing elements they don't expect so we gate adding the key-change
+	// and meta fields on a User-Agent header that doesn't start with 'LetsEncryptPythonClient'
+	legacyClient := strings.HasPrefix(request.UserAgent(), "LetsEncryptPythonClient")
+	if features.Enabled(features.AllowKeyRollover) && !legacyClient {
 		directoryEndpoints["key-change"] = rolloverPath
 	}
+	for name, path := range directoryEndpoints {
+		if !wfe.endpointEnabled(path) {
+			delete(directoryEndpoints, name)
+		}
+	}
+
+	meta := make(map[string]interface{})
+	if wfe.SubscriberAgreementURL != "" {
+		meta["termsOfService"] = wfe.SubscriberAgreementURL
+	}
+	if wfe.DirectoryWebsite != "" {
+		meta["website"] = wfe.DirectoryWebsite
+	}
+	if len(wfe.DirectoryCAAIdentities) > 0 {
+		meta["caaIdentities"] = wfe.DirectoryCAAIdentities
+	}
+	if wfe.DirectoryKeyTypes {
+		meta["keyTypes"] = wfe.keyPolicy.KeyTypes()
+	}
+
+	extra := make(map[string]interface{})
+	if len(meta) > 0 && !legacyClient {
+		extra["meta"] = meta
+	}
+	if wfe.DirectoryRandomKey && !legacyClient {
+		// A key that differs every time keeps intermediaries from serving a
+		// stale cached directory, and clients from relying on its exact
+		// contents.
+		extra[core.RandomString(8)] = randomDirectoryKeyExplanation
+	}
 
 	response.Header().Set("Content-Type", "application/json")
 
-	relDir, err := wfe.relativeDirectory(request, directoryEndpoints)
+	relDir, err := wfe.relativeDirectory(request, directoryEndpoints, extra)
 	if err != nil {
 		marshalProb := probs.ServerInternal("unable to marshal JSON directory")
 		wfe.sendError(response, logEvent, marshalProb, nil)
@@ -377,7 +1326,54 @@ const (
 	unknownKey = "No registration exists matching provided key"
 )
 
-func (wfe *WebFrontEndImpl) extractJWSKey(body string) (*jose.JsonWebKey, *jose.JsonWebSignature, error) {
+// jwsShape holds the top-level members of a JSON-serialized JWS or JWE that
+// say how many signatures or recipients it has, without decoding them.
+type jwsShape struct {
+	Signatures   []json.RawMessage `json:"signatures"`
+	Recipients   json.RawMessage   `json:"recipients"`
+	Ciphertext   json.RawMessage   `json:"ciphertext"`
+	EncryptedKey json.RawMessage   `json:"encrypted_key"`
+}
+
+// checkJWSShape cheaply rejects encrypted (JWE) and multi-signature inputs
+// before they are handed to jose.ParseSigned, which would otherwise decode
+// every part of them first. Only the shape is checked; anything that passes
+// may still fail to parse.
+func (wfe *WebFrontEndImpl) checkJWSShape(body string) error {
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "{") {
+		// Compact serialization: a JWS has three parts, a JWE five.
+		if strings.Count(trimmed, ".") == 4 {
+			wfe.stats.Inc("Errors.JWEInPOST", 1)
+			return errors.New("Encrypted JWE is not supported, POST body must be a JWS")
+		}
+		return nil
+	}
+	var shape jwsShape
+	if err := json.Unmarshal([]byte(trimmed), &shape); err != nil {
+		// Leave reporting of malformed JSON to jose.ParseSigned.
+		return nil
+	}
+	if shape.Recipients != nil || shape.Ciphertext != nil || shape.EncryptedKey != nil {
+		wfe.stats.Inc("Errors.JWEInPOST", 1)
+		return errors.New("Encrypted JWE is not supported, POST body must be a JWS")
+	}
+	if len(shape.Signatures) > 1 {
+		wfe.stats.Inc("Errors.TooManyJWSSignaturesInPOST", 1)
+		return errors.New("Too many signatures in POST body")
+	}
+	return nil
+}
+
+// extractJWSKey parses body as a JWS and returns the JWK embedded in its
+// header. If allowKeyID is true, a JWS may instead name its registration with
+// a "kid", in which case it has no embedded key and a nil key is returned for
+// the caller to look up. Otherwise the key is never nil. A JWS must not have
+// both.
+func (wfe *WebFrontEndImpl) extractJWSKey(body string, allowKeyID bool) (*jose.JsonWebKey, *jose.JsonWebSignature, error) {
+	if err := wfe.checkJWSShape(body); err != nil {
+		return nil, nil, err
+	}
 	parsedJws, err := jose.ParseSigned(body)
 	if err != nil {
 		wfe.stats.Inc("Errors.UnableToParseJWS", 1)
@@ -394,6 +1390,14 @@ func (wfe *WebFrontEndImpl) extractJWSKey(body string) (*jose.JsonWebKey, *jose.
 	}
 
 	key := parsedJws.Signatures[0].Header.JsonWebKey
+	keyID := parsedJws.Signatures[0].Header.KeyID
+	if key != nil && keyID != "" {
+		wfe.stats.Inc("Errors.JWKAndKeyIDInJWSSignatureHeader", 1)
+		return nil, nil, errors.New("JWS header must not contain both jwk and kid")
+	}
+	if keyID != "" && allowKeyID {
+		return nil, parsedJws, nil
+	}
 	if key == nil {
 		wfe.stats.Inc("Errors.NoJWKInJWSSignatureHeader", 1)
 		return nil, nil, errors.New("No JWK in JWS header")
@@ -414,35 +1418,70 @@ func (wfe *WebFrontEndImpl) extractJWSKey(body string) (*jose.JsonWebKey, *jose.
 // Registration (or error).  If regCheck is false, verifyPOST will still try to
 // look up a registration object, and will return it if found. However, if no
 // registration object is found, verifyPOST will attempt to verify the JWS using
-// the key in the JWS headers, and return the key plus a dummy registration if
+// the key in the JWS headers, and return the key and a nil registration if
 // successful. If a caller passes regCheck = false, it should plan on validating
 // the key itself.  verifyPOST also appends its errors to requestEvent.Errors so
 // code calling it does not need to if they immediately return a response to the
 // user.
-func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEvent, request *http.Request, regCheck bool, resource core.AcmeResource) ([]byte, *jose.JsonWebKey, core.Registration, *probs.ProblemDetails) {
-	// TODO: We should return a pointer to a registration, which can be nil,
-	// rather the a registration value with a sentinel value.
-	// https://github.com/letsencrypt/boulder/issues/877
-	reg := core.Registration{ID: 0}
+func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEvent, request *http.Request, regCheck bool, resource core.AcmeResource) ([]byte, *jose.JsonWebKey, *core.Registration, *probs.ProblemDetails) {
+	payload, key, reg, prob := wfe.verifyJWS(ctx, logEvent, request, regCheck)
+	if prob != nil {
+		return nil, nil, nil, prob
+	}
+	if prob := wfe.checkPayload(logEvent, payload, resource); prob != nil {
+		return nil, nil, nil, prob
+	}
+	return payload, key, reg, nil
+}
 
+// verifyPOSTAsGET verifies a POST-as-GET request: a POST whose JWS, signed by
+// the key of a registration, has an empty payload. It returns the
+// registration, and like verifyPOST logs its own errors.
+func (wfe *WebFrontEndImpl) verifyPOSTAsGET(ctx context.Context, logEvent *requestEvent, request *http.Request) (*core.Registration, *probs.ProblemDetails) {
+	payload, _, reg, prob := wfe.verifyJWS(ctx, logEvent, request, true)
+	if prob != nil {
+		return nil, prob
+	}
+	if len(payload) != 0 {
+		wfe.stats.Inc("Errors.NonEmptyPOSTAsGETPayload", 1)
+		logEvent.AddError("POST-as-GET request has a non-empty payload")
+		return nil, probs.Malformed("POST-as-GET requests must have an empty payload")
+	}
+	logEvent.Extra["PostAsGet"] = true
+	return reg, nil
+}
+
+// verifyJWS does the work of verifyPOST up to, but not including, checking
+// the payload: it reads the request body, looks up the registration for its
+// JWK or kid, and verifies the JWS signature and nonce.
+func (wfe *WebFrontEndImpl) verifyJWS(ctx context.Context, logEvent *requestEvent, request *http.Request, regCheck bool) ([]byte, *jose.JsonWebKey, *core.Registration, *probs.ProblemDetails) {
+	if prob := wfe.checkContentType(logEvent, request); prob != nil {
+		return nil, nil, nil, prob
+	}
+	return wfe.verifyJWSBody(ctx, logEvent, request, regCheck)
+}
+
+// verifyJWSBody is verifyJWS without the check of the request's Content-Type,
+// for callers that accept other types.
+func (wfe *WebFrontEndImpl) verifyJWSBody(ctx context.Context, logEvent *requestEvent, request *http.Request, regCheck bool) ([]byte, *jose.JsonWebKey, *core.Registration, *probs.ProblemDetails) {
 	if _, ok := request.Header["Content-Length"]; !ok {
 		wfe.stats.Inc("HTTP.ClientErrors.LengthRequiredError", 1)
 		logEvent.AddError("missing Content-Length header on POST")
-		return nil, nil, reg, probs.ContentLengthRequired()
+		return nil, nil, nil, probs.ContentLengthRequired()
 	}
 
 	// Read body
 	if request.Body == nil {
 		wfe.stats.Inc("Errors.NoPOSTBody", 1)
 		logEvent.AddError("no body on POST")
-		return nil, nil, reg, probs.Malformed("No body on POST")
+		return nil, nil, nil, probs.Malformed("No body on POST")
 	}
 
 	bodyBytes, err := ioutil.ReadAll(request.Body)
 	if err != nil {
 		wfe.stats.Inc("Errors.UnableToReadRequestBody", 1)
 		logEvent.AddError("unable to read request body")
-		return nil, nil, reg, probs.ServerInternal("unable to read request body")
+		return nil, nil, nil, probs.ServerInternal("unable to read request body")
 	}
 
 	body := string(bodyBytes)
@@ -453,16 +1492,30 @@ func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEve
 	// RA.  However the WFE is the RA's only view of the outside world
 	// *anyway*, so it could always lie about what key was used by faking
 	// the signature itself.
-	submittedKey, parsedJws, err := wfe.extractJWSKey(body)
+	submittedKey, parsedJws, err := wfe.extractJWSKey(body, true)
 	if err != nil {
 		logEvent.AddError(err.Error())
-		return nil, nil, reg, probs.Malformed(err.Error())
+		return nil, nil, nil, probs.Malformed(err.Error())
 	}
 
 	var key *jose.JsonWebKey
-	reg, err = wfe.SA.GetRegistrationByKey(ctx, submittedKey)
-	// Special case: If no registration was found, but regCheck is false, use an
-	// empty registration and the submitted key. The caller is expected to do some
+	var reg *core.Registration
+	var found core.Registration
+	start := wfe.clk.Now()
+	if submittedKey == nil {
+		// The JWS names its registration by URL rather than embedding a key.
+		// A registration found this way is used just like one found by key.
+		var prob *probs.ProblemDetails
+		found, prob = wfe.lookupKeyID(ctx, logEvent, request, parsedJws.Signatures[0].Header.KeyID)
+		if prob != nil {
+			return nil, nil, nil, prob
+		}
+	} else {
+		found, err = wfe.SA.GetRegistrationByKey(ctx, submittedKey)
+		wfe.recordBackendLatency(logEvent, "SA.GetRegistrationByKey", start)
+	}
+	// Special case: If no registration was found, but regCheck is false, use no
+	// registration and the submitted key. The caller is expected to do some
 	// validation on the returned key.
 	if _, ok := err.(core.NoSuchRegistrationError); ok && !regCheck {
 		// When looking up keys from the registrations DB, we can be confident they
@@ -471,7 +1524,7 @@ func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEve
 		if err = wfe.keyPolicy.GoodKey(submittedKey.Key); err != nil {
 			wfe.stats.Inc("Errors.JWKRejectedByGoodKey", 1)
 			logEvent.AddError("JWK in request was rejected by GoodKey: %s", err)
-			return nil, nil, reg, probs.Malformed(err.Error())
+			return nil, nil, nil, probs.Malformed(err.Error())
 		}
 		key = submittedKey
 	} else if err != nil {
@@ -479,12 +1532,13 @@ func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEve
 		wfe.stats.Inc("Errors.UnableToGetRegistrationByKey", 1)
 		logEvent.AddError("unable to fetch registration by the given JWK: %s", err)
 		if _, ok := err.(core.NoSuchRegistrationError); ok {
-			return nil, nil, reg, probs.Unauthorized(unknownKey)
+			return nil, nil, nil, probs.Unauthorized(unknownKey)
 		}
 
-		return nil, nil, reg, core.ProblemDetailsForError(err, "")
+		return nil, nil, nil, core.ProblemDetailsForError(err, "")
 	} else {
 		// If the lookup was successful, use that key.
+		reg = &found
 		key = reg.Key
 		logEvent.Requester = reg.ID
 		logEvent.Contacts = reg.Contact
@@ -492,69 +1546,280 @@ func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEve
 
 	// Only check for validity if we are actually checking the registration
 	if regCheck && features.Enabled(features.AllowAccountDeactivation) && reg.Status != core.StatusValid {
-		return nil, nil, reg, probs.Unauthorized(fmt.Sprintf("Registration is not valid, has status '%s'", reg.Status))
+		return nil, nil, nil, wfe.invalidRegistrationProblem(*reg)
 	}
 
 	if statName, err := checkAlgorithm(key, parsedJws); err != nil {
 		wfe.stats.Inc(statName, 1)
-		return nil, nil, reg, probs.Malformed(err.Error())
+		if statName == forbiddenJWSAlgorithm {
+			// Nothing legitimate sends these, so they're worth an audit
+			// record rather than just a log line.
+			wfe.log.AuditErr(fmt.Sprintf("JWS from %s (registration %d) uses forbidden algorithm %q",
+				logEvent.ClientAddr, logEvent.Requester, parsedJws.Signatures[0].Header.Algorithm))
+		}
+		logEvent.AddError("JWS algorithm check failed: %s", err)
+		return nil, nil, nil, probs.Malformed(err.Error())
+	}
+	if prob := wfe.checkAllowedAlgorithm(logEvent, parsedJws.Signatures[0].Header.Algorithm); prob != nil {
+		return nil, nil, nil, prob
 	}
 
-	payload, err := parsedJws.Verify(key)
-	if err != nil {
+	start = wfe.clk.Now()
+	payload, prob, err := wfe.verifyJWSSignature(ctx, logEvent, parsedJws, key)
+	logEvent.addTiming("JWS", wfe.clk.Since(start))
+	if prob != nil {
+		return nil, nil, nil, prob
+	} else if err != nil {
 		wfe.stats.Inc("Errors.JWSVerificationFailed", 1)
 		n := len(body)
 		if n > 100 {
 			n = 100
 		}
 		logEvent.AddError("verification of JWS with the JWK failed: %v; body: %s", err, body[:n])
-		return nil, nil, reg, probs.Malformed("JWS verification error")
+		return nil, nil, nil, probs.Malformed("JWS verification error")
 	}
 
 	// Check that the request has a known anti-replay nonce
-	nonce := parsedJws.Signatures[0].Header.Nonce
-	logEvent.RequestNonce = nonce
-	if len(nonce) == 0 {
+	requestNonce := parsedJws.Signatures[0].Header.Nonce
+	logEvent.RequestNonce = requestNonce
+	if len(requestNonce) == 0 {
 		wfe.stats.Inc("Errors.JWSMissingNonce", 1)
 		logEvent.AddError("JWS is missing an anti-replay nonce")
-		return nil, nil, reg, probs.BadNonce("JWS has no anti-replay nonce")
-	} else if !wfe.nonceService.Valid(nonce) {
+		return nil, nil, nil, probs.BadNonce("JWS has no anti-replay nonce")
+	} else if !wfe.nonceService.Valid(requestNonce) {
 		wfe.stats.Inc("Errors.JWSInvalidNonce", 1)
-		logEvent.AddError("JWS has an invalid anti-replay nonce: %s", nonce)
-		return nil, nil, reg, probs.BadNonce(fmt.Sprintf("JWS has invalid anti-replay nonce %v", nonce))
+		if instance := nonce.Instance(requestNonce); instance != "" {
+			logEvent.Extra["NonceInstance"] = instance
+		}
+		logEvent.AddError("JWS has an invalid anti-replay nonce: %s", requestNonce)
+		return nil, nil, nil, probs.BadNonce(fmt.Sprintf("JWS has invalid anti-replay nonce %v", requestNonce))
+	} else if wfe.NonceMaxAge > 0 {
+		if minted, ok := wfe.nonceService.Minted(requestNonce); ok && wfe.clk.Now().Sub(minted) > wfe.NonceMaxAge {
+			wfe.stats.Inc("Errors.JWSExpiredNonce", 1)
+			logEvent.AddError("JWS has an expired anti-replay nonce: %s, minted at %s", requestNonce, minted)
+			return nil, nil, nil, probs.BadNonce(fmt.Sprintf("JWS has expired anti-replay nonce %v", requestNonce))
+		}
+	}
+
+	return []byte(payload), key, reg, nil
+}
+
+// lookupKeyID returns the registration named by keyID, the "kid" of a JWS
+// that doesn't embed its key. keyID must be the registration's URL, as given
+// in the Location header when it was created.
+func (wfe *WebFrontEndImpl) lookupKeyID(ctx context.Context, logEvent *requestEvent, request *http.Request, keyID string) (core.Registration, *probs.ProblemDetails) {
+	reg := core.Registration{ID: 0}
+	prefix := wfe.relativeEndpoint(request, regPath)
+	id, err := strconv.ParseInt(strings.TrimPrefix(keyID, prefix), 10, 64)
+	if !strings.HasPrefix(keyID, prefix) || err != nil || id <= 0 {
+		wfe.stats.Inc("Errors.InvalidKeyID", 1)
+		logEvent.AddError("JWS kid is not a registration URL: %q", keyID)
+		return reg, probs.Malformed("JWS kid must be a registration URL")
+	}
+
+	start := wfe.clk.Now()
+	reg, err = wfe.SA.GetRegistration(ctx, id)
+	wfe.recordBackendLatency(logEvent, "SA.GetRegistration", start)
+	if err != nil {
+		wfe.stats.Inc("Errors.UnableToGetRegistrationByKeyID", 1)
+		logEvent.AddError("unable to fetch registration %d named by JWS kid: %s", id, err)
+		if _, ok := err.(core.NoSuchRegistrationError); ok {
+			return core.Registration{ID: 0}, probs.Unauthorized("No registration exists matching provided kid")
+		}
+		return core.Registration{ID: 0}, core.ProblemDetailsForError(err, "")
+	}
+	return reg, nil
+}
+
+// checkAllowedAlgorithm checks that jwsAlgorithm, which checkAlgorithm has
+// accepted, is one of AllowedJWSAlgorithms. Use of each algorithm is counted,
+// so that it's clear which are safe to disallow.
+func (wfe *WebFrontEndImpl) checkAllowedAlgorithm(logEvent *requestEvent, jwsAlgorithm string) *probs.ProblemDetails {
+	if len(wfe.AllowedJWSAlgorithms) == 0 {
+		wfe.stats.Inc("JWSAlgorithms."+jwsAlgorithm, 1)
+		return nil
+	}
+	for _, allowed := range wfe.AllowedJWSAlgorithms {
+		if jwsAlgorithm == allowed {
+			wfe.stats.Inc("JWSAlgorithms."+jwsAlgorithm, 1)
+			return nil
+		}
+	}
+	wfe.stats.Inc("Errors.DisallowedJWSAlgorithm."+jwsAlgorithm, 1)
+	logEvent.AddError("JWS algorithm %s is not allowed", jwsAlgorithm)
+	return probs.Malformed("signature type '%s' in JWS header is not allowed, use one of %s",
+		jwsAlgorithm, strings.Join(wfe.AllowedJWSAlgorithms, ", "))
+}
+
+// joseContentType is the media type of ACME POST bodies.
+const joseContentType = "application/jose+json"
+
+// checkContentType checks that a POST body is labeled as a JWS, which it must
+// be if StrictContentType is set.
+func (wfe *WebFrontEndImpl) checkContentType(logEvent *requestEvent, request *http.Request) *probs.ProblemDetails {
+	contentType := request.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == joseContentType {
+		return nil
+	}
+	wfe.stats.Inc("Errors.WrongContentType", 1)
+	logEvent.Extra["ContentType"] = contentType
+	if wfe.StrictContentType {
+		logEvent.AddError("POST has Content-Type %q", contentType)
+		return probs.Malformed("Request Content-Type must be %q", joseContentType)
+	}
+	return nil
+}
+
+// pkcs10ContentType is the media type of new-cert requests whose JWS payload
+// is a DER CSR.
+const pkcs10ContentType = "application/pkcs10"
+
+// SupportedCertificateRequestContentTypes are the content types that can be
+// listed in WebFrontEndImpl.CertificateRequestContentTypes.
+var SupportedCertificateRequestContentTypes = []string{pkcs10ContentType}
+
+// rawCSRRequest returns true if request is a new-cert request with a DER CSR
+// as its JWS payload, which is only the case if CertificateRequestContentTypes
+// allows it.
+func (wfe *WebFrontEndImpl) rawCSRRequest(request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != pkcs10ContentType {
+		return false
+	}
+	for _, accepted := range wfe.CertificateRequestContentTypes {
+		if accepted == pkcs10ContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPayload checks that a JWS payload is a JSON object whose "resource"
+// field matches resource, and that its fields are canonically spelled.
+func (wfe *WebFrontEndImpl) checkPayload(logEvent *requestEvent, payload []byte, resource core.AcmeResource) *probs.ProblemDetails {
+	// encoding/json quietly replaces invalid UTF-8 in strings, and reports
+	// it anywhere else as an unhelpful syntax error.
+	if !utf8.Valid(payload) {
+		wfe.stats.Inc("Errors.InvalidUTF8InJWSPayload", 1)
+		logEvent.AddError("JWS payload is not valid UTF-8")
+		return probs.Malformed("Request payload is not valid UTF-8")
 	}
 
 	// Check that the "resource" field is present and has the correct value
 	var parsedRequest struct {
 		Resource string `json:"resource"`
 	}
-	err = json.Unmarshal([]byte(payload), &parsedRequest)
+	err := json.Unmarshal(payload, &parsedRequest)
 	if err != nil {
 		wfe.stats.Inc("Errors.UnparsableJWSPayload", 1)
 		logEvent.AddError("unable to JSON parse resource from JWS payload: %s", err)
-		return nil, nil, reg, probs.Malformed("Request payload did not parse as JSON")
+		return probs.Malformed("Request payload did not parse as JSON")
 	}
 	if parsedRequest.Resource == "" {
 		wfe.stats.Inc("Errors.NoResourceInJWSPayload", 1)
 		logEvent.AddError("JWS request payload does not specify a resource")
-		return nil, nil, reg, probs.Malformed("Request payload does not specify a resource")
+		return probs.Malformed("Request payload does not specify a resource")
 	} else if resource != core.AcmeResource(parsedRequest.Resource) {
 		wfe.stats.Inc("Errors.MismatchedResourceInJWSPayload", 1)
 		logEvent.AddError("JWS request payload does not match resource")
-		return nil, nil, reg, probs.Malformed("JWS resource payload does not match the HTTP resource: %s != %s", parsedRequest.Resource, resource)
+		return probs.Malformed("JWS resource payload does not match the HTTP resource: %s != %s", parsedRequest.Resource, resource)
 	}
 
-	return []byte(payload), key, reg, nil
+	if fields := nonCanonicalFields(payload); len(fields) > 0 {
+		wfe.stats.Inc("Errors.NonCanonicalFieldCasing", 1)
+		logEvent.Extra["NonCanonicalFields"] = fields
+		if wfe.StrictJSONFieldCasing {
+			logEvent.AddError("JWS payload has non-canonical field names: %v", fields)
+			return probs.Malformed("Request payload field %q must be spelled %q",
+				fields[0], canonicalJSONFields[strings.ToLower(fields[0])])
+		}
+	}
+
+	return nil
+}
+
+// canonicalJSONFields are the request payload fields whose casing is
+// checked, keyed by their lowercased names.
+var canonicalJSONFields = map[string]string{
+	"resource": "resource",
+	"status":   "status",
+}
+
+// legacyJSONFields are spellings accepted besides the canonical ones, for
+// fields that Boulder itself used to serialize that way. Registrations were
+// sent with "Status" before it was tagged "status", so clients echoing them
+// back aren't at fault.
+var legacyJSONFields = map[string]bool{
+	"Status": true,
+}
+
+// nonCanonicalFields returns the top-level fields of a JSON object payload
+// that only match a field in canonicalJSONFields case-insensitively, other
+// than the spellings in legacyJSONFields.
+func nonCanonicalFields(payload []byte) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil
+	}
+	var found []string
+	for field := range fields {
+		canonical, ok := canonicalJSONFields[strings.ToLower(field)]
+		if ok && field != canonical && !legacyJSONFields[field] {
+			found = append(found, field)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// invalidRegistrationProblem returns the problem sent for requests from reg,
+// whose status isn't valid.
+func (wfe *WebFrontEndImpl) invalidRegistrationProblem(reg core.Registration) *probs.ProblemDetails {
+	prob := probs.Unauthorized(fmt.Sprintf("Registration is not valid, has status '%s'", reg.Status))
+	if wfe.InvalidAccountStatusCode != 0 {
+		prob.HTTPStatus = wfe.InvalidAccountStatusCode
+	}
+	return prob
+}
+
+// recordBackendLatency records how long the SA or RA call named by method
+// (e.g. "SA.GetCertificate") has taken since start, and logs a warning if
+// that exceeds wfe.SlowBackendThreshold. The time is also added to the
+// request's timings, under the name of the backend.
+func (wfe *WebFrontEndImpl) recordBackendLatency(logEvent *requestEvent, method string, start time.Time) {
+	elapsed := wfe.clk.Since(start)
+	wfe.stats.TimingDuration(method+".Latency", elapsed)
+	logEvent.addTiming(strings.SplitN(method, ".", 2)[0], elapsed)
+	if wfe.SlowBackendThreshold > 0 && elapsed > wfe.SlowBackendThreshold {
+		wfe.stats.Inc(method+".Slow", 1)
+		wfe.log.Warning(fmt.Sprintf("Slow backend call: %s took %s", method, elapsed))
+	}
 }
 
 // sendError sends an error response represented by the given ProblemDetails,
 // and, if the ProblemDetails.Type is ServerInternalProblem, audit logs the
-// internal ierr.
+// internal ierr. If ierr is a core.RateLimitedError, the limit it reports is
+// named in the problem document.
 func (wfe *WebFrontEndImpl) sendError(response http.ResponseWriter, logEvent *requestEvent, prob *probs.ProblemDetails, ierr error) {
 	code := probs.ProblemDetailsToStatusCode(prob)
 
+	if rlErr, ok := ierr.(core.RateLimitedError); ok && prob.Type == probs.RateLimitedProblem {
+		prob.RateLimit = rlErr.Limit
+		if prob.RetryAfter == 0 {
+			prob.RetryAfter = wfe.RateLimitRetryAfter[prob.RateLimit]
+		}
+		if wfe.DebugRateLimitHeaders && rlErr.Bucket != "" {
+			response.Header().Set("Boulder-RateLimit-Bucket", rlErr.Bucket)
+		}
+	}
+
 	// Record details to the log event
 	logEvent.AddError(fmt.Sprintf("%d :: %s :: %s", prob.HTTPStatus, prob.Type, prob.Detail))
+	for _, sub := range prob.Subproblems {
+		if sub.Identifier != nil {
+			logEvent.AddError("%s :: %s :: %s", sub.Identifier.Value, sub.Type, sub.Detail)
+		}
+	}
 
 	// Only audit log internal errors so users cannot purposefully cause
 	// auditable events.
@@ -562,12 +1827,44 @@ func (wfe *WebFrontEndImpl) sendError(response http.ResponseWriter, logEvent *re
 		wfe.log.AuditErr(fmt.Sprintf("Internal error - %s - %s", prob.Detail, ierr))
 	}
 
-	problemDoc, err := marshalIndent(prob)
+	// The problem document gives the full error types, and names the request
+	// in its instance so that a problem reported by a subscriber can be found
+	// in the log, where the request event carries the same ID.
+	doc := *prob
+	if wfe.IETFErrorNamespace {
+		doc.Type = probs.FullType(prob.Type)
+	}
+	doc.Instance = logEvent.ID
+	if translations := wfe.LocalizedProblemDetails[string(probs.FullType(prob.Type))]; len(translations) > 0 {
+		response.Header().Add("Vary", "Accept-Language")
+		if lang, detail := matchLanguage(logEvent.acceptLanguage, translations); detail != "" {
+			response.Header().Set("Content-Language", lang)
+			doc.Detail = detail
+		}
+	}
+	if len(prob.Subproblems) > 0 {
+		doc.Subproblems = make([]probs.ProblemDetails, len(prob.Subproblems))
+		for i, sub := range prob.Subproblems {
+			if wfe.IETFErrorNamespace {
+				sub.Type = probs.FullType(sub.Type)
+			}
+			doc.Subproblems[i] = sub
+		}
+	}
+	problemDoc, err := marshalIndent(doc)
 	if err != nil {
 		wfe.log.AuditErr(fmt.Sprintf("Could not marshal error message: %s - %+v", err, prob))
 		problemDoc = []byte("{\"detail\": \"Problem marshalling error message.\"}")
 	}
 
+	if prob.RetryAfter > 0 && (code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable) {
+		addRetryAfterHeader(response, prob.RetryAfter)
+	}
+
+	if nw, ok := response.(*nonceWriter); ok && terminalClientError(code, prob) {
+		nw.terminalError = true
+	}
+
 	// Paraphrased from
 	// https://golang.org/src/net/http/server.go#L1272
 	response.Header().Set("Content-Type", "application/problem+json")
@@ -581,10 +1878,94 @@ func (wfe *WebFrontEndImpl) sendError(response http.ResponseWriter, logEvent *re
 	}
 }
 
+// addRetryAfterHeader sets a Retry-After header giving d in whole seconds,
+// rounded up so that clients never retry early.
+func addRetryAfterHeader(response http.ResponseWriter, d time.Duration) {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	response.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
 func link(url, relation string) string {
 	return fmt.Sprintf("<%s>;rel=\"%s\"", url, relation)
 }
 
+// Names of deprecated request shapes, as used in DeprecationWarnings.
+const (
+	// deprecatedAgreement is a registration request carrying an "agreement"
+	// URL rather than "termsOfServiceAgreed".
+	deprecatedAgreement = "agreement"
+)
+
+// addDeprecationWarning adds an RFC 7234 Warning header with the given text to
+// the response, if warnings for the deprecation are enabled.
+func (wfe *WebFrontEndImpl) addDeprecationWarning(response http.ResponseWriter, deprecation, text string) {
+	for _, d := range wfe.DeprecationWarnings {
+		if d == deprecation {
+			response.Header().Add("Warning", fmt.Sprintf("299 - %q", text))
+			return
+		}
+	}
+}
+
+// registrationRequest holds the fields of new-reg and reg payloads that
+// aren't part of core.Registration.
+type registrationRequest struct {
+	TermsOfServiceAgreed bool `json:"termsOfServiceAgreed"`
+	// OnlyReturnExisting asks new-reg to look up the registration for the
+	// request's key rather than create one.
+	OnlyReturnExisting bool `json:"onlyReturnExisting"`
+}
+
+// registrationResponse is a registration as shown to clients.
+type registrationResponse struct {
+	core.Registration
+	// TermsOfServiceAgreed is true if the registration has agreed to the
+	// current subscriber agreement.
+	TermsOfServiceAgreed bool `json:"termsOfServiceAgreed"`
+}
+
+// prepRegistrationForDisplay adds the fields computed from reg to it.
+func (wfe *WebFrontEndImpl) prepRegistrationForDisplay(reg core.Registration) registrationResponse {
+	return registrationResponse{
+		Registration:         reg,
+		TermsOfServiceAgreed: reg.Agreement != "" && reg.Agreement == wfe.SubscriberAgreementURL,
+	}
+}
+
+// applyTermsOfServiceAgreed fills in reg.Agreement with the current
+// subscriber agreement when the request body agreed to the terms of service
+// without naming an agreement URL. It returns true if the request used the
+// deprecated agreement field instead.
+func (wfe *WebFrontEndImpl) applyTermsOfServiceAgreed(body []byte, reg *core.Registration) bool {
+	if reg.Agreement != "" {
+		return true
+	}
+	var req registrationRequest
+	// The body has already been unmarshaled into reg, so it is valid JSON.
+	_ = json.Unmarshal(body, &req)
+	if req.TermsOfServiceAgreed {
+		reg.Agreement = wfe.SubscriberAgreementURL
+	}
+	return false
+}
+
+// checkContactsLength returns a MalformedRequestError if contacts are longer,
+// all together, than MaxContactsTotalBytes.
+func (wfe *WebFrontEndImpl) checkContactsLength(contacts *[]string) error {
+	if wfe.MaxContactsTotalBytes <= 0 || contacts == nil {
+		return nil
+	}
+	total := 0
+	for _, contact := range *contacts {
+		total += len(contact)
+	}
+	if total > wfe.MaxContactsTotalBytes {
+		return core.MalformedRequestError(fmt.Sprintf(
+			"contacts total %d bytes, more than the maximum of %d", total, wfe.MaxContactsTotalBytes))
+	}
+	return nil
+}
+
 // NewRegistration is used by clients to submit a new registration/account
 func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
 
@@ -596,7 +1977,18 @@ func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *reque
 		return
 	}
 
-	if existingReg, err := wfe.SA.GetRegistrationByKey(ctx, key); err == nil {
+	// verifyPOST has checked that the body is JSON.
+	var regRequest registrationRequest
+	_ = json.Unmarshal(body, &regRequest)
+
+	start := wfe.clk.Now()
+	existingReg, err := wfe.SA.GetRegistrationByKey(ctx, key)
+	wfe.recordBackendLatency(logEvent, "SA.GetRegistrationByKey", start)
+	if regRequest.OnlyReturnExisting {
+		wfe.existingRegistration(response, request, logEvent, existingReg, err)
+		return
+	}
+	if err == nil {
 		response.Header().Set("Location", wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, existingReg.ID)))
 		// TODO(#595): check for missing registration err
 		wfe.sendError(response, logEvent, probs.Conflict("Registration key is already in use"), err)
@@ -604,16 +1996,28 @@ func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *reque
 	}
 
 	var init core.Registration
-	err := json.Unmarshal(body, &init)
+	err = json.Unmarshal(body, &init)
 	if err != nil {
 		wfe.sendError(response, logEvent, probs.Malformed("Error unmarshaling JSON"), err)
 		return
 	}
+	usedAgreementField := wfe.applyTermsOfServiceAgreed(body, &init)
 	if len(init.Agreement) > 0 && init.Agreement != wfe.SubscriberAgreementURL {
 		msg := fmt.Sprintf("Provided agreement URL [%s] does not match current agreement URL [%s]", init.Agreement, wfe.SubscriberAgreementURL)
 		wfe.sendError(response, logEvent, probs.Malformed(msg), nil)
 		return
 	}
+	if err := wfe.checkContactsLength(init.Contact); err != nil {
+		logEvent.AddError("invalid contacts: %s", err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid contacts"), err)
+		return
+	}
+	if wfe.RequireAgreementAtRegistration && init.Agreement == "" {
+		logEvent.AddError("new registration did not agree to the subscriber agreement")
+		wfe.addTermsOfServiceLink(response)
+		wfe.sendError(response, logEvent, probs.Unauthorized("Must agree to subscriber agreement to register"), nil)
+		return
+	}
 	init.Key = key
 	init.InitialIP = net.ParseIP(request.Header.Get("X-Real-IP"))
 	if init.InitialIP == nil {
@@ -627,8 +2031,23 @@ func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *reque
 		}
 	}
 
+	start = wfe.clk.Now()
 	reg, err := wfe.RA.NewRegistration(ctx, init)
+	wfe.recordBackendLatency(logEvent, "RA.NewRegistration", start)
 	if err != nil {
+		// A concurrent new-reg with the same key may have won the race since
+		// the check above, in which case the RA's insert fails on the unique
+		// key. Give that registration's Location, as if we had found it then.
+		start = wfe.clk.Now()
+		existingReg, lookupErr := wfe.SA.GetRegistrationByKey(ctx, key)
+		wfe.recordBackendLatency(logEvent, "SA.GetRegistrationByKey", start)
+		if lookupErr == nil {
+			wfe.stats.Inc("Errors.NewRegistrationRace", 1)
+			logEvent.AddError("registration key was registered concurrently: %s", err)
+			response.Header().Set("Location", wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, existingReg.ID)))
+			wfe.sendError(response, logEvent, probs.Conflict("Registration key is already in use"), err)
+			return
+		}
 		logEvent.AddError("unable to create new registration: %s", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Error creating new registration"), err)
 		return
@@ -637,17 +2056,26 @@ func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *reque
 	addRequesterHeader(response, reg.ID)
 	logEvent.Contacts = reg.Contact
 
+	// A freshly created registration is always valid. Some clients insist on
+	// seeing that spelled out, so don't rely on the RA to have filled it in.
+	if reg.Status == "" {
+		reg.Status = core.StatusValid
+	}
+
 	// Use an explicitly typed variable. Otherwise `go vet' incorrectly complains
 	// that reg.ID is a string being passed to %d.
 	regURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, reg.ID))
 
 	response.Header().Add("Location", regURL)
 	response.Header().Add("Link", link(wfe.relativeEndpoint(request, newAuthzPath), "next"))
-	if len(wfe.SubscriberAgreementURL) > 0 {
-		response.Header().Add("Link", link(wfe.SubscriberAgreementURL, "terms-of-service"))
+	wfe.addTermsOfServiceLink(response)
+	if usedAgreementField {
+		wfe.addDeprecationWarning(response, deprecatedAgreement, "The agreement field is deprecated, use termsOfServiceAgreed")
 	}
 
-	err = wfe.writeJsonResponse(response, logEvent, http.StatusCreated, reg)
+	wfe.addThumbprintHeader(response, reg.Key)
+
+	err = wfe.writeJsonResponse(response, logEvent, http.StatusCreated, wfe.prepRegistrationForDisplay(reg))
 	if err != nil {
 		// ServerInternal because we just created this registration, and it
 		// should be OK.
@@ -657,6 +2085,36 @@ func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *reque
 	}
 }
 
+// existingRegistration answers a new-reg request with onlyReturnExisting set,
+// given the result of looking up the registration for the request's key.
+func (wfe *WebFrontEndImpl) existingRegistration(response http.ResponseWriter, request *http.Request, logEvent *requestEvent, reg core.Registration, err error) {
+	if err != nil {
+		if _, ok := err.(core.NoSuchRegistrationError); ok {
+			wfe.sendError(response, logEvent, probs.AccountDoesNotExist("No registration exists with the provided key"), nil)
+			return
+		}
+		logEvent.AddError("unable to look up registration by key: %s", err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Error looking up registration"), err)
+		return
+	}
+	logEvent.Requester = reg.ID
+	addRequesterHeader(response, reg.ID)
+	logEvent.Contacts = reg.Contact
+
+	response.Header().Add("Location", wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, reg.ID)))
+	wfe.addTermsOfServiceLink(response)
+	wfe.addThumbprintHeader(response, reg.Key)
+
+	err = wfe.writeJsonResponse(response, logEvent, http.StatusOK, wfe.prepRegistrationForDisplay(reg))
+	if err != nil {
+		// ServerInternal because we just fetched this registration, and it
+		// should be OK.
+		logEvent.AddError("unable to marshal registration: %s", err)
+		wfe.sendError(response, logEvent, probs.ServerInternal("Error marshaling registration"), err)
+		return
+	}
+}
+
 // NewAuthorization is used by clients to submit a new ID Authorization
 func (wfe *WebFrontEndImpl) NewAuthorization(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
 	body, _, currReg, prob := wfe.verifyPOST(ctx, logEvent, request, true, core.ResourceNewAuthz)
@@ -683,12 +2141,21 @@ func (wfe *WebFrontEndImpl) NewAuthorization(ctx context.Context, logEvent *requ
 	logEvent.Extra["Identifier"] = init.Identifier
 
 	// Create new authz and return
+	start := wfe.clk.Now()
 	authz, err := wfe.RA.NewAuthorization(ctx, init, currReg.ID)
+	wfe.recordBackendLatency(logEvent, "RA.NewAuthorization", start)
 	if err != nil {
 		logEvent.AddError("unable to create new authz: %s", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Error creating new authz"), err)
 		return
 	}
+	// An RA configured to reuse valid authorizations may hand back one that
+	// already exists rather than creating one.
+	status := http.StatusCreated
+	if authz.Status == core.StatusValid {
+		status = http.StatusOK
+		logEvent.Extra["ReusedAuthz"] = true
+	}
 	logEvent.Extra["AuthzID"] = authz.ID
 
 	// Make a URL for this authz, then blow away the ID and RegID before serializing
@@ -697,8 +2164,11 @@ func (wfe *WebFrontEndImpl) NewAuthorization(ctx context.Context, logEvent *requ
 
 	response.Header().Add("Location", authzURL)
 	response.Header().Add("Link", link(wfe.relativeEndpoint(request, newCertPath), "next"))
+	if wfe.EmitTermsLinkEverywhere {
+		wfe.addTermsOfServiceLink(response)
+	}
 
-	err = wfe.writeJsonResponse(response, logEvent, http.StatusCreated, authz)
+	err = wfe.writeJsonResponse(response, logEvent, status, authz)
 	if err != nil {
 		// ServerInternal because we generated the authz, it should be OK
 		wfe.sendError(response, logEvent, probs.ServerInternal("Error marshaling authz"), err)
@@ -706,21 +2176,29 @@ func (wfe *WebFrontEndImpl) NewAuthorization(ctx context.Context, logEvent *requ
 	}
 }
 
-func (wfe *WebFrontEndImpl) regHoldsAuthorizations(ctx context.Context, regID int64, names []string) (bool, error) {
+// regHoldsAuthorizations reports whether regID holds valid authorizations
+// for all of names. Names are compared in their core.NormalizeDNSName form,
+// so case and a trailing dot don't matter.
+func (wfe *WebFrontEndImpl) regHoldsAuthorizations(ctx context.Context, logEvent *requestEvent, regID int64, names []string) (bool, error) {
+	nameMap := make(map[string]bool, len(names))
+	for _, name := range names {
+		nameMap[core.NormalizeDNSName(name)] = true
+	}
+	names = make([]string, 0, len(nameMap))
+	for name := range nameMap {
+		names = append(names, name)
+	}
+
+	start := wfe.clk.Now()
 	authz, err := wfe.SA.GetValidAuthorizations(ctx, regID, names, wfe.clk.Now())
+	wfe.recordBackendLatency(logEvent, "SA.GetValidAuthorizations", start)
 	if err != nil {
 		return false, err
 	}
-	if len(names) != len(authz) {
-		return false, nil
+	for name := range authz {
+		delete(nameMap, core.NormalizeDNSName(name))
 	}
-	missingNames := false
-	for _, name := range names {
-		if _, present := authz[name]; !present {
-			missingNames = true
-		}
-	}
-	return !missingNames, nil
+	return len(nameMap) == 0, nil
 }
 
 // RevokeCertificate is used by clients to request the revocation of a cert.
@@ -754,7 +2232,9 @@ func (wfe *WebFrontEndImpl) RevokeCertificate(ctx context.Context, logEvent *req
 
 	serial := core.SerialToString(providedCert.SerialNumber)
 	logEvent.Extra["ProvidedCertificateSerial"] = serial
+	start := wfe.clk.Now()
 	cert, err := wfe.SA.GetCertificate(ctx, serial)
+	wfe.recordBackendLatency(logEvent, "SA.GetCertificate", start)
 	// TODO(#991): handle db errors better
 	if err != nil || !bytes.Equal(cert.DER, revokeRequest.CertificateDER) {
 		wfe.sendError(response, logEvent, probs.NotFound("No such certificate"), err)
@@ -771,7 +2251,9 @@ func (wfe *WebFrontEndImpl) RevokeCertificate(ctx context.Context, logEvent *req
 	logEvent.Extra["RetrievedCertificateEmailAddresses"] = parsedCertificate.EmailAddresses
 	logEvent.Extra["RetrievedCertificateIPAddresses"] = parsedCertificate.IPAddresses
 
+	start = wfe.clk.Now()
 	certStatus, err := wfe.SA.GetCertificateStatus(ctx, serial)
+	wfe.recordBackendLatency(logEvent, "SA.GetCertificateStatus", start)
 	if err != nil {
 		logEvent.AddError("unable to get certificate status: %s", err)
 		// TODO(#991): handle db errors
@@ -786,12 +2268,22 @@ func (wfe *WebFrontEndImpl) RevokeCertificate(ctx context.Context, logEvent *req
 		return
 	}
 
-	if !(core.KeyDigestEquals(requestKey, parsedCertificate.PublicKey) || registration.ID == cert.RegistrationID) {
-		valid, err := wfe.regHoldsAuthorizations(ctx, registration.ID, parsedCertificate.DNSNames)
-		if err != nil {
-			logEvent.AddError("regHoldsAuthorizations failed: %s", err)
-			wfe.sendError(response, logEvent, probs.ServerInternal("Failed to retrieve authorizations for names in certificate"), err)
-			return
+	// The request may be signed by a key with no registration, in which case
+	// only the certificate's own key can revoke it, and the RA is told the
+	// revoker is registration 0.
+	var revokerID int64
+	if registration != nil {
+		revokerID = registration.ID
+	}
+	if !(core.KeyDigestEquals(requestKey, parsedCertificate.PublicKey) || (registration != nil && registration.ID == cert.RegistrationID)) {
+		valid := false
+		if registration != nil {
+			valid, err = wfe.regHoldsAuthorizations(ctx, logEvent, registration.ID, parsedCertificate.DNSNames)
+			if err != nil {
+				logEvent.AddError("regHoldsAuthorizations failed: %s", err)
+				wfe.sendError(response, logEvent, probs.ServerInternal("Failed to retrieve authorizations for names in certificate"), err)
+				return
+			}
 		}
 		if !valid {
 			wfe.sendError(response, logEvent,
@@ -813,7 +2305,9 @@ func (wfe *WebFrontEndImpl) RevokeCertificate(ctx context.Context, logEvent *req
 		reason = *revokeRequest.Reason
 	}
 
-	err = wfe.RA.RevokeCertificateWithReg(ctx, *parsedCertificate, reason, registration.ID)
+	start = wfe.clk.Now()
+	err = wfe.RA.RevokeCertificateWithReg(ctx, *parsedCertificate, reason, revokerID)
+	wfe.recordBackendLatency(logEvent, "RA.RevokeCertificateWithReg", start)
 	if err != nil {
 		logEvent.AddError("failed to revoke certificate: %s", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Failed to revoke certificate"), err)
@@ -823,29 +2317,65 @@ func (wfe *WebFrontEndImpl) RevokeCertificate(ctx context.Context, logEvent *req
 	}
 }
 
+// checkCertificateProfile returns a MalformedRequestError listing the
+// configured profiles if profile is neither empty nor one of them.
+func (wfe *WebFrontEndImpl) checkCertificateProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+	for _, p := range wfe.CertificateProfiles {
+		if p == profile {
+			return nil
+		}
+	}
+	return core.MalformedRequestError(fmt.Sprintf("unknown certificate profile %q, valid profiles are: %s",
+		profile, strings.Join(wfe.CertificateProfiles, ", ")))
+}
+
 func (wfe *WebFrontEndImpl) logCsr(request *http.Request, cr core.CertificateRequest, registration core.Registration) {
 	var csrLog = struct {
-		ClientAddr   string
-		CSR          string
-		Registration core.Registration
+		ClientAddr          string
+		CSR                 string
+		Registration        core.Registration
+		AuditRetentionClass string `json:",omitempty"`
 	}{
-		ClientAddr:   getClientAddr(request),
-		CSR:          hex.EncodeToString(cr.Bytes),
-		Registration: registration,
+		ClientAddr:          getClientAddr(request),
+		CSR:                 hex.EncodeToString(cr.Bytes),
+		Registration:        registration,
+		AuditRetentionClass: wfe.AuditRetention.Class(registration.ID),
 	}
-	wfe.log.AuditObject("Certificate request", csrLog)
+	logger := wfe.log
+	if wfe.CSRLog != nil {
+		logger = wfe.CSRLog
+	}
+	logger.AuditObject("Certificate request", csrLog)
 }
 
 // NewCertificate is used by clients to request the issuance of a cert for an
 // authorized identifier.
 func (wfe *WebFrontEndImpl) NewCertificate(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
-	body, _, reg, prob := wfe.verifyPOST(ctx, logEvent, request, true, core.ResourceNewCert)
+	var body []byte
+	var reg *core.Registration
+	var prob *probs.ProblemDetails
+	rawCSRRequest := wfe.rawCSRRequest(request)
+	if rawCSRRequest {
+		// The payload is a DER CSR, so there's no resource field to check.
+		logEvent.Extra["RawCSR"] = true
+		body, _, reg, prob = wfe.verifyJWSBody(ctx, logEvent, request, true)
+	} else {
+		body, _, reg, prob = wfe.verifyPOST(ctx, logEvent, request, true, core.ResourceNewCert)
+	}
 	addRequesterHeader(response, logEvent.Requester)
 	if prob != nil {
 		// verifyPOST handles its own setting of logEvent.Errors
 		wfe.sendError(response, logEvent, prob, nil)
 		return
 	}
+	contentType := wfe.negotiateContentType(request, certificateContentTypes...)
+	if contentType == "" {
+		wfe.sendError(response, logEvent, notAcceptable(certificateContentTypes...), nil)
+		return
+	}
 	// Any version of the agreement is acceptable here. Version match is enforced in
 	// wfe.Registration when agreeing the first time. Agreement updates happen
 	// by mailing subscribers and don't require a registration update.
@@ -855,12 +2385,19 @@ func (wfe *WebFrontEndImpl) NewCertificate(ctx context.Context, logEvent *reques
 	}
 
 	var rawCSR core.RawCertificateRequest
-	err := json.Unmarshal(body, &rawCSR)
-	if err != nil {
+	var err error
+	if rawCSRRequest {
+		rawCSR.CSR = body
+	} else if err = json.Unmarshal(body, &rawCSR); err != nil {
 		logEvent.AddError("unable to JSON unmarshal CertificateRequest: %s", err)
 		wfe.sendError(response, logEvent, probs.Malformed("Error unmarshaling certificate request"), err)
 		return
 	}
+	if len(rawCSR.CSR) == 0 {
+		logEvent.AddError("missing CSR")
+		wfe.sendError(response, logEvent, probs.Malformed("missing CSR"), nil)
+		return
+	}
 	// Assuming a properly formatted CSR there should be two four byte SEQUENCE
 	// declarations then a two byte integer declaration which defines the version
 	// of the CSR. If those two bytes (at offset 8 and 9) and equal to 2 and 0
@@ -879,7 +2416,13 @@ func (wfe *WebFrontEndImpl) NewCertificate(ctx context.Context, logEvent *reques
 		return
 	}
 
-	certificateRequest := core.CertificateRequest{Bytes: rawCSR.CSR}
+	if err := wfe.checkCertificateProfile(rawCSR.Profile); err != nil {
+		logEvent.AddError("unknown certificate profile: %s", err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid certificate request"), err)
+		return
+	}
+
+	certificateRequest := core.CertificateRequest{Bytes: rawCSR.CSR, Profile: rawCSR.Profile}
 	certificateRequest.CSR, err = x509.ParseCertificateRequest(rawCSR.CSR)
 	if err != nil {
 		logEvent.AddError("unable to parse certificate request: %s", err)
@@ -887,32 +2430,85 @@ func (wfe *WebFrontEndImpl) NewCertificate(ctx context.Context, logEvent *reques
 		wfe.sendError(response, logEvent, probs.Malformed("Error parsing certificate request. Extensions in the CSR marked critical can cause this error: https://github.com/letsencrypt/boulder/issues/565"), err)
 		return
 	}
-	wfe.logCsr(request, certificateRequest, reg)
+	wfe.logCsr(request, certificateRequest, *reg)
 	// Check that the key in the CSR is good. This will also be checked in the CA
 	// component, but we want to discard CSRs with bad keys as early as possible
 	// because (a) it's an easy check and we can save unnecessary requests and
 	// bytes on the wire, and (b) the CA logs all rejections as audit events, but
 	// a bad key from the client is just a malformed request and doesn't need to
 	// be audited.
+	if err := wfe.checkCSRKey(certificateRequest.CSR.PublicKey); err != nil {
+		logEvent.AddError("CSR public key is out of policy: %s", err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid key in certificate request"), err)
+		return
+	}
 	if err := wfe.keyPolicy.GoodKey(certificateRequest.CSR.PublicKey); err != nil {
 		logEvent.AddError("CSR public key failed GoodKey: %s", err)
 		wfe.sendError(response, logEvent, probs.Malformed("Invalid key in certificate request :: %s", err), err)
 		return
 	}
-	logEvent.Extra["CSRDNSNames"] = certificateRequest.CSR.DNSNames
+	if wfe.RejectSubjectAttributes {
+		if err := checkSubjectAttributes(certificateRequest.CSR.Subject); err != nil {
+			logEvent.AddError("CSR has disallowed subject attributes: %s", err)
+			wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid certificate request"), err)
+			return
+		}
+	}
+	if wfe.RejectDisallowedEKU {
+		if err := checkRequestedEKUs(certificateRequest.CSR); err != nil {
+			logEvent.AddError("CSR requests disallowed extended key usage: %s", err)
+			wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid certificate request"), err)
+			return
+		}
+	}
+	names, err := normalizeCSRNames(certificateRequest.CSR.DNSNames, wfe.AllowDuplicateCSRNames)
+	if err != nil {
+		logEvent.AddError("CSR has duplicate names: %s", err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid certificate request"), err)
+		return
+	}
+	if wfe.MaxNames > 0 && len(names) > wfe.MaxNames {
+		wfe.stats.Inc("Errors.TooManyNames", 1)
+		err := core.MalformedRequestError(fmt.Sprintf("CSR has %d names, more than the maximum of %d", len(names), wfe.MaxNames))
+		logEvent.AddError("CSR has too many names: %s", err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid certificate request"), err)
+		return
+	}
+	logEvent.Extra["CSRDNSNames"] = names
 	logEvent.Extra["CSREmailAddresses"] = certificateRequest.CSR.EmailAddresses
 	logEvent.Extra["CSRIPAddresses"] = certificateRequest.CSR.IPAddresses
 
+	if wfe.LogPreAuthorizedIssuance {
+		start := wfe.clk.Now()
+		preAuthorized, err := wfe.RA.CheckPreAuthorization(ctx, reg.ID, certificateRequest.CSR.DNSNames)
+		wfe.recordBackendLatency(logEvent, "RA.CheckPreAuthorization", start)
+		if err != nil {
+			// The RA makes the same check when issuing, so this only costs us
+			// the audit line.
+			wfe.log.Warning(fmt.Sprintf("unable to check pre-authorization for registration %d: %s", reg.ID, err))
+		} else if preAuthorized {
+			logEvent.Extra["PreAuthorized"] = true
+			wfe.stats.Inc("PreAuthorizedCertificateRequests", 1)
+			wfe.log.AuditInfo(fmt.Sprintf("Pre-authorized certificate request from registration %d for %v", reg.ID, certificateRequest.CSR.DNSNames))
+		}
+	}
+
 	// Create new certificate and return
 	// TODO IMPORTANT: The RA trusts the WFE to provide the correct key. If the
 	// WFE is compromised, *and* the attacker knows the public key of an account
 	// authorized for target site, they could cause issuance for that site by
 	// lying to the RA. We should probably pass a copy of the whole request to the
 	// RA for secondary validation.
+	start := wfe.clk.Now()
 	cert, err := wfe.RA.NewCertificate(ctx, certificateRequest, reg.ID)
+	wfe.recordBackendLatency(logEvent, "RA.NewCertificate", start)
 	if err != nil {
 		logEvent.AddError("unable to create new cert: %s", err)
-		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Error creating new cert"), err)
+		prob := core.ProblemDetailsForError(err, "Error creating new cert")
+		if _, ok := err.(core.UnauthorizedError); ok {
+			prob.Subproblems = wfe.unauthorizedNameProblems(ctx, logEvent, reg.ID, certificateRequest.CSR)
+		}
+		wfe.sendError(response, logEvent, prob, err)
 		return
 	}
 
@@ -927,21 +2523,59 @@ func (wfe *WebFrontEndImpl) NewCertificate(ctx context.Context, logEvent *reques
 		return
 	}
 	serial := parsedCertificate.SerialNumber
-	certURL := wfe.relativeEndpoint(request, certPath+core.SerialToString(serial))
-
-	relativeIssuerPath := wfe.relativeEndpoint(request, issuerPath)
+	certURL := wfe.relativeEndpoint(request, certPath+wfe.CertSerialEncoding.Encode(serial))
+	wfe.issuanceWebhook.notify(issuanceEvent{
+		Serial:         core.SerialToString(serial),
+		RegistrationID: reg.ID,
+		Names:          core.UniqueLowerNames(append([]string{parsedCertificate.Subject.CommonName}, parsedCertificate.DNSNames...)),
+		Issued:         wfe.clk.Now(),
+	})
 
-	// TODO Content negotiation
 	response.Header().Add("Location", certURL)
-	response.Header().Add("Link", link(relativeIssuerPath, "up"))
-	response.Header().Set("Content-Type", "application/pkix-cert")
+	wfe.addCertificateLinks(response, request, certURL, reg.ID)
+	if wfe.EmitTermsLinkEverywhere {
+		wfe.addTermsOfServiceLink(response)
+	}
+	response.Header().Set("Content-Type", contentType)
 	response.WriteHeader(http.StatusCreated)
-	if _, err = response.Write(cert.DER); err != nil {
+	if _, err = response.Write(wfe.certificateBody(contentType, cert.DER)); err != nil {
 		logEvent.AddError(err.Error())
 		wfe.log.Warning(fmt.Sprintf("Could not write response: %s", err))
 	}
 }
 
+// unauthorizedNameProblems returns a subproblem for each name in csr that
+// regID holds no valid authorization for, so that a client refused a
+// certificate can tell which of its names need authorizing. Since this only
+// runs once issuance has failed, lookup errors just mean no subproblems.
+func (wfe *WebFrontEndImpl) unauthorizedNameProblems(ctx context.Context, logEvent *requestEvent, regID int64, csr *x509.CertificateRequest) []probs.ProblemDetails {
+	names := csr.DNSNames
+	if csr.Subject.CommonName != "" {
+		names = append([]string{csr.Subject.CommonName}, names...)
+	}
+	names = core.UniqueLowerNames(names)
+	now := wfe.clk.Now()
+	start := now
+	authzs, err := wfe.SA.GetValidAuthorizations(ctx, regID, names, now)
+	wfe.recordBackendLatency(logEvent, "SA.GetValidAuthorizations", start)
+	if err != nil {
+		logEvent.AddError("unable to look up valid authorizations: %s", err)
+		return nil
+	}
+	var subproblems []probs.ProblemDetails
+	for _, name := range names {
+		if authz := authzs[name]; authz != nil && authz.Expires != nil && authz.Expires.After(now) {
+			continue
+		}
+		subproblems = append(subproblems, probs.ProblemDetails{
+			Type:       probs.UnauthorizedProblem,
+			Detail:     "No valid authorization for this name",
+			Identifier: &probs.ProblemIdentifier{Type: string(core.IdentifierDNS), Value: name},
+		})
+	}
+	return subproblems
+}
+
 // Challenge handles POST requests to challenge URLs.  Such requests are clients'
 // responses to the server's challenges.
 func (wfe *WebFrontEndImpl) Challenge(
@@ -950,6 +2584,11 @@ func (wfe *WebFrontEndImpl) Challenge(
 	response http.ResponseWriter,
 	request *http.Request) {
 
+	postAsGet, requester, ok := wfe.authenticateRead(ctx, logEvent, response, request)
+	if !ok {
+		return
+	}
+
 	notFound := func() {
 		wfe.sendError(response, logEvent, probs.NotFound("No such challenge"), nil)
 	}
@@ -967,10 +2606,62 @@ func (wfe *WebFrontEndImpl) Challenge(
 		notFound()
 		return
 	}
+	wfe.challenge(ctx, logEvent, response, request, postAsGet, requester, authorizationID, challengeID)
+}
+
+// ChallengeV2 serves challenges at /acme/chall-v2/<challenge id>, finding
+// the authorization they belong to from the challenge ID alone, for clients
+// that keep only the challenge URL. It otherwise behaves just like Challenge.
+func (wfe *WebFrontEndImpl) ChallengeV2(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	postAsGet, requester, ok := wfe.authenticateRead(ctx, logEvent, response, request)
+	if !ok {
+		return
+	}
+
+	challengeID, err := strconv.ParseInt(request.URL.Path, 10, 64)
+	if err != nil {
+		wfe.sendError(response, logEvent, probs.NotFound("No such challenge"), nil)
+		return
+	}
+
+	start := wfe.clk.Now()
+	_, authorizationID, err := wfe.SA.GetChallenge(ctx, challengeID)
+	wfe.recordBackendLatency(logEvent, "SA.GetChallenge", start)
+	if err != nil {
+		logEvent.AddError("unable to find challenge %d: %s", challengeID, err)
+		wfe.sendError(response, logEvent, probs.NotFound("No such challenge"), nil)
+		return
+	}
+	wfe.challenge(ctx, logEvent, response, request, postAsGet, requester, authorizationID, challengeID)
+}
+
+// challenge serves a GET, POST-as-GET or update of the challenge with ID
+// challengeID in the authorization with ID authorizationID.
+func (wfe *WebFrontEndImpl) challenge(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request,
+	postAsGet bool,
+	requester *core.Registration,
+	authorizationID string,
+	challengeID int64) {
+
+	notFound := func() {
+		wfe.sendError(response, logEvent, probs.NotFound("No such challenge"), nil)
+	}
+
 	logEvent.Extra["AuthorizationID"] = authorizationID
 	logEvent.Extra["ChallengeID"] = challengeID
 
+	start := wfe.clk.Now()
 	authz, err := wfe.SA.GetAuthorization(ctx, authorizationID)
+	wfe.recordBackendLatency(logEvent, "SA.GetAuthorization", start)
 	if err != nil {
 		// TODO(#1198): handle db errors etc
 		notFound()
@@ -998,11 +2689,15 @@ func (wfe *WebFrontEndImpl) Challenge(
 	logEvent.Extra["AuthorizationStatus"] = authz.Status
 	logEvent.Extra["AuthorizationExpires"] = authz.Expires
 
-	switch request.Method {
-	case "GET", "HEAD":
+	if postAsGet && wfe.rejectForeignResource(logEvent, response, requester, authz.RegistrationID, "authorization") {
+		return
+	}
+
+	switch {
+	case request.Method == "GET", request.Method == "HEAD", postAsGet:
 		wfe.getChallenge(ctx, response, request, authz, &challenge, logEvent)
 
-	case "POST":
+	case request.Method == "POST":
 		wfe.postChallenge(ctx, response, request, authz, challengeIndex, logEvent)
 	}
 }
@@ -1015,6 +2710,22 @@ func (wfe *WebFrontEndImpl) prepChallengeForDisplay(request *http.Request, authz
 	challenge.URI = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s/%d", challengePath, authz.ID, challenge.ID))
 	// 0 is considered "empty" for the purpose of the JSON omitempty tag.
 	challenge.ID = 0
+	// The time a challenge was validated is only meaningful while it remains
+	// valid.
+	if challenge.Status != core.StatusValid {
+		challenge.Validated = nil
+	}
+
+	// Validation records are shown so clients can debug failed validations,
+	// but the DNS authorities consulted are details of our own resolvers.
+	if len(challenge.ValidationRecord) > 0 {
+		records := make([]core.ValidationRecord, len(challenge.ValidationRecord))
+		copy(records, challenge.ValidationRecord)
+		for i := range records {
+			records[i].Authorities = nil
+		}
+		challenge.ValidationRecord = records
+	}
 }
 
 // prepAuthorizationForDisplay takes a core.Authorization and prepares it for
@@ -1026,6 +2737,10 @@ func (wfe *WebFrontEndImpl) prepAuthorizationForDisplay(request *http.Request, a
 	}
 	authz.ID = ""
 	authz.RegistrationID = 0
+	if strings.HasPrefix(authz.Identifier.Value, "*.") {
+		authz.Identifier.Value = strings.TrimPrefix(authz.Identifier.Value, "*.")
+		authz.Wildcard = true
+	}
 }
 
 func (wfe *WebFrontEndImpl) getChallenge(
@@ -1041,6 +2756,9 @@ func (wfe *WebFrontEndImpl) getChallenge(
 	authzURL := wfe.relativeEndpoint(request, authzPath+string(authz.ID))
 	response.Header().Add("Location", challenge.URI)
 	response.Header().Add("Link", link(authzURL, "up"))
+	if wfe.EmitTermsLinkEverywhere {
+		wfe.addTermsOfServiceLink(response)
+	}
 
 	err := wfe.writeJsonResponse(response, logEvent, http.StatusAccepted, challenge)
 	if err != nil {
@@ -1066,6 +2784,14 @@ func (wfe *WebFrontEndImpl) postChallenge(
 		wfe.sendError(response, logEvent, prob, nil)
 		return
 	}
+	// verifyPOST only refuses deactivated registrations when account
+	// deactivation is enabled, but one deactivated some other way mustn't be
+	// able to complete the authorizations it left behind either.
+	if currReg.Status == core.StatusDeactivated {
+		logEvent.AddError("deactivated registration %d responding to challenge", currReg.ID)
+		wfe.sendError(response, logEvent, wfe.invalidRegistrationProblem(*currReg), nil)
+		return
+	}
 	// Any version of the agreement is acceptable here. Version match is enforced in
 	// wfe.Registration when agreeing the first time. Agreement updates happen
 	// by mailing subscribers and don't require a registration update.
@@ -1092,24 +2818,63 @@ func (wfe *WebFrontEndImpl) postChallenge(
 		wfe.sendError(response, logEvent, probs.Malformed("Error unmarshaling challenge response"), err)
 		return
 	}
-
-	// Ask the RA to update this authorization
-	updatedAuthorization, err := wfe.RA.UpdateAuthorization(ctx, authz, challengeIndex, challengeUpdate)
-	if err != nil {
-		logEvent.AddError("unable to update challenge: %s", err)
+	if err := checkChallengeToken(authz.Challenges[challengeIndex], challengeUpdate); err != nil {
+		logEvent.AddError("challenge response token mismatch: %s", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Unable to update challenge"), err)
 		return
 	}
 
-	// assumption: UpdateAuthorization does not modify order of challenges
-	challenge := updatedAuthorization.Challenges[challengeIndex]
-	wfe.prepChallengeForDisplay(request, authz, &challenge)
+	// Keys are scoped to the registration and challenge, so a key can't be
+	// used to read another's response.
+	var idempotencyKey string
+	if key := request.Header.Get(idempotencyKeyHeader); key != "" && wfe.ChallengeIdempotencyWindow > 0 {
+		idempotencyKey = fmt.Sprintf("%d/%s/%d/%s", currReg.ID, authz.ID, challengeIndex, key)
+	}
+
+	var challenge core.Challenge
+	var cached bool
+	finish := func(*core.Challenge, time.Duration) {}
+	if idempotencyKey != "" {
+		challenge, cached, finish = wfe.challengeResponses.claim(idempotencyKey)
+	}
+	if cached {
+		logEvent.Extra["IdempotentReplay"] = true
+		wfe.stats.Inc("ChallengeIdempotentReplays", 1)
+	} else {
+		// Checked after the replay lookup so a retry of the response that
+		// made the authorization valid still gets its original answer.
+		if wfe.RejectChallengesOnValidAuthz && authz.Status == core.StatusValid {
+			finish(nil, 0)
+			logEvent.AddError("challenge response for valid authorization %s", authz.ID)
+			wfe.sendError(response, logEvent, probs.Malformed("Authorization is already valid, no further challenge responses are needed"), nil)
+			return
+		}
+
+		// Ask the RA to update this authorization
+		start := wfe.clk.Now()
+		updatedAuthorization, err := wfe.RA.UpdateAuthorization(ctx, authz, challengeIndex, challengeUpdate)
+		wfe.recordBackendLatency(logEvent, "RA.UpdateAuthorization", start)
+		if err != nil {
+			finish(nil, 0)
+			logEvent.AddError("unable to update challenge: %s", err)
+			wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Unable to update challenge"), err)
+			return
+		}
+
+		// assumption: UpdateAuthorization does not modify order of challenges
+		challenge = updatedAuthorization.Challenges[challengeIndex]
+		wfe.prepChallengeForDisplay(request, authz, &challenge)
+		finish(&challenge, wfe.ChallengeIdempotencyWindow)
+	}
 
 	authzURL := wfe.relativeEndpoint(request, authzPath+string(authz.ID))
 	response.Header().Add("Location", challenge.URI)
 	response.Header().Add("Link", link(authzURL, "up"))
+	if wfe.EmitTermsLinkEverywhere {
+		wfe.addTermsOfServiceLink(response)
+	}
 
-	err = wfe.writeJsonResponse(response, logEvent, http.StatusAccepted, challenge)
+	err := wfe.writeJsonResponse(response, logEvent, http.StatusAccepted, challenge)
 	if err != nil {
 		// ServerInternal because we made the challenges, they should be OK
 		logEvent.AddError("failed to marshal challenge: %s", err)
@@ -1118,29 +2883,49 @@ func (wfe *WebFrontEndImpl) postChallenge(
 	}
 }
 
+// checkChallengeToken returns an error if a challenge response echoes a token,
+// either on its own or as the first part of its key authorization, other than
+// the one we issued for the challenge. The RA would refuse the key
+// authorization anyway, but catching this here gives clients a clearer error.
+func checkChallengeToken(issued, update core.Challenge) error {
+	if update.Token != "" && update.Token != issued.Token {
+		return core.MalformedRequestError(fmt.Sprintf("Challenge response token %q does not match the challenge token %q", update.Token, issued.Token))
+	}
+	if update.ProvidedKeyAuthorization != "" {
+		token := strings.SplitN(update.ProvidedKeyAuthorization, ".", 2)[0]
+		if token != issued.Token {
+			return core.MalformedRequestError(fmt.Sprintf("Key authorization token %q does not match the challenge token %q", token, issued.Token))
+		}
+	}
+	return nil
+}
+
 // Registration is used by a client to submit an update to their registration.
 func (wfe *WebFrontEndImpl) Registration(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	if request.Method == "HEAD" {
+		wfe.headRegistration(ctx, logEvent, response, request)
+		return
+	}
 
-	body, _, currReg, prob := wfe.verifyPOST(ctx, logEvent, request, true, core.ResourceRegistration)
+	// An empty payload makes this a POST-as-GET, which reads the
+	// registration rather than updating it, so the payload is only checked
+	// when there is one.
+	body, _, currReg, prob := wfe.verifyJWS(ctx, logEvent, request, true)
+	if prob == nil && len(body) != 0 {
+		prob = wfe.checkPayload(logEvent, body, core.ResourceRegistration)
+	}
 	addRequesterHeader(response, logEvent.Requester)
 	if prob != nil {
-		// verifyPOST handles its own setting of logEvent.Errors
+		// verifyJWS and checkPayload handle their own setting of logEvent.Errors
 		wfe.sendError(response, logEvent, prob, nil)
 		return
 	}
 
 	// Requests to this handler should have a path that leads to a known
 	// registration
-	idStr := request.URL.Path
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		logEvent.AddError("registration ID must be an integer, was %#v", idStr)
-		wfe.sendError(response, logEvent, probs.Malformed("Registration ID must be an integer"), err)
-		return
-	} else if id <= 0 {
-		msg := fmt.Sprintf("Registration ID must be a positive non-zero integer, was %d", id)
-		logEvent.AddError(msg)
-		wfe.sendError(response, logEvent, probs.Malformed(msg), nil)
+	id, prob := registrationID(logEvent, request)
+	if prob != nil {
+		wfe.sendError(response, logEvent, prob, nil)
 		return
 	} else if id != currReg.ID {
 		logEvent.AddError("Request signing key did not match registration key: %d != %d", id, currReg.ID)
@@ -1148,28 +2933,48 @@ func (wfe *WebFrontEndImpl) Registration(ctx context.Context, logEvent *requestE
 		return
 	}
 
+	if len(body) == 0 {
+		logEvent.Extra["PostAsGet"] = true
+		response.Header().Add("Link", link(wfe.relativeEndpoint(request, newAuthzPath), "next"))
+		wfe.addTermsOfServiceLink(response)
+		wfe.addThumbprintHeader(response, currReg.Key)
+		if err := wfe.writeJsonResponse(response, logEvent, http.StatusOK, wfe.prepRegistrationForDisplay(*currReg)); err != nil {
+			// ServerInternal because we just fetched the reg, it should be OK
+			logEvent.AddError("unable to marshal registration: %s", err)
+			wfe.sendError(response, logEvent, probs.ServerInternal("Failed to marshal registration"), err)
+		}
+		return
+	}
+
 	var update core.Registration
-	err = json.Unmarshal(body, &update)
+	err := json.Unmarshal(body, &update)
 	if err != nil {
 		logEvent.AddError("unable to JSON parse registration: %s", err)
 		wfe.sendError(response, logEvent, probs.Malformed("Error unmarshaling registration"), err)
 		return
 	}
+	usedAgreementField := wfe.applyTermsOfServiceAgreed(body, &update)
 
 	// People *will* POST their full registrations to this endpoint, including
 	// the 'valid' status, to avoid always failing out when that happens only
 	// attempt to deactivate if the provided status is different from their current
 	// status.
 	//
-	// If a user tries to send both a deactivation request and an update to their
-	// contacts or subscriber agreement URL the deactivation will take place and
-	// return before an update would be performed.
+	// A deactivation request can't also change the contacts or subscriber
+	// agreement URL: applying only half of it would be surprising, so the whole
+	// request is rejected instead. Fields that match the current registration
+	// are not counted as changes.
 	if features.Enabled(features.AllowAccountDeactivation) && (update.Status != "" && update.Status != currReg.Status) {
 		if update.Status != core.StatusDeactivated {
 			wfe.sendError(response, logEvent, probs.Malformed("Invalid value provided for status field"), nil)
 			return
 		}
-		wfe.deactivateRegistration(ctx, currReg, response, request, logEvent)
+		if registrationFieldsChanged(*currReg, update) {
+			logEvent.AddError("registration %d sent deactivation along with other updates", currReg.ID)
+			wfe.sendError(response, logEvent, probs.Malformed("Deactivation requests must not update other registration fields"), nil)
+			return
+		}
+		wfe.deactivateRegistration(ctx, *currReg, response, request, logEvent)
 		return
 	}
 
@@ -1189,14 +2994,31 @@ func (wfe *WebFrontEndImpl) Registration(ctx context.Context, logEvent *requestE
 		return
 	}
 
+	if err := wfe.checkContactsLength(update.Contact); err != nil {
+		logEvent.AddError("invalid contacts: %s", err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid contacts"), err)
+		return
+	}
+
+	// Only the contact and agreement fields can be changed here; status
+	// changes were dealt with above. Since clients routinely POST back the
+	// whole registration, the immutable fields (id, initialIp, createdAt and
+	// key) are ignored rather than rejected, and never reach the RA.
+	//
 	// Registration objects contain a JWK object which are merged in UpdateRegistration
 	// if it is different from the existing registration key. Since this isn't how you
 	// update the key we just copy the existing one into the update object here. This
 	// ensures the key isn't changed and that we can cleanly serialize the update as
 	// JSON to send via RPC to the RA.
-	update.Key = currReg.Key
+	update = core.Registration{
+		Contact:   update.Contact,
+		Agreement: update.Agreement,
+		Key:       currReg.Key,
+	}
 
-	updatedReg, err := wfe.RA.UpdateRegistration(ctx, currReg, update)
+	start := wfe.clk.Now()
+	updatedReg, err := wfe.RA.UpdateRegistration(ctx, *currReg, update)
+	wfe.recordBackendLatency(logEvent, "RA.UpdateRegistration", start)
 	if err != nil {
 		logEvent.AddError("unable to update registration: %s", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Unable to update registration"), err)
@@ -1204,11 +3026,14 @@ func (wfe *WebFrontEndImpl) Registration(ctx context.Context, logEvent *requestE
 	}
 
 	response.Header().Add("Link", link(wfe.relativeEndpoint(request, newAuthzPath), "next"))
-	if len(wfe.SubscriberAgreementURL) > 0 {
-		response.Header().Add("Link", link(wfe.SubscriberAgreementURL, "terms-of-service"))
+	wfe.addTermsOfServiceLink(response)
+	if usedAgreementField {
+		wfe.addDeprecationWarning(response, deprecatedAgreement, "The agreement field is deprecated, use termsOfServiceAgreed")
 	}
 
-	err = wfe.writeJsonResponse(response, logEvent, http.StatusAccepted, updatedReg)
+	wfe.addThumbprintHeader(response, updatedReg.Key)
+
+	err = wfe.writeJsonResponse(response, logEvent, http.StatusAccepted, wfe.prepRegistrationForDisplay(updatedReg))
 	if err != nil {
 		// ServerInternal because we just generated the reg, it should be OK
 		logEvent.AddError("unable to marshal updated registration: %s", err)
@@ -1217,6 +3042,52 @@ func (wfe *WebFrontEndImpl) Registration(ctx context.Context, logEvent *requestE
 	}
 }
 
+// registrationID returns the ID of the registration named by the path of
+// request.
+func registrationID(logEvent *requestEvent, request *http.Request) (int64, *probs.ProblemDetails) {
+	idStr := request.URL.Path
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		logEvent.AddError("registration ID must be an integer, was %#v", idStr)
+		return 0, probs.Malformed("Registration ID must be an integer")
+	} else if id <= 0 {
+		msg := fmt.Sprintf("Registration ID must be a positive non-zero integer, was %d", id)
+		logEvent.AddError(msg)
+		return 0, probs.Malformed(msg)
+	}
+	return id, nil
+}
+
+// headRegistration answers an unauthenticated HEAD of a registration URL. It
+// only tells whether the registration exists, along with the Location and
+// Link headers a POST would get; nothing from the registration itself, not
+// even its status, is disclosed.
+func (wfe *WebFrontEndImpl) headRegistration(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	id, prob := registrationID(logEvent, request)
+	if prob != nil {
+		wfe.sendError(response, logEvent, prob, nil)
+		return
+	}
+
+	start := wfe.clk.Now()
+	_, err := wfe.SA.GetRegistration(ctx, id)
+	wfe.recordBackendLatency(logEvent, "SA.GetRegistration", start)
+	if _, ok := err.(core.NoSuchRegistrationError); ok {
+		logEvent.AddError("no such registration: %d", id)
+		wfe.sendError(response, logEvent, probs.NotFound("No such registration"), err)
+		return
+	} else if err != nil {
+		logEvent.AddError("unable to fetch registration: %s", err)
+		wfe.sendError(response, logEvent, probs.ServerInternal("Failed to get registration"), err)
+		return
+	}
+
+	response.Header().Set("Location", wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, id)))
+	response.Header().Add("Link", link(wfe.relativeEndpoint(request, newAuthzPath), "next"))
+	wfe.addTermsOfServiceLink(response)
+	response.WriteHeader(http.StatusOK)
+}
+
 func (wfe *WebFrontEndImpl) deactivateAuthorization(ctx context.Context, authz *core.Authorization, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) bool {
 	body, _, reg, prob := wfe.verifyPOST(ctx, logEvent, request, true, core.ResourceAuthz)
 	addRequesterHeader(response, logEvent.Requester)
@@ -1242,7 +3113,16 @@ func (wfe *WebFrontEndImpl) deactivateAuthorization(ctx context.Context, authz *
 		wfe.sendError(response, logEvent, probs.Malformed("Invalid status value"), err)
 		return false
 	}
+	// A valid authorization is what the subscriber needed the authorization
+	// for in the first place, so only pending ones can be abandoned.
+	if authz.Status != core.StatusPending {
+		logEvent.AddError("can't deactivate authorization with status %s", authz.Status)
+		wfe.sendError(response, logEvent, probs.Malformed(fmt.Sprintf("Only pending authorizations can be deactivated, authorization is %s", authz.Status)), nil)
+		return false
+	}
+	start := wfe.clk.Now()
 	err = wfe.RA.DeactivateAuthorization(ctx, *authz)
+	wfe.recordBackendLatency(logEvent, "RA.DeactivateAuthorization", start)
 	if err != nil {
 		logEvent.AddError("unable to deactivate authorization", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Error deactivating authorization"), err)
@@ -1258,9 +3138,16 @@ func (wfe *WebFrontEndImpl) deactivateAuthorization(ctx context.Context, authz *
 // Authorization is used by clients to submit an update to one of their
 // authorizations.
 func (wfe *WebFrontEndImpl) Authorization(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	postAsGet, requester, ok := wfe.authenticateRead(ctx, logEvent, response, request)
+	if !ok {
+		return
+	}
+
 	// Requests to this handler should have a path that leads to a known authz
 	id := request.URL.Path
+	start := wfe.clk.Now()
 	authz, err := wfe.SA.GetAuthorization(ctx, id)
+	wfe.recordBackendLatency(logEvent, "SA.GetAuthorization", start)
 	if err != nil {
 		logEvent.AddError("No such authorization at id %s", id)
 		// TODO(#1199): handle db errors
@@ -1281,7 +3168,11 @@ func (wfe *WebFrontEndImpl) Authorization(ctx context.Context, logEvent *request
 		return
 	}
 
-	if wfe.AllowAuthzDeactivation && request.Method == "POST" {
+	if postAsGet && wfe.rejectForeignResource(logEvent, response, requester, authz.RegistrationID, "authorization") {
+		return
+	}
+
+	if wfe.AllowAuthzDeactivation && request.Method == "POST" && !postAsGet {
 		// If the deactivation fails return early as errors and return codes
 		// have already been set. Otherwise continue so that the user gets
 		// sent the deactivated authorization.
@@ -1308,48 +3199,192 @@ var allHex = regexp.MustCompile("^[0-9a-f]+$")
 // Certificate is used by clients to request a copy of their current certificate, or to
 // request a reissuance of the certificate.
 func (wfe *WebFrontEndImpl) Certificate(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	postAsGet, requester, ok := wfe.authenticateRead(ctx, logEvent, response, request)
+	if !ok {
+		return
+	} else if request.Method == "POST" && !postAsGet {
+		// Certificates can't be updated, so the only POST they accept is a
+		// POST-as-GET.
+		logEvent.AddError("POST to certificate with a non-empty payload")
+		wfe.sendError(response, logEvent, probs.Malformed("POST-as-GET requests must have an empty payload"), nil)
+		return
+	}
+	contentType := wfe.negotiateContentType(request, certificateContentTypes...)
+	if contentType == "" {
+		wfe.sendError(response, logEvent, notAcceptable(certificateContentTypes...), nil)
+		return
+	}
 
-	serial := request.URL.Path
-	// Certificate paths consist of the CertBase path, plus exactly sixteen hex
-	// digits.
-	if !core.ValidSerial(serial) {
-		logEvent.AddError("certificate serial provided was not valid: %s", serial)
-		wfe.sendError(response, logEvent, probs.NotFound("Certificate not found"), nil)
+	urlSerial := request.URL.Path
+	// Certificate paths consist of the CertBase path, plus a serial in the
+	// configured encoding.
+	serial, err := wfe.CertSerialEncoding.Decode(urlSerial)
+	if err != nil {
+		// A serial that can't be decoded is a bad request, not a missing
+		// certificate.
+		logEvent.AddError("certificate serial provided was not valid: %s", urlSerial)
+		wfe.sendError(response, logEvent, probs.Malformed("Invalid certificate serial"), err)
 		return
 	}
 	logEvent.Extra["RequestedSerial"] = serial
 
+	start := wfe.clk.Now()
 	cert, err := wfe.SA.GetCertificate(ctx, serial)
+	wfe.recordBackendLatency(logEvent, "SA.GetCertificate", start)
 	// TODO(#991): handle db errors
 	if err != nil {
 		logEvent.AddError("unable to get certificate by serial id %#v: %s", serial, err)
-		if strings.HasPrefix(err.Error(), "gorp: multiple rows returned") {
+		switch err.(type) {
+		case core.MultipleMatchingCertificatesError:
 			wfe.sendError(response, logEvent, probs.Conflict("Multiple certificates with same short serial"), err)
-		} else {
+		default:
 			wfe.sendError(response, logEvent, probs.NotFound("Certificate not found"), err)
 		}
 		return
 	}
+	parsedCertificate, err := x509.ParseCertificate(cert.DER)
+	if err != nil {
+		logEvent.AddError("unable to parse certificate %#v: %s", serial, err)
+		wfe.sendError(response, logEvent, probs.ServerInternal("Unable to parse certificate"), err)
+		return
+	}
+	// Never serve a certificate other than the one asked for, even if the
+	// lookup matched it on a shorter or differently padded serial.
+	if !serialMatches(serial, parsedCertificate.SerialNumber) {
+		wfe.stats.Inc("Errors.CertificateSerialMismatch", 1)
+		logEvent.AddError("certificate found for serial %#v has serial %s", serial, core.SerialToString(parsedCertificate.SerialNumber))
+		wfe.sendError(response, logEvent, probs.NotFound("Certificate not found"), nil)
+		return
+	}
+	if postAsGet && wfe.rejectForeignResource(logEvent, response, requester, cert.RegistrationID, "certificate") {
+		return
+	}
 
-	// TODO Content negotiation
-	response.Header().Set("Content-Type", "application/pkix-cert")
-	response.Header().Add("Link", link(issuerPath, "up"))
+	// Certificates expiring within CertNoCacheExpirationWindow keep the
+	// no-cache header so that a cache never serves one past its expiry. The
+	// window is never shorter than the cache duration itself, or an entry
+	// cached just outside it could still outlive the certificate.
+	noCacheWindow := wfe.CertNoCacheExpirationWindow
+	if noCacheWindow < wfe.CertCacheDuration {
+		noCacheWindow = wfe.CertCacheDuration
+	}
+	// Responses to authenticated requests are never cached.
+	if wfe.CertCacheDuration > 0 && !postAsGet &&
+		parsedCertificate.NotAfter.After(wfe.clk.Now().Add(noCacheWindow)) {
+		addCacheHeader(response, wfe.CertCacheDuration)
+	}
+
+	response.Header().Set("Content-Type", contentType)
+	response.Header().Add("Vary", "Accept")
+	certURL := wfe.relativeEndpoint(request, certPath+wfe.CertSerialEncoding.Encode(parsedCertificate.SerialNumber))
+	wfe.addCertificateLinks(response, request, certURL, cert.RegistrationID)
+	if download := request.URL.Query().Get("download"); wfe.CertificateAttachment || (download != "" && download != "0") {
+		addCertificateDisposition(response, serial, contentType)
+	}
 	response.WriteHeader(http.StatusOK)
-	if _, err = response.Write(cert.DER); err != nil {
+	if _, err = response.Write(wfe.certificateBody(contentType, cert.DER)); err != nil {
 		logEvent.AddError(err.Error())
 		wfe.log.Warning(fmt.Sprintf("Could not write response: %s", err))
 	}
 	return
 }
 
+// serialMatches returns true if the hex serial requested is the same number
+// as serial. Leading zeros don't matter, since older certificates are stored
+// with shorter serials, but a requested serial that is merely a prefix of
+// serial doesn't match.
+func serialMatches(requested string, serial *big.Int) bool {
+	r, ok := new(big.Int).SetString(requested, 16)
+	return ok && r.Cmp(serial) == 0
+}
+
 // Terms is used by the client to obtain the current Terms of Service /
 // Subscriber Agreement to which the subscriber must agree.
 func (wfe *WebFrontEndImpl) Terms(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	if len(wfe.LocalizedSubscriberAgreementURLs) > 0 {
+		response.Header().Add("Vary", "Accept-Language")
+		if lang, termsURL := wfe.localizedTerms(request.Header.Get("Accept-Language")); termsURL != "" {
+			response.Header().Set("Content-Language", lang)
+			http.Redirect(response, request, termsURL, http.StatusFound)
+			return
+		}
+	}
 	http.Redirect(response, request, wfe.SubscriberAgreementURL, http.StatusFound)
 }
 
+// localizedTerms returns the language and URL of the translated subscriber
+// agreement best matching an Accept-Language header, or empty strings if
+// none of the accepted languages has one.
+func (wfe *WebFrontEndImpl) localizedTerms(acceptLanguage string) (string, string) {
+	return matchLanguage(acceptLanguage, wfe.LocalizedSubscriberAgreementURLs)
+}
+
+// languageRange is a language tag from an Accept-Language header and its
+// q-value.
+type languageRange struct {
+	tag string
+	q   float64
+}
+
+// byQuality sorts language ranges from the highest q-value to the lowest.
+type byQuality []languageRange
+
+func (r byQuality) Len() int           { return len(r) }
+func (r byQuality) Less(i, j int) bool { return r[i].q > r[j].q }
+func (r byQuality) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// matchLanguage returns the language tag and value of the entry in
+// translations best matching an Accept-Language header, or empty strings if
+// none of the accepted languages has one. A tag without a translation of its
+// own, e.g. "fr-CA", falls back to its primary language, "fr".
+func matchLanguage(acceptLanguage string, translations map[string]string) (string, string) {
+	var ranges []languageRange
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, languageRange{tag, q})
+		}
+	}
+	sort.Stable(byQuality(ranges))
+
+	for _, r := range ranges {
+		candidates := []string{r.tag}
+		if i := strings.Index(r.tag, "-"); i > 0 {
+			candidates = append(candidates, r.tag[:i])
+		}
+		for _, candidate := range candidates {
+			for lang, translation := range translations {
+				if strings.ToLower(lang) == candidate {
+					return lang, translation
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
 // Issuer obtains the issuer certificate used by this instance of Boulder.
 func (wfe *WebFrontEndImpl) Issuer(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	// CheckConfig should have caught this at startup, but an empty body
+	// must never be served as the issuer certificate.
+	if len(wfe.IssuerCert) == 0 {
+		wfe.sendError(response, logEvent, probs.ServerInternal("No issuer certificate available"), errors.New("IssuerCert is empty"))
+		return
+	}
+
 	// TODO Content negotiation
 	response.Header().Set("Content-Type", "application/pkix-cert")
 	response.WriteHeader(http.StatusOK)
@@ -1359,8 +3394,91 @@ func (wfe *WebFrontEndImpl) Issuer(ctx context.Context, logEvent *requestEvent,
 	}
 }
 
+// buildManifest is the JSON form of the /build response.
+type buildManifest struct {
+	BuildID   string `json:"buildID"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// negotiateContentType returns the one of offered, which are in order of our
+// preference, that the request's Accept header ranks highest, or "" if it
+// accepts none of them. Each offered type gets the q-value of the most
+// specific media range matching it: "type/subtype", then "type/*", then
+// "*/*". A request without an Accept header accepts anything.
+func negotiateContentType(request *http.Request, offered ...string) string {
+	header := request.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return offered[0]
+	}
+
+	ranges := make(map[string]float64)
+	for _, accept := range strings.Split(header, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges[mediaRange] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, mediaType := range offered {
+		q, ok := ranges[mediaType]
+		if !ok {
+			q, ok = ranges[strings.SplitN(mediaType, "/", 2)[0]+"/*"]
+		}
+		if !ok {
+			q = ranges["*/*"]
+		}
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+	return best
+}
+
+// negotiateContentType is like the package's negotiateContentType, but unless
+// StrictAccept is set, a request that accepts none of offered gets the first
+// of them.
+func (wfe *WebFrontEndImpl) negotiateContentType(request *http.Request, offered ...string) string {
+	contentType := negotiateContentType(request, offered...)
+	if contentType == "" && !wfe.StrictAccept {
+		return offered[0]
+	}
+	return contentType
+}
+
+// notAcceptable returns the problem for a request that accepts none of
+// offered.
+func notAcceptable(offered ...string) *probs.ProblemDetails {
+	return probs.NotAcceptable(fmt.Sprintf("Accept header must allow one of: %s", strings.Join(offered, ", ")))
+}
+
 // BuildID tells the requestor what build we're running.
 func (wfe *WebFrontEndImpl) BuildID(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	contentType := wfe.negotiateContentType(request, "text/plain", "application/json")
+	if contentType == "" {
+		wfe.sendError(response, logEvent, notAcceptable("text/plain", "application/json"), nil)
+		return
+	}
+	if contentType == "application/json" {
+		manifest := buildManifest{
+			BuildID:   core.GetBuildID(),
+			BuildTime: core.GetBuildTime(),
+			GoVersion: runtime.Version(),
+		}
+		if err := wfe.writeJsonResponse(response, logEvent, http.StatusOK, manifest); err != nil {
+			wfe.sendError(response, logEvent, probs.ServerInternal("Error marshaling build information"), err)
+		}
+		return
+	}
+
 	response.Header().Set("Content-Type", "text/plain")
 	response.WriteHeader(http.StatusOK)
 	detailsString := fmt.Sprintf("Boulder=(%s %s)", core.GetBuildID(), core.GetBuildTime())
@@ -1398,8 +3516,9 @@ func (wfe *WebFrontEndImpl) setCORSHeaders(response http.ResponseWriter, request
 	}
 
 	// Allow CORS if the current origin (or "*") is listed as an
-	// allowed origin in config. Otherwise, disallow by returning
-	// without setting any CORS headers.
+	// allowed origin in config, or matches one of the configured origin
+	// patterns. Otherwise, disallow by returning without setting any CORS
+	// headers.
 	allow := false
 	for _, ao := range wfe.AllowOrigins {
 		if ao == "*" {
@@ -1413,6 +3532,16 @@ func (wfe *WebFrontEndImpl) setCORSHeaders(response http.ResponseWriter, request
 			break
 		}
 	}
+	if !allow {
+		for _, re := range wfe.AllowOriginRegexps {
+			if re.MatchString(reqOrigin) {
+				response.Header().Set("Vary", "Origin")
+				response.Header().Set("Access-Control-Allow-Origin", reqOrigin)
+				allow = true
+				break
+			}
+		}
+	}
 	if !allow {
 		return
 	}
@@ -1434,21 +3563,28 @@ func (wfe *WebFrontEndImpl) KeyRollover(ctx context.Context, logEvent *requestEv
 		return
 	}
 
-	// Parse as JWS
-	newKey, parsedJWS, err := wfe.extractJWSKey(string(body))
+	// Parse as JWS. The inner JWS must embed the new key: there's no
+	// registration for a kid to name.
+	newKey, parsedJWS, err := wfe.extractJWSKey(string(body), false)
 	if err != nil {
 		logEvent.AddError(err.Error())
 		wfe.sendError(response, logEvent, probs.Malformed(err.Error()), err)
 		return
 	}
-	payload, err := parsedJWS.Verify(newKey)
-	if err != nil {
+	payload, prob, err := wfe.verifyJWSSignature(ctx, logEvent, parsedJWS, newKey)
+	if prob != nil {
+		wfe.sendError(response, logEvent, prob, nil)
+		return
+	} else if err != nil {
 		logEvent.AddError("verification of the inner JWS with the inner JWK failed: %v", err)
 		wfe.sendError(response, logEvent, probs.Malformed("JWS verification error"), err)
 		return
 	}
+	// OldKey binds the inner JWS to the account's current key, so that it
+	// can't be stripped out and replayed inside another account's request.
 	var rolloverRequest struct {
 		NewKey  jose.JsonWebKey
+		OldKey  *jose.JsonWebKey
 		Account string
 	}
 	err = json.Unmarshal(payload, &rolloverRequest)
@@ -1476,15 +3612,44 @@ func (wfe *WebFrontEndImpl) KeyRollover(ctx context.Context, logEvent *requestEv
 		return
 	}
 
+	if rolloverRequest.OldKey == nil {
+		logEvent.AddError("no old key in inner payload")
+		wfe.sendError(response, logEvent, probs.Malformed("Inner payload must contain the old JWK"), nil)
+		return
+	}
+	keysEqual, err = core.PublicKeysEqual(rolloverRequest.OldKey.Key, reg.Key.Key)
+	if err != nil || !keysEqual {
+		logEvent.AddError("old key in inner payload doesn't match the account key")
+		wfe.sendError(response, logEvent, probs.Malformed("Old JWK in inner payload doesn't match the account key"), nil)
+		return
+	}
+
+	// Keys can only belong to one account
+	start := wfe.clk.Now()
+	existingReg, err := wfe.SA.GetRegistrationByKey(ctx, newKey)
+	wfe.recordBackendLatency(logEvent, "SA.GetRegistrationByKey", start)
+	if err == nil {
+		logEvent.AddError("new key is already in use by registration %d", existingReg.ID)
+		response.Header().Set("Location", wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, existingReg.ID)))
+		wfe.sendError(response, logEvent, probs.Conflict("New key is already in use for a different account"), nil)
+		return
+	} else if _, ok := err.(core.NoSuchRegistrationError); !ok {
+		logEvent.AddError("unable to look up registration by new key: %s", err)
+		wfe.sendError(response, logEvent, probs.ServerInternal("Failed to check for existing registration"), err)
+		return
+	}
+
 	// Update registration key
-	updatedReg, err := wfe.RA.UpdateRegistration(ctx, reg, core.Registration{Key: newKey})
+	start = wfe.clk.Now()
+	updatedReg, err := wfe.RA.UpdateRegistration(ctx, *reg, core.Registration{Key: newKey})
+	wfe.recordBackendLatency(logEvent, "RA.UpdateRegistration", start)
 	if err != nil {
 		logEvent.AddError("unable to update registration: %s", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Unable to update registration"), err)
 		return
 	}
 
-	jsonReply, err := marshalIndent(updatedReg)
+	jsonReply, err := marshalIndent(wfe.prepRegistrationForDisplay(updatedReg))
 	if err != nil {
 		logEvent.AddError("unable to marshal updated registration: %s", err)
 		wfe.sendError(response, logEvent, probs.ServerInternal("Failed to marshal registration"), err)
@@ -1495,8 +3660,34 @@ func (wfe *WebFrontEndImpl) KeyRollover(ctx context.Context, logEvent *requestEv
 	response.Write(jsonReply)
 }
 
+// registrationFieldsChanged returns true if update would change the contacts
+// or agreement URL of reg.
+func registrationFieldsChanged(reg, update core.Registration) bool {
+	if update.Agreement != "" && update.Agreement != reg.Agreement {
+		return true
+	}
+	if update.Contact == nil {
+		return false
+	}
+	var current []string
+	if reg.Contact != nil {
+		current = *reg.Contact
+	}
+	if len(*update.Contact) != len(current) {
+		return true
+	}
+	for i, contact := range *update.Contact {
+		if contact != current[i] {
+			return true
+		}
+	}
+	return false
+}
+
 func (wfe *WebFrontEndImpl) deactivateRegistration(ctx context.Context, reg core.Registration, response http.ResponseWriter, request *http.Request, logEvent *requestEvent) {
+	start := wfe.clk.Now()
 	err := wfe.RA.DeactivateRegistration(ctx, reg)
+	wfe.recordBackendLatency(logEvent, "RA.DeactivateRegistration", start)
 	if err != nil {
 		logEvent.AddError("unable to deactivate registration", err)
 		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Error deactivating registration"), err)
@@ -1504,7 +3695,7 @@ func (wfe *WebFrontEndImpl) deactivateRegistration(ctx context.Context, reg core
 	}
 	reg.Status = core.StatusDeactivated
 
-	err = wfe.writeJsonResponse(response, logEvent, http.StatusOK, reg)
+	err = wfe.writeJsonResponse(response, logEvent, http.StatusOK, wfe.prepRegistrationForDisplay(reg))
 	if err != nil {
 		// ServerInternal because registration is from DB and should be fine
 		logEvent.AddError("unable to marshal updated registration: %s", err)
@@ -3,23 +3,35 @@ package wfe
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/jmhodges/clock"
 	"golang.org/x/net/context"
 	"gopkg.in/square/go-jose.v1"
@@ -29,6 +41,7 @@ import (
 	"github.com/letsencrypt/boulder/goodkey"
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/metrics/mock_metrics"
 	"github.com/letsencrypt/boulder/mocks"
 	"github.com/letsencrypt/boulder/nonce"
 	"github.com/letsencrypt/boulder/probs"
@@ -203,6 +216,7 @@ EeMZ9nWyIM6bktLrE11HnFOnKhAYsM5fZA==
 
 type MockRegistrationAuthority struct {
 	lastRevocationReason revocation.Reason
+	lastUpdate           core.Registration
 }
 
 func (ra *MockRegistrationAuthority) NewRegistration(ctx context.Context, reg core.Registration) (core.Registration, error) {
@@ -220,6 +234,7 @@ func (ra *MockRegistrationAuthority) NewCertificate(ctx context.Context, req cor
 }
 
 func (ra *MockRegistrationAuthority) UpdateRegistration(ctx context.Context, reg core.Registration, updated core.Registration) (core.Registration, error) {
+	ra.lastUpdate = updated
 	keysMatch, _ := core.PublicKeysEqual(reg.Key.Key, updated.Key.Key)
 	if !keysMatch {
 		reg.Key = updated.Key
@@ -252,12 +267,20 @@ func (ra *MockRegistrationAuthority) DeactivateRegistration(ctx context.Context,
 	return nil
 }
 
+func (ra *MockRegistrationAuthority) CheckPreAuthorization(ctx context.Context, regID int64, names []string) (bool, error) {
+	return false, nil
+}
+
 type mockPA struct{}
 
 func (pa *mockPA) ChallengesFor(identifier core.AcmeIdentifier) (challenges []core.Challenge, combinations [][]int) {
 	return
 }
 
+func (pa *mockPA) ChallengeTypeAllowed(t string, id core.AcmeIdentifier) bool {
+	return true
+}
+
 func (pa *mockPA) WillingToIssue(id core.AcmeIdentifier) error {
 	return nil
 }
@@ -279,6 +302,35 @@ func signRequest(t *testing.T, req string, nonceService *nonce.NonceService) str
 	return ret
 }
 
+// signRequestWithKey is like signRequest, but signs with the RSA private key
+// in keyPEM rather than test1's.
+func signRequestWithKey(t *testing.T, req string, keyPEM string, nonceService *nonce.NonceService) string {
+	accountKey, err := jose.LoadPrivateKey([]byte(keyPEM))
+	test.AssertNotError(t, err, "Failed to load key")
+
+	signer, err := jose.NewSigner("RS256", accountKey)
+	test.AssertNotError(t, err, "Failed to make signer")
+	signer.SetNonceSource(nonceService)
+	result, err := signer.Sign([]byte(req))
+	test.AssertNotError(t, err, "Failed to sign req")
+	return result.FullSerialize()
+}
+
+// signRequestWithKeyID is like signRequestWithKey, but puts keyID in the
+// JWS header as its "kid", and only embeds the JWK if embedJWK is set.
+func signRequestWithKeyID(t *testing.T, req string, keyPEM string, keyID string, embedJWK bool, nonceService *nonce.NonceService) string {
+	accountKey, err := jose.LoadPrivateKey([]byte(keyPEM))
+	test.AssertNotError(t, err, "Failed to load key")
+
+	signer, err := jose.NewSigner("RS256", &jose.JsonWebKey{Key: accountKey, KeyID: keyID})
+	test.AssertNotError(t, err, "Failed to make signer")
+	signer.SetEmbedJwk(embedJWK)
+	signer.SetNonceSource(nonceService)
+	result, err := signer.Sign([]byte(req))
+	test.AssertNotError(t, err, "Failed to sign req")
+	return result.FullSerialize()
+}
+
 var testKeyPolicy = goodkey.KeyPolicy{
 	AllowRSA:           true,
 	AllowECDSANISTP256: true,
@@ -291,7 +343,7 @@ func setupWFE(t *testing.T) (WebFrontEndImpl, clock.FakeClock) {
 	fc := clock.NewFake()
 	stats := metrics.NewNoopScope()
 
-	wfe, err := NewWebFrontEndImpl(stats, fc, testKeyPolicy, blog.NewMock())
+	wfe, err := NewWebFrontEndImpl(stats, fc, testKeyPolicy, 0, nil, blog.NewMock())
 	test.AssertNotError(t, err, "Unable to create WFE")
 
 	wfe.SubscriberAgreementURL = agreementURL
@@ -310,6 +362,7 @@ func makePostRequest(body string) *http.Request {
 		RemoteAddr: "1.1.1.1:7882",
 		Header: map[string][]string{
 			"Content-Length": {fmt.Sprintf("%d", len(body))},
+			"Content-Type":   {"application/jose+json"},
 		},
 		Body: makeBody(body),
 	}
@@ -355,6 +408,19 @@ func assertJSONEquals(t *testing.T, got, expected string) {
 	}
 }
 
+// assertProblemEquals checks that the problem document in rw matches
+// expected, once its instance has been checked to be the request's ID.
+func assertProblemEquals(t *testing.T, rw *httptest.ResponseRecorder, expected string) {
+	var problem map[string]interface{}
+	err := json.Unmarshal(rw.Body.Bytes(), &problem)
+	test.AssertNotError(t, err, "failed to parse problem document")
+	test.AssertEquals(t, problem["instance"], rw.Header().Get("Boulder-Request-ID"))
+	delete(problem, "instance")
+	got, err := json.Marshal(problem)
+	test.AssertNotError(t, err, "failed to marshal problem document")
+	assertJSONEquals(t, string(got), expected)
+}
+
 func TestHandleFunc(t *testing.T) {
 	wfe, _ := setupWFE(t)
 	var mux *http.ServeMux
@@ -394,8 +460,8 @@ func TestHandleFunc(t *testing.T) {
 		} else {
 			test.AssertEquals(t, rw.Code, http.StatusMethodNotAllowed)
 			test.AssertEquals(t, sortHeader(rw.Header().Get("Allow")), sortHeader(strings.Join(addHeadIfGet(c.allowed), ", ")))
-			assertJSONEquals(t,
-				rw.Body.String(),
+			assertProblemEquals(t,
+				rw,
 				`{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
 		}
 		nonce := rw.Header().Get("Replay-Nonce")
@@ -407,7 +473,7 @@ func TestHandleFunc(t *testing.T) {
 	// Disallowed method returns error JSON in body
 	runWrappedHandler(&http.Request{Method: "PUT"}, "GET", "POST")
 	test.AssertEquals(t, rw.Header().Get("Content-Type"), "application/problem+json")
-	assertJSONEquals(t, rw.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
+	assertProblemEquals(t, rw, `{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
 	test.AssertEquals(t, sortHeader(rw.Header().Get("Allow")), "GET, HEAD, POST")
 
 	// Disallowed method special case: response to HEAD has got no body
@@ -421,7 +487,7 @@ func TestHandleFunc(t *testing.T) {
 	test.AssertEquals(t, rw.Code, http.StatusMethodNotAllowed)
 	test.AssertEquals(t, rw.Header().Get("Content-Type"), "application/problem+json")
 	test.AssertEquals(t, rw.Header().Get("Allow"), "POST")
-	assertJSONEquals(t, rw.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
+	assertProblemEquals(t, rw, `{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
 
 	wfe.AllowOrigins = []string{"*"}
 	testOrigin := "https://example.com"
@@ -550,6 +616,101 @@ func TestHandleFunc(t *testing.T) {
 		// http://www.w3.org/TR/cors/ section 6.4:
 		test.AssertEquals(t, rw.Header().Get("Vary"), "Origin")
 	}
+
+	// Origins not listed in AllowOrigins are checked against the configured
+	// patterns, and a match echoes the specific origin.
+	wfe.AllowOrigins = []string{"http://example.org"}
+	wfe.AllowOriginRegexps = []*regexp.Regexp{regexp.MustCompile(`^https://[a-z0-9-]+\.example\.com$`)}
+	for origin, allowed := range map[string]bool{
+		"https://tenant1.example.com":      true,
+		"https://tenant1.example.com.evil": false,
+		"http://tenant1.example.com":       false,
+	} {
+		runWrappedHandler(&http.Request{
+			Method: "OPTIONS",
+			Header: map[string][]string{
+				"Origin":                        {origin},
+				"Access-Control-Request-Method": {"POST"},
+			},
+		}, "POST")
+		test.AssertEquals(t, rw.Code, http.StatusOK)
+		if allowed {
+			test.AssertEquals(t, rw.Header().Get("Access-Control-Allow-Origin"), origin)
+			test.AssertEquals(t, rw.Header().Get("Vary"), "Origin")
+		} else {
+			test.AssertEquals(t, rw.Header().Get("Access-Control-Allow-Origin"), "")
+		}
+	}
+}
+
+func TestGloballyDisabledMethods(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+
+	// TRACE is disabled by default, even where nothing else would reject it.
+	for _, path := range []string{directoryPath, newRegPath, certPath + "00", buildIDPath} {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("TRACE", path, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusMethodNotAllowed)
+		assertProblemEquals(t,
+			responseWriter,
+			`{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
+	}
+
+	// The index page refuses them too, while other paths under it are still
+	// not found.
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("TRACE", "/", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusMethodNotAllowed)
+	assertProblemEquals(t,
+		responseWriter,
+		`{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
+	test.AssertEquals(t, responseWriter.Header().Get("Allow"), "GET")
+
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("TRACE", "/not-a-path", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusNotFound)
+
+	// Methods a route otherwise accepts can be disabled too.
+	wfe.GloballyDisabledMethods = []string{"TRACE", "GET"}
+	for _, path := range []string{"/", directoryPath} {
+		responseWriter = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusMethodNotAllowed)
+	}
+
+	wfe.GloballyDisabledMethods = nil
+	for _, path := range []string{"/", directoryPath} {
+		responseWriter = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	}
+
+	// The Allow header only lists the methods that remain enabled, both on
+	// 405s and in response to OPTIONS.
+	wfe.GloballyDisabledMethods = []string{"POST"}
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", authzPath+"valid", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusMethodNotAllowed)
+	test.AssertEquals(t, sortHeader(responseWriter.Header().Get("Allow")), "GET, HEAD")
+
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", authzPath+"valid", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusMethodNotAllowed)
+	test.AssertEquals(t, sortHeader(responseWriter.Header().Get("Allow")), "GET, HEAD")
+
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("OPTIONS", authzPath+"valid", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, sortHeader(responseWriter.Header().Get("Allow")), "GET, HEAD")
 }
 
 func TestIndexPOST(t *testing.T) {
@@ -623,7 +784,7 @@ func TestDirectory(t *testing.T) {
 	})
 	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/json")
 	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
-	assertJSONEquals(t, responseWriter.Body.String(), `{"key-change":"http://localhost:4300/acme/key-change","new-authz":"http://localhost:4300/acme/new-authz","new-cert":"http://localhost:4300/acme/new-cert","new-reg":"http://localhost:4300/acme/new-reg","revoke-cert":"http://localhost:4300/acme/revoke-cert"}`)
+	assertJSONEquals(t, responseWriter.Body.String(), `{"key-change":"http://localhost:4300/acme/key-change","new-authz":"http://localhost:4300/acme/new-authz","new-cert":"http://localhost:4300/acme/new-cert","new-reg":"http://localhost:4300/acme/new-reg","revoke-cert":"http://localhost:4300/acme/revoke-cert","meta":{"termsOfService":"http://example.invalid/terms"}}`)
 
 	responseWriter.Body.Reset()
 	url, _ = url.Parse("/directory")
@@ -641,10 +802,145 @@ func TestDirectory(t *testing.T) {
 	assertJSONEquals(t, responseWriter.Body.String(), `{"new-authz":"http://localhost:4300/acme/new-authz","new-cert":"http://localhost:4300/acme/new-cert","new-reg":"http://localhost:4300/acme/new-reg","revoke-cert":"http://localhost:4300/acme/revoke-cert"}`)
 }
 
+func TestDirectoryKeyTypes(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.SubscriberAgreementURL = ""
+	wfe.keyPolicy = goodkey.KeyPolicy{AllowRSA: true, AllowECDSANISTP256: true}
+	mux := wfe.Handler()
+
+	getMeta := func() map[string]interface{} {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", directoryPath, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+		var directory map[string]interface{}
+		err := json.Unmarshal(responseWriter.Body.Bytes(), &directory)
+		test.AssertNotError(t, err, "Couldn't unmarshal directory")
+		meta, _ := directory["meta"].(map[string]interface{})
+		return meta
+	}
+
+	test.Assert(t, getMeta() == nil, "Directory had meta without DirectoryKeyTypes")
+
+	wfe.DirectoryKeyTypes = true
+	meta, err := json.Marshal(getMeta())
+	test.AssertNotError(t, err, "Couldn't marshal meta")
+	assertJSONEquals(t, string(meta), `{"keyTypes":[{"type":"RSA","minSize":2048,"maxSize":4096},{"type":"EC","curve":"P-256"}]}`)
+}
+
+func TestDirectoryMeta(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+
+	getMeta := func(userAgent string) string {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", directoryPath, nil)
+		req.Header.Set("User-Agent", userAgent)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+		var directory struct {
+			Meta json.RawMessage `json:"meta"`
+		}
+		err := json.Unmarshal(responseWriter.Body.Bytes(), &directory)
+		test.AssertNotError(t, err, "Couldn't unmarshal directory")
+		return string(directory.Meta)
+	}
+
+	assertJSONEquals(t, getMeta(""), `{"termsOfService":"http://example.invalid/terms"}`)
+
+	wfe.DirectoryWebsite = "https://example.invalid"
+	wfe.DirectoryCAAIdentities = []string{"example.invalid", "ca.example.invalid"}
+	assertJSONEquals(t, getMeta(""), `{
+		"termsOfService":"http://example.invalid/terms",
+		"website":"https://example.invalid",
+		"caaIdentities":["example.invalid","ca.example.invalid"]
+	}`)
+
+	// Clients that can't cope with it don't get one at all.
+	test.AssertEquals(t, getMeta("LetsEncryptPythonClient"), "")
+}
+
+func TestDirectoryRandomKey(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+
+	getDirectory := func(userAgent string) map[string]interface{} {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", directoryPath, nil)
+		req.Header.Set("User-Agent", userAgent)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+		var directory map[string]interface{}
+		err := json.Unmarshal(responseWriter.Body.Bytes(), &directory)
+		test.AssertNotError(t, err, "Couldn't unmarshal directory")
+		return directory
+	}
+	randomKeys := func(directory map[string]interface{}) []string {
+		var keys []string
+		for k, v := range directory {
+			if v == randomDirectoryKeyExplanation {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+
+	test.AssertEquals(t, len(randomKeys(getDirectory(""))), 0)
+
+	wfe.DirectoryRandomKey = true
+	first := randomKeys(getDirectory(""))
+	second := randomKeys(getDirectory(""))
+	test.AssertEquals(t, len(first), 1)
+	test.AssertEquals(t, len(second), 1)
+	test.AssertNotEquals(t, first[0], second[0])
+
+	test.AssertEquals(t, len(randomKeys(getDirectory("LetsEncryptPythonClient"))), 0)
+}
+
+func TestEnabledEndpoints(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+
+	getDirectory := func() map[string]interface{} {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", directoryPath, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+		var directory map[string]interface{}
+		err := json.Unmarshal(responseWriter.Body.Bytes(), &directory)
+		test.AssertNotError(t, err, "Couldn't unmarshal directory")
+		return directory
+	}
+	postNewCert := func() *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, makePostRequestWithPath(newCertPath,
+			signRequest(t, `{"resource":"new-cert"}`, wfe.nonceService)))
+		return responseWriter
+	}
+
+	wfe.EnabledEndpoints = map[string]bool{"new-cert": true}
+	_, present := getDirectory()["new-cert"]
+	test.Assert(t, present, "Enabled new-cert missing from directory")
+	test.AssertEquals(t, postNewCert().Code, http.StatusBadRequest)
+
+	wfe.EnabledEndpoints = map[string]bool{"new-cert": false}
+	directory := getDirectory()
+	_, present = directory["new-cert"]
+	test.Assert(t, !present, "Disabled new-cert listed in directory")
+	_, present = directory["new-reg"]
+	test.Assert(t, present, "Unlisted new-reg missing from directory")
+	responseWriter := postNewCert()
+	test.AssertEquals(t, responseWriter.Code, http.StatusNotFound)
+	assertProblemEquals(t, responseWriter,
+		`{"type":"urn:acme:error:malformed","detail":"Endpoint is disabled","status":404}`)
+}
+
 func TestRelativeDirectory(t *testing.T) {
 	_ = features.Set(map[string]bool{"AllowKeyRollover": true})
 	defer features.Reset()
 	wfe, _ := setupWFE(t)
+	// The meta object is covered by TestDirectoryMeta.
+	wfe.SubscriberAgreementURL = ""
 	mux := wfe.Handler()
 
 	dirTests := []struct {
@@ -733,8 +1029,8 @@ func TestIssueCertificate(t *testing.T) {
 		Method: "GET",
 		URL:    mustParseURL(newCertPath),
 	})
-	assertJSONEquals(t,
-		responseWriter.Body.String(),
+	assertProblemEquals(t,
+		responseWriter,
 		`{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
 
 	// POST, but no body.
@@ -779,7 +1075,7 @@ func TestIssueCertificate(t *testing.T) {
 		makePostRequest(signRequest(t, `{"resource":"new-cert"}`, wfe.nonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
-		`{"type":"urn:acme:error:malformed","detail":"Error parsing certificate request. Extensions in the CSR marked critical can cause this error: https://github.com/letsencrypt/boulder/issues/565","status":400}`)
+		`{"type":"urn:acme:error:malformed","detail":"missing CSR","status":400}`)
 
 	// Valid, signed JWS body, payload has an invalid signature on CSR and no authorizations:
 	// alias b64url="base64 -w0 | sed -e 's,+,-,g' -e 's,/,_,g'"
@@ -807,7 +1103,8 @@ func TestIssueCertificate(t *testing.T) {
 		}`, wfe.nonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
-		`{"type":"urn:acme:error:unauthorized","detail":"Error creating new cert :: Authorizations for these names not found or expired: meep.com","status":403}`)
+		`{"type":"urn:acme:error:unauthorized","detail":"Error creating new cert :: Authorizations for these names not found or expired: meep.com","status":403,`+
+			`"subproblems":[{"type":"urn:acme:error:unauthorized","detail":"No valid authorization for this name","identifier":{"type":"dns","value":"meep.com"}}]}`)
 	assertCsrLogged(t, mockLog)
 
 	mockLog.Clear()
@@ -851,6 +1148,361 @@ func TestIssueCertificate(t *testing.T) {
 		`{"type":"urn:acme:error:malformed","detail":"CSR generated using a pre-1.0.2 OpenSSL with a client that doesn't properly specify the CSR version. See https://community.letsencrypt.org/t/openssl-bug-information/19591","status":400}`)
 }
 
+// mockRANewCertificate is a mock RA whose NewCertificate always issues
+// test/178.crt, and remembers the last request it was given.
+type mockRANewCertificate struct {
+	MockRegistrationAuthority
+	lastRequest *core.CertificateRequest
+}
+
+func (ra *mockRANewCertificate) NewCertificate(ctx context.Context, req core.CertificateRequest, regID int64) (core.Certificate, error) {
+	ra.lastRequest = &req
+	certPemBytes, err := ioutil.ReadFile("test/178.crt")
+	if err != nil {
+		return core.Certificate{}, err
+	}
+	certBlock, _ := pem.Decode(certPemBytes)
+	return core.Certificate{RegistrationID: regID, DER: certBlock.Bytes}, nil
+}
+
+// makeNewCertRequest returns a new-cert payload with a CSR for subject and
+// names, signed by a fresh ECDSA key.
+func makeNewCertRequest(t *testing.T, subject pkix.Name, names ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate CSR key")
+	return makeNewCertRequestWithKey(t, key, subject, names...)
+}
+
+// makeNewCertRequestWithKey is makeNewCertRequest with a CSR signed by key.
+func makeNewCertRequestWithKey(t *testing.T, key interface{}, subject pkix.Name, names ...string) string {
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  subject,
+		DNSNames: names,
+	}, key)
+	test.AssertNotError(t, err, "Failed to create CSR")
+	return fmt.Sprintf(`{"resource":"new-cert","csr":"%s"}`, base64.RawURLEncoding.EncodeToString(csrDER))
+}
+
+func TestRejectSubjectAttributes(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	cnOnly := makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com")
+	withOrg := makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com", Organization: []string{"Example"}}, "not-an-example.com")
+
+	// Subject attributes are ignored by default
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, withOrg, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	wfe.RejectSubjectAttributes = true
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, cnOnly, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, withOrg, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid certificate request :: subject attribute organization is not allowed","status":400}`)
+}
+
+func TestRejectDisallowedEKU(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	requestingEKUs := func(usages ...asn1.ObjectIdentifier) string {
+		ekuExt, err := asn1.Marshal(usages)
+		test.AssertNotError(t, err, "Failed to marshal EKUs")
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		test.AssertNotError(t, err, "Failed to generate CSR key")
+		csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:         pkix.Name{CommonName: "not-an-example.com"},
+			DNSNames:        []string{"not-an-example.com"},
+			ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 37}, Value: ekuExt}},
+		}, key)
+		test.AssertNotError(t, err, "Failed to create CSR")
+		return fmt.Sprintf(`{"resource":"new-cert","csr":"%s"}`, base64.RawURLEncoding.EncodeToString(csrDER))
+	}
+	serverAuth := requestingEKUs(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1})
+	codeSigning := requestingEKUs(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3})
+	unknown := requestingEKUs(asn1.ObjectIdentifier{1, 2, 3, 4})
+
+	// Requested EKUs are ignored by default
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, codeSigning, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	wfe.RejectDisallowedEKU = true
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, serverAuth, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, codeSigning, wfe.nonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid certificate request :: extended key usage codeSigning is not allowed","status":400}`)
+
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, unknown, wfe.nonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid certificate request :: extended key usage 1.2.3.4 is not allowed","status":400}`)
+}
+
+func TestRawCSRCertificateRequest(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	ra := &mockRANewCertificate{}
+	wfe.RA = ra
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate CSR key")
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "not-an-example.com"},
+		DNSNames: []string{"not-an-example.com"},
+	}, key)
+	test.AssertNotError(t, err, "Failed to create CSR")
+	newCert := func(payload, contentType string) *httptest.ResponseRecorder {
+		request := makePostRequest(signRequest(t, payload, wfe.nonceService))
+		request.Header.Set("Content-Type", contentType)
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, request)
+		return responseWriter
+	}
+
+	// The JSON request
+	jsonPayload := fmt.Sprintf(`{"resource":"new-cert","csr":"%s"}`, base64.RawURLEncoding.EncodeToString(csrDER))
+	responseWriter := newCert(jsonPayload, "application/jose+json")
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	jsonRequest := ra.lastRequest
+	jsonBody := responseWriter.Body.String()
+
+	// A raw CSR isn't accepted by default
+	responseWriter = newCert(string(csrDER), "application/pkcs10")
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+
+	// Once it is, it's issued for just as the JSON request was
+	wfe.CertificateRequestContentTypes = []string{"application/pkcs10"}
+	ra.lastRequest = nil
+	responseWriter = newCert(string(csrDER), "application/pkcs10")
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, responseWriter.Body.String(), jsonBody)
+	test.Assert(t, ra.lastRequest != nil, "RA wasn't asked to issue for the raw CSR")
+	test.Assert(t, bytes.Equal(ra.lastRequest.Bytes, jsonRequest.Bytes), "Raw CSR differs from the JSON request's")
+	test.AssertDeepEquals(t, ra.lastRequest.CSR.DNSNames, jsonRequest.CSR.DNSNames)
+
+	// The JSON request still works alongside it
+	responseWriter = newCert(jsonPayload, "application/jose+json")
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+}
+
+func TestNormalizeCSRNames(t *testing.T) {
+	testCases := []struct {
+		names           []string
+		allowDuplicates bool
+		expected        []string
+		err             string
+	}{
+		{[]string{"Example.com", "WWW.example.COM"}, false, []string{"example.com", "www.example.com"}, ""},
+		{[]string{"foo.com.", "bar.com"}, false, []string{"foo.com", "bar.com"}, ""},
+		{[]string{"Example.com", "example.com"}, false, nil, "CSR contains duplicate name example.com"},
+		{[]string{"foo.com.", "foo.com"}, false, nil, "CSR contains duplicate name foo.com"},
+		{[]string{"a.com", "b.com", "a.com"}, false, nil, "CSR contains duplicate name a.com"},
+		{[]string{"Example.com", "example.com.", "b.com", "example.com"}, true, []string{"example.com", "b.com"}, ""},
+	}
+	for _, tc := range testCases {
+		names, err := normalizeCSRNames(tc.names, tc.allowDuplicates)
+		if tc.err != "" {
+			test.AssertError(t, err, fmt.Sprintf("Accepted %v", tc.names))
+			_, ok := err.(core.MalformedRequestError)
+			test.Assert(t, ok, "Wrong error type for duplicate names")
+			test.AssertEquals(t, err.Error(), tc.err)
+			continue
+		}
+		test.AssertNotError(t, err, fmt.Sprintf("Refused %v", tc.names))
+		test.AssertDeepEquals(t, names, tc.expected)
+	}
+}
+
+func TestNewCertificateDuplicateNames(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	wfe.MaxNames = 1
+	subject := pkix.Name{CommonName: "not-an-example.com"}
+
+	// Names differing only in case or a trailing dot are the same name
+	for _, duplicates := range [][]string{
+		{"not-an-example.com", "NOT-an-example.com"},
+		{"not-an-example.com", "not-an-example.com."},
+	} {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+			makeNewCertRequest(t, subject, duplicates...), wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+		assertJSONEquals(t, responseWriter.Body.String(),
+			`{"type":"urn:acme:error:malformed","detail":"Invalid certificate request :: CSR contains duplicate name not-an-example.com","status":400}`)
+	}
+
+	// unless duplicates are allowed, when they count once towards MaxNames
+	// and are logged once
+	wfe.AllowDuplicateCSRNames = true
+	logEvent := newRequestEvent()
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, logEvent, responseWriter, makePostRequest(signRequest(t,
+		makeNewCertRequest(t, subject, "not-an-example.com", "NOT-an-example.com."), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertDeepEquals(t, logEvent.Extra["CSRDNSNames"], []string{"not-an-example.com"})
+}
+
+func TestMaxNames(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	wfe.MaxNames = 3
+	subject := pkix.Name{CommonName: "a.not-an-example.com"}
+
+	// Right at the limit
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+		makeNewCertRequest(t, subject, "a.not-an-example.com", "b.not-an-example.com", "c.not-an-example.com"), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	// One over
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+		makeNewCertRequest(t, subject, "a.not-an-example.com", "b.not-an-example.com", "c.not-an-example.com", "d.not-an-example.com"), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid certificate request :: CSR has 4 names, more than the maximum of 3","status":400}`)
+}
+
+func TestCSRKeyPolicy(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	subject := pkix.Name{CommonName: "not-an-example.com"}
+	newCert := func(key interface{}) *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+			makeNewCertRequestWithKey(t, key, subject, "not-an-example.com"), wfe.nonceService)))
+		return responseWriter
+	}
+
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	test.AssertNotError(t, err, "Failed to generate RSA key")
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Failed to generate RSA key")
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate ECDSA key")
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate ECDSA key")
+
+	wfe.MinRSAKeyBits = 2048
+	wfe.AllowedECCurves = []string{"P-256", "P-384"}
+
+	responseWriter := newCert(rsa1024)
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid key in certificate request :: RSA key is 1024 bits, less than the minimum of 2048","status":400}`)
+
+	responseWriter = newCert(rsa2048)
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	responseWriter = newCert(p224)
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid key in certificate request :: ECDSA curve P-224 is not allowed","status":400}`)
+
+	responseWriter = newCert(p256)
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	// Curves missing from the list are refused even if the key policy
+	// accepts them
+	wfe.AllowedECCurves = []string{"P-384"}
+	responseWriter = newCert(p256)
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+}
+
+// mockRAPreAuthorized is a mock RA that considers registration 1
+// pre-authorized for not-an-example.com.
+type mockRAPreAuthorized struct {
+	mockRANewCertificate
+}
+
+func (ra *mockRAPreAuthorized) CheckPreAuthorization(ctx context.Context, regID int64, names []string) (bool, error) {
+	return regID == 1 && len(names) == 1 && names[0] == "not-an-example.com", nil
+}
+
+func TestLogPreAuthorizedIssuance(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRAPreAuthorized{}
+	mockLog := wfe.log.(*blog.Mock)
+	preAuthorized := makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com")
+	notPreAuthorized := makeNewCertRequest(t, pkix.Name{CommonName: "www.not-an-example.com"}, "www.not-an-example.com")
+	newCert := func(payload string) {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, payload, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	}
+
+	// Without the flag the RA isn't asked
+	newCert(preAuthorized)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Pre-authorized certificate request")), 0)
+
+	wfe.LogPreAuthorizedIssuance = true
+	mockLog.Clear()
+	newCert(notPreAuthorized)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Pre-authorized certificate request")), 0)
+
+	mockLog.Clear()
+	newCert(preAuthorized)
+	matches := mockLog.GetAllMatching("Pre-authorized certificate request")
+	test.AssertEquals(t, len(matches), 1)
+	test.AssertContains(t, matches[0], "[AUDIT] Pre-authorized certificate request from registration 1 for [not-an-example.com]")
+}
+
+func TestCSRLog(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	mockLog := wfe.log.(*blog.Mock)
+	csrLog := blog.NewMock()
+	wfe.CSRLog = csrLog
+
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+		makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com"), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	assertCsrLogged(t, csrLog)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Certificate request JSON=")), 0)
+}
+
+func TestCertificateProfiles(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	ra := &mockRANewCertificate{}
+	wfe.RA = ra
+	wfe.CertificateProfiles = []string{"default", "short-lived"}
+	withProfile := func(profile string) string {
+		payload := makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com")
+		if profile == "" {
+			return payload
+		}
+		return strings.Replace(payload, `"resource"`, `"profile":"`+profile+`","resource"`, 1)
+	}
+
+	// Without a profile, the RA gets none and the default is used
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, withProfile(""), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, ra.lastRequest.Profile, "")
+
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, withProfile("short-lived"), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, ra.lastRequest.Profile, "short-lived")
+
+	ra.lastRequest = nil
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, withProfile("long-lived"), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid certificate request :: unknown certificate profile \"long-lived\", valid profiles are: default, short-lived","status":400}`)
+	test.Assert(t, ra.lastRequest == nil, "Request with unknown profile reached the RA")
+}
+
 func TestGetChallenge(t *testing.T) {
 	wfe, _ := setupWFE(t)
 
@@ -929,28 +1581,328 @@ func TestChallenge(t *testing.T) {
 		`{"type":"urn:acme:error:malformed","detail":"Expired authorization","status":404}`)
 }
 
-func TestBadNonce(t *testing.T) {
+func TestChallengeV2(t *testing.T) {
 	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
 
-	key, err := jose.LoadPrivateKey([]byte(test2KeyPrivatePEM))
-	test.AssertNotError(t, err, "Failed to load key")
-	rsaKey, ok := key.(*rsa.PrivateKey)
-	test.Assert(t, ok, "Couldn't load RSA key")
-	signer, err := jose.NewSigner("RS256", rsaKey)
-	test.AssertNotError(t, err, "Failed to make signer")
-
+	// Fetching by the challenge ID alone gives the same challenge.
 	responseWriter := httptest.NewRecorder()
-	result, err := signer.Sign([]byte(`{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"` + agreementURL + `"}`))
-	test.AssertNotError(t, err, "Failed to sign body")
-	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(result.FullSerialize()))
-	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:badNonce","detail":"JWS has no anti-replay nonce","status":400}`)
-}
+	req, _ := http.NewRequest("GET", "/acme/chall-v2/23", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+	test.AssertEquals(t, responseWriter.Header().Get("Link"), `<http://localhost/acme/authz/valid>;rel="up"`)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"dns","uri":"http://localhost/acme/challenge/valid/23"}`)
 
-func TestNewECDSARegistration(t *testing.T) {
-	wfe, _ := setupWFE(t)
+	// As does updating it.
+	responseWriter = httptest.NewRecorder()
+	wfe.ChallengeV2(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("23", signRequest(t, `{"resource":"challenge"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "http://localhost/acme/challenge/valid/23")
 
-	// E1 always exists; E2 never exists
+	// Only the authorization's owner may update it.
+	responseWriter = httptest.NewRecorder()
+	wfe.ChallengeV2(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("23", signRequestWithKey(t, `{"resource":"challenge"}`, test3KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+
+	// Challenges of expired authorizations are inaccessible.
+	responseWriter = httptest.NewRecorder()
+	wfe.ChallengeV2(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("32", signRequest(t, `{"resource":"challenge"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusNotFound)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Expired authorization","status":404}`)
+
+	for _, id := range []string{"99", "valid"} {
+		responseWriter = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/acme/chall-v2/"+id, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusNotFound)
+	}
+}
+
+// mockSATokenAuthz is a mock SA whose authorizations' challenges carry a
+// token.
+type mockSATokenAuthz struct {
+	core.StorageGetter
+}
+
+func (sa *mockSATokenAuthz) GetAuthorization(ctx context.Context, id string) (core.Authorization, error) {
+	authz, err := sa.StorageGetter.GetAuthorization(ctx, id)
+	for i := range authz.Challenges {
+		authz.Challenges[i].Token = "tok"
+	}
+	return authz, err
+}
+
+func TestChallengeToken(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.SA = &mockSATokenAuthz{wfe.SA}
+
+	testCases := []struct {
+		payload  string
+		code     int
+		expected string
+	}{
+		{`{"resource":"challenge"}`, http.StatusAccepted, ""},
+		{`{"resource":"challenge","token":"tok"}`, http.StatusAccepted, ""},
+		{`{"resource":"challenge","keyAuthorization":"tok.thumbprint"}`, http.StatusAccepted, ""},
+		{`{"resource":"challenge","token":"kot"}`, http.StatusBadRequest,
+			`{"type":"urn:acme:error:malformed","detail":"Unable to update challenge :: Challenge response token \"kot\" does not match the challenge token \"tok\"","status":400}`},
+		{`{"resource":"challenge","keyAuthorization":"kot.thumbprint"}`, http.StatusBadRequest,
+			`{"type":"urn:acme:error:malformed","detail":"Unable to update challenge :: Key authorization token \"kot\" does not match the challenge token \"tok\"","status":400}`},
+	}
+	for _, tc := range testCases {
+		responseWriter := httptest.NewRecorder()
+		wfe.Challenge(ctx, newRequestEvent(), responseWriter,
+			makePostRequestWithPath("valid/23", signRequest(t, tc.payload, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, tc.code)
+		if tc.expected != "" {
+			assertJSONEquals(t, responseWriter.Body.String(), tc.expected)
+		}
+	}
+}
+
+func TestChallengeOnValidAuthz(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RejectChallengesOnValidAuthz = true
+
+	responseWriter := httptest.NewRecorder()
+	wfe.Challenge(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("valid/23",
+			signRequest(t, `{"resource":"challenge"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Authorization is already valid, no further challenge responses are needed","status":400}`)
+
+	// Responses for pending authorizations are still passed to the RA.
+	wfe.SA = &mockSAPendingAuthz{wfe.SA}
+	responseWriter = httptest.NewRecorder()
+	wfe.Challenge(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("valid/23",
+			signRequest(t, `{"resource":"challenge"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"dns","uri":"http://localhost/acme/challenge/valid/23"}`)
+}
+
+type mockRACountUpdateAuthorization struct {
+	MockRegistrationAuthority
+	calls int
+}
+
+func (ra *mockRACountUpdateAuthorization) UpdateAuthorization(ctx context.Context, authz core.Authorization, index int, challenge core.Challenge) (core.Authorization, error) {
+	ra.calls++
+	return ra.MockRegistrationAuthority.UpdateAuthorization(ctx, authz, index, challenge)
+}
+
+func TestChallengeIdempotencyKey(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	ra := &mockRACountUpdateAuthorization{}
+	wfe.RA = ra
+	wfe.ChallengeIdempotencyWindow = time.Minute
+
+	postChallenge := func(key string) *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		request := makePostRequestWithPath("valid/23",
+			signRequest(t, `{"resource":"challenge"}`, wfe.nonceService))
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		wfe.Challenge(ctx, newRequestEvent(), responseWriter, request)
+		test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+		assertJSONEquals(t, responseWriter.Body.String(),
+			`{"type":"dns","uri":"http://localhost/acme/challenge/valid/23"}`)
+		return responseWriter
+	}
+
+	// A retry with the same key gets the first response
+	postChallenge("abc")
+	test.AssertEquals(t, ra.calls, 1)
+	responseWriter := postChallenge("abc")
+	test.AssertEquals(t, ra.calls, 1)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "http://localhost/acme/challenge/valid/23")
+
+	// A new key, or no key, goes to the RA
+	postChallenge("def")
+	test.AssertEquals(t, ra.calls, 2)
+	postChallenge("")
+	postChallenge("")
+	test.AssertEquals(t, ra.calls, 4)
+
+	// Keys are forgotten once the window has passed
+	fc.Add(time.Minute)
+	postChallenge("abc")
+	test.AssertEquals(t, ra.calls, 5)
+
+	// Without a window, keys are ignored
+	wfe.ChallengeIdempotencyWindow = 0
+	postChallenge("def")
+	test.AssertEquals(t, ra.calls, 6)
+}
+
+// mockRASlowUpdateAuthorization is a mock RA whose UpdateAuthorization waits
+// for release, counting its calls.
+type mockRASlowUpdateAuthorization struct {
+	MockRegistrationAuthority
+	calls   int32
+	release chan struct{}
+}
+
+func (ra *mockRASlowUpdateAuthorization) UpdateAuthorization(ctx context.Context, authz core.Authorization, index int, challenge core.Challenge) (core.Authorization, error) {
+	atomic.AddInt32(&ra.calls, 1)
+	<-ra.release
+	return ra.MockRegistrationAuthority.UpdateAuthorization(ctx, authz, index, challenge)
+}
+
+func TestChallengeIdempotencyKeyConcurrent(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	ra := &mockRASlowUpdateAuthorization{release: make(chan struct{})}
+	wfe.RA = ra
+	wfe.ChallengeIdempotencyWindow = time.Minute
+
+	// Retries sent while the first request is still with the RA wait for
+	// its response rather than going to the RA too.
+	codes := make(chan int, 3)
+	for i := 0; i < cap(codes); i++ {
+		request := makePostRequestWithPath("valid/23",
+			signRequest(t, `{"resource":"challenge"}`, wfe.nonceService))
+		request.Header.Set("Idempotency-Key", "abc")
+		go func() {
+			responseWriter := httptest.NewRecorder()
+			wfe.Challenge(ctx, newRequestEvent(), responseWriter, request)
+			codes <- responseWriter.Code
+		}()
+	}
+	for atomic.LoadInt32(&ra.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(ra.release)
+	for i := 0; i < cap(codes); i++ {
+		test.AssertEquals(t, <-codes, http.StatusAccepted)
+	}
+	test.AssertEquals(t, atomic.LoadInt32(&ra.calls), int32(1))
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	fc := clock.NewFake()
+	cache := newIdempotencyCache(fc)
+	cache.maxEntries = 2
+	challenge := core.Challenge{Type: "dns"}
+
+	// A failed request leaves nothing behind
+	_, cached, finish := cache.claim("a")
+	test.Assert(t, !cached, "Claim of a new key was cached")
+	finish(nil, 0)
+	test.AssertEquals(t, len(cache.responses), 0)
+
+	for _, key := range []string{"a", "b"} {
+		_, _, finish = cache.claim(key)
+		finish(&challenge, time.Minute)
+	}
+	got, cached, _ := cache.claim("a")
+	test.Assert(t, cached, "Stored key wasn't cached")
+	test.AssertDeepEquals(t, got, challenge)
+
+	// Once full, new keys aren't remembered
+	_, cached, finish = cache.claim("c")
+	finish(&challenge, time.Minute)
+	_, cached, finish = cache.claim("c")
+	test.Assert(t, !cached, "Key was remembered in a full cache")
+	finish(&challenge, time.Minute)
+	test.AssertEquals(t, len(cache.responses), 2)
+
+	// until the expired ones are swept
+	fc.Add(time.Minute)
+	_, cached, finish = cache.claim("c")
+	test.Assert(t, !cached, "New key was cached")
+	finish(&challenge, time.Minute)
+	test.AssertEquals(t, len(cache.responses), 1)
+}
+
+func TestChallengeDeactivatedRegistration(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	// Whether or not verifyPOST checks the registration's status, the
+	// deactivated registration can't respond to challenges
+	for _, enabled := range []bool{false, true} {
+		_ = features.Set(map[string]bool{"AllowAccountDeactivation": enabled})
+		responseWriter := httptest.NewRecorder()
+		wfe.Challenge(ctx, newRequestEvent(), responseWriter,
+			makePostRequestWithPath("valid/23",
+				signRequestWithKey(t, `{"resource":"challenge"}`, test3KeyPrivatePEM, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+		assertJSONEquals(t, responseWriter.Body.String(),
+			`{"type":"urn:acme:error:unauthorized","detail":"Registration is not valid, has status 'deactivated'","status":403}`)
+	}
+	// features.Reset doesn't undo Set once it has been called, so turn the
+	// feature back off for the tests that follow.
+	_ = features.Set(map[string]bool{"AllowAccountDeactivation": false})
+}
+
+func TestBadNonce(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	key, err := jose.LoadPrivateKey([]byte(test2KeyPrivatePEM))
+	test.AssertNotError(t, err, "Failed to load key")
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	test.Assert(t, ok, "Couldn't load RSA key")
+	signer, err := jose.NewSigner("RS256", rsaKey)
+	test.AssertNotError(t, err, "Failed to make signer")
+
+	responseWriter := httptest.NewRecorder()
+	result, err := signer.Sign([]byte(`{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"` + agreementURL + `"}`))
+	test.AssertNotError(t, err, "Failed to sign body")
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(result.FullSerialize()))
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:badNonce","detail":"JWS has no anti-replay nonce","status":400}`)
+}
+
+func TestSkipNonceOnTerminalErrors(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.SkipNonceOnTerminalErrors = true
+	mux := wfe.Handler()
+
+	key, err := jose.LoadPrivateKey([]byte(test2KeyPrivatePEM))
+	test.AssertNotError(t, err, "Failed to load key")
+	signer, err := jose.NewSigner("RS256", key)
+	test.AssertNotError(t, err, "Failed to make signer")
+	noNonce, err := signer.Sign([]byte(`{"resource":"new-reg"}`))
+	test.AssertNotError(t, err, "Failed to sign body")
+
+	// A malformed request gets no nonce
+	responseWriter := httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, makePostRequestWithPath(newRegPath, "hi"))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	test.AssertEquals(t, responseWriter.Header().Get("Replay-Nonce"), "")
+
+	// A bad nonce is worth retrying with a good one
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, makePostRequestWithPath(newRegPath, noNonce.FullSerialize()))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	test.AssertNotEquals(t, responseWriter.Header().Get("Replay-Nonce"), "")
+
+	// As are successful responses
+	responseWriter = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", directoryPath, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertNotEquals(t, responseWriter.Header().Get("Replay-Nonce"), "")
+
+	// Without the option, every response gets a nonce
+	wfe.SkipNonceOnTerminalErrors = false
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, makePostRequestWithPath(newRegPath, "hi"))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	test.AssertNotEquals(t, responseWriter.Header().Get("Replay-Nonce"), "")
+}
+
+func TestNewECDSARegistration(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	// E1 always exists; E2 never exists
 	key, err := jose.LoadPrivateKey([]byte(testE2KeyPrivatePEM))
 	test.AssertNotError(t, err, "Failed to load key")
 	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
@@ -1118,7 +2070,7 @@ func TestNewRegistration(t *testing.T) {
 	for _, rt := range regErrTests {
 		responseWriter := httptest.NewRecorder()
 		mux.ServeHTTP(responseWriter, rt.r)
-		assertJSONEquals(t, responseWriter.Body.String(), rt.respBody)
+		assertProblemEquals(t, responseWriter, rt.respBody)
 	}
 
 	responseWriter := httptest.NewRecorder()
@@ -1133,6 +2085,11 @@ func TestNewRegistration(t *testing.T) {
 	test.AssertEquals(t, (*reg.Contact)[0], "mailto:person@mail.com")
 	test.AssertEquals(t, reg.Agreement, "http://example.invalid/terms")
 	test.AssertEquals(t, reg.InitialIP.String(), "1.1.1.1")
+	test.AssertEquals(t, reg.Status, core.StatusValid)
+	var rawReg map[string]interface{}
+	err = json.Unmarshal(responseWriter.Body.Bytes(), &rawReg)
+	test.AssertNotError(t, err, "Couldn't unmarshal returned registration object")
+	test.AssertEquals(t, rawReg["status"], "valid")
 
 	test.AssertEquals(
 		t, responseWriter.Header().Get("Location"),
@@ -1170,104 +2127,561 @@ func TestNewRegistration(t *testing.T) {
 	test.AssertEquals(t, responseWriter.Code, 409)
 }
 
-func makeRevokeRequestJSON(reason *revocation.Reason) ([]byte, error) {
-	certPemBytes, err := ioutil.ReadFile("test/238.crt")
-	if err != nil {
-		return nil, err
+func TestRegistrationDeprecationWarning(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.DeprecationWarnings = []string{deprecatedAgreement}
+
+	// The deprecated agreement field earns a Warning header
+	responseWriter := httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","agreement":"`+agreementURL+`"}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, responseWriter.Header().Get("Warning"), `299 - "The agreement field is deprecated, use termsOfServiceAgreed"`)
+
+	// termsOfServiceAgreed agrees to the current terms without a warning
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","termsOfServiceAgreed":true}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, responseWriter.Header().Get("Warning"), "")
+	var reg core.Registration
+	err := json.Unmarshal(responseWriter.Body.Bytes(), &reg)
+	test.AssertNotError(t, err, "Couldn't unmarshal returned registration object")
+	test.AssertEquals(t, reg.Agreement, agreementURL)
+
+	// No warning is given when the deprecation isn't configured
+	wfe.DeprecationWarnings = nil
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","agreement":"`+agreementURL+`"}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, responseWriter.Header().Get("Warning"), "")
+}
+
+func TestAllowedJWSAlgorithms(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	newReg := `{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"` + agreementURL + `"}`
+	signES256 := func() string {
+		key, err := jose.LoadPrivateKey([]byte(testE2KeyPrivatePEM))
+		test.AssertNotError(t, err, "Failed to load key")
+		signer, err := jose.NewSigner("ES256", key.(*ecdsa.PrivateKey))
+		test.AssertNotError(t, err, "Failed to make signer")
+		signer.SetNonceSource(wfe.nonceService)
+		result, err := signer.Sign([]byte(newReg))
+		test.AssertNotError(t, err, "Failed to sign")
+		return result.FullSerialize()
 	}
-	certBlock, _ := pem.Decode(certPemBytes)
-	if err != nil {
-		return nil, err
+
+	// By default, every supported algorithm is allowed
+	for _, body := range []string{signRequestWithKey(t, newReg, test2KeyPrivatePEM, wfe.nonceService), signES256()} {
+		_, _, _, prob := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(body), false, core.ResourceNewReg)
+		test.Assert(t, prob == nil, fmt.Sprintf("Rejected a request by default: %v", prob))
 	}
-	revokeRequest := struct {
-		Resource       string             `json:"resource"`
-		CertificateDER core.JSONBuffer    `json:"certificate"`
-		Reason         *revocation.Reason `json:"reason"`
+
+	// Only listed algorithms are allowed otherwise
+	wfe.AllowedJWSAlgorithms = []string{"ES256"}
+	_, _, _, prob := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(signES256()), false, core.ResourceNewReg)
+	test.Assert(t, prob == nil, fmt.Sprintf("Rejected an allowed algorithm: %v", prob))
+	logEvent := newRequestEvent()
+	_, _, _, prob = wfe.verifyPOST(ctx, logEvent, makePostRequest(signRequestWithKey(t, newReg, test2KeyPrivatePEM, wfe.nonceService)), false, core.ResourceNewReg)
+	test.Assert(t, prob != nil, "Accepted an algorithm that isn't allowed")
+	test.AssertEquals(t, prob.Type, probs.MalformedProblem)
+	test.AssertEquals(t, prob.Detail, "signature type 'RS256' in JWS header is not allowed, use one of ES256")
+	test.AssertDeepEquals(t, logEvent.Errors, []string{"JWS algorithm RS256 is not allowed"})
+
+	// Uses of each algorithm are counted, allowed or not
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	wfe.stats = stats
+	stats.EXPECT().Inc("JWSAlgorithms.ES256", int64(1))
+	test.Assert(t, wfe.checkAllowedAlgorithm(newRequestEvent(), "ES256") == nil, "Rejected an allowed algorithm")
+	stats.EXPECT().Inc("Errors.DisallowedJWSAlgorithm.ES384", int64(1))
+	test.Assert(t, wfe.checkAllowedAlgorithm(newRequestEvent(), "ES384") != nil, "Accepted an algorithm that isn't allowed")
+}
+
+func TestRejectJWEAndMultipleSignatures(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	wfe.stats = stats
+
+	testCases := []struct {
+		name   string
+		body   string
+		stat   string
+		detail string
 	}{
-		Resource:       "revoke-cert",
-		CertificateDER: certBlock.Bytes,
-		Reason:         reason,
+		{
+			name:   "compact JWE",
+			body:   "eyJhbGciOiJSU0EtT0FFUCJ9.a2V5.aXY.Y2lwaGVydGV4dA.dGFn",
+			stat:   "Errors.JWEInPOST",
+			detail: "Encrypted JWE is not supported, POST body must be a JWS",
+		},
+		{
+			name:   "JSON JWE with multiple recipients",
+			body:   `{"protected":"eyJlbmMiOiJBMTI4R0NNIn0","recipients":[{"encrypted_key":"a2V5"},{"encrypted_key":"a2V5"}],"iv":"aXY","ciphertext":"Y2lwaGVydGV4dA","tag":"dGFn"}`,
+			stat:   "Errors.JWEInPOST",
+			detail: "Encrypted JWE is not supported, POST body must be a JWS",
+		},
+		{
+			name:   "JWS with multiple signatures",
+			body:   `{"payload":"e30","signatures":[{"protected":"e30","signature":"c2ln"},{"protected":"e30","signature":"c2ln"}]}`,
+			stat:   "Errors.TooManyJWSSignaturesInPOST",
+			detail: "Too many signatures in POST body",
+		},
 	}
-	revokeRequestJSON, err := json.Marshal(revokeRequest)
-	if err != nil {
-		return nil, err
+	for _, tc := range testCases {
+		stats.EXPECT().Inc(tc.stat, int64(1))
+		stats.EXPECT().Inc("HTTP.ErrorCodes.400", int64(1))
+		stats.EXPECT().Inc("HTTP.ProblemTypes.malformed", int64(1))
+		responseWriter := httptest.NewRecorder()
+		wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter, makePostRequest(tc.body))
+		test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+		assertJSONEquals(t, responseWriter.Body.String(),
+			`{"type":"urn:acme:error:malformed","detail":"`+tc.detail+`","status":400}`)
 	}
-	return revokeRequestJSON, nil
 }
 
-// An SA mock that always returns NoSuchRegistrationError. This is necessary
-// because the standard mock in our mocks package always returns a given test
-// registration when GetRegistrationByKey is called, and we want to get a
-// NoSuchRegistrationError for tests that pass regCheck = false to verifyPOST.
-type mockSANoSuchRegistration struct {
-	core.StorageGetter
+func TestStrictJSONFieldCasing(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.StrictJSONFieldCasing = true
+
+	// Canonical casing is accepted
+	responseWriter := httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	// Non-canonical casing is refused in strict mode
+	responseWriter = httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, `{"Resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Request payload field \"Resource\" must be spelled \"resource\"","status":400}`)
+
+	// and only logged otherwise
+	wfe.StrictJSONFieldCasing = false
+	responseWriter = httptest.NewRecorder()
+	logEvent := newRequestEvent()
+	wfe.NewAuthorization(ctx, logEvent, responseWriter,
+		makePostRequest(signRequest(t, `{"RESOURCE":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertDeepEquals(t, logEvent.Extra["NonCanonicalFields"], []string{"RESOURCE"})
 }
 
-func (msa mockSANoSuchRegistration) GetRegistrationByKey(ctx context.Context, jwk *jose.JsonWebKey) (core.Registration, error) {
-	return core.Registration{}, core.NoSuchRegistrationError("reg not found")
+func TestNonCanonicalFields(t *testing.T) {
+	testCases := []struct {
+		payload  string
+		expected []string
+	}{
+		{`{"resource":"reg","status":"valid"}`, nil},
+		// Registrations used to be serialized with "Status"
+		{`{"resource":"reg","Status":"valid"}`, nil},
+		{`{"resource":"reg","STATUS":"valid"}`, []string{"STATUS"}},
+		{`{"Resource":"reg","Status":"valid"}`, []string{"Resource"}},
+		{`not json`, nil},
+	}
+	for _, tc := range testCases {
+		test.AssertDeepEquals(t, nonCanonicalFields([]byte(tc.payload)), tc.expected)
+	}
 }
 
-// Valid revocation request for existing, non-revoked cert, signed with cert
-// key.
-func TestRevokeCertificateCertKey(t *testing.T) {
-	keyPemBytes, err := ioutil.ReadFile("test/238.key")
-	test.AssertNotError(t, err, "Failed to load key")
-	key, err := jose.LoadPrivateKey(keyPemBytes)
-	test.AssertNotError(t, err, "Failed to load key")
-	rsaKey, ok := key.(*rsa.PrivateKey)
-	test.Assert(t, ok, "Couldn't load RSA key")
-	signer, err := jose.NewSigner("RS256", rsaKey)
-	test.AssertNotError(t, err, "Failed to make signer")
+func TestStrictContentType(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.StrictContentType = true
+	newAuthz := `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`
 
-	revokeRequestJSON, err := makeRevokeRequestJSON(nil)
-	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
+	// application/jose+json is accepted, with or without parameters
+	for _, contentType := range []string{"application/jose+json", "Application/JOSE+JSON; charset=utf-8"} {
+		responseWriter := httptest.NewRecorder()
+		request := makePostRequest(signRequest(t, newAuthz, wfe.nonceService))
+		request.Header.Set("Content-Type", contentType)
+		wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter, request)
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	}
 
-	wfe, fc := setupWFE(t)
-	wfe.AcceptRevocationReason = true
-	wfe.SA = &mockSANoSuchRegistration{mocks.NewStorageAuthority(fc)}
-	responseWriter := httptest.NewRecorder()
+	// Anything else is refused in strict mode, without reading the body
+	for _, contentType := range []string{"", "application/json", "application/jose", "not a media type"} {
+		responseWriter := httptest.NewRecorder()
+		body := signRequest(t, newAuthz, wfe.nonceService)
+		request := makePostRequest(body)
+		request.Header.Set("Content-Type", contentType)
+		wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter, request)
+		test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+		assertJSONEquals(t, responseWriter.Body.String(),
+			`{"type":"urn:acme:error:malformed","detail":"Request Content-Type must be \"application/jose+json\"","status":400}`)
+		unread, err := ioutil.ReadAll(request.Body)
+		test.AssertNotError(t, err, "Couldn't read request body")
+		test.AssertEquals(t, string(unread), body)
+	}
 
-	signer.SetNonceSource(wfe.nonceService)
-	result, _ := signer.Sign(revokeRequestJSON)
-	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(result.FullSerialize()))
-	test.AssertEquals(t, responseWriter.Code, 200)
-	test.AssertEquals(t, responseWriter.Body.String(), "")
+	// and only logged otherwise
+	wfe.StrictContentType = false
+	responseWriter := httptest.NewRecorder()
+	logEvent := newRequestEvent()
+	request := makePostRequest(signRequest(t, newAuthz, wfe.nonceService))
+	request.Header.Set("Content-Type", "application/json")
+	wfe.NewAuthorization(ctx, logEvent, responseWriter, request)
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, logEvent.Extra["ContentType"], "application/json")
 }
 
-func TestRevokeCertificateReasons(t *testing.T) {
-	keyPemBytes, err := ioutil.ReadFile("test/238.key")
-	test.AssertNotError(t, err, "Failed to load key")
-	key, err := jose.LoadPrivateKey(keyPemBytes)
-	test.AssertNotError(t, err, "Failed to load key")
-	rsaKey, ok := key.(*rsa.PrivateKey)
-	test.Assert(t, ok, "Couldn't load RSA key")
-	signer, err := jose.NewSigner("RS256", rsaKey)
-	test.AssertNotError(t, err, "Failed to make signer")
+func TestPrepChallengeForDisplayValidationRecords(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	authz := core.Authorization{ID: "eyup"}
+	challenge := core.Challenge{
+		ID:     12,
+		Type:   core.ChallengeTypeHTTP01,
+		Status: core.StatusInvalid,
+		Error:  probs.ConnectionFailure("Could not connect to example.com"),
+		Token:  "token",
+		ValidationRecord: []core.ValidationRecord{
+			{
+				Authorities:       []string{"ns.internal.example"},
+				URL:               "http://example.com/.well-known/acme-challenge/token",
+				Hostname:          "example.com",
+				Port:              "80",
+				AddressesResolved: []net.IP{net.ParseIP("1.2.3.4")},
+				AddressUsed:       net.ParseIP("1.2.3.4"),
+			},
+		},
+	}
+	original := challenge.ValidationRecord
+
+	wfe.prepChallengeForDisplay(&http.Request{Host: "example.com"}, authz, &challenge)
+
+	chalJSON, err := json.Marshal(challenge)
+	test.AssertNotError(t, err, "Failed to marshal challenge")
+	assertJSONEquals(t, string(chalJSON), `{
+		"type":"http-01",
+		"status":"invalid",
+		"error":{"type":"urn:acme:error:connection","detail":"Could not connect to example.com","status":400},
+		"uri":"http://example.com/acme/challenge/eyup/12",
+		"token":"token",
+		"validationRecord":[{
+			"url":"http://example.com/.well-known/acme-challenge/token",
+			"hostname":"example.com",
+			"port":"80",
+			"addressesResolved":["1.2.3.4"],
+			"addressUsed":"1.2.3.4"
+		}]
+	}`)
+	// The stored challenge's records are left alone
+	test.AssertDeepEquals(t, original[0].Authorities, []string{"ns.internal.example"})
+}
 
-	// Valid reason
-	keyComp := revocation.Reason(1)
-	revokeRequestJSON, err := makeRevokeRequestJSON(&keyComp)
-	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
+func TestPrepChallengeForDisplayValidated(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	authz := core.Authorization{ID: "eyup"}
+	validated := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	challenge := core.Challenge{
+		ID:        12,
+		Type:      core.ChallengeTypeHTTP01,
+		Status:    core.StatusValid,
+		Token:     "token",
+		Validated: &validated,
+	}
+	wfe.prepChallengeForDisplay(&http.Request{Host: "example.com"}, authz, &challenge)
+	chalJSON, err := json.Marshal(challenge)
+	test.AssertNotError(t, err, "Failed to marshal challenge")
+	assertJSONEquals(t, string(chalJSON), `{
+		"type":"http-01",
+		"status":"valid",
+		"uri":"http://example.com/acme/challenge/eyup/12",
+		"token":"token",
+		"validated":"2016-09-01T12:00:00Z"
+	}`)
+
+	// A challenge that isn't valid has no validated time, even if one was
+	// recorded
+	challenge = core.Challenge{
+		ID:        12,
+		Type:      core.ChallengeTypeHTTP01,
+		Status:    core.StatusPending,
+		Token:     "token",
+		Validated: &validated,
+	}
+	wfe.prepChallengeForDisplay(&http.Request{Host: "example.com"}, authz, &challenge)
+	chalJSON, err = json.Marshal(challenge)
+	test.AssertNotError(t, err, "Failed to marshal challenge")
+	assertJSONEquals(t, string(chalJSON), `{
+		"type":"http-01",
+		"status":"pending",
+		"uri":"http://example.com/acme/challenge/eyup/12",
+		"token":"token"
+	}`)
+}
 
-	wfe, fc := setupWFE(t)
-	wfe.AcceptRevocationReason = true
-	ra := wfe.RA.(*MockRegistrationAuthority)
-	wfe.SA = &mockSANoSuchRegistration{mocks.NewStorageAuthority(fc)}
-	responseWriter := httptest.NewRecorder()
+func TestPrepAuthorizationForDisplayWildcard(t *testing.T) {
+	wfe, _ := setupWFE(t)
 
-	signer.SetNonceSource(wfe.nonceService)
-	result, _ := signer.Sign(revokeRequestJSON)
-	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(result.FullSerialize()))
-	test.AssertEquals(t, responseWriter.Code, 200)
-	test.AssertEquals(t, responseWriter.Body.String(), "")
-	test.AssertEquals(t, ra.lastRevocationReason, revocation.Reason(1))
+	authz := core.Authorization{
+		ID:         "wild",
+		Identifier: core.AcmeIdentifier{Type: core.IdentifierDNS, Value: "*.example.com"},
+	}
+	wfe.prepAuthorizationForDisplay(&http.Request{Host: "example.com"}, &authz)
+	authzJSON, err := json.Marshal(authz)
+	test.AssertNotError(t, err, "Failed to marshal authorization")
+	assertJSONEquals(t, string(authzJSON),
+		`{"identifier":{"type":"dns","value":"example.com"},"wildcard":true}`)
+
+	authz = core.Authorization{
+		ID:         "tame",
+		Identifier: core.AcmeIdentifier{Type: core.IdentifierDNS, Value: "example.com"},
+	}
+	wfe.prepAuthorizationForDisplay(&http.Request{Host: "example.com"}, &authz)
+	authzJSON, err = json.Marshal(authz)
+	test.AssertNotError(t, err, "Failed to marshal authorization")
+	assertJSONEquals(t, string(authzJSON), `{"identifier":{"type":"dns","value":"example.com"}}`)
+}
 
-	// No reason
-	responseWriter = httptest.NewRecorder()
-	revokeRequestJSON, err = makeRevokeRequestJSON(nil)
-	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
+// mockSARegisteredConcurrently is a mock SA that only finds a registration for
+// the test2 key after it has been asked once, as if another new-reg with the
+// same key had been completed in between.
+type mockSARegisteredConcurrently struct {
+	core.StorageGetter
+	lookups int
+}
+
+func (sa *mockSARegisteredConcurrently) GetRegistrationByKey(ctx context.Context, jwk *jose.JsonWebKey) (core.Registration, error) {
+	sa.lookups++
+	if sa.lookups == 1 {
+		return core.Registration{}, core.NoSuchRegistrationError("no registration")
+	}
+	return core.Registration{ID: 3, Key: jwk}, nil
+}
+
+// mockRADuplicateRegistration is a mock RA whose NewRegistration fails as it
+// does when the SA's insert hits the unique key constraint.
+type mockRADuplicateRegistration struct {
+	MockRegistrationAuthority
+}
+
+func (ra *mockRADuplicateRegistration) NewRegistration(ctx context.Context, reg core.Registration) (core.Registration, error) {
+	return core.Registration{}, core.InternalServerError("Error 1062: Duplicate entry for key 'jwk_sha256'")
+}
+
+func TestNewRegistrationRace(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	sa := &mockSARegisteredConcurrently{StorageGetter: wfe.SA}
+	wfe.SA = sa
+	wfe.RA = &mockRADuplicateRegistration{}
+
+	responseWriter := httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg"}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusConflict)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "http://localhost/acme/reg/3")
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Registration key is already in use","status":409}`)
+	test.AssertEquals(t, sa.lookups, 2)
+
+	// Other RA failures are still reported as such
+	wfe.SA = sa.StorageGetter
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg"}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusInternalServerError)
+}
+
+func TestNewRegistrationOnlyReturnExisting(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	// An existing registration is returned as it is
+	responseWriter := httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, `{"resource":"new-reg","onlyReturnExisting":true}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "http://localhost/acme/reg/1")
+	var reg core.Registration
+	err := json.Unmarshal(responseWriter.Body.Bytes(), &reg)
+	test.AssertNotError(t, err, "Couldn't unmarshal returned registration object")
+	test.AssertEquals(t, reg.ID, int64(1))
+	test.AssertEquals(t, (*reg.Contact)[0], "mailto:person@mail.com")
+
+	// An unknown key doesn't get a registration created for it
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","onlyReturnExisting":true}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusNotFound)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "")
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:accountDoesNotExist","detail":"No registration exists with the provided key","status":404}`)
+
+	// Without the field, new-reg still creates registrations
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","onlyReturnExisting":false}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+}
+
+func TestRequireAgreementAtRegistration(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RequireAgreementAtRegistration = true
+
+	// Not agreeing is refused, with a pointer to the terms
+	responseWriter := httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","contact":["mailto:person@mail.com"]}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+	test.AssertEquals(t, responseWriter.Header().Get("Link"), `<`+agreementURL+`>;rel="terms-of-service"`)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:unauthorized","detail":"Must agree to subscriber agreement to register","status":403}`)
+
+	// Agreeing with either field succeeds
+	for _, payload := range []string{
+		`{"resource":"new-reg","agreement":"` + agreementURL + `"}`,
+		`{"resource":"new-reg","termsOfServiceAgreed":true}`,
+	} {
+		responseWriter = httptest.NewRecorder()
+		wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+			makePostRequest(signRequestWithKey(t, payload, test2KeyPrivatePEM, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	}
+
+	// Without the flag, agreement can still wait
+	wfe.RequireAgreementAtRegistration = false
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg"}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+}
+
+func TestMaxContactsTotalBytes(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	// "mailto:person@mail.com" is 22 bytes
+	wfe.MaxContactsTotalBytes = 44
+
+	// Right at the limit
+	responseWriter := httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","contact":["mailto:person@mail.com","mailto:people@mail.com"]}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+
+	// Just over the limit
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","contact":["mailto:person@mail.com","mailto:persons@mail.com"]}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid contacts :: contacts total 45 bytes, more than the maximum of 44","status":400}`)
+
+	// The limit applies to updates too
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","contact":["mailto:person@mail.com","mailto:persons@mail.com"]}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid contacts :: contacts total 45 bytes, more than the maximum of 44","status":400}`)
+
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","contact":["mailto:person@mail.com","mailto:people@mail.com"]}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+}
+
+func makeRevokeRequestJSON(reason *revocation.Reason) ([]byte, error) {
+	certPemBytes, err := ioutil.ReadFile("test/238.crt")
+	if err != nil {
+		return nil, err
+	}
+	certBlock, _ := pem.Decode(certPemBytes)
+	if err != nil {
+		return nil, err
+	}
+	revokeRequest := struct {
+		Resource       string             `json:"resource"`
+		CertificateDER core.JSONBuffer    `json:"certificate"`
+		Reason         *revocation.Reason `json:"reason"`
+	}{
+		Resource:       "revoke-cert",
+		CertificateDER: certBlock.Bytes,
+		Reason:         reason,
+	}
+	revokeRequestJSON, err := json.Marshal(revokeRequest)
+	if err != nil {
+		return nil, err
+	}
+	return revokeRequestJSON, nil
+}
+
+// An SA mock that always returns NoSuchRegistrationError. This is necessary
+// because the standard mock in our mocks package always returns a given test
+// registration when GetRegistrationByKey is called, and we want to get a
+// NoSuchRegistrationError for tests that pass regCheck = false to verifyPOST.
+type mockSANoSuchRegistration struct {
+	core.StorageGetter
+}
+
+func (msa mockSANoSuchRegistration) GetRegistrationByKey(ctx context.Context, jwk *jose.JsonWebKey) (core.Registration, error) {
+	return core.Registration{}, core.NoSuchRegistrationError("reg not found")
+}
+
+// Valid revocation request for existing, non-revoked cert, signed with cert
+// key.
+func TestRevokeCertificateCertKey(t *testing.T) {
+	keyPemBytes, err := ioutil.ReadFile("test/238.key")
+	test.AssertNotError(t, err, "Failed to load key")
+	key, err := jose.LoadPrivateKey(keyPemBytes)
+	test.AssertNotError(t, err, "Failed to load key")
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	test.Assert(t, ok, "Couldn't load RSA key")
+	signer, err := jose.NewSigner("RS256", rsaKey)
+	test.AssertNotError(t, err, "Failed to make signer")
+
+	revokeRequestJSON, err := makeRevokeRequestJSON(nil)
+	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
+
+	wfe, fc := setupWFE(t)
+	wfe.AcceptRevocationReason = true
+	wfe.SA = &mockSANoSuchRegistration{mocks.NewStorageAuthority(fc)}
+	responseWriter := httptest.NewRecorder()
+
+	signer.SetNonceSource(wfe.nonceService)
+	result, _ := signer.Sign(revokeRequestJSON)
+	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(result.FullSerialize()))
+	test.AssertEquals(t, responseWriter.Code, 200)
+	test.AssertEquals(t, responseWriter.Body.String(), "")
+}
+
+func TestRevokeCertificateReasons(t *testing.T) {
+	keyPemBytes, err := ioutil.ReadFile("test/238.key")
+	test.AssertNotError(t, err, "Failed to load key")
+	key, err := jose.LoadPrivateKey(keyPemBytes)
+	test.AssertNotError(t, err, "Failed to load key")
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	test.Assert(t, ok, "Couldn't load RSA key")
+	signer, err := jose.NewSigner("RS256", rsaKey)
+	test.AssertNotError(t, err, "Failed to make signer")
+
+	// Valid reason
+	keyComp := revocation.Reason(1)
+	revokeRequestJSON, err := makeRevokeRequestJSON(&keyComp)
+	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
+
+	wfe, fc := setupWFE(t)
+	wfe.AcceptRevocationReason = true
+	ra := wfe.RA.(*MockRegistrationAuthority)
+	wfe.SA = &mockSANoSuchRegistration{mocks.NewStorageAuthority(fc)}
+	responseWriter := httptest.NewRecorder()
+
+	signer.SetNonceSource(wfe.nonceService)
+	result, _ := signer.Sign(revokeRequestJSON)
+	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(result.FullSerialize()))
+	test.AssertEquals(t, responseWriter.Code, 200)
+	test.AssertEquals(t, responseWriter.Body.String(), "")
+	test.AssertEquals(t, ra.lastRevocationReason, revocation.Reason(1))
+
+	// No reason
+	responseWriter = httptest.NewRecorder()
+	revokeRequestJSON, err = makeRevokeRequestJSON(nil)
+	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
 
 	signer.SetNonceSource(wfe.nonceService)
 	result, _ = signer.Sign(revokeRequestJSON)
@@ -1419,7 +2833,7 @@ func TestAuthorization(t *testing.T) {
 		Method: "GET",
 		URL:    mustParseURL(newAuthzPath),
 	})
-	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
+	assertProblemEquals(t, responseWriter, `{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
 
 	// POST, but no body.
 	responseWriter.Body.Reset()
@@ -1525,8 +2939,8 @@ func TestRegistration(t *testing.T) {
 		URL:    mustParseURL(regPath),
 		Body:   makeBody("invalid"),
 	})
-	assertJSONEquals(t,
-		responseWriter.Body.String(),
+	assertProblemEquals(t,
+		responseWriter,
 		`{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
 	responseWriter.Body.Reset()
 
@@ -1535,8 +2949,8 @@ func TestRegistration(t *testing.T) {
 		Method: "GET",
 		URL:    mustParseURL(regPath),
 	})
-	assertJSONEquals(t,
-		responseWriter.Body.String(),
+	assertProblemEquals(t,
+		responseWriter,
 		`{"type":"urn:acme:error:malformed","detail":"Method not allowed","status":405}`)
 	responseWriter.Body.Reset()
 
@@ -1618,101 +3032,997 @@ func TestRegistration(t *testing.T) {
 	responseWriter.Body.Reset()
 }
 
-func TestTermsRedirect(t *testing.T) {
+func TestRegistrationUpdateImmutableFields(t *testing.T) {
 	wfe, _ := setupWFE(t)
-	responseWriter := httptest.NewRecorder()
+	mockRA := wfe.RA.(*MockRegistrationAuthority)
 
-	path, _ := url.Parse("/terms")
-	wfe.Terms(ctx, newRequestEvent(), responseWriter, &http.Request{
-		Method: "GET",
-		URL:    path,
-	})
-	test.AssertEquals(
-		t, responseWriter.Header().Get("Location"),
-		agreementURL)
-	test.AssertEquals(t, responseWriter.Code, 302)
+	for _, field := range []string{
+		`"id":2`,
+		`"initialIp":"10.0.0.1"`,
+		`"createdAt":"2015-01-01T00:00:00Z"`,
+		`"key":` + test2KeyPublicJSON,
+	} {
+		responseWriter := httptest.NewRecorder()
+		body := `{"resource":"reg","contact":["mailto:new@example.com"],` + field + `}`
+		wfe.Registration(ctx, newRequestEvent(), responseWriter,
+			makePostRequestWithPath("1", signRequest(t, body, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+
+		// The mutable field is passed along to the RA, and nothing else.
+		update := mockRA.lastUpdate
+		test.AssertEquals(t, (*update.Contact)[0], "mailto:new@example.com")
+		test.AssertEquals(t, update.ID, int64(0))
+		test.AssertEquals(t, len(update.InitialIP), 0)
+		test.Assert(t, update.CreatedAt.IsZero(), "createdAt was passed to the RA")
+		test.Assert(t, core.KeyDigestEquals(update.Key, loadTest1PublicKey(t)), "key was changed in update")
+
+		var reg core.Registration
+		err := json.Unmarshal(responseWriter.Body.Bytes(), &reg)
+		test.AssertNotError(t, err, "Couldn't unmarshal returned registration object")
+		test.AssertEquals(t, reg.ID, int64(1))
+		test.Assert(t, core.KeyDigestEquals(reg.Key, loadTest1PublicKey(t)), "key was changed in response")
+	}
 }
 
-func TestIssuer(t *testing.T) {
+// mockSANoRegistrations is a mock SA that has no registrations by ID.
+func TestInvalidUTF8Payload(t *testing.T) {
 	wfe, _ := setupWFE(t)
-	wfe.IssuerCacheDuration = time.Second * 10
-	wfe.IssuerCert = []byte{0, 0, 1}
 
-	responseWriter := httptest.NewRecorder()
+	for _, payload := range []string{
+		"{\"resource\":\"reg\",\"contact\":[\"mailto:\xff@example.com\"]}",
+		"{\"resource\":\"reg\"}\xc3",
+	} {
+		responseWriter := httptest.NewRecorder()
+		wfe.Registration(ctx, newRequestEvent(), responseWriter,
+			makePostRequestWithPath("1", signRequest(t, payload, wfe.nonceService)))
+		assertJSONEquals(t, responseWriter.Body.String(),
+			`{"type":"urn:acme:error:malformed","detail":"Request payload is not valid UTF-8","status":400}`)
+	}
 
-	wfe.Issuer(ctx, newRequestEvent(), responseWriter, &http.Request{
-		Method: "GET",
-	})
-	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
-	test.Assert(t, bytes.Compare(responseWriter.Body.Bytes(), wfe.IssuerCert) == 0, "Incorrect bytes returned")
+	// Multi-byte characters are fine
+	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","contact":["mailto:josé@example.com"]}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
 }
 
-func TestGetCertificate(t *testing.T) {
+func TestNonceInstanceLogged(t *testing.T) {
 	wfe, _ := setupWFE(t)
-	mux := wfe.Handler()
-
-	wfe.CertCacheDuration = time.Second * 10
-	wfe.CertNoCacheExpirationWindow = time.Hour * 24 * 7
-
-	certPemBytes, _ := ioutil.ReadFile("test/178.crt")
-	certBlock, _ := pem.Decode(certPemBytes)
+	test.AssertNotError(t, wfe.SetNonceInstance("wfe-a"), "Couldn't set nonce instance")
+	other, err := nonce.NewNonceService(metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Couldn't make nonce service")
+	test.AssertNotError(t, other.SetInstance("wfe-b"), "Couldn't set nonce instance")
 
+	// Our own nonces are accepted
 	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
 
-	mockLog := wfe.log.(*blog.Mock)
-	mockLog.Clear()
+	// and those from another instance are rejected, naming the instance
+	responseWriter = httptest.NewRecorder()
+	logEvent := newRequestEvent()
+	wfe.Registration(ctx, logEvent, responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, other)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	test.AssertContains(t, responseWriter.Body.String(), "urn:acme:error:badNonce")
+	test.AssertEquals(t, logEvent.Extra["NonceInstance"], "wfe-b")
+}
 
-	// Valid serial, cached
-	req, _ := http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000b2", nil)
-	req.RemoteAddr = "192.168.0.1"
-	mux.ServeHTTP(responseWriter, req)
-	test.AssertEquals(t, responseWriter.Code, 200)
-	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
-	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
-	test.Assert(t, bytes.Compare(responseWriter.Body.Bytes(), certBlock.Bytes) == 0, "Certificates don't match")
+func TestNonceMaxAge(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	wfe.NonceMaxAge = time.Minute
 
-	t.Logf("UGH %#v", mockLog.GetAll()[0])
-	reqlogs := mockLog.GetAllMatching(`Successful request`)
-	test.AssertEquals(t, len(reqlogs), 1)
-	test.AssertContains(t, reqlogs[0], `INFO: `)
-	test.AssertContains(t, reqlogs[0], `"ClientAddr":"192.168.0.1"`)
+	// A nonce used within the max age is accepted
+	fresh := signRequest(t, `{"resource":"reg"}`, wfe.nonceService)
+	stale := signRequest(t, `{"resource":"reg"}`, wfe.nonceService)
+	fc.Add(time.Minute)
+	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter, makePostRequestWithPath("1", fresh))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
 
-	// Unused serial, no cache
-	mockLog.Clear()
+	// and one used after it is rejected
+	fc.Add(time.Second)
 	responseWriter = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000ff", nil)
-	req.RemoteAddr = "192.168.0.1"
-	req.Header.Set("X-Forwarded-For", "192.168.99.99")
-	mux.ServeHTTP(responseWriter, req)
-	test.AssertEquals(t, responseWriter.Code, 404)
-	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
-	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Certificate not found","status":404}`)
-
-	reqlogs = mockLog.GetAllMatching(`Terminated request`)
-	test.AssertEquals(t, len(reqlogs), 1)
-	test.AssertContains(t, reqlogs[0], `INFO: `)
-	test.AssertContains(t, reqlogs[0], `"ClientAddr":"192.168.99.99,192.168.0.1"`)
+	wfe.Registration(ctx, newRequestEvent(), responseWriter, makePostRequestWithPath("1", stale))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	test.AssertContains(t, responseWriter.Body.String(), "urn:acme:error:badNonce")
+	test.AssertContains(t, responseWriter.Body.String(), "JWS has expired anti-replay nonce")
 
-	// Invalid serial, no cache
+	// unless there's no max age
+	wfe.NonceMaxAge = 0
 	responseWriter = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/acme/cert/nothex", nil)
-	mux.ServeHTTP(responseWriter, req)
-	test.AssertEquals(t, responseWriter.Code, 404)
-	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
-	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Certificate not found","status":404}`)
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
 
-	// Invalid serial, no cache
-	responseWriter = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/acme/cert/00000000000000", nil)
-	mux.ServeHTTP(responseWriter, req)
-	test.AssertEquals(t, responseWriter.Code, 404)
-	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
-	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Certificate not found","status":404}`)
+	// Buffered nonces are minted before they're handed out, so they can't
+	// be given a max age.
+	buffered, err := NewWebFrontEndImpl(metrics.NewNoopScope(), fc, testKeyPolicy, 16, nil, blog.NewMock())
+	test.AssertNotError(t, err, "Unable to create WFE")
+	buffered.IssuerCert = []byte{0, 0, 1}
+	test.AssertNotError(t, buffered.CheckConfig(), "CheckConfig failed with a nonce buffer")
+	buffered.NonceMaxAge = time.Minute
+	test.AssertError(t, buffered.CheckConfig(), "CheckConfig passed with a nonce buffer and max age")
 }
 
-func assertCsrLogged(t *testing.T, mockLog *blog.Mock) {
-	matches := mockLog.GetAllMatching("^INFO: \\[AUDIT\\] Certificate request JSON=")
-	test.Assert(t, len(matches) == 1,
+func TestRegistrationTermsOfServiceAgreed(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	agreed := func(body []byte) bool {
+		var reg struct {
+			TermsOfServiceAgreed *bool
+		}
+		err := json.Unmarshal(body, &reg)
+		test.AssertNotError(t, err, "Couldn't unmarshal registration")
+		test.Assert(t, reg.TermsOfServiceAgreed != nil, "No termsOfServiceAgreed in registration")
+		return *reg.TermsOfServiceAgreed
+	}
+
+	// Registration 1 agreed to the current terms
+	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, "", wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.Assert(t, agreed(responseWriter.Body.Bytes()), "Registration with the current agreement hasn't agreed")
+	test.AssertContains(t, responseWriter.Body.String(), `"agreement": "`+agreementURL+`"`)
+
+	// but not to newer ones
+	wfe.SubscriberAgreementURL = agreementURL + "/v2"
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, "", wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.Assert(t, !agreed(responseWriter.Body.Bytes()), "Registration with an outdated agreement has agreed")
+
+	// A new registration without an agreement hasn't agreed
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","contact":["mailto:person@mail.com"]}`, test2KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.Assert(t, !agreed(responseWriter.Body.Bytes()), "Registration without an agreement has agreed")
+}
+
+func TestRegistrationPostAsGet(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	// An empty payload reads the registration
+	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, "", wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	var reg core.Registration
+	err := json.Unmarshal(responseWriter.Body.Bytes(), &reg)
+	test.AssertNotError(t, err, "Couldn't unmarshal registration")
+	test.AssertEquals(t, reg.ID, int64(1))
+	test.AssertEquals(t, reg.Agreement, agreementURL)
+	links := responseWriter.Header()["Link"]
+	test.AssertEquals(t, contains(links, "<http://localhost/acme/new-authz>;rel=\"next\""), true)
+	test.AssertEquals(t, contains(links, "<"+agreementURL+">;rel=\"terms-of-service\""), true)
+
+	// but only the signer's own
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("2", signRequest(t, "", wfe.nonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:unauthorized","detail":"Request signing key did not match registration key","status":403}`)
+
+	// A non-empty payload is still an update, and must name the resource
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{}`, wfe.nonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Request payload does not specify a resource","status":400}`)
+
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+}
+
+type mockSANoRegistrations struct {
+	core.StorageGetter
+}
+
+func (sa *mockSANoRegistrations) GetRegistration(ctx context.Context, id int64) (core.Registration, error) {
+	return core.Registration{}, core.NoSuchRegistrationError("no registration")
+}
+
+func TestHeadRegistration(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+	headReg := func() *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, &http.Request{
+			Method: "HEAD",
+			URL:    mustParseURL(regPath + "1"),
+		})
+		return responseWriter
+	}
+
+	responseWriter := headReg()
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "http://localhost/acme/reg/1")
+	links := responseWriter.Header()["Link"]
+	test.AssertEquals(t, contains(links, `<http://localhost/acme/new-authz>;rel="next"`), true)
+	test.AssertEquals(t, contains(links, `<`+agreementURL+`>;rel="terms-of-service"`), true)
+	// Nothing from the registration is disclosed, even in Requester
+	test.AssertEquals(t, responseWriter.Body.Len(), 0)
+	test.AssertEquals(t, responseWriter.Header().Get("Boulder-Requester"), "")
+
+	wfe.SA = &mockSANoRegistrations{StorageGetter: wfe.SA}
+	responseWriter = headReg()
+	test.AssertEquals(t, responseWriter.Code, http.StatusNotFound)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "")
+}
+
+// rfc7638Thumbprint computes the RFC 7638 thumbprint of an RSA JWK given as
+// JSON, by hashing its required members in lexicographic order.
+func rfc7638Thumbprint(t *testing.T, keyJSON string) string {
+	var jwk struct {
+		E string `json:"e"`
+		N string `json:"n"`
+	}
+	err := json.Unmarshal([]byte(keyJSON), &jwk)
+	test.AssertNotError(t, err, "Failed to unmarshal JWK")
+	digest := sha256.Sum256([]byte(fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func TestAccountThumbprintHeader(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	newReg := func() *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+			makePostRequest(signRequestWithKey(t, `{"resource":"new-reg","agreement":"`+agreementURL+`"}`, test2KeyPrivatePEM, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+		return responseWriter
+	}
+
+	test.AssertEquals(t, newReg().Header().Get("Boulder-Account-Thumbprint"), "")
+
+	wfe.AccountThumbprintHeader = true
+	test.AssertEquals(t, newReg().Header().Get("Boulder-Account-Thumbprint"), rfc7638Thumbprint(t, test2KeyPublicJSON))
+
+	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+	test.AssertEquals(t, responseWriter.Header().Get("Boulder-Account-Thumbprint"), rfc7638Thumbprint(t, test1KeyPublicJSON))
+}
+
+func loadTest1PublicKey(t *testing.T) *jose.JsonWebKey {
+	var key jose.JsonWebKey
+	err := key.UnmarshalJSON([]byte(test1KeyPublicJSON))
+	test.AssertNotError(t, err, "Failed to unmarshal test1 key")
+	return &key
+}
+
+func TestTermsRedirect(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	responseWriter := httptest.NewRecorder()
+
+	path, _ := url.Parse("/terms")
+	wfe.Terms(ctx, newRequestEvent(), responseWriter, &http.Request{
+		Method: "GET",
+		URL:    path,
+	})
+	test.AssertEquals(
+		t, responseWriter.Header().Get("Location"),
+		agreementURL)
+	test.AssertEquals(t, responseWriter.Code, 302)
+}
+
+func TestLocalizedTermsRedirect(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.LocalizedSubscriberAgreementURLs = map[string]string{
+		"fr":    "http://example.invalid/terms.fr",
+		"pt-BR": "http://example.invalid/terms.pt-BR",
+	}
+
+	testCases := []struct {
+		acceptLanguage  string
+		location        string
+		contentLanguage string
+	}{
+		{"fr", "http://example.invalid/terms.fr", "fr"},
+		{"fr-CA, en;q=0.5", "http://example.invalid/terms.fr", "fr"},
+		{"en, pt-br;q=0.8, fr;q=0.2", "http://example.invalid/terms.pt-BR", "pt-BR"},
+		{"de", agreementURL, ""},
+		{"", agreementURL, ""},
+	}
+	for _, tc := range testCases {
+		responseWriter := httptest.NewRecorder()
+		wfe.Terms(ctx, newRequestEvent(), responseWriter, &http.Request{
+			Method: "GET",
+			URL:    mustParseURL("/terms"),
+			Header: http.Header{"Accept-Language": {tc.acceptLanguage}},
+		})
+		test.AssertEquals(t, responseWriter.Code, 302)
+		test.AssertEquals(t, responseWriter.Header().Get("Location"), tc.location)
+		test.AssertEquals(t, responseWriter.Header().Get("Content-Language"), tc.contentLanguage)
+		test.AssertEquals(t, responseWriter.Header().Get("Vary"), "Accept-Language")
+	}
+}
+
+func TestIssuer(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.IssuerCacheDuration = time.Second * 10
+	wfe.IssuerCert = []byte{0, 0, 1}
+
+	responseWriter := httptest.NewRecorder()
+
+	wfe.Issuer(ctx, newRequestEvent(), responseWriter, &http.Request{
+		Method: "GET",
+	})
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.Assert(t, bytes.Compare(responseWriter.Body.Bytes(), wfe.IssuerCert) == 0, "Incorrect bytes returned")
+}
+
+func TestIssuerUnset(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	test.AssertError(t, wfe.CheckConfig(), "CheckConfig passed without an issuer certificate")
+
+	responseWriter := httptest.NewRecorder()
+	wfe.Issuer(ctx, newRequestEvent(), responseWriter, &http.Request{
+		Method: "GET",
+	})
+	test.AssertEquals(t, responseWriter.Code, http.StatusInternalServerError)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:serverInternal","detail":"No issuer certificate available","status":500}`)
+
+	wfe.IssuerCert = []byte{0, 0, 1}
+	test.AssertNotError(t, wfe.CheckConfig(), "CheckConfig failed with an issuer certificate")
+}
+
+func TestBuildID(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+
+	// Plain text by default
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", buildIDPath, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "text/plain")
+	test.AssertEquals(t, responseWriter.Body.String(),
+		fmt.Sprintf("Boulder=(%s %s)\n", core.GetBuildID(), core.GetBuildTime()))
+
+	// JSON when asked for
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", buildIDPath, nil)
+	req.Header.Set("Accept", "text/html, application/json;q=0.9")
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/json")
+	var manifest map[string]interface{}
+	err := json.Unmarshal(responseWriter.Body.Bytes(), &manifest)
+	test.AssertNotError(t, err, "Couldn't unmarshal build manifest")
+	test.AssertEquals(t, manifest["buildID"], core.GetBuildID())
+	test.AssertEquals(t, manifest["buildTime"], core.GetBuildTime())
+	test.AssertEquals(t, manifest["goVersion"], runtime.Version())
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		offered  []string
+		expected string
+	}{
+		// No Accept header means our first preference
+		{"", []string{"text/plain", "application/json"}, "text/plain"},
+		// An explicit type wins over one matched only by a wildcard
+		{"*/*;q=0.5, application/json", []string{"text/plain", "application/json"}, "application/json"},
+		// Higher q-values win
+		{"text/plain;q=0.2, application/json;q=0.9", []string{"text/plain", "application/json"}, "application/json"},
+		// Equal q-values fall back to our order of preference
+		{"application/json, text/plain", []string{"text/plain", "application/json"}, "text/plain"},
+		// */* accepts anything
+		{"*/*", []string{"application/pkix-cert"}, "application/pkix-cert"},
+		// application/* matches application subtypes only
+		{"text/*;q=0.8, application/*", []string{"text/plain", "application/json"}, "application/json"},
+		{"application/*", []string{"application/pkix-cert"}, "application/pkix-cert"},
+		// The most specific range applies, so q=0 excludes a type despite */*
+		{"*/*, application/json;q=0", []string{"application/json"}, ""},
+		// An unsupported explicit type is not acceptable
+		{"text/html", []string{"application/pkix-cert"}, ""},
+		// Malformed ranges are ignored
+		{"text/html, ;;, application/pkix-cert", []string{"application/pkix-cert"}, "application/pkix-cert"},
+	}
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		test.AssertEquals(t, negotiateContentType(req, tc.offered...), tc.expected)
+	}
+}
+
+func TestCertificatePEMChain(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	mux := wfe.Handler()
+	issuerPEM, _ := ioutil.ReadFile("test/238.crt")
+	issuerBlock, _ := pem.Decode(issuerPEM)
+	wfe.IssuerCert = issuerBlock.Bytes
+	certPEM, _ := ioutil.ReadFile("test/178.crt")
+	certBlock, _ := pem.Decode(certPEM)
+
+	assertChain := func(body []byte, leaf []byte) {
+		block, rest := pem.Decode(body)
+		test.Assert(t, block != nil && block.Type == "CERTIFICATE", "Chain didn't start with a certificate")
+		test.Assert(t, bytes.Equal(block.Bytes, leaf), "First certificate in chain wasn't the leaf")
+		block, rest = pem.Decode(rest)
+		test.Assert(t, block != nil && block.Type == "CERTIFICATE", "Chain had no issuer certificate")
+		test.Assert(t, bytes.Equal(block.Bytes, wfe.IssuerCert), "Second certificate in chain wasn't the issuer")
+		test.AssertEquals(t, len(rest), 0)
+	}
+
+	testCases := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "application/pkix-cert"},
+		{"application/pkix-cert", "application/pkix-cert"},
+		{"*/*", "application/pkix-cert"},
+		{"application/pem-certificate-chain", "application/pem-certificate-chain"},
+		{"application/pkix-cert;q=0.5, application/pem-certificate-chain", "application/pem-certificate-chain"},
+	}
+	for _, tc := range testCases {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000b2", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+		test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), tc.contentType)
+		test.AssertEquals(t, responseWriter.Header().Get("Vary"), "Accept")
+		if tc.contentType == "application/pkix-cert" {
+			test.Assert(t, bytes.Equal(responseWriter.Body.Bytes(), certBlock.Bytes), "Certificates don't match")
+		} else {
+			assertChain(responseWriter.Body.Bytes(), certBlock.Bytes)
+		}
+	}
+
+	// new-cert negotiates the same way
+	payload := makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com")
+	responseWriter := httptest.NewRecorder()
+	req := makePostRequest(signRequest(t, payload, wfe.nonceService))
+	req.Header.Set("Accept", "application/pem-certificate-chain")
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pem-certificate-chain")
+	block, _ := pem.Decode(responseWriter.Body.Bytes())
+	test.Assert(t, block != nil, "new-cert response wasn't PEM")
+	assertChain(responseWriter.Body.Bytes(), block.Bytes)
+
+	// and gives clients that only accept JSON the default format
+	responseWriter = httptest.NewRecorder()
+	req = makePostRequest(signRequest(t, payload, wfe.nonceService))
+	req.Header.Set("Accept", "application/json")
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+}
+
+func TestGetCertificateNotAcceptable(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+
+	// Requests that accept none of the formats get the default one
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000b2", nil)
+	req.Header.Set("Accept", "application/json")
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+
+	// unless StrictAccept is set
+	wfe.StrictAccept = true
+	responseWriter = httptest.NewRecorder()
+	req.Header.Set("Accept", "text/html")
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusNotAcceptable)
+	assertProblemEquals(t, responseWriter,
+		`{"type":"urn:acme:error:malformed","detail":"Accept header must allow one of: application/pkix-cert, application/pem-certificate-chain","status":406}`)
+
+	responseWriter = httptest.NewRecorder()
+	req.Header.Set("Accept", "application/*")
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+}
+
+func TestEmitServerTime(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	mux := wfe.Handler()
+
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", directoryPath, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Header().Get("Boulder-Server-Time"), "")
+
+	wfe.EmitServerTime = true
+	fc.Set(time.Date(2016, 3, 14, 15, 9, 26, 0, time.UTC))
+	for _, path := range []string{directoryPath, "/", "/acme/cert/0000000000000000000000000000000000ff"} {
+		responseWriter = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Header().Get("Boulder-Server-Time"), "Mon, 14 Mar 2016 15:09:26 GMT")
+	}
+}
+
+func TestExpectedHosts(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.ExpectedHosts = []string{"acme.example.com", "localhost:4000"}
+	mux := wfe.Handler()
+
+	for _, host := range []string{"acme.example.com", "ACME.example.com:443", "localhost:4000"} {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", directoryPath, nil)
+		req.Host = host
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	}
+
+	for _, host := range []string{"evil.example.com", "localhost:4001", ""} {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", directoryPath, nil)
+		req.Host = host
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+		var prob probs.ProblemDetails
+		err := json.Unmarshal(responseWriter.Body.Bytes(), &prob)
+		test.AssertNotError(t, err, "Couldn't unmarshal problem")
+		test.AssertEquals(t, prob.Type, probs.MalformedProblem)
+		test.AssertEquals(t, prob.Detail, fmt.Sprintf("Unexpected Host header %q", host))
+	}
+
+	// The index page is checked too
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Host = "evil.example.com"
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+}
+
+func TestGetCertificate(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+
+	wfe.CertCacheDuration = time.Second * 10
+	wfe.CertNoCacheExpirationWindow = time.Hour * 24 * 7
+
+	certPemBytes, _ := ioutil.ReadFile("test/178.crt")
+	certBlock, _ := pem.Decode(certPemBytes)
+
+	responseWriter := httptest.NewRecorder()
+
+	mockLog := wfe.log.(*blog.Mock)
+	mockLog.Clear()
+
+	// Valid serial, cached
+	req, _ := http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000b2", nil)
+	req.RemoteAddr = "192.168.0.1"
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 200)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=10")
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+	test.Assert(t, bytes.Compare(responseWriter.Body.Bytes(), certBlock.Bytes) == 0, "Certificates don't match")
+
+	t.Logf("UGH %#v", mockLog.GetAll()[0])
+	reqlogs := mockLog.GetAllMatching(`Successful request`)
+	test.AssertEquals(t, len(reqlogs), 1)
+	test.AssertContains(t, reqlogs[0], `INFO: `)
+	test.AssertContains(t, reqlogs[0], `"ClientAddr":"192.168.0.1"`)
+
+	// Unused serial, no cache
+	mockLog.Clear()
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000ff", nil)
+	req.RemoteAddr = "192.168.0.1"
+	req.Header.Set("X-Forwarded-For", "192.168.99.99")
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 404)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
+	assertProblemEquals(t, responseWriter, `{"type":"urn:acme:error:malformed","detail":"Certificate not found","status":404}`)
+
+	reqlogs = mockLog.GetAllMatching(`Terminated request`)
+	test.AssertEquals(t, len(reqlogs), 1)
+	test.AssertContains(t, reqlogs[0], `INFO: `)
+	test.AssertContains(t, reqlogs[0], `"ClientAddr":"192.168.99.99,192.168.0.1"`)
+
+	// Invalid serial, no cache
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/acme/cert/nothex", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 400)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
+	assertProblemEquals(t, responseWriter, `{"type":"urn:acme:error:malformed","detail":"Invalid certificate serial","status":400}`)
+
+	// Well-formed 32 character serial that doesn't exist, no cache
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/acme/cert/0000000000000000000000000000abcd", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 404)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
+	assertProblemEquals(t, responseWriter, `{"type":"urn:acme:error:malformed","detail":"Certificate not found","status":404}`)
+}
+
+func TestCertificateLinkRelations(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+	wfe.RA = &mockRANewCertificate{}
+
+	err := wfe.SetCertificateLinkRelations([]string{"up", "rel"})
+	test.AssertError(t, err, "Accepted an unknown Link relation")
+
+	// Relations are sent in the same order however they're configured
+	err = wfe.SetCertificateLinkRelations([]string{"alternate", "author", "index", "up"})
+	test.AssertNotError(t, err, "Failed to set certificate Link relations")
+	expected := []string{
+		`<http://localhost/acme/issuer-cert>;rel="up"`,
+		`<http://localhost/directory>;rel="index"`,
+		`<http://localhost/acme/reg/1>;rel="author"`,
+		`<http://localhost/acme/cert/0000000000000000000000000000000000b2>;rel="alternate"`,
+	}
+
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+		makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com"), wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], expected)
+
+	responseWriter = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000b2", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], expected)
+
+	// Only "up" by default
+	wfe.certLinkRelations = nil
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], expected[:1])
+}
+
+// mockSACertCollision returns the certificate with serial b2 for any serial.
+type mockSACertCollision struct {
+	core.StorageGetter
+}
+
+func (sa *mockSACertCollision) GetCertificate(ctx context.Context, serial string) (core.Certificate, error) {
+	return sa.StorageGetter.GetCertificate(ctx, "0000000000000000000000000000000000b2")
+}
+
+func TestGetCertificateSerialMismatch(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.SA = &mockSACertCollision{wfe.SA}
+	mux := wfe.Handler()
+
+	testCases := []struct {
+		serial   string
+		expected int
+	}{
+		// The exact serial
+		{"0000000000000000000000000000000000b2", http.StatusOK},
+		// The same serial as an older, shorter one
+		{"000000000000000000000000000000b2", http.StatusOK},
+		// A prefix of the serial
+		{"0000000000000000000000000000000000", http.StatusNotFound},
+		// Another serial altogether
+		{"0000000000000000000000000000000000ee", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/acme/cert/"+tc.serial, nil)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, tc.expected)
+		if tc.expected == http.StatusNotFound {
+			assertProblemEquals(t, responseWriter, `{"type":"urn:acme:error:malformed","detail":"Certificate not found","status":404}`)
+		}
+	}
+}
+
+type mockSAMultipleCertificates struct {
+	core.StorageGetter
+}
+
+func (sa *mockSAMultipleCertificates) GetCertificate(ctx context.Context, serial string) (core.Certificate, error) {
+	return core.Certificate{}, core.MultipleMatchingCertificatesError("Multiple certificates found for " + serial)
+}
+
+func TestGetCertificateMultipleMatches(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.SA = &mockSAMultipleCertificates{wfe.SA}
+	mux := wfe.Handler()
+
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/acme/cert/000000000000000000000000000000b2", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusConflict)
+	assertProblemEquals(t, responseWriter,
+		`{"type":"urn:acme:error:malformed","detail":"Multiple certificates with same short serial","status":409}`)
+}
+
+func TestGetCertificateNearExpiry(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	mux := wfe.Handler()
+
+	wfe.CertCacheDuration = time.Second * 10
+	wfe.CertNoCacheExpirationWindow = time.Hour * 24 * 7
+
+	certPemBytes, _ := ioutil.ReadFile("test/178.crt")
+	certBlock, _ := pem.Decode(certPemBytes)
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	test.AssertNotError(t, err, "Failed to parse test certificate")
+
+	// Comfortably outside the window the normal cache header is sent
+	fc.Set(cert.NotAfter.Add(-wfe.CertNoCacheExpirationWindow - time.Hour))
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000b2", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 200)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=10")
+
+	// Inside the window the certificate must not be cached
+	fc.Set(cert.NotAfter.Add(-time.Hour * 24))
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 200)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
+	test.Assert(t, bytes.Compare(responseWriter.Body.Bytes(), certBlock.Bytes) == 0, "Certificates don't match")
+
+	// Expiring exactly at the edge of the window counts as inside it
+	fc.Set(cert.NotAfter.Add(-wfe.CertNoCacheExpirationWindow))
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
+
+	// A cache duration longer than the window widens it, so that nothing is
+	// cached past the certificate's expiry
+	wfe.CertCacheDuration = time.Hour * 24 * 30
+	fc.Set(cert.NotAfter.Add(-wfe.CertNoCacheExpirationWindow - time.Hour))
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
+
+	fc.Set(cert.NotAfter.Add(-wfe.CertCacheDuration - time.Hour))
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=2592000")
+}
+
+func TestRequirePostAsGet(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+	certURL := "/acme/cert/0000000000000000000000000000000000b2"
+
+	// Plain GET works by default
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", certURL, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+
+	// and is refused once POST-as-GET is required
+	wfe.RequirePostAsGet = true
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusMethodNotAllowed)
+	test.AssertEquals(t, responseWriter.Header().Get("Allow"), "POST")
+	assertProblemEquals(t, responseWriter,
+		`{"type":"urn:acme:error:malformed","detail":"Unauthenticated GET is not supported, use POST-as-GET","status":405}`)
+
+	// The certificate can still be fetched with POST-as-GET
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, makePostRequestWithPath(certURL,
+		signRequestWithKey(t, "", test1KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+
+	// and only POST is advertised for it
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("OPTIONS", certURL, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Header().Get("Allow"), "POST")
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", certURL, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusMethodNotAllowed)
+	test.AssertEquals(t, responseWriter.Header().Get("Allow"), "POST")
+}
+
+func TestPostAsGet(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RequirePostAsGet = true
+	wfe.AllowAuthzDeactivation = true
+	mux := wfe.Handler()
+	postAsGet := func(handler wfeHandlerFunc, path, keyPEM string) *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		handler(ctx, newRequestEvent(), responseWriter,
+			makePostRequestWithPath(path, signRequestWithKey(t, "", keyPEM, wfe.nonceService)))
+		return responseWriter
+	}
+
+	// The owner of each resource can read it, and reading an authorization
+	// doesn't deactivate it
+	responseWriter := postAsGet(wfe.Authorization, "valid", test1KeyPrivatePEM)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertContains(t, responseWriter.Body.String(), `"status": "valid"`)
+
+	responseWriter = postAsGet(wfe.Challenge, "valid/23", test1KeyPrivatePEM)
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"dns","uri":"http://localhost/acme/challenge/valid/23"}`)
+
+	responseWriter = postAsGet(wfe.Certificate, "0000000000000000000000000000000000b2", test1KeyPrivatePEM)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+
+	// Registration 2 (test3's, deactivated, but that isn't checked with the
+	// feature off) owns none of them
+	for _, tc := range []struct {
+		handler  wfeHandlerFunc
+		path     string
+		resource string
+	}{
+		{wfe.Authorization, "valid", "authorization"},
+		{wfe.Challenge, "valid/23", "authorization"},
+		{wfe.Certificate, "0000000000000000000000000000000000b2", "certificate"},
+	} {
+		responseWriter = postAsGet(tc.handler, tc.path, test3KeyPrivatePEM)
+		assertJSONEquals(t, responseWriter.Body.String(),
+			`{"type":"urn:acme:error:unauthorized","detail":"Registration ID doesn't match ID for `+tc.resource+`","status":403}`)
+	}
+
+	// Certificates only take POST-as-GET
+	responseWriter = httptest.NewRecorder()
+	wfe.Certificate(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("0000000000000000000000000000000000b2",
+			signRequest(t, `{"resource":"cert"}`, wfe.nonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"POST-as-GET requests must have an empty payload","status":400}`)
+
+	// and the route accepts them, never caching the response
+	wfe.CertCacheDuration = time.Second * 10
+	responseWriter = httptest.NewRecorder()
+	req := makePostRequestWithPath("/acme/cert/0000000000000000000000000000000000b2",
+		signRequest(t, "", wfe.nonceService))
+	req.Method = "POST"
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Cache-Control"), "public, max-age=0, no-cache")
+}
+
+func TestCertificateDisposition(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+	certURL := "/acme/cert/0000000000000000000000000000000000b2"
+
+	// Not an attachment by default
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", certURL, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Disposition"), "")
+
+	// Asked for with the download parameter
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", certURL+"?download=1", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Disposition"),
+		`attachment; filename="0000000000000000000000000000000000b2.der"`)
+
+	// or always, by configuration
+	wfe.CertificateAttachment = true
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", certURL, nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Disposition"),
+		`attachment; filename="0000000000000000000000000000000000b2.der"`)
+}
+
+func TestSerialEncodings(t *testing.T) {
+	serial := big.NewInt(0xb2)
+	for name, encoding := range SerialEncodings {
+		encoded := encoding.Encode(serial)
+		decoded, err := encoding.Decode(encoded)
+		test.AssertNotError(t, err, fmt.Sprintf("Failed to decode %s serial %q", name, encoded))
+		test.AssertEquals(t, decoded, core.SerialToString(serial))
+
+		_, err = encoding.Decode("!!")
+		test.AssertError(t, err, fmt.Sprintf("Decoded garbage as %s serial", name))
+	}
+	test.AssertEquals(t, Base64URLSerialEncoding.Encode(serial), "AAAAAAAAAAAAAAAAAAAAAACy")
+
+	// Older, 32 hex digit serials keep their width
+	for name, encoding := range SerialEncodings {
+		short := "000000000000000000000000000000b2"
+		encoded := short
+		if name == "base64url" {
+			encoded = "AAAAAAAAAAAAAAAAAAAAsg"
+		}
+		decoded, err := encoding.Decode(encoded)
+		test.AssertNotError(t, err, fmt.Sprintf("Failed to decode %s serial %q", name, encoded))
+		test.AssertEquals(t, decoded, short)
+	}
+
+	// Serials too short to be stored are invalid
+	_, err := Base64URLSerialEncoding.Decode("sg")
+	test.AssertError(t, err, "Decoded a one byte base64url serial")
+
+	wfe, _ := setupWFE(t)
+	wfe.CertSerialEncoding = Base64URLSerialEncoding
+	mux := wfe.Handler()
+
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/acme/cert/AAAAAAAAAAAAAAAAAAAAAACy", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 200)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Type"), "application/pkix-cert")
+
+	// Hex serials are no longer understood, and are too long to be serials
+	responseWriter = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/acme/cert/0000000000000000000000000000000000b2", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, 400)
+}
+
+// mockSASlowCertificate is a mock SA whose GetCertificate calls take two
+// seconds of fake time.
+type mockSASlowCertificate struct {
+	core.StorageGetter
+	clk clock.FakeClock
+}
+
+func (sa mockSASlowCertificate) GetCertificate(ctx context.Context, serial string) (core.Certificate, error) {
+	sa.clk.Add(2 * time.Second)
+	return sa.StorageGetter.GetCertificate(ctx, serial)
+}
+
+func TestSlowBackendCall(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	wfe.SA = mockSASlowCertificate{wfe.SA, fc}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	wfe.stats = stats
+	mockLog := wfe.log.(*blog.Mock)
+
+	getCert := func() {
+		responseWriter := httptest.NewRecorder()
+		wfe.Certificate(ctx, newRequestEvent(), responseWriter, &http.Request{
+			Method: "GET",
+			URL:    mustParseURL("0000000000000000000000000000000000b2"),
+		})
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	}
+
+	// Under the threshold, only the latency is recorded
+	wfe.SlowBackendThreshold = 5 * time.Second
+	mockLog.Clear()
+	stats.EXPECT().TimingDuration("SA.GetCertificate.Latency", 2*time.Second)
+	getCert()
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Slow backend call")), 0)
+
+	// Over the threshold, the call is counted and logged as slow
+	wfe.SlowBackendThreshold = time.Second
+	mockLog.Clear()
+	stats.EXPECT().TimingDuration("SA.GetCertificate.Latency", 2*time.Second)
+	stats.EXPECT().Inc("SA.GetCertificate.Slow", int64(1))
+	getCert()
+	warnings := mockLog.GetAllMatching("Slow backend call")
+	test.AssertEquals(t, len(warnings), 1)
+	test.AssertContains(t, warnings[0], "WARNING: Slow backend call: SA.GetCertificate took 2s")
+}
+
+func assertCsrLogged(t *testing.T, mockLog *blog.Mock) {
+	matches := mockLog.GetAllMatching("^INFO: \\[AUDIT\\] Certificate request JSON=")
+	test.Assert(t, len(matches) == 1,
 		fmt.Sprintf("Incorrect number of certificate request log entries: %d",
 			len(matches)))
 }
@@ -1738,111 +4048,811 @@ func TestLogCsrPem(t *testing.T) {
 	mockLog := wfe.log.(*blog.Mock)
 	mockLog.Clear()
 
-	wfe.logCsr(req, certificateRequest, reg)
+	wfe.logCsr(req, certificateRequest, reg)
+
+	assertCsrLogged(t, mockLog)
+}
+
+func TestLogCsrAuditRetentionClass(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	mockLog := wfe.log.(*blog.Mock)
+	mockSA := mocks.NewStorageAuthority(fc)
+	cr := core.CertificateRequest{Bytes: []byte{0x30, 0x00}}
+	req, err := http.NewRequest("GET", "http://[::1]/", nil)
+	test.AssertNotError(t, err, "NewRequest failed")
+
+	logged := func(regID int64) string {
+		reg, err := mockSA.GetRegistration(ctx, regID)
+		test.AssertNotError(t, err, "Unable to get registration")
+		mockLog.Clear()
+		wfe.logCsr(req, cr, reg)
+		matches := mockLog.GetAllMatching("Certificate request JSON=")
+		test.AssertEquals(t, len(matches), 1)
+		return matches[0]
+	}
+
+	// Untagged by default
+	test.AssertNotContains(t, logged(1), "AuditRetentionClass")
+
+	wfe.AuditRetention.Registrations = map[int64]string{1: "extended"}
+	test.AssertContains(t, logged(1), `"AuditRetentionClass":"extended"`)
+	test.AssertNotContains(t, logged(2), "AuditRetentionClass")
+
+	wfe.AuditRetention.Default = "standard"
+	test.AssertContains(t, logged(1), `"AuditRetentionClass":"extended"`)
+	test.AssertContains(t, logged(2), `"AuditRetentionClass":"standard"`)
+}
+
+func TestLengthRequired(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	_, _, _, prob := wfe.verifyPOST(ctx, newRequestEvent(), &http.Request{
+		Method: "POST",
+		URL:    mustParseURL("/"),
+	}, false, "resource")
+	test.Assert(t, prob != nil, "No error returned for request body missing Content-Length.")
+	test.AssertEquals(t, probs.MalformedProblem, prob.Type)
+	test.AssertEquals(t, http.StatusLengthRequired, prob.HTTPStatus)
+}
+
+type mockSADifferentStoredKey struct {
+	core.StorageGetter
+}
+
+func (sa mockSADifferentStoredKey) GetRegistrationByKey(ctx context.Context, jwk *jose.JsonWebKey) (core.Registration, error) {
+	keyJSON := []byte(test2KeyPublicJSON)
+	var parsedKey jose.JsonWebKey
+	err := parsedKey.UnmarshalJSON(keyJSON)
+	if err != nil {
+		panic(err)
+	}
+
+	return core.Registration{
+		Key: &parsedKey,
+	}, nil
+}
+
+func TestVerifyPOSTUnregisteredKey(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	body := signRequestWithKey(t, `{"resource":"new-reg"}`, test2KeyPrivatePEM, wfe.nonceService)
+
+	// Without regCheck, a key with no registration is returned without one
+	_, key, reg, prob := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(body), false, core.ResourceNewReg)
+	test.Assert(t, prob == nil, fmt.Sprintf("Rejected an unregistered key: %v", prob))
+	test.Assert(t, reg == nil, "Returned a registration for an unregistered key")
+	test.Assert(t, key != nil, "Didn't return the unregistered key")
+
+	body = signRequestWithKey(t, `{"resource":"new-authz"}`, test2KeyPrivatePEM, wfe.nonceService)
+	_, _, reg, prob = wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(body), true, core.ResourceNewAuthz)
+	test.Assert(t, reg == nil, "Returned a registration along with a problem")
+	test.AssertEquals(t, prob.Type, probs.UnauthorizedProblem)
+	test.AssertEquals(t, prob.Detail, unknownKey)
+}
+
+func TestVerifyPOSTUsesStoredKey(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	wfe.SA = &mockSADifferentStoredKey{mocks.NewStorageAuthority(fc)}
+	// signRequest signs with test1Key, but our special mock returns a
+	// registration with test2Key
+	_, _, _, err := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(signRequest(t, `{"resource":"foo"}`, wfe.nonceService)), true, "foo")
+	test.AssertError(t, err, "No error returned when provided key differed from stored key.")
+}
+
+func TestVerifyPOSTKeyID(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	payload := `{"resource":"reg"}`
+	regURL := "http://localhost/acme/reg/1"
+
+	testCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name: "jwk only",
+			body: signRequest(t, payload, wfe.nonceService),
+		},
+		{
+			name: "kid only",
+			body: signRequestWithKeyID(t, payload, test1KeyPrivatePEM, regURL, false, wfe.nonceService),
+		},
+		{
+			name:     "jwk and kid",
+			body:     signRequestWithKeyID(t, payload, test1KeyPrivatePEM, regURL, true, wfe.nonceService),
+			expected: "JWS header must not contain both jwk and kid",
+		},
+		{
+			name:     "neither jwk nor kid",
+			body:     signRequestWithKeyID(t, payload, test1KeyPrivatePEM, "", false, wfe.nonceService),
+			expected: "No JWK in JWS header",
+		},
+		{
+			name:     "kid is not a registration URL",
+			body:     signRequestWithKeyID(t, payload, test1KeyPrivatePEM, "http://localhost/acme/authz/1", false, wfe.nonceService),
+			expected: "JWS kid must be a registration URL",
+		},
+		{
+			name:     "kid is not a registration ID",
+			body:     signRequestWithKeyID(t, payload, test1KeyPrivatePEM, regURL+"x", false, wfe.nonceService),
+			expected: "JWS kid must be a registration URL",
+		},
+		{
+			name:     "kid names a registration with a different key",
+			body:     signRequestWithKeyID(t, payload, test2KeyPrivatePEM, regURL, false, wfe.nonceService),
+			expected: "JWS verification error",
+		},
+	}
+	for _, tc := range testCases {
+		_, key, reg, prob := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(tc.body), true, core.ResourceRegistration)
+		if tc.expected == "" {
+			if prob != nil {
+				t.Errorf("%s: unexpected problem %s", tc.name, prob)
+				continue
+			}
+			test.AssertEquals(t, reg.ID, int64(1))
+			test.AssertDeepEquals(t, key, reg.Key)
+			continue
+		}
+		if prob == nil {
+			t.Errorf("%s: expected problem %q, got none", tc.name, tc.expected)
+			continue
+		}
+		test.AssertEquals(t, prob.Type, probs.MalformedProblem)
+		test.AssertEquals(t, prob.Detail, tc.expected)
+	}
+}
+
+func TestBadKeyCSR(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	responseWriter := httptest.NewRecorder()
+
+	// CSR with a bad (512 bit RSA) key.
+	// openssl req -outform der -new -newkey rsa:512 -nodes -keyout foo.com.key
+	//   -subj /CN=foo.com | base64 -w0 | sed -e 's,+,-,g' -e 's,/,_,g'
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, `{
+			"resource":"new-cert",
+			"csr": "MIHLMHcCAQAwEjEQMA4GA1UEAwwHZm9vLmNvbTBcMA0GCSqGSIb3DQEBAQUAA0sAMEgCQQDCZftp4x4owgjBnwOKfzihIPedT-BUmV2fuQPMqaUlc8yJUp13vcO5uxUlaBm8leM7Dj_sgTDP_JgykorlYo73AgMBAAGgADANBgkqhkiG9w0BAQsFAANBAEaQ2QBhweK-kp1ejQCedUhMit_wG-uTBtKnc3M82f6_fztLkhg1vWQ782nmhbEI5orXp6QtNHgJYnBpqA9Ut00"
+		}`, wfe.nonceService)))
+
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid key in certificate request :: Key too small: 512","status":400}`)
+}
+
+// This uses httptest.NewServer because ServeMux.ServeHTTP won't prevent the
+// body from being sent like the net/http Server's actually do.
+func TestGetCertificateHEADHasCorrectBodyLength(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	certPemBytes, _ := ioutil.ReadFile("test/178.crt")
+	certBlock, _ := pem.Decode(certPemBytes)
+
+	mockLog := wfe.log.(*blog.Mock)
+	mockLog.Clear()
+
+	mux := wfe.Handler()
+	s := httptest.NewServer(mux)
+	// TODO(#1989): Close s
+	req, _ := http.NewRequest("HEAD", s.URL+"/acme/cert/0000000000000000000000000000000000b2", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		test.AssertNotError(t, err, "do error")
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		test.AssertNotEquals(t, err, "readall error")
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		test.AssertNotEquals(t, err, "readall error")
+	}
+	test.AssertEquals(t, resp.StatusCode, 200)
+	test.AssertEquals(t, strconv.Itoa(len(certBlock.Bytes)), resp.Header.Get("Content-Length"))
+	test.AssertEquals(t, 0, len(body))
+}
+
+func newRequestEvent() *requestEvent {
+	return &requestEvent{Extra: make(map[string]interface{})}
+}
+
+func TestVerificationLimiter(t *testing.T) {
+	var unlimited *verificationLimiter
+	test.Assert(t, unlimited.acquire(ctx), "Nil limiter refused a verification")
+	unlimited.release()
+
+	limiter := newVerificationLimiter(2, 10*time.Millisecond)
+	test.Assert(t, limiter.acquire(ctx), "Refused the first verification")
+	test.Assert(t, limiter.acquire(ctx), "Refused the second verification")
+	test.Assert(t, !limiter.acquire(ctx), "Allowed a verification over the limit")
+
+	// A waiting verification gets the slot released while it waits.
+	go func() {
+		time.Sleep(time.Millisecond)
+		limiter.release()
+	}()
+	limiter.wait = time.Minute
+	test.Assert(t, limiter.acquire(ctx), "Waiting verification didn't get the released slot")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	test.Assert(t, !limiter.acquire(cancelled), "Allowed a verification after the request was cancelled")
+}
+
+func TestMaxConcurrentJWSVerifications(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.SetMaxConcurrentJWSVerifications(1, time.Millisecond)
+
+	// Take the only slot, as a verification in progress would.
+	test.Assert(t, wfe.jwsVerifications.acquire(ctx), "Couldn't take a slot")
+	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusServiceUnavailable)
+	test.AssertEquals(t, responseWriter.Header().Get("Retry-After"), "1")
+	test.AssertContains(t, responseWriter.Body.String(), "urn:acme:error:rateLimited")
+	test.AssertEquals(t, len(wfe.log.(*blog.Mock).GetAllMatching("Internal error")), 0)
+
+	// Once it's free again, requests are verified.
+	wfe.jwsVerifications.release()
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusAccepted)
+	test.AssertEquals(t, len(wfe.jwsVerifications.slots), 0)
+}
+
+func TestIssuanceWebhook(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	newCert := func() {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+			makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com"), wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	}
+
+	// Without a webhook, issuance works as ever
+	newCert()
+
+	// The first attempt to deliver the event fails, the retry succeeds
+	var attempts int32
+	events := make(chan issuanceEvent, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var event issuanceEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Couldn't decode issuance event: %s", err)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Issuance event has Content-Type %q", contentType)
+		}
+		events <- event
+	}))
+	defer receiver.Close()
+	wfe.SetIssuanceWebhook(receiver.URL, 10, 1, time.Second)
+	defer wfe.Close()
+	issued := fc.Now()
+	newCert()
+	// The retry waits on the fake clock, so move it along until the event
+	// arrives.
+	var event issuanceEvent
+	deadline := time.After(5 * time.Second)
+	for delivered := false; !delivered; {
+		select {
+		case event = <-events:
+			delivered = true
+		case <-time.After(10 * time.Millisecond):
+			fc.Add(time.Second)
+		case <-deadline:
+			t.Fatal("Issuance event wasn't delivered")
+		}
+	}
+	test.AssertEquals(t, event.Serial, "0000000000000000000000000000000000b2")
+	test.AssertEquals(t, event.RegistrationID, int64(1))
+	test.AssertDeepEquals(t, event.Names, []string{"178"})
+	test.Assert(t, event.Issued.Equal(issued), fmt.Sprintf("Event issued at %s, not %s", event.Issued, issued))
+	test.AssertEquals(t, atomic.LoadInt32(&attempts), int32(2))
+}
+
+func TestIssuanceWebhookSlowReceiver(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer receiver.Close()
+	defer close(release)
+	wfe.SetIssuanceWebhook(receiver.URL, 1, 0, time.Minute)
+
+	// With one event stuck in delivery and the queue full, issuance still
+	// completes, and further events are dropped.
+	mockLog := wfe.log.(*blog.Mock)
+	mockLog.Clear()
+	requests := make([]*http.Request, 5)
+	for i := range requests {
+		requests[i] = makePostRequest(signRequest(t,
+			makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com"), wfe.nonceService))
+	}
+	codes := make(chan int, len(requests))
+	go func() {
+		for _, request := range requests {
+			responseWriter := httptest.NewRecorder()
+			wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, request)
+			codes <- responseWriter.Code
+		}
+	}()
+	for range requests {
+		select {
+		case code := <-codes:
+			test.AssertEquals(t, code, http.StatusCreated)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Issuance blocked on a slow webhook")
+		}
+	}
+	test.Assert(t, len(mockLog.GetAllMatching("Issuance webhook queue full")) >= 3, "Events weren't dropped")
+}
+
+func TestIssuanceWebhookClose(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
+	var delivered int32
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		atomic.AddInt32(&delivered, 1)
+	}))
+	defer receiver.Close()
+
+	// Replacing the webhook stops the old one
+	wfe.SetIssuanceWebhook(receiver.URL, 10, 0, time.Minute)
+	old := wfe.issuanceWebhook
+	wfe.SetIssuanceWebhook(receiver.URL, 10, 0, time.Minute)
+	select {
+	case <-old.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replaced webhook is still running")
+	}
+
+	// Events queued when the WFE is closed are still delivered
+	for i := 0; i < 3; i++ {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+			makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com"), wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	}
+	close(release)
+	wfe.Close()
+	test.AssertEquals(t, atomic.LoadInt32(&delivered), int32(3))
+
+	// and later ones are dropped
+	mockLog := wfe.log.(*blog.Mock)
+	mockLog.Clear()
+	wfe.issuanceWebhook.notify(issuanceEvent{Serial: "00"})
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Issuance webhook closed")), 1)
+
+	// A receiver that never answers holds up closing only until the
+	// delivery timeout has passed, and the events left are dropped.
+	hang := make(chan struct{})
+	hangingReceiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer hangingReceiver.Close()
+	defer close(hang)
+	wfe.SetIssuanceWebhook(hangingReceiver.URL, 10, 0, time.Hour)
+	for i := 0; i < 3; i++ {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t,
+			makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com"), wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	}
+	mockLog.Clear()
+	closed := make(chan struct{})
+	go func() {
+		wfe.Close()
+		close(closed)
+	}()
+	deadline := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case <-closed:
+			done = true
+		case <-time.After(10 * time.Millisecond):
+			fc.Add(10 * time.Minute)
+		case <-deadline:
+			t.Fatal("Closing the webhook waited on a receiver that hangs")
+		}
+	}
+	dropped := len(mockLog.GetAllMatching("Issuance webhook timed out closing, dropping event for serial"))
+	failed := len(mockLog.GetAllMatching("Unable to deliver issuance event"))
+	test.Assert(t, dropped >= 2, fmt.Sprintf("Only %d queued events were dropped", dropped))
+	test.AssertEquals(t, dropped+failed, 3)
+}
+
+func BenchmarkVerifyPOST(b *testing.B) {
+	wfe, _ := setupWFE(&testing.T{})
+	wfe.SetMaxConcurrentJWSVerifications(runtime.NumCPU(), time.Second)
+	key, err := jose.LoadPrivateKey([]byte(test1KeyPrivatePEM))
+	if err != nil {
+		b.Fatal(err)
+	}
+	signer, err := jose.NewSigner("RS256", key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	signer.SetNonceSource(wfe.nonceService)
+	bodies := make([]string, b.N)
+	for i := range bodies {
+		jws, err := signer.Sign([]byte(`{"resource":"reg"}`))
+		if err != nil {
+			b.Fatal(err)
+		}
+		bodies[i] = jws.FullSerialize()
+	}
+	var next int64 = -1
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			body := bodies[atomic.AddInt64(&next, 1)]
+			_, _, _, prob := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(body), true, core.ResourceRegistration)
+			if prob != nil {
+				b.Fatal(prob)
+			}
+		}
+	})
+}
+
+func TestVerifyPOSTInvalidJWK(t *testing.T) {
+	badJWS := `{"signatures":[{"header":{"jwk":{"kty":"RSA","n":"","e":""}}}],"payload":""}`
+	wfe, _ := setupWFE(t)
+	_, _, _, prob := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(badJWS), false, "resource")
+	test.Assert(t, prob != nil, "No error returned for request body with invalid JWS key.")
+	test.AssertEquals(t, probs.MalformedProblem, prob.Type)
+	test.AssertEquals(t, http.StatusBadRequest, prob.HTTPStatus)
+}
+
+func TestEmitTermsLinkEverywhere(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	payload := `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`
+
+	// Off by default
+	responseWriter := httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], []string{`<http://localhost/acme/new-cert>;rel="next"`})
+
+	wfe.EmitTermsLinkEverywhere = true
+	responseWriter = httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], []string{
+		`<http://localhost/acme/new-cert>;rel="next"`,
+		`<` + agreementURL + `>;rel="terms-of-service"`,
+	})
+}
+
+// mockRAReuseAuthz is a mock RA whose NewAuthorization hands back an existing
+// valid authorization, as the RA does when configured to reuse them.
+type mockRAReuseAuthz struct {
+	MockRegistrationAuthority
+}
+
+func (ra *mockRAReuseAuthz) NewAuthorization(ctx context.Context, authz core.Authorization, regID int64) (core.Authorization, error) {
+	return core.Authorization{
+		ID:             "valid",
+		Identifier:     authz.Identifier,
+		RegistrationID: regID,
+		Status:         core.StatusValid,
+	}, nil
+}
+
+func TestNewAuthorizationReused(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	payload := `{"resource":"new-authz","identifier":{"type":"dns","value":"not-an-example.com"}}`
+
+	// A new authorization is created
+	responseWriter := httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"),
+		"http://localhost/acme/authz/bkrPh2u0JUf18-rVBZtOOWWb3GuIiliypL-hBM9Ak1Q")
+
+	// An existing authorization the RA reuses is returned as it is
+	wfe.RA = &mockRAReuseAuthz{}
+	responseWriter = httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Header().Get("Location"), "http://localhost/acme/authz/valid")
+	var authz core.Authorization
+	err := json.Unmarshal(responseWriter.Body.Bytes(), &authz)
+	test.AssertNotError(t, err, "Couldn't unmarshal returned authorization object")
+	test.AssertEquals(t, authz.Status, core.StatusValid)
+	test.AssertEquals(t, authz.Identifier.Value, "not-an-example.com")
+}
+
+func TestRegHoldsAuthorizationsNormalizesNames(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	for _, names := range [][]string{
+		{"not-an-example.com"},
+		{"not-an-example.com."},
+		{"Not-An-Example.COM"},
+		{"NOT-AN-EXAMPLE.COM.", "not-an-example.com"},
+	} {
+		valid, err := wfe.regHoldsAuthorizations(ctx, newRequestEvent(), 1, names)
+		test.AssertNotError(t, err, "regHoldsAuthorizations failed")
+		test.Assert(t, valid, fmt.Sprintf("Expected authorizations for %v", names))
+	}
+
+	valid, err := wfe.regHoldsAuthorizations(ctx, newRequestEvent(), 1, []string{"not-an-example.com.", "example.com."})
+	test.AssertNotError(t, err, "regHoldsAuthorizations failed")
+	test.Assert(t, !valid, "Expected no authorization for example.com")
+}
+
+// mockRARateLimited is a mock RA whose NewAuthorization always fails with a
+// rate limit error.
+type mockRARateLimited struct {
+	MockRegistrationAuthority
+}
+
+func (ra *mockRARateLimited) NewAuthorization(ctx context.Context, authz core.Authorization, regID int64) (core.Authorization, error) {
+	return core.Authorization{}, core.RateLimitedError{
+		Detail: "Too many currently pending authorizations.",
+		Limit:  "pendingAuthorizationsPerAccount",
+	}
+}
+
+func TestProblemInstance(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux := wfe.Handler()
+	mockLog := wfe.log.(*blog.Mock)
+	mockLog.Clear()
+
+	responseWriter := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/acme/cert/nope", nil)
+	mux.ServeHTTP(responseWriter, req)
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
 
-	assertCsrLogged(t, mockLog)
+	var prob probs.ProblemDetails
+	err := json.Unmarshal(responseWriter.Body.Bytes(), &prob)
+	test.AssertNotError(t, err, "Couldn't unmarshal problem")
+	test.AssertEquals(t, prob.Type, probs.ProblemType("urn:acme:error:malformed"))
+	test.Assert(t, prob.Instance != "", "Problem document has no instance")
+	test.AssertEquals(t, prob.Instance, responseWriter.Header().Get("Boulder-Request-ID"))
+
+	reqlogs := mockLog.GetAllMatching(`Terminated request`)
+	test.AssertEquals(t, len(reqlogs), 1)
+	test.AssertContains(t, reqlogs[0], fmt.Sprintf(`"ID":"%s"`, prob.Instance))
 }
 
-func TestLengthRequired(t *testing.T) {
+func TestLocalizedProblemDetails(t *testing.T) {
 	wfe, _ := setupWFE(t)
-	_, _, _, prob := wfe.verifyPOST(ctx, newRequestEvent(), &http.Request{
-		Method: "POST",
-		URL:    mustParseURL("/"),
-	}, false, "resource")
-	test.Assert(t, prob != nil, "No error returned for request body missing Content-Length.")
-	test.AssertEquals(t, probs.MalformedProblem, prob.Type)
-	test.AssertEquals(t, http.StatusLengthRequired, prob.HTTPStatus)
+	wfe.LocalizedProblemDetails = map[string]map[string]string{
+		"urn:ietf:params:acme:error:malformed": {"fr": "Requête mal formée"},
+	}
+	mux := wfe.Handler()
+
+	getProblem := func(acceptLanguage string) (*httptest.ResponseRecorder, probs.ProblemDetails) {
+		responseWriter := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/acme/cert/nope", nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		mux.ServeHTTP(responseWriter, req)
+		test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+		var prob probs.ProblemDetails
+		err := json.Unmarshal(responseWriter.Body.Bytes(), &prob)
+		test.AssertNotError(t, err, "Couldn't unmarshal problem")
+		return responseWriter, prob
+	}
+
+	responseWriter, prob := getProblem("fr-CA, en;q=0.5")
+	test.AssertEquals(t, prob.Detail, "Requête mal formée")
+	test.AssertEquals(t, prob.Type, probs.MalformedProblem)
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Language"), "fr")
+	test.AssertEquals(t, responseWriter.Header().Get("Vary"), "Accept-Language")
+
+	// Unsupported languages get the default detail.
+	responseWriter, prob = getProblem("de")
+	test.AssertEquals(t, prob.Detail, "Invalid certificate serial")
+	test.AssertEquals(t, responseWriter.Header().Get("Content-Language"), "")
 }
 
-type mockSADifferentStoredKey struct {
-	core.StorageGetter
+func TestIETFErrorNamespace(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	prob := &probs.ProblemDetails{
+		Type:       probs.RateLimitedProblem,
+		Detail:     "Too many things",
+		HTTPStatus: http.StatusTooManyRequests,
+		Subproblems: []probs.ProblemDetails{{
+			Type:       probs.MalformedProblem,
+			Detail:     "Bad thing",
+			Identifier: &probs.ProblemIdentifier{Type: "dns", Value: "example.com"},
+		}},
+	}
+
+	// Problem types are sent in the legacy namespace by default
+	responseWriter := httptest.NewRecorder()
+	wfe.sendError(responseWriter, newRequestEvent(), prob, nil)
+	var doc probs.ProblemDetails
+	test.AssertNotError(t, json.Unmarshal(responseWriter.Body.Bytes(), &doc), "Failed to unmarshal problem")
+	test.AssertEquals(t, doc.Type, probs.ProblemType("urn:acme:error:rateLimited"))
+	test.AssertEquals(t, doc.Subproblems[0].Type, probs.ProblemType("urn:acme:error:malformed"))
+
+	// and in the IETF one when configured
+	wfe.IETFErrorNamespace = true
+	responseWriter = httptest.NewRecorder()
+	wfe.sendError(responseWriter, newRequestEvent(), prob, nil)
+	doc = probs.ProblemDetails{}
+	test.AssertNotError(t, json.Unmarshal(responseWriter.Body.Bytes(), &doc), "Failed to unmarshal problem")
+	test.AssertEquals(t, doc.Type, probs.ProblemType("urn:ietf:params:acme:error:rateLimited"))
+	test.AssertEquals(t, doc.Subproblems[0].Type, probs.ProblemType("urn:ietf:params:acme:error:malformed"))
 }
 
-func (sa mockSADifferentStoredKey) GetRegistrationByKey(ctx context.Context, jwk *jose.JsonWebKey) (core.Registration, error) {
-	keyJSON := []byte(test2KeyPublicJSON)
-	var parsedKey jose.JsonWebKey
-	err := parsedKey.UnmarshalJSON(keyJSON)
-	if err != nil {
-		panic(err)
+func TestRateLimitName(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	// The name of the limit comes from the error, not from its message.
+	for _, err := range []core.RateLimitedError{
+		{Detail: "Too many registrations from this IP", Limit: "registrationsPerIP"},
+		{Detail: "Some brand new limit", Limit: "brandNewLimit"},
+		{Detail: "Too many certificates already issued for: example.com"},
+	} {
+		responseWriter := httptest.NewRecorder()
+		wfe.sendError(responseWriter, newRequestEvent(), core.ProblemDetailsForError(err, "Error"), err)
+		test.AssertEquals(t, responseWriter.Code, 429)
+		var prob probs.ProblemDetails
+		test.AssertNotError(t, json.Unmarshal(responseWriter.Body.Bytes(), &prob), "Failed to unmarshal problem")
+		test.AssertEquals(t, prob.Type, probs.RateLimitedProblem)
+		test.AssertEquals(t, prob.RateLimit, err.Limit)
 	}
 
-	return core.Registration{
-		Key: &parsedKey,
-	}, nil
+	// The limit name makes it all the way out of a handler
+	wfe.RA = &mockRARateLimited{}
+	responseWriter := httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.nonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:rateLimited","detail":"Error creating new authz :: Too many currently pending authorizations.","status":429,"rateLimit":"pendingAuthorizationsPerAccount"}`)
 }
 
-func TestVerifyPOSTUsesStoredKey(t *testing.T) {
-	wfe, fc := setupWFE(t)
-	wfe.SA = &mockSADifferentStoredKey{mocks.NewStorageAuthority(fc)}
-	// signRequest signs with test1Key, but our special mock returns a
-	// registration with test2Key
-	_, _, _, err := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(signRequest(t, `{"resource":"foo"}`, wfe.nonceService)), true, "foo")
-	test.AssertError(t, err, "No error returned when provided key differed from stored key.")
+// mockRACertRateLimited is a mock RA whose NewCertificate always fails with
+// the certificatesPerName rate limit.
+type mockRACertRateLimited struct {
+	MockRegistrationAuthority
 }
 
-func TestBadKeyCSR(t *testing.T) {
+func (ra *mockRACertRateLimited) NewCertificate(ctx context.Context, req core.CertificateRequest, regID int64) (core.Certificate, error) {
+	return core.Certificate{}, core.RateLimitedError{
+		Detail: "Too many certificates already issued for: not-an-example.com",
+		Limit:  "certificatesPerName",
+		Bucket: "not-an-example.com",
+	}
+}
+
+func TestDebugRateLimitHeaders(t *testing.T) {
 	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRACertRateLimited{}
+	payload := makeNewCertRequest(t, pkix.Name{CommonName: "www.not-an-example.com"}, "www.not-an-example.com")
+
 	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusTooManyRequests)
+	test.AssertEquals(t, responseWriter.Header().Get("Boulder-RateLimit-Bucket"), "")
 
-	// CSR with a bad (512 bit RSA) key.
-	// openssl req -outform der -new -newkey rsa:512 -nodes -keyout foo.com.key
-	//   -subj /CN=foo.com | base64 -w0 | sed -e 's,+,-,g' -e 's,/,_,g'
-	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(signRequest(t, `{
-			"resource":"new-cert",
-			"csr": "MIHLMHcCAQAwEjEQMA4GA1UEAwwHZm9vLmNvbTBcMA0GCSqGSIb3DQEBAQUAA0sAMEgCQQDCZftp4x4owgjBnwOKfzihIPedT-BUmV2fuQPMqaUlc8yJUp13vcO5uxUlaBm8leM7Dj_sgTDP_JgykorlYo73AgMBAAGgADANBgkqhkiG9w0BAQsFAANBAEaQ2QBhweK-kp1ejQCedUhMit_wG-uTBtKnc3M82f6_fztLkhg1vWQ782nmhbEI5orXp6QtNHgJYnBpqA9Ut00"
-		}`, wfe.nonceService)))
+	wfe.DebugRateLimitHeaders = true
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusTooManyRequests)
+	test.AssertEquals(t, responseWriter.Header().Get("Boulder-RateLimit-Bucket"), "not-an-example.com")
+}
 
-	assertJSONEquals(t,
-		responseWriter.Body.String(),
-		`{"type":"urn:acme:error:malformed","detail":"Invalid key in certificate request :: Key too small: 512","status":400}`)
+// mockRACertUnauthorized is a mock RA whose NewCertificate always fails for
+// want of authorizations.
+type mockRACertUnauthorized struct {
+	MockRegistrationAuthority
 }
 
-// This uses httptest.NewServer because ServeMux.ServeHTTP won't prevent the
-// body from being sent like the net/http Server's actually do.
-func TestGetCertificateHEADHasCorrectBodyLength(t *testing.T) {
+func (ra *mockRACertUnauthorized) NewCertificate(ctx context.Context, req core.CertificateRequest, regID int64) (core.Certificate, error) {
+	return core.Certificate{}, core.UnauthorizedError("Authorizations for these names not found or expired: www.not-an-example.com")
+}
+
+func TestNewCertificateSubproblems(t *testing.T) {
 	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRACertUnauthorized{}
+	payload := makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com", "WWW.not-an-example.com")
 
-	certPemBytes, _ := ioutil.ReadFile("test/178.crt")
-	certBlock, _ := pem.Decode(certPemBytes)
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+	assertJSONEquals(t, responseWriter.Body.String(), `{
+		"type": "urn:acme:error:unauthorized",
+		"detail": "Error creating new cert :: Authorizations for these names not found or expired: www.not-an-example.com",
+		"status": 403,
+		"subproblems": [{
+			"type": "urn:acme:error:unauthorized",
+			"detail": "No valid authorization for this name",
+			"identifier": {"type": "dns", "value": "www.not-an-example.com"}
+		}]
+	}`)
+
+	// Other failures don't get subproblems
+	wfe.RA = &mockRACertRateLimited{}
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusTooManyRequests)
+	test.AssertNotContains(t, responseWriter.Body.String(), "subproblems")
+}
 
-	mockLog := wfe.log.(*blog.Mock)
-	mockLog.Clear()
+func TestNewCertificateMissingCSR(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RA = &mockRANewCertificate{}
 
-	mux := wfe.Handler()
-	s := httptest.NewServer(mux)
-	// TODO(#1989): Close s
-	req, _ := http.NewRequest("HEAD", s.URL+"/acme/cert/0000000000000000000000000000000000b2", nil)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		test.AssertNotError(t, err, "do error")
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		test.AssertNotEquals(t, err, "readall error")
+	testCases := []struct {
+		payload string
+		detail  string
+	}{
+		{`{"resource":"new-cert"}`, "missing CSR"},
+		{`{"resource":"new-cert","csr":""}`, "missing CSR"},
+		{`{"resource":"new-cert","csr":null}`, "missing CSR"},
+		{`{"resource":"new-cert","csr":"   "}`, "Error unmarshaling certificate request"},
+		{`{"resource":"new-cert","csr":"bm90IGEgQ1NS"}`, "Error parsing certificate request. Extensions in the CSR marked critical can cause this error: https://github.com/letsencrypt/boulder/issues/565"},
 	}
-	err = resp.Body.Close()
-	if err != nil {
-		test.AssertNotEquals(t, err, "readall error")
+	for _, tc := range testCases {
+		responseWriter := httptest.NewRecorder()
+		wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, tc.payload, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+		assertJSONEquals(t, responseWriter.Body.String(),
+			fmt.Sprintf(`{"type":"urn:acme:error:malformed","detail":%q,"status":400}`, tc.detail))
 	}
-	test.AssertEquals(t, resp.StatusCode, 200)
-	test.AssertEquals(t, strconv.Itoa(len(certBlock.Bytes)), resp.Header.Get("Content-Length"))
-	test.AssertEquals(t, 0, len(body))
-}
-
-func newRequestEvent() *requestEvent {
-	return &requestEvent{Extra: make(map[string]interface{})}
 }
 
-func TestVerifyPOSTInvalidJWK(t *testing.T) {
-	badJWS := `{"signatures":[{"header":{"jwk":{"kty":"RSA","n":"","e":""}}}],"payload":""}`
+func TestRateLimitRetryAfter(t *testing.T) {
 	wfe, _ := setupWFE(t)
-	_, _, _, prob := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(badJWS), false, "resource")
-	test.Assert(t, prob != nil, "No error returned for request body with invalid JWS key.")
-	test.AssertEquals(t, probs.MalformedProblem, prob.Type)
-	test.AssertEquals(t, http.StatusBadRequest, prob.HTTPStatus)
-}
+	wfe.RA = &mockRACertRateLimited{}
+	payload := makeNewCertRequest(t, pkix.Name{CommonName: "www.not-an-example.com"}, "www.not-an-example.com")
+
+	// No Retry-After unless one is configured for the limit
+	responseWriter := httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusTooManyRequests)
+	test.AssertEquals(t, responseWriter.Header().Get("Retry-After"), "")
 
+	wfe.RateLimitRetryAfter = map[string]time.Duration{"certificatesPerName": time.Hour}
+	responseWriter = httptest.NewRecorder()
+	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter, makePostRequest(signRequest(t, payload, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusTooManyRequests)
+	test.AssertEquals(t, responseWriter.Header().Get("Retry-After"), "3600")
+
+	// A problem's own RetryAfter is rounded up to whole seconds, and only
+	// sent with 429 and 503 responses
+	testCases := []struct {
+		status   int
+		expected string
+	}{
+		{http.StatusTooManyRequests, "2"},
+		{http.StatusServiceUnavailable, "2"},
+		{http.StatusBadRequest, ""},
+		{http.StatusInternalServerError, ""},
+	}
+	for _, tc := range testCases {
+		responseWriter = httptest.NewRecorder()
+		prob := &probs.ProblemDetails{
+			Type:       probs.ServerInternalProblem,
+			Detail:     "try later",
+			HTTPStatus: tc.status,
+			RetryAfter: 1500 * time.Millisecond,
+		}
+		wfe.sendError(responseWriter, newRequestEvent(), prob, nil)
+		test.AssertEquals(t, responseWriter.Code, tc.status)
+		test.AssertEquals(t, responseWriter.Header().Get("Retry-After"), tc.expected)
+	}
+}
 func TestHeaderBoulderRequestId(t *testing.T) {
 	wfe, _ := setupWFE(t)
 	mux := wfe.Handler()
@@ -1857,6 +4867,243 @@ func TestHeaderBoulderRequestId(t *testing.T) {
 	test.Assert(t, len(requestID) > 0, "Boulder-Request-ID header is empty")
 }
 
+func TestDedupeLinkHeaders(t *testing.T) {
+	termsLink := `<http://example.invalid/terms>;rel="terms-of-service"`
+	upLink := `<http://localhost/acme/issuer-cert>;rel="up"`
+	handler := func(dedupe bool) *topHandler {
+		return &topHandler{
+			log: blog.NewMock(),
+			clk: clock.NewFake(),
+			wfe: wfeHandlerFunc(func(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+				response.Header().Add("Link", termsLink)
+				response.Header().Add("Link", upLink)
+				response.Header().Add("Link", termsLink)
+				response.Write([]byte("hi"))
+			}),
+			dedupeLinks: dedupe,
+		}
+	}
+
+	responseWriter := httptest.NewRecorder()
+	handler(false).ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/")})
+	test.AssertEquals(t, len(responseWriter.Header()["Link"]), 3)
+
+	responseWriter = httptest.NewRecorder()
+	handler(true).ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/")})
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], []string{termsLink, upLink})
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertEquals(t, responseWriter.Body.String(), "hi")
+}
+
+func TestMaxLinkHeaders(t *testing.T) {
+	mockLog := blog.NewMock()
+	th := &topHandler{
+		log: mockLog,
+		clk: clock.NewFake(),
+		wfe: wfeHandlerFunc(func(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+			for i := 0; i < 4; i++ {
+				response.Header().Add("Link", fmt.Sprintf(`<http://localhost/%d>;rel="alternate"`, i))
+			}
+			response.Header().Add("Link", `<http://localhost/terms>;rel="terms-of-service"`)
+			response.Header().Add("Link", `<http://localhost/acme/authz/1>; rel=up`)
+			response.WriteHeader(http.StatusOK)
+		}),
+		maxLinks: 3,
+	}
+
+	// Optional links are dropped from the last, essential ones kept
+	responseWriter := httptest.NewRecorder()
+	th.ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/links")})
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], []string{
+		`<http://localhost/0>;rel="alternate"`,
+		`<http://localhost/terms>;rel="terms-of-service"`,
+		`<http://localhost/acme/authz/1>; rel=up`,
+	})
+	warnings := mockLog.GetAllMatching("Dropping")
+	test.AssertEquals(t, len(warnings), 1)
+	test.AssertContains(t, warnings[0], "WARNING: Dropping 3 of 6 Link headers in response to /links")
+
+	// Essential links are sent even if they're over the cap
+	mockLog.Clear()
+	th.maxLinks = 1
+	responseWriter = httptest.NewRecorder()
+	th.ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/links")})
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], []string{
+		`<http://localhost/terms>;rel="terms-of-service"`,
+		`<http://localhost/acme/authz/1>; rel=up`,
+	})
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Sending 2 essential Link headers, over the cap of 1")), 1)
+}
+
+func TestEssentialLink(t *testing.T) {
+	for value, essential := range map[string]bool{
+		link("http://localhost/acme/authz/1", "up"):        true,
+		link("http://localhost/acme/new-cert", "next"):     true,
+		link("http://localhost/terms", "terms-of-service"): true,
+		`<http://localhost/>; REL="UP"`:                    true,
+		`<http://localhost/>;rel="alternate up"`:           true,
+		link("http://localhost/acme/issuer-cert", "index"): false,
+		`<http://localhost/>;title="up"`:                   false,
+		`<http://localhost/>`:                              false,
+	} {
+		test.AssertEquals(t, essentialLink(value), essential)
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	mockLog := blog.NewMock()
+	links := []string{
+		`<http://localhost/acme/new-authz>;rel="next"`,
+		`<http://localhost/terms>;rel="terms-of-service"`,
+		`<http://localhost/0>;rel="alternate"`,
+		`<http://localhost/1>;rel="alternate"`,
+	}
+	th := &topHandler{
+		log: mockLog,
+		clk: clock.NewFake(),
+		wfe: wfeHandlerFunc(func(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+			for _, link := range links {
+				response.Header().Add("Link", link)
+			}
+			response.Header().Set("Boulder-RateLimit-Bucket", "example.com")
+			response.Header().Set("Content-Type", "application/json")
+			logEvent.addTiming("SA", time.Millisecond)
+			response.WriteHeader(http.StatusOK)
+		}),
+		timing: true,
+	}
+
+	// Without a cap, every header is sent
+	responseWriter := httptest.NewRecorder()
+	th.ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/headers")})
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], links)
+	test.AssertEquals(t, responseWriter.Header().Get("Server-Timing"), "SA;dur=1.000")
+	full := headerSize(responseWriter.Header())
+
+	// A cap the headers already fit in changes nothing
+	th.maxHeaderBytes = full
+	responseWriter = httptest.NewRecorder()
+	th.ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/headers")})
+	test.AssertDeepEquals(t, responseWriter.Header()["Link"], links)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Dropping")), 0)
+
+	// Going over the cap drops the optional headers first, then the last
+	// Link headers
+	optional := len("Server-Timing: SA;dur=1.000\r\n") + len("Boulder-Ratelimit-Bucket: example.com\r\n")
+	th.maxHeaderBytes = full - optional - 1
+	responseWriter = httptest.NewRecorder()
+	th.ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/headers")})
+	header := responseWriter.Header()
+	test.Assert(t, headerSize(header) <= th.maxHeaderBytes, "Headers are still over the cap")
+	test.AssertEquals(t, header.Get("Server-Timing"), "")
+	test.AssertEquals(t, header.Get("Boulder-RateLimit-Bucket"), "")
+	test.AssertDeepEquals(t, header["Link"], links[:3])
+	test.AssertEquals(t, header.Get("Content-Type"), "application/json")
+	test.AssertNotEquals(t, header.Get("Boulder-Request-ID"), "")
+	warnings := mockLog.GetAllMatching("Dropping")
+	test.AssertEquals(t, len(warnings), 1)
+	test.AssertContains(t, warnings[0],
+		"Dropping Server-Timing, Boulder-RateLimit-Bucket, 1 Link headers from")
+	test.AssertContains(t, warnings[0], "in response to /headers")
+
+	// Essential links are never dropped: oversized headers are sent instead
+	mockLog.Clear()
+	th.maxHeaderBytes = 100
+	responseWriter = httptest.NewRecorder()
+	th.ServeHTTP(responseWriter, &http.Request{Method: "GET", URL: mustParseURL("/headers")})
+	header = responseWriter.Header()
+	test.AssertDeepEquals(t, header["Link"], links[:2])
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Dropping .* 2 Link headers from")), 1)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("over the cap of 100, in response to /headers")), 1)
+}
+
+func TestLogSampling(t *testing.T) {
+	th := &topHandler{logSampleRate: 0.1}
+	sampled := 0
+	const requests = 10000
+	for i := 0; i < requests; i++ {
+		id := core.NewToken()
+		if th.sampled(id) {
+			sampled++
+		}
+		test.AssertEquals(t, th.sampled(id), th.sampled(id))
+	}
+	if sampled < requests*8/100 || sampled > requests*12/100 {
+		t.Errorf("Sampled %d of %d requests, expected about %d", sampled, requests, requests/10)
+	}
+
+	// Rates outside (0, 1) sample every request
+	for _, rate := range []float64{0, 1, -1, 2} {
+		th.logSampleRate = rate
+		test.Assert(t, th.sampled(core.NewToken()), fmt.Sprintf("Request not sampled at rate %g", rate))
+	}
+
+	// Unsampled requests only log a few fields
+	mockLog := blog.NewMock()
+	th = &topHandler{
+		log: mockLog,
+		clk: clock.NewFake(),
+		wfe: wfeHandlerFunc(func(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+			logEvent.Endpoint = "/acme/reg/"
+			logEvent.Requester = 1
+			logEvent.AddError("oops")
+			response.WriteHeader(http.StatusBadRequest)
+		}),
+		logSampleRate: 0.5,
+	}
+	var full, minimal int
+	for i := 0; i < 100; i++ {
+		mockLog.Clear()
+		responseWriter := httptest.NewRecorder()
+		th.ServeHTTP(responseWriter, &http.Request{Method: "POST", URL: mustParseURL("/acme/reg/1"), Header: http.Header{"User-Agent": {"test"}}})
+		id := responseWriter.Header().Get("Boulder-Request-ID")
+		logged := mockLog.GetAllMatching("Terminated request")
+		test.AssertEquals(t, len(logged), 1)
+		test.AssertContains(t, logged[0], id)
+		test.AssertContains(t, logged[0], `"Requester":1`)
+		if th.sampled(id) {
+			full++
+			test.AssertContains(t, logged[0], `"UserAgent":"test"`)
+			test.AssertContains(t, logged[0], "oops")
+		} else {
+			minimal++
+			test.AssertNotContains(t, logged[0], "UserAgent")
+			test.AssertNotContains(t, logged[0], "oops")
+		}
+	}
+	test.Assert(t, full > 0 && minimal > 0, fmt.Sprintf("%d requests logged in full and %d minimally", full, minimal))
+}
+
+// mockRASlowNewCertificate is a mockRANewCertificate whose NewCertificate
+// takes 40ms of fake time.
+type mockRASlowNewCertificate struct {
+	mockRANewCertificate
+	clk clock.FakeClock
+}
+
+func (ra *mockRASlowNewCertificate) NewCertificate(ctx context.Context, req core.CertificateRequest, regID int64) (core.Certificate, error) {
+	ra.clk.Add(40 * time.Millisecond)
+	return ra.mockRANewCertificate.NewCertificate(ctx, req, regID)
+}
+
+func TestServerTimingHeader(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	wfe.RA = &mockRASlowNewCertificate{clk: fc}
+	payload := makeNewCertRequest(t, pkix.Name{CommonName: "not-an-example.com"}, "not-an-example.com")
+	newCert := func() *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		wfe.Handler().ServeHTTP(responseWriter,
+			makePostRequestWithPath(newCertPath, signRequest(t, payload, wfe.nonceService)))
+		test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+		return responseWriter
+	}
+
+	test.AssertEquals(t, newCert().Header().Get("Server-Timing"), "")
+
+	wfe.ServerTimingHeader = true
+	test.AssertEquals(t, newCert().Header().Get("Server-Timing"), "SA;dur=0.000, JWS;dur=0.000, RA;dur=40.000")
+}
+
 func TestHeaderBoulderRequester(t *testing.T) {
 	wfe, _ := setupWFE(t)
 	mux := wfe.Handler()
@@ -1886,6 +5133,17 @@ func TestHeaderBoulderRequester(t *testing.T) {
 	test.AssertEquals(t, responseWriter.Header().Get("Boulder-Requester"), "1")
 }
 
+// mockSAPendingAuthz is a mock SA whose authorizations are all pending.
+type mockSAPendingAuthz struct {
+	core.StorageGetter
+}
+
+func (sa *mockSAPendingAuthz) GetAuthorization(ctx context.Context, id string) (core.Authorization, error) {
+	authz, err := sa.StorageGetter.GetAuthorization(ctx, id)
+	authz.Status = core.StatusPending
+	return authz, err
+}
+
 func TestDeactivateAuthorization(t *testing.T) {
 	wfe, _ := setupWFE(t)
 	wfe.AllowAuthzDeactivation = true
@@ -1898,9 +5156,25 @@ func TestDeactivateAuthorization(t *testing.T) {
 		responseWriter.Body.String(),
 		`{"type": "urn:acme:error:malformed","detail": "Invalid status value","status": 400}`)
 
-	responseWriter.Body.Reset()
+	responseWriter = httptest.NewRecorder()
+	wfe.Authorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("valid", signRequest(t, `{"resource":"authz","status":"deactivated"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"type": "urn:acme:error:malformed","detail": "Only pending authorizations can be deactivated, authorization is valid","status": 400}`)
+
+	// Another registration can't deactivate the authorization.
+	wfe.SA = &mockSAPendingAuthz{wfe.SA}
+	responseWriter = httptest.NewRecorder()
+	wfe.Authorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("valid", signRequestWithKey(t, `{"resource":"authz","status":"deactivated"}`, test3KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+
+	responseWriter = httptest.NewRecorder()
 	wfe.Authorization(ctx, newRequestEvent(), responseWriter,
 		makePostRequestWithPath("valid", signRequest(t, `{"resource":"authz","status":"deactivated"}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{
@@ -1950,7 +5224,8 @@ func TestDeactivateRegistration(t *testing.T) {
 		  "agreement": "http://example.invalid/terms",
 		  "initialIp": "",
 		  "createdAt": "0001-01-01T00:00:00Z",
-		  "Status": "deactivated"
+		  "status": "deactivated",
+		  "termsOfServiceAgreed": true
 		}`)
 
 	responseWriter.Body.Reset()
@@ -1958,21 +5233,24 @@ func TestDeactivateRegistration(t *testing.T) {
 		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"deactivated","contact":[]}`, wfe.nonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
-		`{
-		  "id": 1,
-		  "key": {
-		    "kty": "RSA",
-		    "n": "yNWVhtYEKJR21y9xsHV-PD_bYwbXSeNuFal46xYxVfRL5mqha7vttvjB_vc7Xg2RvgCxHPCqoxgMPTzHrZT75LjCwIW2K_klBYN8oYvTwwmeSkAz6ut7ZxPv-nZaT5TJhGk0NT2kh_zSpdriEJ_3vW-mqxYbbBmpvHqsa1_zx9fSuHYctAZJWzxzUZXykbWMWQZpEiE0J4ajj51fInEzVn7VxV-mzfMyboQjujPh7aNJxAWSq4oQEJJDgWwSh9leyoJoPpONHxh5nEE5AjE01FkGICSxjpZsF-w8hOTI3XXohUdu29Se26k2B0PolDSuj0GIQU6-W9TdLXSjBb2SpQ",
-		    "e": "AQAB"
-		  },
-		  "contact": [
-		    "mailto:person@mail.com"
-		  ],
-		  "agreement": "http://example.invalid/terms",
-		  "initialIp": "",
-		  "createdAt": "0001-01-01T00:00:00Z",
-		  "Status": "deactivated"
-		}`)
+		`{"type": "urn:acme:error:malformed","detail": "Deactivation requests must not update other registration fields","status": 400}`)
+
+	responseWriter.Body.Reset()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"deactivated","agreement":"http://example.invalid/other-terms"}`, wfe.nonceService)))
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"type": "urn:acme:error:malformed","detail": "Deactivation requests must not update other registration fields","status": 400}`)
+
+	// Sending back the unchanged contacts and agreement alongside the status is
+	// not an update.
+	responseWriter.Body.Reset()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"deactivated","contact":["mailto:person@mail.com"],"agreement":"http://example.invalid/terms"}`, wfe.nonceService)))
+	var reg core.Registration
+	err := json.Unmarshal(responseWriter.Body.Bytes(), &reg)
+	test.AssertNotError(t, err, "Failed to unmarshal registration")
+	test.AssertEquals(t, reg.Status, core.StatusDeactivated)
 
 	key, err := jose.LoadPrivateKey([]byte(test3KeyPrivatePEM))
 	test.AssertNotError(t, err, "Failed to load key")
@@ -1999,6 +5277,34 @@ func TestDeactivateRegistration(t *testing.T) {
 		}`)
 }
 
+func TestInvalidAccountStatusCode(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	_ = features.Set(map[string]bool{"AllowAccountDeactivation": true})
+	defer features.Reset()
+
+	// Requests from the deactivated test3 registration are refused with a
+	// 403 by default
+	responseWriter := httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, test3KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+
+	// or with the configured status
+	wfe.InvalidAccountStatusCode = http.StatusGone
+	responseWriter = httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequestWithKey(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, test3KeyPrivatePEM, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusGone)
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:unauthorized","detail":"Registration is not valid, has status 'deactivated'","status":410}`)
+
+	// Valid registrations are unaffected
+	responseWriter = httptest.NewRecorder()
+	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.nonceService)))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+}
+
 func TestKeyRollover(t *testing.T) {
 	responseWriter := httptest.NewRecorder()
 	wfe, _ := setupWFE(t)
@@ -2012,6 +5318,10 @@ func TestKeyRollover(t *testing.T) {
 	signer, err := jose.NewSigner("RS256", rsaKey)
 	test.AssertNotError(t, err, "Failed to make signer")
 	signer.SetNonceSource(wfe.nonceService)
+	oldKey, err := jose.LoadPrivateKey([]byte(test1KeyPrivatePEM))
+	test.AssertNotError(t, err, "Failed to load key")
+	oldJWK, err := json.Marshal(jose.JsonWebKey{Key: &oldKey.(*rsa.PrivateKey).PublicKey})
+	test.AssertNotError(t, err, "Failed to marshal old key")
 
 	wfe.KeyRollover(ctx, newRequestEvent(), responseWriter, makePostRequestWithPath("", "{}"))
 	assertJSONEquals(t,
@@ -2053,23 +5363,13 @@ func TestKeyRollover(t *testing.T) {
 		     "status": 400
 		   }`,
 		},
-		// Valid request
+		// New key already belongs to registration 2
 		{
-			`{"newKey":{"kty":"RSA","n":"uTQER6vUA1RDixS8xsfCRiKUNGRzzyIK0MhbS2biClShbb0hSx2mPP7gBvis2lizZ9r-y9hL57kNQoYCKndOBg0FYsHzrQ3O9AcoV1z2Mq-XhHZbFrVYaXI0M3oY9BJCWog0dyi3XC0x8AxC1npd1U61cToHx-3uSvgZOuQA5ffEn5L38Dz1Ti7OV3E4XahnRJvejadUmTkki7phLBUXm5MnnyFm0CPpf6ApV7zhLjN5W-nV0WL17o7v8aDgV_t9nIdi1Y26c3PlCEtiVHZcebDH5F1Deta3oLLg9-g6rWnTqPbY3knffhp4m0scLD6e33k8MtzxDX_D7vHsg0_X1w","e":"AQAB"},"account":"http://localhost/acme/reg/1"}`,
+			`{"newKey":{"kty":"RSA","n":"uTQER6vUA1RDixS8xsfCRiKUNGRzzyIK0MhbS2biClShbb0hSx2mPP7gBvis2lizZ9r-y9hL57kNQoYCKndOBg0FYsHzrQ3O9AcoV1z2Mq-XhHZbFrVYaXI0M3oY9BJCWog0dyi3XC0x8AxC1npd1U61cToHx-3uSvgZOuQA5ffEn5L38Dz1Ti7OV3E4XahnRJvejadUmTkki7phLBUXm5MnnyFm0CPpf6ApV7zhLjN5W-nV0WL17o7v8aDgV_t9nIdi1Y26c3PlCEtiVHZcebDH5F1Deta3oLLg9-g6rWnTqPbY3knffhp4m0scLD6e33k8MtzxDX_D7vHsg0_X1w","e":"AQAB"},"oldKey":` + string(oldJWK) + `,"account":"http://localhost/acme/reg/1"}`,
 			`{
-		     "id": 1,
-		     "key": {
-		       "kty": "RSA",
-		       "n": "uTQER6vUA1RDixS8xsfCRiKUNGRzzyIK0MhbS2biClShbb0hSx2mPP7gBvis2lizZ9r-y9hL57kNQoYCKndOBg0FYsHzrQ3O9AcoV1z2Mq-XhHZbFrVYaXI0M3oY9BJCWog0dyi3XC0x8AxC1npd1U61cToHx-3uSvgZOuQA5ffEn5L38Dz1Ti7OV3E4XahnRJvejadUmTkki7phLBUXm5MnnyFm0CPpf6ApV7zhLjN5W-nV0WL17o7v8aDgV_t9nIdi1Y26c3PlCEtiVHZcebDH5F1Deta3oLLg9-g6rWnTqPbY3knffhp4m0scLD6e33k8MtzxDX_D7vHsg0_X1w",
-		       "e": "AQAB"
-		     },
-		     "contact": [
-		       "mailto:person@mail.com"
-		     ],
-		     "agreement": "http://example.invalid/terms",
-		     "initialIp": "",
-		     "createdAt": "0001-01-01T00:00:00Z",
-		     "Status": "valid"
+		     "type": "urn:acme:error:malformed",
+		     "detail": "New key is already in use for a different account",
+		     "status": 409
 		   }`,
 		},
 	} {
@@ -2083,4 +5383,46 @@ func TestKeyRollover(t *testing.T) {
 		wfe.KeyRollover(ctx, newRequestEvent(), responseWriter, makePostRequestWithPath("", outer))
 		assertJSONEquals(t, responseWriter.Body.String(), testCase.expectedResponse)
 	}
+
+	// An inner JWS that names a registration by kid rather than embedding
+	// the new key is malformed.
+	innerStr := signRequestWithKeyID(t, `{"account":"http://localhost/acme/reg/1"}`, test2KeyPrivatePEM, "http://localhost/acme/reg/1", false, wfe.nonceService)
+	innerStr = innerStr[:len(innerStr)-1] + `,"resource":"key-change"}`
+	responseWriter = httptest.NewRecorder()
+	wfe.KeyRollover(ctx, newRequestEvent(), responseWriter, makePostRequestWithPath("", signRequest(t, innerStr, wfe.nonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"No JWK in JWS header","status":400}`)
+
+	// Rolling over to a key no account holds works, but the inner payload
+	// must carry the old key, and it must be the account's
+	newKey, err := jose.LoadPrivateKey([]byte(test2KeyPrivatePEM))
+	test.AssertNotError(t, err, "Failed to load key")
+	newSigner, err := jose.NewSigner("RS256", newKey)
+	test.AssertNotError(t, err, "Failed to make signer")
+	newSigner.SetNonceSource(wfe.nonceService)
+	newJWK, err := json.Marshal(jose.JsonWebKey{Key: &newKey.(*rsa.PrivateKey).PublicKey})
+	test.AssertNotError(t, err, "Failed to marshal new key")
+	for _, testCase := range []struct {
+		payload string
+		code    int
+		detail  string
+	}{
+		{`{"newKey":` + string(newJWK) + `,"account":"http://localhost/acme/reg/1"}`, http.StatusBadRequest, "Inner payload must contain the old JWK"},
+		{`{"newKey":` + string(newJWK) + `,"oldKey":` + string(newJWK) + `,"account":"http://localhost/acme/reg/1"}`, http.StatusBadRequest, "Old JWK in inner payload doesn't match the account key"},
+		{`{"newKey":` + string(newJWK) + `,"oldKey":` + string(oldJWK) + `,"account":"http://localhost/acme/reg/1"}`, http.StatusOK, ""},
+	} {
+		inner, err := newSigner.Sign([]byte(testCase.payload))
+		test.AssertNotError(t, err, "Unable to sign")
+		innerStr := inner.FullSerialize()
+		innerStr = innerStr[:len(innerStr)-1] + `,"resource":"key-change"}`
+		outer := signRequest(t, innerStr, wfe.nonceService)
+
+		responseWriter = httptest.NewRecorder()
+		wfe.KeyRollover(ctx, newRequestEvent(), responseWriter, makePostRequestWithPath("", outer))
+		test.AssertEquals(t, responseWriter.Code, testCase.code)
+		if testCase.code == http.StatusBadRequest {
+			assertJSONEquals(t, responseWriter.Body.String(),
+				`{"type":"urn:acme:error:malformed","detail":"`+testCase.detail+`","status":400}`)
+		}
+	}
 }